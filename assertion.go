@@ -582,3 +582,62 @@ func AssertExact(_ context.Context, source, target []*Assertions) bool {
 	}
 	return match
 }
+
+// AssertEquivalent returns an Assert which, like AssertExact, requires
+// that each of target's assertions be present in and match those in
+// source, except that mismatched assertions in namespace ns are
+// accepted if the assertions for the same subject in namespace equiv
+// are present in both source and target, and match. For example, blob
+// objects whose etags differ may be accepted if their content digests
+// match.
+func AssertEquivalent(ns, equiv string) Assert {
+	return func(_ context.Context, source, target []*Assertions) bool {
+		tgts, tSz := NonEmptyAssertions(target...)
+		if tSz == 0 {
+			return true
+		}
+		srcs, sSz := NonEmptyAssertions(source...)
+		if sSz == 0 {
+			return false
+		}
+		lookup := func(list []*Assertions, k AssertionKey) *assertion {
+			for _, a := range list {
+				a.mu.RLock()
+				v, ok := a.m[k]
+				a.mu.RUnlock()
+				if ok {
+					return v
+				}
+			}
+			return nil
+		}
+		for _, tgt := range tgts {
+			tgt.mu.RLock()
+			keys := make([]AssertionKey, 0, len(tgt.m))
+			values := make([]*assertion, 0, len(tgt.m))
+			for k, v := range tgt.m {
+				keys = append(keys, k)
+				values = append(values, v)
+			}
+			tgt.mu.RUnlock()
+			for i, k := range keys {
+				sv := lookup(srcs, k)
+				if sv == nil {
+					return false
+				}
+				if sv.equal(values[i]) {
+					continue
+				}
+				if k.Namespace != ns {
+					return false
+				}
+				ek := AssertionKey{k.Subject, equiv}
+				sev, tev := lookup(srcs, ek), lookup(tgts, ek)
+				if sev == nil || tev == nil || len(sev.objects) == 0 || !sev.equal(tev) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
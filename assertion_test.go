@@ -549,3 +549,47 @@ func BenchmarkSort(b *testing.B) {
 		}
 	}
 }
+
+func TestAssertionsAssertEquivalent(t *testing.T) {
+	kc := reflow.AssertionKey{k1.Subject, "content"}
+	var (
+		c1 = map[string]string{"digest": "d1"}
+		c2 = map[string]string{"digest": "d2"}
+		v2 = map[string]string{"etag": "v2"}
+	)
+	assert := reflow.AssertEquivalent("blob", "content")
+	tests := []struct {
+		src, tgt *reflow.Assertions
+		w        bool
+	}{
+		{a1, nil, true},
+		{nil, a2, false},
+		{a1, a1, true},
+		{a2, reflow.AssertionsFromEntry(k1, v2), false},
+		{
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k1: k1v1, kc: c1}),
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k1: v2, kc: c1}),
+			true,
+		},
+		{
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k1: k1v1, kc: c1}),
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k1: v2, kc: c2}),
+			false,
+		},
+		{
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k1: k1v1, kc: c1}),
+			reflow.AssertionsFromEntry(k1, v2),
+			false,
+		},
+		{
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k2: k2v2, kc: c1}),
+			reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{k2: {"version": "v1"}, kc: c1}),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got, want := assert(context.Background(), []*reflow.Assertions{tt.src}, []*reflow.Assertions{tt.tgt}), tt.w; got != want {
+			t.Errorf("AssertEquivalent(%v, %v): got %v, want %v", tt.src, tt.tgt, got, want)
+		}
+	}
+}
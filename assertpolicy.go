@@ -0,0 +1,248 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package reflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AssertRule applies an Assert policy to the assertions whose keys
+// match the rule.
+type AssertRule struct {
+	// Prefix, if nonempty, restricts the rule to subjects with
+	// this prefix, e.g., "s3://bucket/references/".
+	Prefix string
+	// Namespace, if nonempty, restricts the rule to the namespace
+	// and its subnamespaces, e.g., namespace "blob" includes
+	// "blob-content".
+	Namespace string
+	// Policy is the name of the rule's policy, e.g., "exact".
+	Policy string
+	// Assert implements the rule's policy. Assertions governed by a
+	// rule with a nil Assert are never asserted, and so need not be
+	// generated.
+	Assert Assert
+}
+
+// Matches tells whether the rule applies to assertions with the
+// provided key.
+func (r AssertRule) Matches(k AssertionKey) bool {
+	if !strings.HasPrefix(k.Subject, r.Prefix) {
+		return false
+	}
+	return r.Namespace == "" || k.Namespace == r.Namespace || strings.HasPrefix(k.Namespace, r.Namespace+"-")
+}
+
+// String returns a description of the rule, e.g.,
+// "exact (prefix s3://bucket/, namespace blob)".
+func (r AssertRule) String() string {
+	var where []string
+	if r.Prefix != "" {
+		where = append(where, "prefix "+r.Prefix)
+	}
+	if r.Namespace != "" {
+		where = append(where, "namespace "+r.Namespace)
+	}
+	if len(where) == 0 {
+		return r.Policy
+	}
+	return fmt.Sprintf("%s (%s)", r.Policy, strings.Join(where, ", "))
+}
+
+// AssertPolicy applies Assert policies to assertions according to a
+// set of rules, so that how strictly cached results are asserted may
+// depend on the subjects and namespaces of their assertions. For
+// example, reference data may never be asserted while sample data is
+// asserted exactly.
+//
+// Each assertion is governed by the most specific rule that matches
+// its key: the rule with the longest prefix; among rules with the same
+// prefix, those restricted to a namespace are preferred. Assertions
+// that match no rule are governed by the default rule.
+type AssertPolicy struct {
+	// Rules is the set of rules of the policy.
+	Rules []AssertRule
+	// Default is the rule that governs the assertions not matched by
+	// any of Rules. Its Prefix and Namespace are ignored.
+	Default AssertRule
+}
+
+// rule returns the rule that governs assertions with the provided key.
+func (p *AssertPolicy) rule(k AssertionKey) *AssertRule {
+	var best *AssertRule
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.Matches(k) {
+			continue
+		}
+		if best == nil || len(r.Prefix) > len(best.Prefix) ||
+			len(r.Prefix) == len(best.Prefix) && best.Namespace == "" && r.Namespace != "" {
+			best = r
+		}
+	}
+	if best == nil {
+		return &p.Default
+	}
+	return best
+}
+
+// Asserts tells whether the policy asserts assertions with the
+// provided key.
+func (p *AssertPolicy) Asserts(k AssertionKey) bool {
+	return p.rule(k).Assert != nil
+}
+
+// Select returns the assertions in the provided list that are
+// asserted by the policy.
+func (p *AssertPolicy) Select(list []*Assertions) []*Assertions {
+	selected := make([]*Assertions, 0, len(list))
+	for _, a := range list {
+		if a.IsEmpty() {
+			continue
+		}
+		s := &Assertions{m: make(map[AssertionKey]*assertion)}
+		a.mu.RLock()
+		for k, v := range a.m {
+			if p.Asserts(k) {
+				s.m[k] = v
+			}
+		}
+		a.mu.RUnlock()
+		if !s.IsEmpty() {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
+
+// AssertResult is the result of asserting the assertions governed by
+// a rule.
+type AssertResult struct {
+	// Rule is the rule that governs the assertions.
+	Rule AssertRule
+	// N is the number of assertions governed by the rule.
+	N int
+	// Ok tells whether the assertions were validated by the rule's
+	// policy.
+	Ok bool
+}
+
+// String returns a description of the result, e.g.,
+// "exact (prefix s3://bucket/): 2 valid".
+func (r AssertResult) String() string {
+	verdict := "valid"
+	if !r.Ok {
+		verdict = "invalid"
+	}
+	return fmt.Sprintf("%s: %d %s", r.Rule, r.N, verdict)
+}
+
+// Check asserts the target assertions against the source assertions:
+// the target's assertions are partitioned by the rules that govern
+// them, and each partition is asserted by its rule's policy. Check
+// returns a result for each rule that governs some of the target's
+// assertions (or, for rules that never assert, some of the source's),
+// ordered as in Rules, with the default rule last.
+func (p *AssertPolicy) Check(ctx context.Context, source, target []*Assertions) []AssertResult {
+	parts := make(map[*AssertRule]*Assertions)
+	add := func(list []*Assertions, never bool) {
+		for _, a := range list {
+			if a.IsEmpty() {
+				continue
+			}
+			a.mu.RLock()
+			for k, v := range a.m {
+				r := p.rule(k)
+				if never && r.Assert != nil {
+					continue
+				}
+				part := parts[r]
+				if part == nil {
+					part = &Assertions{m: make(map[AssertionKey]*assertion)}
+					parts[r] = part
+				}
+				if _, ok := part.m[k]; !ok {
+					part.m[k] = v
+				}
+			}
+			a.mu.RUnlock()
+		}
+	}
+	add(target, false)
+	// Source assertions that are never asserted need not have been
+	// refreshed, but they are reported nonetheless.
+	add(source, true)
+	var results []AssertResult
+	check := func(r *AssertRule) {
+		part, ok := parts[r]
+		if !ok {
+			return
+		}
+		result := AssertResult{Rule: *r, N: part.size(), Ok: true}
+		if r.Assert != nil {
+			result.Ok = r.Assert(ctx, source, []*Assertions{part})
+		}
+		results = append(results, result)
+	}
+	for i := range p.Rules {
+		check(&p.Rules[i])
+	}
+	check(&p.Default)
+	return results
+}
+
+// Assert implements Assert for the policy: the target is compatible
+// with the source if each of the policy's rules validates the
+// assertions that it governs.
+func (p *AssertPolicy) Assert(ctx context.Context, source, target []*Assertions) bool {
+	for _, r := range p.Check(ctx, source, target) {
+		if !r.Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertProperties returns an Assert which, like AssertExact, requires
+// each of the target's assertions to be present in source, but which
+// compares only the provided properties of each. For example,
+// AssertProperties("size") asserts only the sizes of blob objects.
+func AssertProperties(properties ...string) Assert {
+	return func(_ context.Context, source, target []*Assertions) bool {
+		tgts, tSz := NonEmptyAssertions(target...)
+		if tSz == 0 {
+			return true
+		}
+		srcs, _ := NonEmptyAssertions(source...)
+		for _, tgt := range tgts {
+			tgt.mu.RLock()
+			for k, tv := range tgt.m {
+				var sv *assertion
+				for _, src := range srcs {
+					src.mu.RLock()
+					sv = src.m[k]
+					src.mu.RUnlock()
+					if sv != nil {
+						break
+					}
+				}
+				if sv == nil {
+					tgt.mu.RUnlock()
+					return false
+				}
+				for _, prop := range properties {
+					if sv.objects[prop] != tv.objects[prop] {
+						tgt.mu.RUnlock()
+						return false
+					}
+				}
+			}
+			tgt.mu.RUnlock()
+		}
+		return true
+	}
+}
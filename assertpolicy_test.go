@@ -0,0 +1,123 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package reflow_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/reflow"
+)
+
+func testAssertPolicy() *reflow.AssertPolicy {
+	return &reflow.AssertPolicy{
+		Rules: []reflow.AssertRule{
+			{Prefix: "s3://refs/", Policy: "never"},
+			{Prefix: "s3://samples/", Policy: "exact", Assert: reflow.AssertExact},
+			{Prefix: "s3://scratch/", Policy: "size", Assert: reflow.AssertProperties("size")},
+			{Prefix: "s3://refs/", Namespace: "blob", Policy: "size", Assert: reflow.AssertProperties("size")},
+		},
+		Default: reflow.AssertRule{Policy: "exact", Assert: reflow.AssertExact},
+	}
+}
+
+func TestAssertPolicyRules(t *testing.T) {
+	p := testAssertPolicy()
+	for _, tc := range []struct {
+		key     reflow.AssertionKey
+		asserts bool
+	}{
+		{reflow.AssertionKey{Subject: "s3://refs/hg19.fa", Namespace: "blob"}, true},
+		{reflow.AssertionKey{Subject: "s3://refs/hg19.fa", Namespace: "blob-content"}, true},
+		{reflow.AssertionKey{Subject: "s3://refs/hg19.fa", Namespace: "other"}, false},
+		{reflow.AssertionKey{Subject: "s3://samples/x.bam", Namespace: "blob"}, true},
+		{reflow.AssertionKey{Subject: "ubuntu", Namespace: "docker"}, true},
+	} {
+		if got, want := p.Asserts(tc.key), tc.asserts; got != want {
+			t.Errorf("%v: got %v, want %v", tc.key, got, want)
+		}
+	}
+}
+
+func TestAssertPolicyCheck(t *testing.T) {
+	ctx := context.Background()
+	p := testAssertPolicy()
+	var (
+		sample  = reflow.AssertionKey{Subject: "s3://samples/x.bam", Namespace: "blob"}
+		scratch = reflow.AssertionKey{Subject: "s3://scratch/tmp", Namespace: "blob"}
+		ref     = reflow.AssertionKey{Subject: "s3://refs/hg19.fa", Namespace: "other"}
+	)
+	source := []*reflow.Assertions{reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{
+		sample:  {"etag": "a", "size": "1"},
+		scratch: {"etag": "b", "size": "2"},
+		ref:     {"version": "1"},
+	})}
+	for _, tc := range []struct {
+		target map[reflow.AssertionKey]map[string]string
+		want   []string
+	}{
+		{
+			map[reflow.AssertionKey]map[string]string{
+				sample:  {"etag": "a", "size": "1"},
+				scratch: {"etag": "c", "size": "2"},
+				ref:     {"version": "2"},
+			},
+			[]string{
+				"never (prefix s3://refs/): 1 valid",
+				"exact (prefix s3://samples/): 1 valid",
+				"size (prefix s3://scratch/): 1 valid",
+			},
+		},
+		{
+			map[reflow.AssertionKey]map[string]string{
+				sample:  {"etag": "b", "size": "1"},
+				scratch: {"etag": "c", "size": "3"},
+			},
+			[]string{
+				"never (prefix s3://refs/): 1 valid",
+				"exact (prefix s3://samples/): 1 invalid",
+				"size (prefix s3://scratch/): 1 invalid",
+			},
+		},
+		{
+			map[reflow.AssertionKey]map[string]string{
+				{Subject: "ubuntu", Namespace: "docker"}: {"sha256": "x"},
+			},
+			[]string{"never (prefix s3://refs/): 1 valid", "exact: 1 invalid"},
+		},
+	} {
+		target := []*reflow.Assertions{reflow.AssertionsFromMap(tc.target)}
+		var got []string
+		ok := true
+		for _, r := range p.Check(ctx, source, target) {
+			got = append(got, r.String())
+			ok = ok && r.Ok
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %v, want %v", got, tc.want)
+		}
+		if got, want := p.Assert(ctx, source, target), ok; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAssertPolicySelect(t *testing.T) {
+	p := testAssertPolicy()
+	var (
+		sample = reflow.AssertionKey{Subject: "s3://samples/x.bam", Namespace: "blob"}
+		ref    = reflow.AssertionKey{Subject: "s3://refs/hg19.fa", Namespace: "other"}
+	)
+	list := []*reflow.Assertions{
+		reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{sample: {"etag": "a"}, ref: {"version": "1"}}),
+		reflow.AssertionsFromEntry(ref, map[string]string{"version": "1"}),
+		nil,
+	}
+	got := p.Select(list)
+	if len(got) != 1 || !got[0].Equal(reflow.AssertionsFromEntry(sample, map[string]string{"etag": "a"})) {
+		t.Errorf("got %v", got)
+	}
+}
@@ -29,6 +29,9 @@ const (
 	Logs
 	// Bundle stores the program source, args, image names.
 	Bundle
+	// Eval maps the digests of syntax-level evaluations to their
+	// memoized (encoded) values.
+	Eval
 )
 
 // MappingHandler is an interface for handling a mapping while scanning.
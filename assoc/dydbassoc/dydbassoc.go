@@ -57,6 +57,7 @@ var (
 		assoc.Logs:        "Logs",
 		assoc.Bundle:      "Bundle",
 		assoc.ExecInspect: "ExecInspect",
+		assoc.Eval:        "Eval",
 	}
 	backOffPolicy = retry.MaxTries(retry.Backoff(2*time.Millisecond, time.Minute, 1), 10)
 )
@@ -213,7 +214,7 @@ func (a *Assoc) Version() int {
 // k's association for (kind,v) will be removed.
 func (a *Assoc) Store(ctx context.Context, kind assoc.Kind, k, v digest.Digest) error {
 	switch kind {
-	case assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle:
+	case assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle, assoc.Eval:
 	default:
 		return errors.E(errors.NotSupported, errors.Errorf("mappings of kind %v are not supported", kind))
 	}
@@ -325,7 +326,15 @@ func (a *Assoc) getUpdateComponents(kind assoc.Kind, k, v digest.Digest) (expr s
 			av[":id4"] = &dynamodb.AttributeValue{S: aws.String(k4.HexN(4))}
 			av[":empty_list"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
 		}
-
+	case assoc.Eval:
+		switch {
+		case v.IsZero():
+			expr = "REMOVE Eval"
+		default:
+			expr = "SET Eval = :eval, LastAccessTime = :lastaccess"
+			av[":eval"] = &dynamodb.AttributeValue{S: aws.String(v.String())}
+			av[":lastaccess"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(time.Now().Unix()))}
+		}
 	}
 	if !v.IsZero() && len(a.Labels) > 0 {
 		a.labelsOnce.Do(func() {
@@ -348,7 +357,7 @@ func (a *Assoc) getUpdateComponents(kind assoc.Kind, k, v digest.Digest) (expr s
 func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (digest.Digest, digest.Digest, error) {
 	var v digest.Digest
 	switch kind {
-	case assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle:
+	case assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle, assoc.Eval:
 	default:
 		return k, v, errors.E(errors.NotSupported, errors.Errorf("mappings of kind %v are not supported", kind))
 	}
@@ -362,6 +371,8 @@ func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (dige
 		col = "Logs"
 	case assoc.Bundle:
 		col = "Bundle"
+	case assoc.Eval:
+		col = "Eval"
 	}
 	if err := a.Limiter.Acquire(ctx, 1); err != nil {
 		return k, v, err
@@ -421,7 +432,7 @@ func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (dige
 		}
 		item = resp.Item[col]
 	}
-	if item == nil || (kind == assoc.Fileset && item.S == nil) || (kind == assoc.ExecInspect && item.L == nil) || (kind == assoc.Logs && item.L == nil) || (kind == assoc.Bundle && item.L == nil) {
+	if item == nil || (kind == assoc.Fileset && item.S == nil) || (kind == assoc.ExecInspect && item.L == nil) || (kind == assoc.Logs && item.L == nil) || (kind == assoc.Bundle && item.L == nil) || (kind == assoc.Eval && item.S == nil) {
 		return k, v, errors.E("lookup", k, errors.NotExist)
 	}
 	if item.L != nil {
@@ -802,7 +813,7 @@ func (a *Assoc) Scan(ctx context.Context, kind assoc.Kind, mappingHandler assoc.
 				dbval := *item[colname]
 				var v []digest.Digest
 				switch kind {
-				case assoc.Fileset:
+				case assoc.Fileset, assoc.Eval:
 					d, err := reflow.Digester.Parse(*dbval.S)
 					if err != nil {
 						log.Errorf("invalid digest of kind %v for dynamodb entry %v", kind, item)
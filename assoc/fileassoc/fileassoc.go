@@ -0,0 +1,215 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package fileassoc implements an assoc.Assoc that stores its
+// mappings in a local directory, e.g., to keep a local cache. Each
+// mapping is stored in its own file, named by the mapping's kind and
+// key, whose modification time is its last access time.
+package fileassoc
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/liveset"
+)
+
+func init() {
+	infra.Register("fileassoc", new(Assoc))
+}
+
+// kinds is the set of mapping kinds.
+var kinds = []assoc.Kind{assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle, assoc.Eval}
+
+// Assoc implements a filesystem-backed assoc.Assoc.
+type Assoc struct {
+	// Dir is the directory in which mappings are stored.
+	Dir string
+}
+
+// Help implements infra.Provider.
+func (*Assoc) Help() string {
+	return "configure an assoc in a local directory"
+}
+
+// Flags implements infra.Provider.
+func (a *Assoc) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&a.Dir, "dir", "", "assoc directory")
+}
+
+// Init implements infra.Provider.
+func (a *Assoc) Init() error {
+	if a.Dir == "" {
+		return errors.New("fileassoc: no directory configured")
+	}
+	dir, err := filepath.Abs(a.Dir)
+	if err != nil {
+		return err
+	}
+	a.Dir = dir
+	return os.MkdirAll(a.Dir, 0777)
+}
+
+func (a *Assoc) path(kind assoc.Kind, k digest.Digest) string {
+	hex := k.Hex()
+	return filepath.Join(a.Dir, kind.String(), hex[:2], hex[2:])
+}
+
+// Store stores the mapping k, v, replacing any existing mapping.
+// Zero values delete the mapping.
+func (a *Assoc) Store(ctx context.Context, kind assoc.Kind, k, v digest.Digest) error {
+	path := a.path(kind, k)
+	if v.IsZero() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.E("store", k, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return errors.E("store", k, err)
+	}
+	// Write to a temporary file and rename it so that concurrent
+	// readers never observe partial mappings.
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+	if err != nil {
+		return errors.E("store", k, err)
+	}
+	_, err = f.WriteString(v.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.E("store", k, err)
+	}
+	return nil
+}
+
+// Get returns the value mapped by k, and records the mapping's access.
+// Abbreviated keys are not supported.
+func (a *Assoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (digest.Digest, digest.Digest, error) {
+	path := a.path(kind, k)
+	v, err := readValue(path)
+	if os.IsNotExist(err) {
+		return k, digest.Digest{}, errors.E("get", k, errors.NotExist)
+	}
+	if err != nil {
+		return k, digest.Digest{}, errors.E("get", k, err)
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return k, v, nil
+}
+
+func readValue(path string) (digest.Digest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	return reflow.Digester.Parse(strings.TrimSpace(string(b)))
+}
+
+// BatchGet looks up each key in the batch.
+func (a *Assoc) BatchGet(ctx context.Context, batch assoc.Batch) error {
+	for k := range batch {
+		_, v, err := a.Get(ctx, k.Kind, k.Digest)
+		if errors.Is(errors.NotExist, err) {
+			err = nil
+		}
+		batch[k] = assoc.Result{Digest: v, Error: err}
+	}
+	return ctx.Err()
+}
+
+// CollectWithThreshold is not supported by file assocs.
+func (a *Assoc) CollectWithThreshold(context.Context, liveset.Liveset, liveset.Liveset, time.Time, int64, bool) error {
+	return errors.E("collect", errors.NotSupported)
+}
+
+// Count returns the number of mappings in the assoc.
+func (a *Assoc) Count(ctx context.Context) (int64, error) {
+	var n int64
+	for _, kind := range kinds {
+		err := a.walk(kind, func(string, digest.Digest, os.FileInfo) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Scan calls the handler for each mapping of the provided kind. The
+// mappings' last access times are reported; they have no labels.
+func (a *Assoc) Scan(ctx context.Context, kind assoc.Kind, handler assoc.MappingHandler) error {
+	return a.walk(kind, func(path string, k digest.Digest, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		v, err := readValue(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handler.HandleMapping(k, []digest.Digest{v}, kind, info.ModTime(), nil)
+		return nil
+	})
+}
+
+// walk calls fn for each mapping file of the provided kind.
+func (a *Assoc) walk(kind assoc.Kind, fn func(path string, k digest.Digest, info os.FileInfo) error) error {
+	root := filepath.Join(a.Dir, kind.String())
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		k, err := reflow.Digester.Parse(strings.Replace(rel, string(filepath.Separator), "", -1))
+		if err != nil {
+			// Skip temporary files.
+			return nil
+		}
+		return fn(path, k, info)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete deletes the mappings of all kinds for the key k.
+func (a *Assoc) Delete(ctx context.Context, k digest.Digest) error {
+	for _, kind := range kinds {
+		if err := os.Remove(a.path(kind, k)); err != nil && !os.IsNotExist(err) {
+			return errors.E("delete", k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package fileassoc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+)
+
+func TestAssoc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileassoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	a := &Assoc{Dir: dir}
+	if err := a.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		k = reflow.Digester.FromString("key")
+		v = reflow.Digester.FromString("value")
+		i = reflow.Digester.FromString("inspect")
+	)
+	if _, _, err := a.Get(ctx, assoc.Fileset, k); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("got %v, want not exist", err)
+	}
+	if err := a.Store(ctx, assoc.Fileset, k, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Store(ctx, assoc.ExecInspect, k, i); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := a.Get(ctx, assoc.Fileset, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %v, want %v", got, v)
+	}
+	if n, err := a.Count(ctx); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("got %v, want 2", n)
+	}
+
+	batch := make(assoc.Batch)
+	other := reflow.Digester.FromString("other")
+	batch.Add(assoc.Key{Kind: assoc.ExecInspect, Digest: k}, assoc.Key{Kind: assoc.Fileset, Digest: other})
+	if err := a.BatchGet(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	if !batch.Found(assoc.Key{Kind: assoc.ExecInspect, Digest: k}) || batch.Found(assoc.Key{Kind: assoc.Fileset, Digest: other}) {
+		t.Errorf("unexpected batch results %v", batch)
+	}
+
+	var scanned []digest.Digest
+	err = a.Scan(ctx, assoc.Fileset, assoc.MappingHandlerFunc(func(k digest.Digest, v []digest.Digest, _ assoc.Kind, lastAccess time.Time, _ []string) {
+		if lastAccess.IsZero() {
+			t.Errorf("%v: no last access time", k)
+		}
+		scanned = append(scanned, k, v[0])
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 2 || scanned[0] != k || scanned[1] != v {
+		t.Errorf("got %v, want [%v %v]", scanned, k, v)
+	}
+
+	if err := a.Delete(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []assoc.Kind{assoc.Fileset, assoc.ExecInspect} {
+		if _, _, err := a.Get(ctx, kind, k); !errors.Is(errors.NotExist, err) {
+			t.Errorf("%v: got %v, want not exist", kind, err)
+		}
+	}
+}
@@ -4,9 +4,9 @@ package assoc
 
 import "strconv"
 
-const _Kind_name = "FilesetExecInspectLogsBundle"
+const _Kind_name = "FilesetExecInspectLogsBundleEval"
 
-var _Kind_index = [...]uint8{0, 7, 18, 22, 28}
+var _Kind_index = [...]uint8{0, 7, 18, 22, 28, 32}
 
 func (i Kind) String() string {
 	if i < 0 || i >= Kind(len(_Kind_index)-1) {
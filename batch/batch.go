@@ -336,15 +336,8 @@ type BatchState struct {
 // Init initializes a batch. If reset is set to true, then previously saved state is discarded.
 // If retry is set, then only failed runs in the batch are retried. Init also upgrades old state files.
 func (b *Batch) Init(reset bool, retry bool) error {
-	var (
-		err error
-		f   *os.File
-	)
-	if f, err = os.Open(filepath.Join(b.Dir, b.ConfigFilename)); err != nil {
-		return err
-	}
-	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&b.config); err != nil {
+	var err error
+	if err = b.readConfig(); err != nil {
 		return err
 	}
 	// Digest the contents of the config file and the runs file to see if we are rerunning an
@@ -478,33 +471,72 @@ func (b *Batch) ReadState() error {
 	return nil
 }
 
-func (b *Batch) read(retry bool) error {
-	f, err := os.Open(b.path(b.config.RunsFile))
+// ReadRuns reads the batch's configuration and runs file and returns
+// the batch's runs, in runs file order, without initializing the
+// batch: only the returned runs' ID, Program, Args, and Argv are
+// defined. ReadRuns may be used to inspect a batch without modifying
+// its state.
+func (b *Batch) ReadRuns() ([]*Run, error) {
+	if err := b.readConfig(); err != nil {
+		return nil, err
+	}
+	return b.parseRuns()
+}
+
+func (b *Batch) readConfig() error {
+	f, err := os.Open(filepath.Join(b.Dir, b.ConfigFilename))
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	return json.NewDecoder(f).Decode(&b.config)
+}
+
+// parseRuns parses the batch's runs file.
+func (b *Batch) parseRuns() ([]*Run, error) {
+	f, err := os.Open(b.path(b.config.RunsFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 	r := csv.NewReader(f)
 	r.FieldsPerRecord = -1
 	records, err := r.ReadAll()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(records) < 1 {
-		return errors.New("empty batch")
+		return nil, errors.New("empty batch")
 	}
 	header := records[0]
 	records = records[1:]
-	runs := map[string]*Run{}
-	for _, fields := range records {
+	runs := make([]*Run, len(records))
+	for i, fields := range records {
 		if len(fields) != len(header) {
-			return errors.Errorf("batch file row [%v] has %v fields, need %v", fields, len(fields), len(header))
+			return nil, errors.Errorf("batch file row [%v] has %v fields, need %v", fields, len(fields), len(header))
 		}
-		id := fields[0]
 		attrs := map[string]string{}
 		for j := 1; j < len(header); j++ {
 			attrs[header[j]] = fields[j]
 		}
+		runs[i] = &Run{
+			ID:      fields[0],
+			Args:    attrs,
+			Argv:    fields[len(header):],
+			Program: b.path(b.config.Program),
+		}
+	}
+	return runs, nil
+}
+
+func (b *Batch) read(retry bool) error {
+	parsed, err := b.parseRuns()
+	if err != nil {
+		return err
+	}
+	runs := map[string]*Run{}
+	for _, p := range parsed {
+		id := p.ID
 		run := b.Runs[id]
 		if run == nil {
 			run = new(Run)
@@ -512,9 +544,9 @@ func (b *Batch) read(retry bool) error {
 			run.RunID = taskdb.NewRunID()
 		}
 		run.ID = id
-		run.Args = attrs
-		run.Argv = fields[len(header):]
-		run.Program = b.path(b.config.Program)
+		run.Args = p.Args
+		run.Argv = p.Argv
+		run.Program = p.Program
 		var prevRunID taskdb.RunID
 
 		if run.RunID.IsValid() {
@@ -0,0 +1,205 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+)
+
+// ContentAssertionsNamespace defines the namespace for content
+// assertions, which assert the digests of the contents of blob
+// objects. Content assertions are generated by a ContentGenerator.
+const ContentAssertionsNamespace = "blob-content"
+
+// ChecksumSuffix is the suffix of sidecar checksum objects. The
+// sidecar checksum object of an object contains the hex-encoded
+// SHA256 checksum of the object's contents, in the format produced
+// by sha256sum(1).
+const ChecksumSuffix = ".sha256"
+
+// maxChecksumSize is the largest sidecar checksum object that is read.
+const maxChecksumSize = 1 << 10
+
+// AssertContent is an Assert policy that accepts cached results whose
+// blob assertions (e.g., etags) differ from the current ones, so long
+// as the contents of the blob objects, as asserted in the content
+// namespace, are unchanged.
+var AssertContent = reflow.AssertEquivalent(AssertionsNamespace, ContentAssertionsNamespace)
+
+// ContentAssertions returns content assertions for a blob file. The
+// file's content digest is its ID (for resolved files), or else its
+// content hash. ContentAssertions returns nil if the file has no
+// source or its content digest is not known.
+func ContentAssertions(f reflow.File) *reflow.Assertions {
+	if f.Source == "" {
+		return nil
+	}
+	d := f.ContentHash
+	if !f.IsRef() {
+		d = f.ID
+	}
+	if d.IsZero() {
+		return nil
+	}
+	return contentAssertions(f.Source, d)
+}
+
+func contentAssertions(url string, d digest.Digest) *reflow.Assertions {
+	return reflow.AssertionsFromEntry(
+		reflow.AssertionKey{Subject: url, Namespace: ContentAssertionsNamespace},
+		map[string]string{"digest": d.String()})
+}
+
+// ContentGenerator implements the AssertionGenerator interface for
+// the content namespace. The content digest of an object is
+// determined, in order of preference, from: the content hash stored
+// with the object (as it is for objects written by reflow); the
+// object's sidecar checksum object (see ChecksumSuffix), so long as
+// it was not modified before the object itself; or else by reading
+// the object. Digests are cached by each object's URL and
+// etag, so that each version of an object is read at most once.
+type ContentGenerator struct {
+	// Mux is used to access blob objects.
+	Mux Mux
+
+	mu     sync.Mutex
+	cache  map[contentKey]digest.Digest
+	hits   int
+	misses int
+}
+
+type contentKey struct {
+	url, etag string
+	size      int64
+}
+
+// NewContentGenerator returns a new ContentGenerator that accesses
+// objects through the provided mux.
+func NewContentGenerator(mux Mux) *ContentGenerator {
+	return &ContentGenerator{Mux: mux, cache: make(map[contentKey]digest.Digest)}
+}
+
+// Generate implements the AssertionGenerator interface for the
+// content namespace.
+func (g *ContentGenerator) Generate(ctx context.Context, key reflow.AssertionKey) (*reflow.Assertions, error) {
+	if key.Namespace != ContentAssertionsNamespace {
+		return nil, fmt.Errorf("unsupported namespace: %v", key.Namespace)
+	}
+	d, err := g.Digest(ctx, key.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return contentAssertions(key.Subject, d), nil
+}
+
+// Digest returns the content digest of the object named by the
+// provided URL.
+func (g *ContentGenerator) Digest(ctx context.Context, url string) (digest.Digest, error) {
+	f, err := g.Mux.File(ctx, url)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if !f.ContentHash.IsZero() {
+		return f.ContentHash, nil
+	}
+	// Objects without etags cannot be identified across calls, and so
+	// their digests are not cached.
+	key := contentKey{url, f.ETag, f.Size}
+	g.mu.Lock()
+	d, ok := g.cache[key]
+	if ok {
+		g.hits++
+	} else {
+		g.misses++
+	}
+	g.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	if d, err = g.checksum(ctx, url, f); err != nil {
+		return digest.Digest{}, err
+	}
+	if d.IsZero() {
+		if d, err = g.compute(ctx, url, f.ETag); err != nil {
+			return digest.Digest{}, err
+		}
+	}
+	if f.ETag != "" {
+		g.mu.Lock()
+		g.cache[key] = d
+		g.mu.Unlock()
+	}
+	return d, nil
+}
+
+// Stats returns the number of digests that were served from the
+// generator's cache, and the number that were not.
+func (g *ContentGenerator) Stats() (hits, misses int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.hits, g.misses
+}
+
+// checksum returns the digest in the sidecar checksum object of the
+// object f, named by url, or a zero digest if there is none. Sidecar checksum
+// objects that are older than the object (e.g., because the object
+// has since been rewritten), or whose age relative to the object
+// cannot be determined, are stale, and are ignored.
+func (g *ContentGenerator) checksum(ctx context.Context, url string, f reflow.File) (digest.Digest, error) {
+	rc, sidecar, err := g.Mux.Get(ctx, url+ChecksumSuffix, "")
+	if errors.Is(errors.NotExist, err) {
+		return digest.Digest{}, nil
+	}
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if f.LastModified.IsZero() || sidecar.LastModified.Before(f.LastModified) {
+		rc.Close()
+		return digest.Digest{}, nil
+	}
+	defer rc.Close()
+	p, err := ioutil.ReadAll(io.LimitReader(rc, maxChecksumSize))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	fields := strings.Fields(string(p))
+	if len(fields) == 0 {
+		return digest.Digest{}, errors.E("blob.checksum", url+ChecksumSuffix, errors.Invalid, errors.New("empty checksum"))
+	}
+	d, err := reflow.Digester.Parse("sha256:" + strings.ToLower(fields[0]))
+	if err != nil {
+		return digest.Digest{}, errors.E("blob.checksum", url+ChecksumSuffix, errors.Invalid, err)
+	}
+	return d, nil
+}
+
+// compute computes the digest of the object named by url by reading
+// it. The object is read at the provided etag, if nonempty.
+func (g *ContentGenerator) compute(ctx context.Context, url, etag string) (digest.Digest, error) {
+	rc, f, err := g.Mux.Get(ctx, url, etag)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	defer rc.Close()
+	w := reflow.Digester.NewWriter()
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if f.Size > 0 && n != f.Size {
+		return digest.Digest{}, errors.E("blob.compute", url, errors.Integrity,
+			errors.Errorf("read %d bytes, expected %d", n, f.Size))
+	}
+	return w.Digest(), nil
+}
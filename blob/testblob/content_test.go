@@ -0,0 +1,165 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package testblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/blob"
+)
+
+func put(t *testing.T, m blob.Mux, url, contents string) {
+	t.Helper()
+	if err := m.Put(context.Background(), url, int64(len(contents)), bytes.NewReader([]byte(contents)), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentGenerator(t *testing.T) {
+	ctx := context.Background()
+	m := blob.Mux{"test": New("test")}
+	g := blob.NewContentGenerator(m)
+	const url = "test://bucket/file"
+	put(t, m, url, "hello world")
+	want := reflow.Digester.FromString("hello world")
+
+	key := reflow.AssertionKey{Subject: url, Namespace: blob.ContentAssertionsNamespace}
+	a, err := g.Generate(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantA := blob.ContentAssertions(reflow.File{ID: want, Source: url})
+	if !a.Equal(wantA) {
+		t.Errorf("got %v, want %v", a, wantA)
+	}
+	if _, err := g.Digest(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := g.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("got %v hits, %v misses, want 1, 1", hits, misses)
+	}
+
+	// Rewriting the object changes its etag, so its digest is recomputed.
+	put(t, m, url, "goodbye world")
+	d, err := g.Digest(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d, reflow.Digester.FromString("goodbye world"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, misses := g.Stats(); misses != 2 {
+		t.Errorf("got %v misses, want 2", misses)
+	}
+
+	if _, err := g.Generate(ctx, reflow.AssertionKey{Subject: url, Namespace: blob.AssertionsNamespace}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestContentGeneratorChecksum(t *testing.T) {
+	ctx := context.Background()
+	m := blob.Mux{"test": New("test")}
+	g := blob.NewContentGenerator(m)
+	const url = "test://bucket/file"
+	put(t, m, url, "hello world")
+	// The sidecar checksum is trusted over the object's contents.
+	sum := sha256.Sum256([]byte("some other content"))
+	put(t, m, url+blob.ChecksumSuffix, hex.EncodeToString(sum[:])+"  file\n")
+	d, err := g.Digest(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d, reflow.Digester.FromString("some other content"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	put(t, m, url, "hello again")
+	put(t, m, url+blob.ChecksumSuffix, "not a checksum")
+	if _, err := g.Digest(ctx, url); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestContentGeneratorStaleChecksum(t *testing.T) {
+	ctx := context.Background()
+	m := blob.Mux{"test": New("test")}
+	g := blob.NewContentGenerator(m)
+	const url = "test://bucket/file"
+	sum := sha256.Sum256([]byte("hello world"))
+	put(t, m, url, "hello world")
+	put(t, m, url+blob.ChecksumSuffix, hex.EncodeToString(sum[:])+"  file\n")
+	d, err := g.Digest(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d, reflow.Digester.FromString("hello world"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The object is rewritten, but its sidecar checksum is not: the
+	// stale checksum is ignored, and the digest is computed.
+	time.Sleep(time.Millisecond)
+	put(t, m, url, "goodbye world")
+	d, err = g.Digest(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d, reflow.Digester.FromString("goodbye world"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAssertContent(t *testing.T) {
+	ctx := context.Background()
+	m := blob.Mux{"test": New("test")}
+	g := reflow.AssertionGeneratorMux{
+		blob.AssertionsNamespace:        m,
+		blob.ContentAssertionsNamespace: blob.NewContentGenerator(m),
+	}
+	const url = "test://bucket/file"
+	put(t, m, url, "hello world")
+	f, err := m.File(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ID = reflow.Digester.FromString("hello world")
+	source := []*reflow.Assertions{blob.Assertions(f), blob.ContentAssertions(f)}
+	generate := func() []*reflow.Assertions {
+		t.Helper()
+		var target []*reflow.Assertions
+		for _, ns := range []string{blob.AssertionsNamespace, blob.ContentAssertionsNamespace} {
+			a, err := g.Generate(ctx, reflow.AssertionKey{Subject: url, Namespace: ns})
+			if err != nil {
+				t.Fatal(err)
+			}
+			target = append(target, a)
+		}
+		return target
+	}
+	if !blob.AssertContent(ctx, source, generate()) {
+		t.Error("expected unchanged object to be accepted")
+	}
+	// Testblob etags are checksums; we simulate a re-upload of
+	// identical content by changing the source's etag instead.
+	f.ETag = "reuploaded"
+	source[0] = blob.Assertions(f)
+	target := generate()
+	if reflow.AssertExact(ctx, source, target) {
+		t.Error("expected AssertExact to reject mismatched etags")
+	}
+	if !blob.AssertContent(ctx, source, target) {
+		t.Error("expected identical content to be accepted")
+	}
+	put(t, m, url, "goodbye world")
+	if blob.AssertContent(ctx, source, generate()) {
+		t.Error("expected changed content to be rejected")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/blob"
@@ -45,9 +46,10 @@ func (s *store) Bucket(ctx context.Context, name string) (blob.Bucket, error) {
 	b, ok := s.buckets[name]
 	if !ok {
 		b = &bucket{
-			name:    fmt.Sprintf("%s://%s/", s.scheme, name),
-			objects: make(map[string][]byte),
-			ids:     make(map[string]string),
+			name:     fmt.Sprintf("%s://%s/", s.scheme, name),
+			objects:  make(map[string][]byte),
+			ids:      make(map[string]string),
+			modified: make(map[string]time.Time),
 		}
 		s.buckets[name] = b
 	}
@@ -55,10 +57,11 @@ func (s *store) Bucket(ctx context.Context, name string) (blob.Bucket, error) {
 }
 
 type bucket struct {
-	name    string
-	mu      sync.Mutex
-	objects map[string][]byte
-	ids     map[string]string
+	name     string
+	mu       sync.Mutex
+	objects  map[string][]byte
+	ids      map[string]string
+	modified map[string]time.Time
 }
 
 func (b *bucket) get(key string) ([]byte, string, bool) {
@@ -71,10 +74,14 @@ func (b *bucket) get(key string) ([]byte, string, bool) {
 
 func (b *bucket) file(key string) (reflow.File, []byte, bool) {
 	p, _, ok := b.get(key)
+	b.mu.Lock()
+	modified := b.modified[key]
+	b.mu.Unlock()
 	return reflow.File{
-		Size:   int64(len(p)),
-		Source: fmt.Sprintf("%s/%s", b.name, key),
-		ETag:   fmt.Sprint(crc32.Checksum(p, crc32.IEEETable)),
+		Size:         int64(len(p)),
+		Source:       b.name + key,
+		ETag:         fmt.Sprint(crc32.Checksum(p, crc32.IEEETable)),
+		LastModified: modified,
 	}, p, ok
 }
 
@@ -159,6 +166,7 @@ func (b *bucket) Put(ctx context.Context, key string, size int64, body io.Reader
 	b.mu.Lock()
 	b.objects[key] = p
 	b.ids[key] = contentHash
+	b.modified[key] = time.Now()
 	b.mu.Unlock()
 	return nil
 }
@@ -219,6 +227,7 @@ func (b *bucket) Delete(ctx context.Context, keys ...string) error {
 	defer b.mu.Unlock()
 	for _, key := range keys {
 		delete(b.objects, key)
+		delete(b.modified, key)
 	}
 	return nil
 }
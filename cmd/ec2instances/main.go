@@ -99,6 +99,8 @@ func main() {
 	g.Printf("	VCPU uint\n")
 	g.Printf("	// Memory stores the number of (fractional) GiB of memory provided by this instance type.\n")
 	g.Printf("	Memory float64\n")
+	g.Printf("	// GPU stores the number of GPUs provided by this instance type.\n")
+	g.Printf("	GPU uint\n")
 	g.Printf("	// Price stores the on-demand price per region for this instance type.\n")
 	g.Printf("	Price map[string]float64\n")
 	g.Printf("	// Generation stores the generation name for this instance (\"current\" or \"previous\").\n")
@@ -179,6 +181,9 @@ func main() {
 		g.Printf("	EBSThroughput: %f,\n", e.EBSThroughput)
 		g.Printf("	VCPU: %v,\n", e.VCPU)
 		g.Printf("	Memory: %f,\n", e.Memory)
+		if e.GPU > 0 {
+			g.Printf("	GPU: %v,\n", e.GPU)
+		}
 		g.Printf("	Price: map[string]float64{\n")
 		var regions []string
 		for region := range e.Pricing {
@@ -258,6 +263,7 @@ type entry struct {
 	EBSOptimized  bool     `json:"ebs_optimized"`
 	EBSThroughput float64  `json:"ebs_throughput"`
 	Memory        float64  `json:"memory"`
+	GPU           uint     `json:"GPU"`
 	// VCPU must be an abstract, because "N/A" is returned
 	// for the "i3.metal" instance type.
 	VCPU          interface{}                       `json:"vCPU"`
@@ -17,15 +17,20 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/assoc"
 	_ "github.com/grailbio/reflow/assoc/dydbassoc"
+	_ "github.com/grailbio/reflow/assoc/fileassoc"
 	_ "github.com/grailbio/reflow/ec2cluster"
+	_ "github.com/grailbio/reflow/hostcluster"
 	infra2 "github.com/grailbio/reflow/infra"
 	_ "github.com/grailbio/reflow/localcluster"
 	"github.com/grailbio/reflow/log"
+	_ "github.com/grailbio/reflow/multicluster"
 	"github.com/grailbio/reflow/pool"
+	_ "github.com/grailbio/reflow/repository/filerepo"
 	_ "github.com/grailbio/reflow/repository/s3"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/taskdb"
 	_ "github.com/grailbio/reflow/taskdb/dynamodbtask"
+	"github.com/grailbio/reflow/tiered"
 	"github.com/grailbio/reflow/tool"
 	"github.com/grailbio/reflow/trace"
 	_ "github.com/grailbio/reflow/trace"
@@ -78,24 +83,28 @@ func main() {
 		},
 	}
 	cmd.Schema = infra.Schema{
-		infra2.AWSCreds:   new(credentials.Credentials),
-		infra2.Assoc:      new(assoc.Assoc),
-		infra2.AWSTool:    new(aws.AWSTool),
-		infra2.Cache:      new(infra2.CacheProvider),
-		infra2.Cluster:    new(runner.Cluster),
-		infra2.Labels:     make(pool.Labels),
-		infra2.Log:        new(log.Logger),
-		infra2.Bootstrap:  new(infra2.BootstrapImage),
-		infra2.Reflow:     new(infra2.ReflowVersion),
-		infra2.Reflowlet:  new(infra2.ReflowletConfig),
-		infra2.Repository: new(reflow.Repository),
-		infra2.Session:    new(session.Session),
-		infra2.SSHKey:     new(infra2.SshKey),
-		infra2.TLS:        new(tls.Certs),
-		infra2.Username:   new(infra2.User),
-		infra2.Tracer:     new(trace.Tracer),
-		infra2.TaskDB:     new(taskdb.TaskDB),
-		infra2.Docker:     new(infra2.DockerConfig),
+		infra2.AWSCreds:     new(credentials.Credentials),
+		infra2.Assoc:        new(assoc.Assoc),
+		infra2.AWSTool:      new(aws.AWSTool),
+		infra2.Cache:        new(infra2.CacheProvider),
+		infra2.Cluster:      new(runner.Cluster),
+		infra2.Labels:       make(pool.Labels),
+		infra2.Log:          new(log.Logger),
+		infra2.Bootstrap:    new(infra2.BootstrapImage),
+		infra2.Reflow:       new(infra2.ReflowVersion),
+		infra2.Reflowlet:    new(infra2.ReflowletConfig),
+		infra2.Repository:   new(reflow.Repository),
+		infra2.Session:      new(session.Session),
+		infra2.SSHKey:       new(infra2.SshKey),
+		infra2.TLS:          new(tls.Certs),
+		infra2.Username:     new(infra2.User),
+		infra2.Tracer:       new(trace.Tracer),
+		infra2.TaskDB:       new(taskdb.TaskDB),
+		infra2.Docker:       new(infra2.DockerConfig),
+		infra2.Budget:       new(infra2.BudgetConfig),
+		infra2.PoolAuth:     new(infra2.PoolAuthConfig),
+		infra2.AssertPolicy: new(infra2.AssertPolicyConfig),
+		infra2.Tiers:        new(tiered.Cache),
 	}
 	cmd.SchemaKeys = infra.Keys{
 		infra2.AWSCreds:  "awscreds",
@@ -19,6 +19,7 @@ package ec2cluster
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"sort"
@@ -464,6 +465,29 @@ func (c *Cluster) Available(need reflow.Resources) (InstanceSpec, bool) {
 	return InstanceSpec{config.Type, config.Resources}, ok
 }
 
+// Price returns the estimated hourly price, in US dollars, of the
+// provided alloc: the on-demand price in the cluster's region of the
+// cheapest configured instance type that provides the alloc's CPU,
+// memory, and GPUs. (Disk sizes are dynamic, and are not considered.)
+func (c *Cluster) Price(alloc pool.Alloc) (float64, bool) {
+	var (
+		need  = reflow.Resources{"cpu": alloc.Resources()["cpu"], "mem": alloc.Resources()["mem"]}
+		price = math.MaxFloat64
+		found bool
+	)
+	if gpu := alloc.Resources()["gpu"]; gpu > 0 {
+		need["gpu"] = gpu
+	}
+	for _, config := range c.instanceConfigs {
+		p, ok := config.Price[c.Region]
+		if !ok || p >= price || !config.Resources.Available(need) {
+			continue
+		}
+		price, found = p, true
+	}
+	return price, found
+}
+
 // Launch launches an EC2 instance based on the given spec and returns a ManagedInstance.
 func (c *Cluster) Launch(ctx context.Context, spec InstanceSpec) ManagedInstance {
 	config, ok := c.instanceConfigs[spec.Type]
@@ -149,6 +149,9 @@ func init() {
 			// Allocate one feature per VCPU.
 			instanceTypes[typ.Name].Resources[key] = float64(typ.VCPU)
 		}
+		if typ.GPU > 0 {
+			instanceTypes[typ.Name].Resources["gpu"] = float64(typ.GPU)
+		}
 	}
 }
 
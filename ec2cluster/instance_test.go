@@ -42,6 +42,42 @@ func TestInstanceState(t *testing.T) {
 	}
 }
 
+func TestInstanceStateGPU(t *testing.T) {
+	var instances []instanceConfig
+	for _, config := range instanceTypes {
+		config.Resources["disk"] = float64(2000 << 30)
+		instances = append(instances, config)
+	}
+	is := newInstanceState(instances, 1*time.Second, "us-west-2")
+	for _, tc := range []struct {
+		r                reflow.Resources
+		wantMin, wantMax string
+	}{
+		{reflow.Resources{"mem": 8 << 30, "cpu": 2, "gpu": 1}, "g4dn.xlarge", "p3dn.24xlarge"},
+		{reflow.Resources{"mem": 100 << 30, "cpu": 16, "gpu": 4}, "g4dn.12xlarge", "p3dn.24xlarge"},
+		{reflow.Resources{"mem": 60 << 30, "cpu": 8, "gpu": 8}, "p2.8xlarge", "p3dn.24xlarge"},
+	} {
+		for _, spot := range []bool{true, false} {
+			got, ok := is.MinAvailable(tc.r, spot)
+			if !ok {
+				t.Fatalf("no instance type for resources %v", tc.r)
+			}
+			if got.Type != tc.wantMin {
+				t.Errorf("got %v, want %v for spot %v, resources %v", got.Type, tc.wantMin, spot, tc.r)
+			}
+			if got.Resources["gpu"] < tc.r["gpu"] {
+				t.Errorf("instance type %v has %v GPUs, want at least %v", got.Type, got.Resources["gpu"], tc.r["gpu"])
+			}
+			if got, _ := is.MaxAvailable(tc.r, spot); got.Type != tc.wantMax {
+				t.Errorf("got %v, want %v for spot %v, resources %v", got.Type, tc.wantMax, spot, tc.r)
+			}
+		}
+	}
+	if _, ok := is.MinAvailable(reflow.Resources{"mem": 1 << 30, "cpu": 1, "gpu": 32}, false); ok {
+		t.Error("expected no instance type with 32 GPUs")
+	}
+}
+
 func TestInstanceStateLargest(t *testing.T) {
 	instances := newInstanceState(
 		[]instanceConfig{instanceTypes["c5.2xlarge"]},
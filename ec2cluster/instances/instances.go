@@ -14,6 +14,8 @@ type Type struct {
 	VCPU uint
 	// Memory stores the number of (fractional) GiB of memory provided by this instance type.
 	Memory float64
+	// GPU stores the number of GPUs provided by this instance type.
+	GPU uint
 	// Price stores the on-demand price per region for this instance type.
 	Price map[string]float64
 	// Generation stores the generation name for this instance ("current" or "previous").
@@ -947,6 +949,7 @@ var Types = []Type{
 		EBSThroughput: 218.750000,
 		VCPU:          8,
 		Memory:        61.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-northeast-1": 4.194,
 			"ap-northeast-2": 4.234,
@@ -1345,6 +1348,7 @@ var Types = []Type{
 		EBSThroughput: 1750.000000,
 		VCPU:          64,
 		Memory:        488.000000,
+		GPU:           4,
 		Price: map[string]float64{
 			"ap-northeast-1": 6.32,
 			"ap-northeast-2": 5.68,
@@ -1656,6 +1660,7 @@ var Types = []Type{
 		EBSThroughput: 1187.500000,
 		VCPU:          32,
 		Memory:        128.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-east-1":      3.351,
 			"ap-northeast-1": 2.938,
@@ -2505,6 +2510,7 @@ var Types = []Type{
 		EBSThroughput: 1750.000000,
 		VCPU:          64,
 		Memory:        488.000000,
+		GPU:           8,
 		Price: map[string]float64{
 			"ap-northeast-1": 33.552,
 			"ap-northeast-2": 33.872,
@@ -2929,6 +2935,7 @@ var Types = []Type{
 		EBSThroughput: 2375.000000,
 		VCPU:          96,
 		Memory:        768.000000,
+		GPU:           8,
 		Price: map[string]float64{
 			"ap-northeast-1": 42.783,
 			"eu-west-1":      33.711,
@@ -2951,6 +2958,7 @@ var Types = []Type{
 		EBSThroughput: 1250.000000,
 		VCPU:          64,
 		Memory:        732.000000,
+		GPU:           16,
 		Price: map[string]float64{
 			"ap-northeast-1": 24.672,
 			"ap-northeast-2": 23.44,
@@ -3350,6 +3358,7 @@ var Types = []Type{
 		EBSThroughput: 1187.500000,
 		VCPU:          64,
 		Memory:        256.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-east-1":      6.702,
 			"ap-northeast-1": 5.875,
@@ -3758,6 +3767,7 @@ var Types = []Type{
 		EBSThroughput: 625.000000,
 		VCPU:          32,
 		Memory:        488.000000,
+		GPU:           8,
 		Price: map[string]float64{
 			"ap-northeast-1": 12.336,
 			"ap-northeast-2": 11.72,
@@ -4638,6 +4648,7 @@ var Types = []Type{
 		EBSThroughput: 93.750000,
 		VCPU:          4,
 		Memory:        61.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-northeast-1": 1.542,
 			"ap-northeast-2": 1.465,
@@ -4993,6 +5004,7 @@ var Types = []Type{
 		EBSThroughput: 125.000000,
 		VCPU:          8,
 		Memory:        15.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-northeast-1": 0.898,
 			"ap-northeast-2": 0.898,
@@ -5372,6 +5384,7 @@ var Types = []Type{
 		EBSThroughput: 875.000000,
 		VCPU:          32,
 		Memory:        244.000000,
+		GPU:           2,
 		Price: map[string]float64{
 			"ap-northeast-1": 3.16,
 			"ap-northeast-2": 2.84,
@@ -5535,6 +5548,7 @@ var Types = []Type{
 		EBSThroughput: 1187.500000,
 		VCPU:          48,
 		Memory:        192.000000,
+		GPU:           4,
 		Price: map[string]float64{
 			"ap-east-1":      6.024,
 			"ap-northeast-1": 5.281,
@@ -6063,6 +6077,7 @@ var Types = []Type{
 		EBSThroughput: 437.500000,
 		VCPU:          4,
 		Memory:        16.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-east-1":      0.81,
 			"ap-northeast-1": 0.71,
@@ -6161,6 +6176,7 @@ var Types = []Type{
 		EBSThroughput: 437.500000,
 		VCPU:          16,
 		Memory:        122.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-northeast-1": 1.58,
 			"ap-northeast-2": 1.42,
@@ -6276,6 +6292,7 @@ var Types = []Type{
 		EBSThroughput: 0.000000,
 		VCPU:          32,
 		Memory:        60.000000,
+		GPU:           4,
 		Price: map[string]float64{
 			"ap-northeast-1": 3.592,
 			"ap-northeast-2": 3.592,
@@ -6339,6 +6356,7 @@ var Types = []Type{
 		EBSThroughput: 593.750000,
 		VCPU:          16,
 		Memory:        64.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-east-1":      1.854,
 			"ap-northeast-1": 1.625,
@@ -6548,6 +6566,7 @@ var Types = []Type{
 		EBSThroughput: 875.000000,
 		VCPU:          32,
 		Memory:        244.000000,
+		GPU:           4,
 		Price: map[string]float64{
 			"ap-northeast-1": 16.776,
 			"ap-northeast-2": 16.936,
@@ -7022,6 +7041,7 @@ var Types = []Type{
 		EBSThroughput: 437.500000,
 		VCPU:          8,
 		Memory:        32.000000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-east-1":      1.158,
 			"ap-northeast-1": 1.015,
@@ -7258,6 +7278,7 @@ var Types = []Type{
 		EBSThroughput: 106.250000,
 		VCPU:          4,
 		Memory:        30.500000,
+		GPU:           1,
 		Price: map[string]float64{
 			"ap-northeast-1": 1.04,
 			"ap-northeast-2": 0.934,
@@ -73,6 +73,12 @@ const (
 	Precondition
 	// OOM indicates a out-of-memory error.
 	OOM
+	// Preempted indicates that an operation was preempted, e.g.,
+	// because its host was about to be terminated.
+	Preempted
+	// BudgetExceeded indicates that an operation was halted because
+	// it exceeded its budget, e.g., of instance-hours or dollars.
+	BudgetExceeded
 
 	maxKind
 )
@@ -114,6 +120,10 @@ func (k Kind) String() string {
 		return "precondition was not met"
 	case OOM:
 		return "OOM error"
+	case Preempted:
+		return "preempted"
+	case BudgetExceeded:
+		return "budget exceeded"
 	}
 }
 
@@ -135,6 +145,8 @@ var kind2string = [maxKind]string{
 	Net:                "Net",
 	Precondition:       "Precondition",
 	OOM:                "OOM",
+	Preempted:          "Preempted",
+	BudgetExceeded:     "BudgetExceeded",
 }
 
 var string2kind = map[string]Kind{
@@ -155,6 +167,8 @@ var string2kind = map[string]Kind{
 	"Net":                Net,
 	"Precondition":       Precondition,
 	"OOM":                OOM,
+	"Preempted":          Preempted,
+	"BudgetExceeded":     BudgetExceeded,
 }
 
 // Error defines a Reflow error. It is used to indicate an error
@@ -112,6 +112,13 @@ type ExecConfig struct {
 	// OutputIsDir tells whether an output argument (by index)
 	// is a directory.
 	OutputIsDir []bool `json:",omitempty"`
+
+	// LazyInputs tells whether input arguments whose files are all
+	// references (see Fileset.RefURL) are passed to the command as
+	// their source URLs, instead of being loaded into the executor's
+	// repository, so that the command may read only the parts of the
+	// files that it needs.
+	LazyInputs bool `json:",omitempty"`
 }
 
 func (e ExecConfig) String() string {
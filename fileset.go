@@ -228,6 +228,34 @@ func (v Fileset) Flatten() []Fileset {
 	}
 }
 
+// RefURL returns the URL from which the contents of the (flat)
+// fileset v may be retrieved, provided that all of its files are
+// references. A file (a fileset with the single path ".") is
+// retrieved from its source. A directory is retrieved from the
+// common prefix of its files' sources, which must each be the
+// prefix followed by the file's path.
+func (v Fileset) RefURL() (string, bool) {
+	if v.List != nil || len(v.Map) == 0 {
+		return "", false
+	}
+	if file, ok := v.Map["."]; ok {
+		return file.Source, len(v.Map) == 1 && file.IsRef() && file.Source != ""
+	}
+	var prefix string
+	for path, file := range v.Map {
+		if !file.IsRef() || !strings.HasSuffix(file.Source, "/"+path) {
+			return "", false
+		}
+		p := strings.TrimSuffix(file.Source, path)
+		if prefix == "" {
+			prefix = p
+		} else if p != prefix {
+			return "", false
+		}
+	}
+	return prefix, true
+}
+
 // Files returns the set of Files that comprise the value.
 func (v Fileset) Files() []File {
 	fs := map[digest.Digest]File{}
@@ -209,6 +209,40 @@ func TestSubst(t *testing.T) {
 	}
 }
 
+func TestRefURL(t *testing.T) {
+	ref := func(source string) reflow.File {
+		return reflow.File{Source: source, ETag: "etag", Size: 1}
+	}
+	for _, tt := range []struct {
+		fs   reflow.Fileset
+		want string
+		ok   bool
+	}{
+		{reflow.Fileset{Map: map[string]reflow.File{".": ref("s3://bucket/x.bam")}}, "s3://bucket/x.bam", true},
+		{reflow.Fileset{Map: map[string]reflow.File{".": file1}}, "", false},
+		{reflow.Fileset{Map: map[string]reflow.File{
+			"a":   ref("s3://bucket/dir/a"),
+			"b/c": ref("s3://bucket/dir/b/c"),
+		}}, "s3://bucket/dir/", true},
+		{reflow.Fileset{Map: map[string]reflow.File{
+			"a": ref("s3://bucket/dir/a"),
+			"b": ref("s3://bucket/other/b"),
+		}}, "", false},
+		{reflow.Fileset{Map: map[string]reflow.File{
+			"a": ref("s3://bucket/dir/a"),
+			"b": file2,
+		}}, "", false},
+		{reflow.Fileset{Map: map[string]reflow.File{"a": ref("s3://bucket/dir/b")}}, "", false},
+		{reflow.Fileset{}, "", false},
+		{vlist, "", false},
+	} {
+		got, ok := tt.fs.RefURL()
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("%v: got %v, %v, want %v, %v", tt.fs, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
 func TestDiff(t *testing.T) {
 	for _, tt := range []struct {
 		a, b  reflow.Fileset
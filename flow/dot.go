@@ -2,17 +2,27 @@ package flow
 
 import (
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
 	"github.com/grailbio/reflow/log"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
 )
 
+// DigestComponentAttrPrefix prefixes the names of the dot attributes
+// that store a node's digest components.
+const DigestComponentAttrPrefix = "dc_"
+
 // Node is a flow node in the dot graph.
 type Node struct {
 	*Flow
+	// Analysis tells whether the node carries the attributes used to
+	// analyze the graph after the run has completed.
+	Analysis bool
 }
 
 // ID is the unique identifier for this node. Implements graph.Node
@@ -43,9 +53,17 @@ func (n Node) DOTID() string {
 	return msg
 }
 
-// Attributes implments encoding.Attributer.
+// Attributes implments encoding.Attributer. In addition to
+// rendering attributes, analysis nodes carry the flow's digest, op,
+// ident, source position, final evaluation state and runtime, as
+// well as the components of its digest (as attributes prefixed by
+// DigestComponentAttrPrefix), so that the graph can be analyzed
+// after the run has completed.
 func (n Node) Attributes() []encoding.Attribute {
 	var attrs []encoding.Attribute
+	if n.Analysis {
+		attrs = n.analysisAttributes()
+	}
 	if n.Op.External() {
 		switch n.ExecDepIncorrectCacheKeyBug {
 		case true:
@@ -59,6 +77,30 @@ func (n Node) Attributes() []encoding.Attribute {
 	return attrs
 }
 
+func (n Node) analysisAttributes() []encoding.Attribute {
+	attrs := []encoding.Attribute{
+		{Key: "digest", Value: n.Digest().String()},
+		{Key: "op", Value: n.Op.String()},
+		{Key: "ident", Value: n.Ident},
+		{Key: "position", Value: n.Position},
+		{Key: "state", Value: n.State.Name()},
+		{Key: "cached", Value: fmt.Sprintf("%t", n.Cached)},
+	}
+	if n.Runtime > 0 {
+		attrs = append(attrs, encoding.Attribute{Key: "runtime", Value: n.Runtime.String()})
+	}
+	if n.Err != nil {
+		attrs = append(attrs, encoding.Attribute{Key: "error", Value: n.Err.Error()})
+	}
+	for _, c := range n.DigestComponents() {
+		attrs = append(attrs, encoding.Attribute{
+			Key:   DigestComponentAttrPrefix + c.Name,
+			Value: strings.TrimSpace(c.Digest.String() + " " + c.Value),
+		})
+	}
+	return attrs
+}
+
 // Edge represents a dependency from a flow node to another.
 type Edge struct {
 	graph.Edge
@@ -81,8 +123,41 @@ func (e Edge) Attributes() []encoding.Attribute {
 func (e *Eval) printDeps(f *Flow, dynamic bool) {
 	for _, v := range f.Deps {
 		if !e.flowgraph.HasEdgeBetween(Node{Flow: f}.ID(), Node{Flow: v}.ID()) {
-			e.flowgraph.SetEdge(Edge{Edge: e.flowgraph.NewEdge(Node{f}, Node{v}), dynamic: dynamic})
+			e.flowgraph.SetEdge(Edge{Edge: e.flowgraph.NewEdge(Node{Flow: f}, Node{Flow: v}), dynamic: dynamic})
 		}
 		e.printDeps(v, dynamic)
 	}
 }
+
+// analysisGraph returns a copy of the flowgraph whose nodes carry
+// the attributes used for post-run analysis.
+func (e *Eval) analysisGraph() *simple.DirectedGraph {
+	g := simple.NewDirectedGraph()
+	for nodes := e.flowgraph.Nodes(); nodes.Next(); {
+		n := nodes.Node().(Node)
+		n.Analysis = true
+		g.AddNode(n)
+	}
+	for edges := e.flowgraph.Edges(); edges.Next(); {
+		edge := edges.Edge().(Edge)
+		from, to := g.Node(edge.From().ID()), g.Node(edge.To().ID())
+		g.SetEdge(Edge{Edge: g.NewEdge(from, to), dynamic: edge.dynamic})
+	}
+	return g
+}
+
+// writeDot writes the graph g to w in dot format, if w is non-nil.
+func (e *Eval) writeDot(w io.Writer, g *simple.DirectedGraph) {
+	if w == nil {
+		return
+	}
+	b, err := dot.Marshal(g, fmt.Sprintf("reflow flowgraph %v", e.EvalConfig.RunID.ID()), "", "")
+	if err != nil {
+		e.Log.Debugf("err dot marshal: %v", err)
+		return
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		e.Log.Debugf("err writing dot file: %v", err)
+	}
+}
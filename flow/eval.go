@@ -42,7 +42,6 @@ import (
 	"github.com/grailbio/reflow/values"
 	"github.com/willf/bloom"
 	"golang.org/x/sync/errgroup"
-	"gonum.org/v1/gonum/graph/encoding/dot"
 	"gonum.org/v1/gonum/graph/simple"
 )
 
@@ -113,6 +112,11 @@ type EvalConfig struct {
 	// DotWriter is an (optional) writer where the evaluator will write the flowgraph to in dot format.
 	DotWriter io.Writer
 
+	// EvalGraphWriter is an (optional) writer where the evaluator will
+	// write the flowgraph to in dot format, with its nodes annotated
+	// for post-run analysis (see Node.Attributes).
+	EvalGraphWriter io.Writer
+
 	// Status gets evaluation status reports.
 	Status *status.Group
 
@@ -136,6 +140,21 @@ type EvalConfig struct {
 	// Assert is the policy to use for asserting cached Assertions.
 	Assert reflow.Assert
 
+	// AssertPolicy, if set, is used instead of Assert to assert cached
+	// Assertions according to their subjects and namespaces.
+	// Assertions that the policy never asserts are neither checked for
+	// consistency nor refreshed, and the rules that validated or
+	// invalidated each cached result are logged.
+	AssertPolicy *reflow.AssertPolicy
+
+	// FileAssertions, if set, computes additional assertions for each
+	// file interned by the evaluation, e.g., the digests of the files'
+	// contents (see blob.ContentAssertions). These are added to the
+	// assertions of interned filesets, whether computed or cached,
+	// and are propagated to downstream execs, so that they may be
+	// used by the Assert policy.
+	FileAssertions func(reflow.File) *reflow.Assertions
+
 	// TaskDB is the db to which run/tasks information and keepalives are maintained.
 	TaskDB taskdb.TaskDB
 
@@ -165,6 +184,13 @@ type EvalConfig struct {
 	// PostUseChecksum indicates whether input filesets are checksummed after use.
 	PostUseChecksum bool
 
+	// VerifyDeterminism is the fraction of cache-missing execs that
+	// are re-executed, on a different alloc, to verify that they
+	// produce the same outputs. Execs whose outputs differ are
+	// recorded in the taskdb and reported by LogSummary. Verification
+	// is performed only in scheduler mode.
+	VerifyDeterminism float64
+
 	// Config stores the flow config to be used.
 	Config Config
 
@@ -182,8 +208,12 @@ type EvalConfig struct {
 	// results should be invalidated.
 	Invalidate func(f *Flow) bool
 
-	// Labels is the labels for this run.
+	// Labels is the labels for this run. The label "project", if
+	// present, names the project on whose behalf tasks are run.
 	Labels pool.Labels
+
+	// User is the user on whose behalf tasks are run.
+	User string
 }
 
 // String returns a human-readable form of the evaluation configuration.
@@ -251,6 +281,9 @@ func (e EvalConfig) String() string {
 	if e.PostUseChecksum {
 		flags = append(flags, "postusechecksum")
 	}
+	if e.VerifyDeterminism > 0 {
+		flags = append(flags, fmt.Sprintf("verifydeterminism=%g", e.VerifyDeterminism))
+	}
 	fmt.Fprintf(&b, " flags %s", strings.Join(flags, ","))
 	fmt.Fprintf(&b, " flowconfig %s", e.Config)
 	fmt.Fprintf(&b, " cachelookuptimeout %s", e.CacheLookupTimeout)
@@ -323,6 +356,13 @@ type Eval struct {
 	marshalLimiter *limiter.Limiter
 
 	flowgraph *simple.DirectedGraph
+
+	// mismatches are the execs found to be nondeterministic
+	// by determinism verification.
+	mismatches   []mismatch
+	mismatchesMu sync.Mutex
+	// verifies tracks the pending determinism verifications.
+	verifies sync.WaitGroup
 }
 
 // NewEval creates and initializes a new evaluator using the provided
@@ -463,16 +503,9 @@ func (e *Eval) Err() error {
 // repository (e.g., S3).
 func (e *Eval) Do(ctx context.Context) error {
 	defer func() {
-		if e.DotWriter != nil {
-			b, err := dot.Marshal(e.flowgraph, fmt.Sprintf("reflow flowgraph %v", e.EvalConfig.RunID.ID()), "", "")
-			if err != nil {
-				e.Log.Debugf("err dot marshal: %v", err)
-				return
-			}
-			_, err = e.DotWriter.Write(b)
-			if err != nil {
-				e.Log.Debugf("err writing dot file: %v", err)
-			}
+		e.writeDot(e.DotWriter, e.flowgraph)
+		if e.EvalGraphWriter != nil {
+			e.writeDot(e.EvalGraphWriter, e.analysisGraph())
 		}
 	}()
 	e.Log.Debugf("evaluating with configuration: %s", e.EvalConfig)
@@ -480,6 +513,9 @@ func (e *Eval) Do(ctx context.Context) error {
 	defer func() {
 		e.totalTime = time.Since(e.begin)
 	}()
+	// Pending determinism verifications (i.e., on error) are
+	// canceled, and waited for, so that they do not outlive Do.
+	defer e.verifies.Wait()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	e.ticker = time.NewTicker(10 * time.Second)
@@ -646,6 +682,7 @@ func (e *Eval) Do(ctx context.Context) error {
 				}
 				e.Mutate(f, Execing, Reserve(f.Resources))
 				task := e.newTask(f)
+				verify := e.newVerification(f)
 				tasks = append(tasks, task)
 				flows = append(flows, f)
 				e.step(f, func(f *Flow) error {
@@ -676,6 +713,14 @@ func (e *Eval) Do(ctx context.Context) error {
 						e.Mutate(f, Incr) // just so the cache write can decr it
 						e.cacheWriteAsync(ctx, f)
 					}
+					// Verifications do not hold up the flow's dependents.
+					if verify != nil && task.Err == nil && task.Result.Err == nil {
+						e.verifies.Add(1)
+						go func(task *sched.Task) {
+							defer e.verifies.Done()
+							e.verifyTask(ctx, verify, task)
+						}(task)
+					}
 					return nil
 				})
 			}
@@ -728,6 +773,9 @@ func (e *Eval) Do(ctx context.Context) error {
 			return err
 		}
 	}
+	// Wait for determinism verifications, so that their mismatches
+	// are reported.
+	e.verifies.Wait()
 	e.collect(ctx)
 	for _, f := range e.needLog {
 		e.LogFlow(ctx, f)
@@ -827,9 +875,10 @@ func (e *Eval) LogSummary(log *log.Logger) {
 		fmt.Fprint(&tw, "\n")
 	}
 	if len(warningIdents) > 0 {
-		fmt.Fprintf(&tw, "warning: reduce memory requirements for over-allocating execs: %s", strings.Join(warningIdents, ", "))
+		fmt.Fprintf(&tw, "warning: reduce memory requirements for over-allocating execs: %s\n", strings.Join(warningIdents, ", "))
 	}
 	tw.Flush()
+	e.writeMismatches(&b)
 	log.Printf(b.String())
 }
 
@@ -1664,6 +1713,13 @@ func (e *Eval) batchLookup(ctx context.Context, flows ...*Flow) {
 				e.lookupFailed(f)
 				return nil
 			}
+			if f.Op == Intern {
+				if err = e.addFileAssertions(&fs); err != nil {
+					e.Log.Debugf("file assertions: %v", err)
+					e.lookupFailed(f)
+					return nil
+				}
+			}
 			// If the cached fileset has viable non-empty assertions, assert them.
 			if a, size := reflow.NonEmptyAssertions(fs.Assertions()...); size > 0 {
 				// Check if the assertions are internally consistent for the cached fileset.
@@ -1678,7 +1734,7 @@ func (e *Eval) batchLookup(ctx context.Context, flows ...*Flow) {
 					e.lookupFailed(f)
 					return nil
 				}
-				if !e.Assert(ctx, a, anew) {
+				if !e.assert(ctx, f, a, anew) {
 					if e.Log.At(log.DebugLevel) {
 						if diff := reflow.PrettyDiff(a, anew); diff != "" {
 							e.Log.Debugf("flow %s assertions diff:\n%s\n", f.Digest().Short(), diff)
@@ -1762,7 +1818,11 @@ func (e *Eval) refreshAssertions(ctx context.Context, list []*reflow.Assertions,
 	for _, a := range list {
 		newA, missing := e.assertions.Filter(a)
 		refreshed = append(refreshed, newA)
-		toGenerate = append(toGenerate, missing...)
+		for _, k := range missing {
+			if e.AssertPolicy == nil || e.AssertPolicy.Asserts(k) {
+				toGenerate = append(toGenerate, k)
+			}
+		}
 	}
 	if len(toGenerate) == 0 {
 		refreshed, _ = reflow.NonEmptyAssertions(refreshed...)
@@ -1858,10 +1918,39 @@ func (e *Eval) assertionsConsistent(f *Flow, list []*reflow.Assertions) error {
 	// Add assertions of dependencies of flow.
 	as := f.depAssertions()
 	as = append(as, list...)
+	if e.AssertPolicy != nil {
+		as = e.AssertPolicy.Select(as)
+	}
 	_, err := reflow.MergeAssertions(as...)
 	return err
 }
 
+// assert asserts the target assertions of flow f's cached result
+// against its source assertions, using the configured AssertPolicy
+// if any, in which case the rules that validated or invalidated the
+// result are logged.
+func (e *Eval) assert(ctx context.Context, f *Flow, source, target []*reflow.Assertions) bool {
+	if e.AssertPolicy == nil {
+		return e.Assert(ctx, source, target)
+	}
+	results := e.AssertPolicy.Check(ctx, source, target)
+	if len(results) == 0 {
+		return true
+	}
+	ok := true
+	strs := make([]string, len(results))
+	for i, r := range results {
+		ok = ok && r.Ok
+		strs[i] = r.String()
+	}
+	verdict := "validated"
+	if !ok {
+		verdict = "invalidated"
+	}
+	e.Log.Printf("cached result of %s %s %s by: %s", f.Digest().Short(), f.Ident, verdict, strings.Join(strs, "; "))
+	return ok
+}
+
 // propagateAssertions propagates assertions from this flow's dependencies (if any)
 // to its output.  This must be called after the flow is computed but before
 // it is marked as Done.
@@ -1874,9 +1963,41 @@ func (e *Eval) propagateAssertions(f *Flow) error {
 	if !ok {
 		return nil
 	}
+	if f.Op == Intern {
+		if err := e.addFileAssertions(&fs); err != nil {
+			return err
+		}
+	}
 	return fs.AddAssertions(f.depAssertions()...)
 }
 
+// addFileAssertions adds the assertions computed by e.FileAssertions
+// (if any) to each of the files in the given fileset.
+func (e *Eval) addFileAssertions(fs *reflow.Fileset) error {
+	if e.FileAssertions == nil {
+		return nil
+	}
+	for i := range fs.List {
+		if err := e.addFileAssertions(&fs.List[i]); err != nil {
+			return err
+		}
+	}
+	for k, file := range fs.Map {
+		a := e.FileAssertions(file)
+		if a.IsEmpty() {
+			continue
+		}
+		if file.Assertions == nil {
+			file.Assertions = reflow.NewAssertions()
+		}
+		if err := file.Assertions.AddFrom(a); err != nil {
+			return err
+		}
+		fs.Map[k] = file
+	}
+	return nil
+}
+
 // exec performs and waits for an exec with the given config.
 // exec tries each step up to numExecTries. Exec returns a value
 // pointer which has been registered as live.
@@ -1903,6 +2024,14 @@ func (e *Eval) exec(ctx context.Context, f *Flow) error {
 		tctx    context.Context
 	)
 
+	// Only schedulers load exec inputs selectively; without one, lazy
+	// inputs would be silently loaded in full.
+	if f.LazyInputs {
+		e.Mutate(f, errors.E("exec", f.Ident, errors.NotSupported,
+			errors.New("lazy inputs require a scheduler")), Done)
+		return nil
+	}
+
 	// TODO(marius): we should distinguish between fatal and nonfatal errors.
 	// The fatal ones are useless to retry.
 
@@ -2476,6 +2605,10 @@ func (e *Eval) newTask(f *Flow) *sched.Task {
 	t.RunID = e.RunID
 	t.FlowID = f.Digest()
 	t.Config = f.ExecConfig()
+	t.Affinity = f.Affinity
+	t.AntiAffinity = f.AntiAffinity
+	t.User = e.User
+	t.Project = e.Labels["project"]
 	t.Log = e.Log.Tee(nil, fmt.Sprintf("scheduler task %s (flow %s): ", t.ID.IDShort(), t.FlowID.Short()))
 	return t
 }
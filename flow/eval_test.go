@@ -30,6 +30,7 @@ import (
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/repository/filerepo"
 	"github.com/grailbio/reflow/sched"
+	"github.com/grailbio/reflow/taskdb"
 	op "github.com/grailbio/reflow/test/flow"
 	"github.com/grailbio/reflow/test/testutil"
 	"github.com/grailbio/reflow/types"
@@ -111,6 +112,67 @@ func TestSimpleEval(t *testing.T) {
 	}
 }
 
+func TestEvalLazyInputsNoScheduler(t *testing.T) {
+	intern := op.Intern("internurl")
+	exec := op.Exec("image", "command", testutil.Resources, intern)
+	exec.LazyInputs = true
+	testutil.AssignExecId(nil, intern, exec)
+
+	e := testutil.Executor{Have: testutil.Resources}
+	e.Init()
+	eval := flow.NewEval(exec, flow.EvalConfig{
+		Executor: &e,
+		Log:      logger(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rc := testutil.EvalAsync(ctx, eval)
+	e.Ok(ctx, intern, testutil.Files("a/b/c"))
+	r := <-rc
+	if !errors.Is(errors.NotSupported, r.Err) {
+		t.Fatalf("got %v, want %v", r.Err, errors.NotSupported)
+	}
+	if e.Pending(exec) {
+		t.Error("lazy exec was run without a scheduler")
+	}
+}
+
+func TestEvalGraphWriter(t *testing.T) {
+	intern := op.Intern("internurl")
+	exec := op.Exec("image", "command", testutil.Resources, intern)
+	testutil.AssignExecId(nil, intern, exec)
+
+	e := testutil.Executor{Have: testutil.Resources}
+	e.Init()
+	var dotb, evalb bytes.Buffer
+	eval := flow.NewEval(exec, flow.EvalConfig{
+		Executor:        &e,
+		Log:             logger(),
+		DotWriter:       &dotb,
+		EvalGraphWriter: &evalb,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rc := testutil.EvalAsync(ctx, eval)
+	e.Ok(ctx, intern, testutil.Files("a/b/c"))
+	e.Ok(ctx, exec, testutil.Files("execout"))
+	if r := <-rc; r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	// Only the evaluation graph carries analysis attributes.
+	for _, attr := range []string{"digest=", "state=", flow.DigestComponentAttrPrefix} {
+		if strings.Contains(dotb.String(), attr) {
+			t.Errorf("dot graph contains %s:\n%s", attr, dotb.String())
+		}
+		if !strings.Contains(evalb.String(), attr) {
+			t.Errorf("evaluation graph does not contain %s:\n%s", attr, evalb.String())
+		}
+	}
+	if !strings.Contains(dotb.String(), "fillcolor") {
+		t.Errorf("dot graph is missing rendering attributes:\n%s", dotb.String())
+	}
+}
+
 func TestSimpleK(t *testing.T) {
 	runTestKWithN(t, 4, false)
 	runTestKWithN(t, 4, true)
@@ -713,6 +775,137 @@ func TestCacheLookupBottomupPhysical(t *testing.T) {
 	}
 }
 
+func TestCacheLookupWithFileAssertions(t *testing.T) {
+	const src = "s3://bucket/a"
+	// The source object was re-uploaded: its blob assertions changed but
+	// its content did not.
+	gen := reflow.AssertionGeneratorMux{
+		"blob":    newTestGenerator(map[string]string{src: "etag2"}),
+		"content": newTestGenerator(map[string]string{src: "d1"}),
+	}
+	fileAssertions := func(f reflow.File) *reflow.Assertions {
+		if f.Source == "" {
+			return nil
+		}
+		return reflow.AssertionsFromEntry(reflow.AssertionKey{f.Source, "content"}, map[string]string{"tag": "d1"})
+	}
+	for _, tt := range []struct {
+		assert reflow.Assert
+		cached bool
+	}{
+		{reflow.AssertExact, false},
+		{reflow.AssertEquivalent("blob", "content"), true},
+	} {
+		intern := op.Intern("internurl")
+		testutil.AssignExecId(nil, intern)
+
+		e := testutil.Executor{Have: testutil.Resources}
+		e.Init()
+		e.Repo = testutil.NewInmemoryRepository()
+		eval := flow.NewEval(intern, flow.EvalConfig{
+			Executor:           &e,
+			CacheMode:          infra.CacheRead | infra.CacheWrite,
+			Assoc:              testutil.NewInmemoryAssoc(),
+			AssertionGenerator: gen,
+			Assert:             tt.assert,
+			FileAssertions:     fileAssertions,
+			Repository:         testutil.NewInmemoryRepository(),
+			Transferer:         testutil.Transferer,
+			Log:                logger(),
+			Trace:              logger(),
+		})
+		fs := testutil.WriteFiles(eval.Repository, "a")
+		file := fs.Map["a"]
+		file.Source = src
+		file.Assertions = reflow.AssertionsFromEntry(reflow.AssertionKey{src, "blob"}, map[string]string{"tag": "etag1"})
+		fs.Map["a"] = file
+		testutil.WriteCacheFileset(eval, intern.Digest(), fs)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		rc := testutil.EvalAsync(ctx, eval)
+		var want []*flow.Flow
+		if !tt.cached {
+			e.Ok(ctx, intern, fs)
+			want = append(want, intern)
+		}
+		r := <-rc
+		cancel()
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if !e.Equiv(want...) {
+			t.Errorf("cached %v: wrong set of expected flows", tt.cached)
+		}
+		// The file assertions are added to the interned fileset, from
+		// which they are propagated to downstream execs.
+		a, err := reflow.MergeAssertions(r.Val.Assertions()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, missing := a.Filter(fileAssertions(file)); len(missing) > 0 {
+			t.Errorf("cached %v: missing assertions %v", tt.cached, missing)
+		}
+	}
+}
+
+func TestCacheLookupWithAssertPolicy(t *testing.T) {
+	policy := &reflow.AssertPolicy{
+		Rules:   []reflow.AssertRule{{Prefix: "s3://refs/", Policy: "never"}},
+		Default: reflow.AssertRule{Policy: "exact", Assert: reflow.AssertExact},
+	}
+	for _, tt := range []struct {
+		policy *reflow.AssertPolicy
+		tag    string
+		cached bool
+	}{
+		// Without a policy, the reference's assertions cannot be generated.
+		{nil, "v1", false},
+		{policy, "v1", true},
+		{policy, "v2", false},
+	} {
+		intern := op.Intern("internurl")
+		testutil.AssignExecId(nil, intern)
+
+		e := testutil.Executor{Have: testutil.Resources}
+		e.Init()
+		e.Repo = testutil.NewInmemoryRepository()
+		eval := flow.NewEval(intern, flow.EvalConfig{
+			Executor:           &e,
+			CacheMode:          infra.CacheRead | infra.CacheWrite,
+			Assoc:              testutil.NewInmemoryAssoc(),
+			AssertionGenerator: newTestGenerator(map[string]string{"s3://samples/b": tt.tag}),
+			Assert:             reflow.AssertExact,
+			AssertPolicy:       tt.policy,
+			Repository:         testutil.NewInmemoryRepository(),
+			Transferer:         testutil.Transferer,
+			Log:                logger(),
+			Trace:              logger(),
+		})
+		fs := testutil.WriteFiles(eval.Repository, "a", "b")
+		_ = fs.AddAssertions(reflow.AssertionsFromMap(map[reflow.AssertionKey]map[string]string{
+			{Subject: "s3://refs/a", Namespace: "error"}:   {"tag": "v1"},
+			{Subject: "s3://samples/b", Namespace: "blob"}: {"tag": "v1"},
+		}))
+		testutil.WriteCacheFileset(eval, intern.Digest(), fs)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		rc := testutil.EvalAsync(ctx, eval)
+		var want []*flow.Flow
+		if !tt.cached {
+			e.Ok(ctx, intern, fs)
+			want = append(want, intern)
+		}
+		r := <-rc
+		cancel()
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if !e.Equiv(want...) {
+			t.Errorf("policy %v, tag %s: wrong set of expected flows", tt.policy != nil, tt.tag)
+		}
+	}
+}
+
 func TestCacheLookupBottomupWithAssertions(t *testing.T) {
 	intern := op.Intern("internurl")
 	groupby := op.Groupby("(.*)", intern)
@@ -1278,16 +1471,42 @@ func (a *testAlloc) Unload(ctx context.Context, fs reflow.Fileset) error {
 // and returns the alloc to be scrutinized under this setup. The returned
 // config can be used to configure evaluation.
 func newTestScheduler() (alloc *testAlloc, config flow.EvalConfig, done func()) {
-	alloc = new(testAlloc)
-	alloc.Have.Scale(testutil.Resources, 2.0)
-	alloc.Repo = testutil.NewInmemoryRepository()
-	alloc.Init()
+	allocs, config, done := newTestSchedulerAllocs(1, nil)
+	return allocs[0], config, done
+}
+
+// TestAllocs is a sched.Cluster that hands out each of its allocs
+// once.
+type testAllocs []*testAlloc
+
+func (c testAllocs) Allocate(ctx context.Context, req reflow.Requirements, labels pool.Labels) (pool.Alloc, error) {
+	for _, a := range c {
+		if alloc, err := a.Allocate(ctx, req, labels); err == nil {
+			return alloc, nil
+		}
+	}
+	return nil, errors.E(errors.ResourcesExhausted)
+}
+
+// NewTestSchedulerAllocs is like newTestScheduler, but its scheduler
+// is given n allocs, which share a repository, and records its tasks
+// in tdb, if not nil.
+func newTestSchedulerAllocs(n int, tdb taskdb.TaskDB) (allocs testAllocs, config flow.EvalConfig, done func()) {
+	repo := testutil.NewInmemoryRepository()
+	for i := 0; i < n; i++ {
+		alloc := new(testAlloc)
+		alloc.Have.Scale(testutil.Resources, 2.0)
+		alloc.Repo = repo
+		alloc.Init()
+		allocs = append(allocs, alloc)
+	}
 
 	sched := sched.New()
 	sched.Transferer = testutil.Transferer
-	sched.Repository = alloc.Repo
-	sched.Cluster = alloc
+	sched.Repository = repo
+	sched.Cluster = allocs
 	sched.MinAlloc = reflow.Resources{}
+	sched.TaskDB = tdb
 	ctx, cancel := context.WithCancel(context.Background())
 
 	var wg sync.WaitGroup
@@ -1546,6 +1765,165 @@ func TestSchedulerSubmit(t *testing.T) {
 	_ = e.Exec(ctx, exec2)
 }
 
+// mismatchTaskDB is a taskdb that records the tasks that are created,
+// and the errors with which they are last completed.
+type mismatchTaskDB struct {
+	taskdb.TaskDB
+	mu      sync.Mutex
+	created map[taskdb.TaskID]bool
+	errs    map[taskdb.TaskID]error
+}
+
+func newMismatchTaskDB() *mismatchTaskDB {
+	return &mismatchTaskDB{
+		TaskDB:  testutil.NewNopTaskDB(),
+		created: make(map[taskdb.TaskID]bool),
+		errs:    make(map[taskdb.TaskID]error),
+	}
+}
+
+func (db *mismatchTaskDB) CreateTask(ctx context.Context, id taskdb.TaskID, runID taskdb.RunID, flowID digest.Digest, imgCmdID taskdb.ImgCmdID, ident, uri string) error {
+	db.mu.Lock()
+	db.created[id] = true
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *mismatchTaskDB) SetTaskComplete(ctx context.Context, id taskdb.TaskID, err error, end time.Time) error {
+	db.mu.Lock()
+	if err != nil {
+		db.errs[id] = err
+	} else {
+		delete(db.errs, id)
+	}
+	db.mu.Unlock()
+	return nil
+}
+
+func TestVerifyDeterminism(t *testing.T) {
+	for _, deterministic := range []bool{true, false} {
+		tdb := newMismatchTaskDB()
+		allocs, config, done := newTestSchedulerAllocs(2, tdb)
+		config.TaskDB = tdb
+		config.VerifyDeterminism = 1
+		exec := op.Exec("ubuntu", "command", testutil.Resources)
+		testutil.AssignExecIdRandom(exec)
+
+		eval := flow.NewEval(exec, config)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		rc := testutil.EvalAsync(ctx, eval)
+		// The exec runs on the first alloc, and is then re-executed on
+		// the second.
+		first, second := allocs[0], allocs[1]
+		want := testutil.WriteFiles(first.Repo, "out")
+		first.Ok(ctx, exec, want)
+		// The verifying exec is run under its own task ID.
+		var execs []reflow.Exec
+		for len(execs) == 0 {
+			time.Sleep(10 * time.Millisecond)
+			execs, _ = second.Execs(ctx)
+		}
+		// The evaluation waits for the verification to complete.
+		select {
+		case <-rc:
+			t.Fatal("evaluation completed before verification")
+		case <-time.After(50 * time.Millisecond):
+		}
+		got := want
+		if !deterministic {
+			got = testutil.WriteFiles(second.Repo, "other")
+		}
+		execs[0].(*testutil.Exec).Ok(reflow.Result{Fileset: got})
+		r := <-rc
+		cancel()
+		done()
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if got := r.Val; !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var b bytes.Buffer
+		eval.LogSummary(log.New(golog.New(&b, "", 0), log.InfoLevel))
+		if got, want := strings.Contains(b.String(), "nondeterministic execs n=1"), !deterministic; got != want {
+			t.Errorf("deterministic %v: got report %v, want %v:\n%s", deterministic, got, want, b.String())
+		}
+		if !deterministic && !strings.Contains(b.String(), "ubuntu") {
+			t.Errorf("report does not name the exec's image:\n%s", b.String())
+		}
+		tdb.mu.Lock()
+		// Only the exec's task and its verification task are recorded;
+		// a mismatch is recorded on the verification task.
+		if got, want := len(tdb.created), 2; got != want {
+			t.Errorf("deterministic %v: got %d tasks, want %d", deterministic, got, want)
+		}
+		if got, want := len(tdb.errs), 0; !deterministic {
+			want = 1
+			if got != want {
+				t.Errorf("deterministic %v: got taskdb errors %v, want one integrity error", deterministic, tdb.errs)
+			}
+			for id, err := range tdb.errs {
+				if !tdb.created[id] || !errors.Is(errors.Integrity, err) {
+					t.Errorf("deterministic %v: task %v: got error %v, want an integrity error on a created task", deterministic, id, err)
+				}
+			}
+		} else if got != want {
+			t.Errorf("deterministic %v: got taskdb errors %v, want none", deterministic, tdb.errs)
+		}
+		tdb.mu.Unlock()
+	}
+}
+
+// syncBuffer is a bytes.Buffer that may be written concurrently.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestVerifyDeterminismError(t *testing.T) {
+	allocs, config, done := newTestSchedulerAllocs(2, nil)
+	defer done()
+	var b syncBuffer
+	config.Log = log.New(golog.New(&b, "", 0), log.InfoLevel)
+	config.VerifyDeterminism = 1
+	ok, failed := op.Exec("ubuntu", "ok", testutil.Resources), op.Exec("ubuntu", "failed", testutil.Resources)
+	testutil.AssignExecIdRandom(ok, failed)
+
+	eval := flow.NewEval(op.Merge(ok, failed), config)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rc := testutil.EvalAsync(ctx, eval)
+	first, second := allocs[0], allocs[1]
+	first.Ok(ctx, ok, testutil.WriteFiles(first.Repo, "out"))
+	for {
+		if execs, _ := second.Execs(ctx); len(execs) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The evaluation fails while the verification is pending: the
+	// verification is canceled before the evaluation returns.
+	first.Ok(ctx, failed, errors.New("failed"))
+	if r := <-rc; r.Err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(b.String(), "verify determinism") {
+		t.Errorf("evaluation returned before its verification was canceled:\n%s", b.String())
+	}
+}
+
 func TestRefreshAssertionBatchCache(t *testing.T) {
 	torefresh := make([]*reflow.Assertions, 100)
 	for i := 0; i < len(torefresh); i++ {
@@ -17,6 +17,7 @@ import (
 	"math"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time" // This is imported for the sha256 implementation, which is always required for Reflow.
@@ -42,11 +43,20 @@ type Config struct {
 	// HashV1 should be set to true if the flow should use the legacy
 	// "v1" hash algorithm.
 	HashV1 bool
+
+	// FuseExecs should be set to true if chains of execs, where
+	// each exec's output is consumed only by the next, should be
+	// fused into single execs that run on the same alloc. The
+	// intermediate outputs of fused execs are not stored in the
+	// repository, and fused execs are cached under digests that
+	// differ from those of the execs they are fused from.
+	FuseExecs bool
 }
 
 // Merge merges config d into config c.
 func (c *Config) Merge(d Config) {
 	c.HashV1 = c.HashV1 || d.HashV1
+	c.FuseExecs = c.FuseExecs || d.FuseExecs
 }
 
 // IsZero tells whether this config stores any non-default config.
@@ -56,10 +66,14 @@ func (c Config) IsZero() bool {
 
 // String returns a summary of the configuration c.
 func (c Config) String() string {
+	s := "hashv2"
 	if c.HashV1 {
-		return "hashv1"
+		s = "hashv1"
+	}
+	if c.FuseExecs {
+		s += ",fuseexecs"
 	}
-	return "hashv2"
+	return s
 }
 
 // Op is an enum representing operations that may be
@@ -263,6 +277,16 @@ type Flow struct {
 	Argmap []ExecArg
 	// OutputIsDir tells whether the output i is a directory.
 	OutputIsDir []bool
+	// Affinity is the exec's affinity group. Execs in the same
+	// affinity group are preferably scheduled onto the same alloc.
+	Affinity string
+	// AntiAffinity is the exec's anti-affinity group. Execs in the
+	// same anti-affinity group are never scheduled onto the same alloc.
+	AntiAffinity string
+	// LazyInputs tells whether the exec's inputs that are file
+	// references are passed to it by URL (see
+	// reflow.ExecConfig.LazyInputs).
+	LazyInputs bool
 
 	// Original fields if this Flow was rewritten with canonical values.
 	OriginalImage string
@@ -472,6 +496,9 @@ func (f *Flow) Fork(flow *Flow) {
 	f.Argmap = flow.Argmap
 	f.Coerce = flow.Coerce
 	f.OutputIsDir = flow.OutputIsDir
+	f.Affinity = flow.Affinity
+	f.AntiAffinity = flow.AntiAffinity
+	f.LazyInputs = flow.LazyInputs
 	f.Err = flow.Err
 }
 
@@ -812,6 +839,7 @@ func (f *Flow) ExecConfig() reflow.ExecConfig {
 			Args:             args,
 			Resources:        reserved,
 			OutputIsDir:      outputIsDir,
+			LazyInputs:       f.LazyInputs,
 		}
 	default:
 		panic("no exec config for op " + f.Op.String())
@@ -862,6 +890,10 @@ func (f *Flow) computeDigest() {
 	f.digest = w.Digest()
 }
 
+// lazyInputsDigestString is digested for execs whose inputs are
+// passed lazily, since commands are passed URLs instead of paths.
+const lazyInputsDigestString = "lazyinputs"
+
 func must(n int, err error) {
 	if err != nil {
 		panic(err)
@@ -910,6 +942,9 @@ func (f *Flow) WriteDigest(w io.Writer) {
 				writeN(w, arg.Index)
 			}
 		}
+		if f.LazyInputs {
+			io.WriteString(w, lazyInputsDigestString)
+		}
 	case Groupby:
 		io.WriteString(w, f.Re.String())
 	case Map:
@@ -951,6 +986,145 @@ func (f *Flow) WriteDigest(w io.Writer) {
 	}
 }
 
+// DigestComponent is a single component of a flow's digest.
+type DigestComponent struct {
+	// Name names the component. Dependencies are named "depN" (or
+	// "argN" for exec arguments), and the files of a fileset value are
+	// named "fileN", where N is their index.
+	Name string
+	// Digest is the digest of the component's material. For
+	// dependencies, it is the dependency's flow digest.
+	Digest digest.Digest
+	// Value is a human-readable (and possibly abbreviated)
+	// representation of the component.
+	Value string
+}
+
+// IsDep tells whether the component is a dependency's digest.
+func (c DigestComponent) IsDep() bool {
+	return strings.HasPrefix(c.Name, "dep") || strings.HasPrefix(c.Name, "arg")
+}
+
+// Material tells whether the component is part of the flow's
+// digest. Components that are not (resources) are informational.
+func (c DigestComponent) Material() bool {
+	return c.Name != "resources"
+}
+
+// maxFileComponents is the maximum number of files in a fileset value
+// for which individual digest components are returned.
+const maxFileComponents = 64
+
+// DigestComponents returns the components of the material digested
+// by WriteDigest, so that changes in a flow's digest can be
+// attributed to the component responsible. The flow's resources are
+// returned too, as a non-material component. Flows that delegate
+// their digest (requirements and forked flows) return the components
+// of their delegate.
+func (f *Flow) DigestComponents() []DigestComponent {
+	if f.Op == Requirements {
+		return f.Deps[0].DigestComponents()
+	}
+	if p := f.Parent; p != nil {
+		return p.DigestComponents()
+	}
+	var cs []DigestComponent
+	str := func(name, value string) {
+		cs = append(cs, DigestComponent{name, Digester.FromString(value), lim64(value)})
+	}
+	if Universe != "" {
+		str("universe", Universe)
+	}
+	args := make(map[int]int)
+	switch {
+	case f.Op != Exec:
+	case f.Argmap == nil:
+		// Dependencies map directly to arguments (see setArgmap).
+		for i := range f.Deps {
+			args[i] = i
+		}
+	default:
+		for i, arg := range f.Argmap {
+			if !arg.Out {
+				args[arg.Index] = i
+			}
+		}
+	}
+	for i, dep := range f.Deps {
+		name := fmt.Sprintf("dep%d", i)
+		if j, ok := args[i]; ok {
+			name = fmt.Sprintf("arg%d", j)
+		}
+		cs = append(cs, DigestComponent{name, dep.Digest(), fmt.Sprintf("%s %s", dep.Op, dep.Ident)})
+	}
+	str("op", f.Op.DigestString())
+	switch f.Op {
+	case Intern, Extern:
+		str("url", f.URL.String())
+	case Exec:
+		str("image", f.Image)
+		str("cmd", f.Cmd)
+		var b strings.Builder
+		for i, arg := range f.Argmap {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			if arg.Out {
+				fmt.Fprintf(&b, "out%d", arg.Index)
+			} else {
+				fmt.Fprintf(&b, "%d", arg.Index)
+			}
+		}
+		str("argmap", b.String())
+		if f.LazyInputs {
+			str("lazyinputs", lazyInputsDigestString)
+		}
+	case Groupby:
+		str("regexp", f.Re.String())
+	case Map:
+		cs = append(cs, DigestComponent{"mapflow", f.MapFlow.Digest(), f.MapFlow.Ident})
+	case Collect:
+		str("regexp", f.Re.String())
+		str("repl", f.Repl)
+	case Val:
+		switch v := f.Value.(type) {
+		case nil:
+			if f.Err != nil {
+				// Erroneous values have scrambled digests.
+				str("error", f.Err.Error())
+			} else {
+				cs = append(cs, DigestComponent{"value", f.FlowDigest, ""})
+			}
+		case reflow.Fileset:
+			cs = append(cs, DigestComponent{"value", v.Digest(), fmt.Sprintf("fileset of %d files", v.N())})
+			if len(v.Map) > 0 && len(v.Map) <= maxFileComponents {
+				paths := make([]string, 0, len(v.Map))
+				for path := range v.Map {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+				for i, path := range paths {
+					file := v.Map[path]
+					cs = append(cs, DigestComponent{fmt.Sprintf("file%d", i), file.Digest(), lim64(fmt.Sprintf("%s (%s)", path, file))})
+				}
+			}
+		default:
+			cs = append(cs, DigestComponent{"value", f.FlowDigest, ""})
+		}
+	case K, Kctx, Coerce:
+		cs = append(cs, DigestComponent{"flowdigest", f.FlowDigest, ""})
+	case Data:
+		cs = append(cs, DigestComponent{"data", Digester.FromBytes(f.Data), fmt.Sprintf("%d bytes", len(f.Data))})
+	}
+	if !f.ExtraDigest.IsZero() {
+		cs = append(cs, DigestComponent{"extra", f.ExtraDigest, ""})
+	}
+	if f.Resources != nil {
+		str("resources", f.Resources.String())
+	}
+	return cs
+}
+
 // PhysicalDigest returns the digest for this node substituting the
 // image name in the node with the provided one, if an exec node.
 func (f *Flow) physicalDigest(image string) digest.Digest {
@@ -972,6 +1146,9 @@ func (f *Flow) physicalDigest(image string) digest.Digest {
 				writeN(w, arg.Index)
 			}
 		}
+		if f.LazyInputs {
+			io.WriteString(w, lazyInputsDigestString)
+		}
 	}
 	if !f.ExtraDigest.IsZero() {
 		digest.WriteDigest(w, f.ExtraDigest)
@@ -1034,7 +1211,16 @@ func (f *Flow) Visitor() *FlowVisitor {
 // semantically equivalent flows (as per Flow.Digest) are collapsed
 // into one.
 func (f *Flow) Canonicalize(config Config) *Flow {
-	return f.canonicalize(newFlowMap(), config)
+	return f.canonicalizeGraph(newFlowMap(), config)
+}
+
+// canonicalizeGraph canonicalizes the newly created graph rooted at
+// f, first fusing its execs if the config so requires.
+func (f *Flow) canonicalizeGraph(m *flowMap, config Config) *Flow {
+	if config.FuseExecs {
+		f = fuseExecs(f, m)
+	}
+	return f.canonicalize(m, config)
 }
 
 func (f *Flow) canonicalize(m *flowMap, config Config) *Flow {
@@ -1051,14 +1237,14 @@ func (f *Flow) canonicalize(m *flowMap, config Config) *Flow {
 	if f.MapFunc != nil {
 		orig := f.MapFunc
 		f.MapFunc = func(flow *Flow) *Flow {
-			return orig(flow.canonicalize(m, config)).canonicalize(m, config)
+			return orig(flow.canonicalize(m, config)).canonicalizeGraph(m, config)
 		}
 		f.MapInit()
 	}
 	if f.K != nil {
 		orig := f.K
 		f.K = func(vs []values.T) *Flow {
-			return orig(vs).canonicalize(m, config)
+			return orig(vs).canonicalizeGraph(m, config)
 		}
 	}
 	return m.Put(f)
@@ -1111,10 +1297,13 @@ func (v *FlowVisitor) Walk() bool {
 type flowMap struct {
 	sync.Mutex
 	flows map[digest.Digest]*Flow
+	// fused stores the digests of the execs that have been fused
+	// into their consumers (see fuseExecs).
+	fused map[digest.Digest]bool
 }
 
 func newFlowMap() *flowMap {
-	return &flowMap{flows: map[digest.Digest]*Flow{}}
+	return &flowMap{flows: map[digest.Digest]*Flow{}, fused: map[digest.Digest]bool{}}
 }
 
 var mustInternDigest = reflow.Digester.FromString("internMustInternDigest")
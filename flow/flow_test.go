@@ -7,12 +7,14 @@ package flow_test
 import (
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/flow"
 	op "github.com/grailbio/reflow/test/flow"
 	"github.com/grailbio/reflow/test/testutil"
+	"github.com/grailbio/reflow/values"
 )
 
 func mustParseURL(s string) *url.URL {
@@ -201,3 +203,154 @@ func TestImageQualifiers(t *testing.T) {
 		}
 	}
 }
+
+func TestDigestComponents(t *testing.T) {
+	val := op.Val(reflow.Fileset{Map: map[string]reflow.File{
+		"R1.fastq": {Source: "s3://bucket/R1.fastq", ETag: "a"},
+	}})
+	exec := op.Exec("image@sha256:aaa", "cmd %s", reflow.Resources{"mem": 10}, val)
+	names := make(map[string]flow.DigestComponent)
+	for _, c := range exec.DigestComponents() {
+		names[c.Name] = c
+	}
+	for _, name := range []string{"arg0", "op", "image", "cmd", "argmap", "resources"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("missing component %s", name)
+		}
+	}
+	if got, want := names["arg0"].Digest, val.Digest(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !names["arg0"].IsDep() || names["image"].IsDep() {
+		t.Error("bad dependency components")
+	}
+	if names["resources"].Material() {
+		t.Error("resources are not part of the digest")
+	}
+
+	// Changing a file's etag changes the value's file component.
+	val2 := op.Val(reflow.Fileset{Map: map[string]reflow.File{
+		"R1.fastq": {Source: "s3://bucket/R1.fastq", ETag: "b"},
+	}})
+	c1, c2 := val.DigestComponents(), val2.DigestComponents()
+	if got, want := len(c1), len(c2); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var diffs []string
+	for i := range c1 {
+		if c1[i].Digest != c2[i].Digest {
+			diffs = append(diffs, c1[i].Name)
+		}
+	}
+	if got, want := diffs, []string{"value", "file0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// fusable returns an exec of a single file, with a single file
+// output, whose input is the output of exec dep, coerced as it is by
+// reflow's evaluator.
+func fusable(image, cmd string, dep *flow.Flow) *flow.Flow {
+	dep = &flow.Flow{
+		Op:         flow.Coerce,
+		Deps:       []*flow.Flow{dep},
+		FlowDigest: reflow.Digester.FromString("output"),
+		Coerce: func(v values.T) (values.T, error) {
+			return v.(reflow.Fileset).List[0].Map["."], nil
+		},
+	}
+	dep = &flow.Flow{
+		Op:         flow.Coerce,
+		Deps:       []*flow.Flow{dep},
+		FlowDigest: reflow.Digester.FromString("input"),
+		Coerce: func(v values.T) (values.T, error) {
+			return reflow.Fileset{Map: map[string]reflow.File{".": v.(reflow.File)}}, nil
+		},
+	}
+	return &flow.Flow{
+		Op:          flow.Exec,
+		Image:       image,
+		Cmd:         cmd,
+		Deps:        []*flow.Flow{dep},
+		Argmap:      []flow.ExecArg{{Index: 0}, {Out: true, Index: 0}},
+		OutputIsDir: []bool{false},
+		Resources:   reflow.Resources{"mem": 10, "cpu": 1, "disk": 10},
+	}
+}
+
+func TestFuseExecs(t *testing.T) {
+	in := op.Val(reflow.Fileset{Map: map[string]reflow.File{".": {ID: reflow.Digester.FromString("in")}}})
+	view := &flow.Flow{
+		Op:          flow.Exec,
+		Image:       "samtools",
+		Cmd:         "samtools view %s > %s",
+		Deps:        []*flow.Flow{in},
+		Argmap:      []flow.ExecArg{{Index: 0}, {Out: true, Index: 0}},
+		OutputIsDir: []bool{false},
+		Resources:   reflow.Resources{"mem": 20, "cpu": 1, "disk": 30},
+	}
+	sort := fusable("samtools", "samtools sort %s > %s", view)
+	index := fusable("samtools", "samtools index %s %s", sort)
+
+	if got, want := index.Canonicalize(flow.Config{}), index; got.Digest() != want.Digest() || len(got.Deps) != 1 || got.Deps[0].Op != flow.Coerce {
+		t.Fatalf("execs fused without config: %s", got.Cmd)
+	}
+
+	fused := index.Canonicalize(flow.Config{FuseExecs: true})
+	if got, want := fused.Op, flow.Exec; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := fused.Deps, []*flow.Flow{in}; len(got) != 1 || got[0].Digest() != want[0].Digest() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := fused.Argmap, []flow.ExecArg{{Index: 0}, {Out: true, Index: 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The execs' disk is summed, since their outputs are all kept.
+	if got, want := fused.Resources, (reflow.Resources{"mem": 20, "cpu": 1, "disk": 50}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The commands are run in order, each reading its predecessor's
+	// output from the scratch directory.
+	lines := strings.Split(fused.Cmd, "\n")
+	var cmds []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "samtools") {
+			cmds = append(cmds, line)
+		}
+	}
+	if len(cmds) != 3 {
+		t.Fatalf("bad fused command %q", fused.Cmd)
+	}
+	if !strings.HasPrefix(cmds[0], "samtools view %s > /tmp/reflow-fuse-") {
+		t.Errorf("bad view command %q", cmds[0])
+	}
+	for i := 1; i < len(cmds); i++ {
+		out := strings.Fields(cmds[i-1])[4]
+		if got, want := strings.Fields(cmds[i])[2], out; got != want {
+			t.Errorf("command %d: got input %v, want %v", i, got, want)
+		}
+	}
+	if !strings.HasSuffix(fused.Cmd, "samtools index "+strings.Fields(cmds[1])[4]+" %s") {
+		t.Errorf("bad index command %q", fused.Cmd)
+	}
+	if fused.Digest() == index.Digest() {
+		t.Error("fused exec has the same digest as the unfused one")
+	}
+
+	// Execs with multiple consumers, or using different images, are
+	// not fused.
+	index = fusable("samtools", "samtools index %s %s", sort)
+	fused = op.Merge(index, sort).Canonicalize(flow.Config{FuseExecs: true})
+	if got := fused.Deps[0]; got.Deps[0].Op != flow.Coerce || strings.Contains(got.Cmd, "sort") {
+		t.Errorf("exec with multiple consumers was fused: %q", got.Cmd)
+	}
+	if got := fused.Deps[1]; !strings.Contains(got.Cmd, "view") {
+		t.Errorf("exec with a single consumer was not fused: %q", got.Cmd)
+	}
+	index = fusable("other", "samtools index %s %s", sort)
+	fused = index.Canonicalize(flow.Config{FuseExecs: true})
+	if strings.Contains(fused.Cmd, "sort") || !strings.Contains(fused.Deps[0].Deps[0].Deps[0].Cmd, "view") {
+		t.Errorf("execs with different images were fused: %q", fused.Cmd)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/values"
+)
+
+// fusePrefix is the path prefix of the scratch files and directories
+// to which fused execs write the outputs of their intermediate
+// commands. Executors provide each exec with its own temporary
+// directory, mounted at /tmp.
+const fusePrefix = "/tmp/reflow-fuse-"
+
+// fuseExecs rewrites the flow graph rooted at f so that chains of
+// execs, in which each exec's output is consumed only by the next
+// exec in the chain, are fused into single execs. A fused exec runs
+// the chain's commands one after the other, in the same container
+// (and thus on the same alloc), with the outputs of all but the
+// last command written to the exec's scratch space instead of being
+// installed in the alloc's repository. The fused exec's digest
+// covers the whole chain, and its result, the output of the chain's
+// last exec, is cached under it.
+//
+// Only execs that use the same image (and pass their inputs in the
+// same way) are fused, and only if they
+// are connected through coercions (as produced by reflow's
+// evaluator when exec outputs are passed to other execs). Execs
+// that are already part of the evaluation graph m, or that have
+// been fused before, are left alone so that their results may be
+// shared. fuseExecs does not modify the flows in the graph rooted
+// at f; it returns a rewritten copy if any execs were fused.
+func fuseExecs(f *Flow, m *flowMap) *Flow {
+	u := &fuser{
+		m:         m,
+		consumers: make(map[*Flow]int),
+		rewritten: make(map[*Flow]*Flow),
+	}
+	for v := f.Visitor(); v.Walk(); v.Visit() {
+		for _, dep := range v.Deps {
+			u.consumers[dep]++
+		}
+	}
+	return u.rewrite(f)
+}
+
+type fuser struct {
+	m         *flowMap
+	consumers map[*Flow]int
+	rewritten map[*Flow]*Flow
+}
+
+// rewrite returns the rewritten version of flow f, fusing f with
+// the execs that produce its inputs, if possible.
+func (u *fuser) rewrite(f *Flow) *Flow {
+	if r, ok := u.rewritten[f]; ok {
+		return r
+	}
+	r := f
+	for i, dep := range f.Deps {
+		if d := u.rewrite(dep); d != dep {
+			if r == f {
+				r = f.Copy()
+			}
+			r.Deps[i] = d
+		}
+	}
+	if f.Op == Exec {
+		// Fuse dependencies in reverse order, so that the indices of
+		// the dependencies yet to be considered are unchanged by
+		// previous fusions.
+		for i := len(f.Deps) - 1; i >= 0; i-- {
+			a, k, ok := u.producer(f, f.Deps[i])
+			if !ok {
+				continue
+			}
+			fused, ok := fuseExec(u.rewrite(a), r, i, k)
+			if !ok {
+				continue
+			}
+			u.m.Lock()
+			u.m.fused[a.Digest()] = true
+			u.m.Unlock()
+			r = fused
+		}
+	}
+	u.rewritten[f] = r
+	return r
+}
+
+// producer returns the exec that produces the input dep of exec f,
+// and the index of the exec's output that is passed to f, provided
+// that the producer can be fused with f.
+func (u *fuser) producer(f, dep *Flow) (a *Flow, k int, ok bool) {
+	var chain []*Flow
+	for dep.Op == Coerce && len(dep.Deps) == 1 && u.consumers[dep] == 1 {
+		chain = append(chain, dep)
+		dep = dep.Deps[0]
+	}
+	if len(chain) == 0 || dep.Op != Exec || u.consumers[dep] != 1 {
+		return nil, 0, false
+	}
+	if dep.Image != f.Image || dep.LazyInputs != f.LazyInputs || dep.OutputIsDir == nil || !dep.ExtraDigest.IsZero() {
+		return nil, 0, false
+	}
+	if u.m.Get(dep) != nil {
+		return nil, 0, false
+	}
+	u.m.Lock()
+	fused := u.m.fused[dep.Digest()]
+	u.m.Unlock()
+	if fused {
+		return nil, 0, false
+	}
+	k, ok = probeChain(dep, chain)
+	return dep, k, ok
+}
+
+// probeChain determines which output of exec a is passed through
+// the chain of coercions (ordered from consumer to producer). It
+// does so by coercing placeholder outputs: the chain passes output
+// k if it produces exactly the placeholder for k.
+func probeChain(a *Flow, chain []*Flow) (int, bool) {
+	list := make([]reflow.Fileset, len(a.OutputIsDir))
+	for k, isdir := range a.OutputIsDir {
+		file := func(name string) reflow.File {
+			return reflow.File{ID: reflow.Digester.FromString(fmt.Sprintf("fuse %d %s", k, name))}
+		}
+		if isdir {
+			list[k] = reflow.Fileset{Map: map[string]reflow.File{"a": file("a"), "b": file("b")}}
+		} else {
+			list[k] = reflow.Fileset{Map: map[string]reflow.File{".": file(".")}}
+		}
+	}
+	var v values.T = reflow.Fileset{List: list}
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		if v, err = chain[i].Coerce(v); err != nil {
+			return 0, false
+		}
+	}
+	fs, ok := v.(reflow.Fileset)
+	if !ok {
+		return 0, false
+	}
+	for k := range list {
+		if fs.Equal(list[k]) {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// fuseExec returns an exec that fuses exec a with exec b, where
+// output k of a is passed to b as its dependency i. The fused exec
+// runs a's command in a subshell, with its outputs written to
+// scratch paths, and then b's command. Its dependencies are
+// b's, less dependency i, followed by a's. It requires the
+// maximum of a's and b's resources, but the sum of their disk.
+func fuseExec(a, b *Flow, i, k int) (*Flow, bool) {
+	afrags, bfrags := execFrags(a.Cmd), execFrags(b.Cmd)
+	if len(afrags) != a.NExecArg()+1 || len(bfrags) != b.NExecArg()+1 {
+		return nil, false
+	}
+	var (
+		prefix  = fusePrefix + a.Digest().Hex()[:16]
+		cmd     strings.Builder
+		argmap  []ExecArg
+		argstrs []string
+		offset  = len(b.Deps) - 1
+	)
+	path := func(k int) string { return fmt.Sprintf("%s-%d", prefix, k) }
+	// Executors create directory outputs before running execs.
+	for k, isdir := range a.OutputIsDir {
+		if isdir {
+			cmd.WriteString("mkdir -p " + path(k) + "\n")
+		}
+	}
+	cmd.WriteString("(\n")
+	for j := 0; j < a.NExecArg(); j++ {
+		cmd.WriteString(afrags[j])
+		earg := a.ExecArg(j)
+		if earg.Out {
+			cmd.WriteString(path(earg.Index))
+			continue
+		}
+		cmd.WriteString("%s")
+		argmap = append(argmap, ExecArg{Index: offset + earg.Index})
+		argstrs = append(argstrs, execArgstr(a, j))
+	}
+	cmd.WriteString(afrags[len(afrags)-1])
+	cmd.WriteString("\n)\n")
+	for j := 0; j < b.NExecArg(); j++ {
+		cmd.WriteString(bfrags[j])
+		earg := b.ExecArg(j)
+		switch {
+		case !earg.Out && earg.Index == i:
+			cmd.WriteString(path(k))
+			continue
+		case !earg.Out && earg.Index > i:
+			earg.Index--
+		}
+		cmd.WriteString("%s")
+		argmap = append(argmap, earg)
+		argstrs = append(argstrs, execArgstr(b, j))
+	}
+	cmd.WriteString(bfrags[len(bfrags)-1])
+
+	fused := b.Copy()
+	fused.Deps = append(append(append([]*Flow{}, b.Deps[:i]...), b.Deps[i+1:]...), a.Deps...)
+	fused.Cmd = cmd.String()
+	fused.Argmap = argmap
+	if len(a.Argstrs) == a.NExecArg() && len(b.Argstrs) == b.NExecArg() {
+		fused.Argstrs = argstrs
+	} else {
+		fused.Argstrs = nil
+	}
+	// The exec's commands run in sequence, but a's outputs occupy
+	// scratch space while b's are written: disk is needed for both.
+	fused.Resources = nil
+	fused.Resources.Max(a.Resources, b.Resources)
+	if disk := a.Resources["disk"] + b.Resources["disk"]; disk > 0 {
+		fused.Resources["disk"] = disk
+	}
+	fused.NonDeterministic = a.NonDeterministic || b.NonDeterministic
+	return fused, true
+}
+
+// execFrags splits an exec command into the fragments surrounding
+// its arguments, leaving escaped percent signs intact.
+func execFrags(cmd string) []string {
+	var (
+		frags []string
+		b     strings.Builder
+	)
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] == '%' && i+1 < len(cmd) {
+			switch cmd[i+1] {
+			case 's':
+				frags = append(frags, b.String())
+				b.Reset()
+				i++
+				continue
+			case '%':
+				b.WriteString("%%")
+				i++
+				continue
+			}
+		}
+		b.WriteByte(cmd[i])
+	}
+	return append(frags, b.String())
+}
+
+// execArgstr returns the symbolic name of exec f's jth argument.
+func execArgstr(f *Flow, j int) string {
+	if j < len(f.Argstrs) {
+		return f.Argstrs[j]
+	}
+	return ""
+}
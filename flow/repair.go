@@ -39,6 +39,15 @@ type Repair struct {
 	GetLimit *limiter.Limiter
 	// NumWrites is incremented for each new assoc entry written by the repair job.
 	NumWrites int64
+	// NoWriteback disables writing back cache keys; the repair job
+	// then only simulates evaluation, e.g., to inspect the cache.
+	NoWriteback bool
+	// Visit, if not nil, is called for every external (exec, intern,
+	// or extern) flow after it has been repaired. If the flow was
+	// found in the cache, key and fsid are the cache key that was hit
+	// and the ID of its fileset; they are zero otherwise. Visit may
+	// be called concurrently when Do is called concurrently.
+	Visit func(f *Flow, key, fsid digest.Digest)
 
 	writebacks chan writeback
 	g          *errgroup.Group
@@ -72,9 +81,9 @@ func (r *Repair) Do(ctx context.Context, f *Flow) {
 		return
 	}
 	var (
-		fs   reflow.Fileset
-		fsid digest.Digest
-		hit  bool
+		fs           reflow.Fileset
+		hitKey, fsid digest.Digest
+		hit          bool
 	)
 	r.Log.Debugf("Repair.Do(%v)", f)
 	keys := f.CacheKeys()
@@ -100,7 +109,7 @@ func (r *Repair) Do(ctx context.Context, f *Flow) {
 		}
 		err = unmarshal(ctx, r.Repository, fsid, &fs)
 		if err == nil {
-			hit = true
+			hit, hitKey = true, key
 			break
 		}
 		if !errors.Is(errors.NotExist, err) {
@@ -134,10 +143,19 @@ func (r *Repair) Do(ctx context.Context, f *Flow) {
 		r.eval(f)
 	}
 	// We may have to recur evaluation in case the flow was forked.
-	if f.State != Done {
+	forked := f.State != Done
+	if forked {
 		r.Do(ctx, f)
 	}
-	if f.Op != Exec {
+	// Forked flows are visited by the recursive call above.
+	if r.Visit != nil && !forked && f.Op.External() {
+		if hit {
+			r.Visit(f, hitKey, fsid)
+		} else {
+			r.Visit(f, digest.Digest{}, digest.Digest{})
+		}
+	}
+	if f.Op != Exec || r.NoWriteback {
 		return
 	}
 	if f.Err != nil {
@@ -295,5 +313,8 @@ func (r *Repair) Go(ctx context.Context, concurrency int) {
 // returns after all outstanding writebacks have been performed.
 func (r *Repair) Done() error {
 	close(r.writebacks)
+	if r.g == nil {
+		return nil
+	}
 	return r.g.Wait()
 }
@@ -0,0 +1,139 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/sched"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+// A mismatch is an exec that produced different outputs when it was
+// re-executed to verify its determinism.
+type mismatch struct {
+	// ident is the exec's identifier.
+	ident string
+	// image and cmd are the exec's image and (abbreviated) command.
+	image, cmd string
+	// want is the digest of the exec's original output fileset;
+	// got is the digest of the output of its re-execution.
+	want, got digest.Digest
+}
+
+// A verification is a re-execution of an exec, submitted to verify
+// its determinism. It is prepared when the exec itself is submitted,
+// so that verifyTask, which runs concurrently with the evaluator,
+// need not access the exec's flow.
+type verification struct {
+	*sched.Task
+	// flow is the digest of the exec's flow.
+	flow digest.Digest
+	// ident and cmd are the exec's identifier and (abbreviated) command.
+	ident, cmd string
+}
+
+// newVerification returns a verification for the exec of flow f,
+// or nil if its determinism should not be verified.
+func (e *Eval) newVerification(f *Flow) *verification {
+	if e.VerifyDeterminism <= 0 || f.Op != Exec || f.NonDeterministic {
+		return nil
+	}
+	if rand.Float64() >= e.VerifyDeterminism {
+		return nil
+	}
+	v := &verification{Task: e.newTask(f), flow: f.Digest(), ident: f.Ident, cmd: f.AbbrevCmd()}
+	// The verification is recorded in the taskdb as a task of its own.
+	v.ID = taskdb.NewTaskID()
+	return v
+}
+
+// verifyTask verifies that an exec is deterministic: it submits
+// verification v, which re-executes the exec successfully run by
+// task, on a different alloc, and compares the digests of the two
+// runs' output filesets. Mismatches are recorded in the taskdb, on
+// the record of the verification task, and reported by LogSummary.
+// Verification errors are logged, but do not fail the evaluation:
+// the flow's own result stands.
+func (e *Eval) verifyTask(ctx context.Context, v *verification, task *sched.Task) {
+	verify := v.Task
+	verify.ExcludeAlloc = task.AllocID()
+	// Use the resources with which the exec succeeded (e.g., after OOM retries).
+	verify.Config.Resources = make(reflow.Resources)
+	verify.Config.Resources.Set(task.Config.Resources)
+	verify.Log = e.Log.Tee(nil, fmt.Sprintf("scheduler task %s (flow %s, verifying task %s): ", verify.ID.IDShort(), verify.FlowID.Short(), task.ID.IDShort()))
+	e.Log.Debugf("flow %s: verifying determinism of task %s with task %s", v.flow.Short(), task.ID.IDShort(), verify.ID.IDShort())
+	e.Scheduler.Submit(verify)
+	if err := verify.Wait(ctx, sched.TaskDone); err != nil {
+		e.Log.Errorf("flow %s: verify determinism: task %s: %v", v.flow.Short(), verify.ID.IDShort(), err)
+		return
+	}
+	if err := verify.Err; err != nil {
+		e.Log.Errorf("flow %s: verify determinism: task %s: %v", v.flow.Short(), verify.ID.IDShort(), err)
+		return
+	}
+	if err := verify.Result.Err; err != nil {
+		e.Log.Errorf("flow %s: verify determinism: task %s: %v", v.flow.Short(), verify.ID.IDShort(), err)
+		return
+	}
+	want, got := task.Result.Fileset.Digest(), verify.Result.Fileset.Digest()
+	if got == want {
+		e.Log.Debugf("flow %s: task %s verified deterministic", v.flow.Short(), task.ID.IDShort())
+		return
+	}
+	m := mismatch{
+		ident: v.ident,
+		image: task.Config.Image,
+		cmd:   v.cmd,
+		want:  want,
+		got:   got,
+	}
+	e.Log.Printf("warning: flow %s: nondeterministic exec %s (image %s): task %s produced %s, task %s produced %s",
+		v.flow.Short(), m.ident, m.image, task.ID.IDShort(), want.Short(), verify.ID.IDShort(), got.Short())
+	e.mismatchesMu.Lock()
+	e.mismatches = append(e.mismatches, m)
+	e.mismatchesMu.Unlock()
+	if e.TaskDB == nil {
+		return
+	}
+	// The scheduler completes the verification task's record before
+	// the task itself; the mismatch is recorded as the task's error,
+	// so that nondeterministic images and commands may be queried.
+	err := errors.E("verify", m.ident, errors.Integrity,
+		errors.Errorf("nondeterministic output of task %s: got fileset %s, want %s", task.ID.IDShort(), m.got.Short(), m.want.Short()))
+	if tdbErr := e.TaskDB.SetTaskComplete(ctx, verify.ID, err, time.Now()); tdbErr != nil {
+		e.Log.Errorf("taskdb record mismatch: %v", tdbErr)
+	}
+}
+
+// writeMismatches writes a report of the execs that were found to
+// be nondeterministic to w.
+func (e *Eval) writeMismatches(w io.Writer) {
+	e.mismatchesMu.Lock()
+	defer e.mismatchesMu.Unlock()
+	if len(e.mismatches) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "nondeterministic execs n=%d\n", len(e.mismatches))
+	var tw tabwriter.Writer
+	tw.Init(newPrefixWriter(w, "\t"), 4, 4, 1, ' ', 0)
+	fmt.Fprintln(&tw, "ident\twant\tgot\timage\texec")
+	for _, m := range e.mismatches {
+		ident := m.ident
+		if ident == "" {
+			ident = "?"
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\t%s\t%s\n", ident, m.want.Short(), m.got.Short(), m.image, m.cmd)
+	}
+	tw.Flush()
+}
@@ -29,6 +29,7 @@ require (
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	golang.org/x/tools v0.0.0-20190911174233-4f2ddba30aff
+	gonum.org/v1/gonum v0.0.0-20190902003836-43865b531bee
 	gopkg.in/yaml.v2 v2.2.4
 	gotest.tools v2.2.0+incompatible // indirect
 	v.io/x/lib v0.1.4
@@ -0,0 +1,539 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package hostcluster implements a runner.Cluster over a fixed
+// inventory of hosts, such as a set of on-premises servers.
+//
+// The cluster is managed by an ec2cluster.Manager: hostcluster
+// implements ec2cluster.ManagedCluster, and "launching an instance"
+// starts a reflowlet on an idle host of the inventory. Reflowlets are
+// started by a Launcher, by default by running a (configurable) shell
+// command, typically over SSH. Reflowlets that are already running on
+// the inventory's hosts, regardless of how they were started, are
+// discovered when the cluster is refreshed.
+//
+// A hostcluster may be configured as follows:
+//
+//	cluster: hostcluster
+//	hostcluster:
+//	  launch: ssh {{.Host}} 'nohup reflow serve -addr :{{.Port}} -dir {{.Dir}} >/dev/null 2>&1 &'
+//	  hosts:
+//	  - addr: lab01.example.com:9000
+//	    cpu: 64
+//	    mem: 512
+//	    disk: 4096
+//	  - addr: lab02.example.com:9000
+//	    cpu: 32
+//	    mem: 256
+//	    disk: 2048
+//
+// A cluster of reflowlets on the local machine can be configured by
+// using a launch command that runs "reflow serve -insecure -addr
+// {{.Addr}} -dir {{.Dir}}" together with hosts on distinct local ports
+// and the flag insecure.
+package hostcluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/status"
+	"github.com/grailbio/base/sync/once"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/infra"
+	"github.com/grailbio/infra/tls"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/ec2cluster"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/pool/client"
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	infra.Register("hostcluster", new(Cluster))
+}
+
+const (
+	// allocAttemptInterval defines how often we attempt to allocate from the
+	// existing pool while waiting for an allocation request to be completed.
+	allocAttemptInterval = 30 * time.Second
+	// probeTimeout is the timeout for liveness probes of a host's reflowlet.
+	probeTimeout = 10 * time.Second
+	// launchProbeInterval is the interval at which a host is probed while
+	// its reflowlet is being launched.
+	launchProbeInterval = 2 * time.Second
+
+	defaultLaunch = "ssh {{.Host}} 'nohup reflow serve -addr :{{.Port}} -dir {{.Dir}} >/dev/null 2>&1 &'"
+	defaultDir    = "/mnt/data/reflow"
+)
+
+// Host describes a host in the cluster's inventory.
+type Host struct {
+	// Name names the host. It defaults to Addr.
+	Name string `yaml:"name,omitempty"`
+	// Addr is the address (host:port) of the host's reflowlet.
+	Addr string `yaml:"addr"`
+	// CPU is the number of CPUs offered by the host.
+	CPU float64 `yaml:"cpu"`
+	// Mem is the amount of memory, in GiB, offered by the host.
+	Mem float64 `yaml:"mem"`
+	// Disk is the amount of disk space, in GiB, offered by the host.
+	Disk float64 `yaml:"disk"`
+	// Dir is the reflowlet's runtime data directory. It defaults to the
+	// cluster's directory.
+	Dir string `yaml:"dir,omitempty"`
+	// Launch overrides the cluster's launch command for this host.
+	Launch string `yaml:"launch,omitempty"`
+}
+
+// Resources returns the resources offered by the host.
+func (h Host) Resources() reflow.Resources {
+	return reflow.Resources{
+		"cpu":  h.CPU,
+		"mem":  h.Mem * float64(data.GiB),
+		"disk": h.Disk * float64(data.GiB),
+	}
+}
+
+// Hostname returns the host part of the host's address.
+func (h Host) Hostname() string {
+	host, _, err := net.SplitHostPort(h.Addr)
+	if err != nil {
+		return h.Addr
+	}
+	return host
+}
+
+// Port returns the port part of the host's address.
+func (h Host) Port() string {
+	_, port, err := net.SplitHostPort(h.Addr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// A Launcher starts reflowlets on hosts.
+type Launcher interface {
+	// Launch starts a reflowlet on the provided host, listening on
+	// the host's address. Launch need not wait for the reflowlet to
+	// become available.
+	Launch(ctx context.Context, h Host) error
+}
+
+// CommandLauncher is a Launcher that starts reflowlets by running
+// shell commands on the local machine. Commands are Go templates
+// that are expanded with the fields Name, Addr, Host, Port, and Dir
+// of the host being launched.
+type CommandLauncher struct {
+	// Command is the default command template.
+	Command string
+	// Log is used to log command output.
+	Log *log.Logger
+}
+
+type commandArgs struct {
+	Name, Addr, Host, Port, Dir string
+}
+
+// Launch implements Launcher. The command is started in the
+// background; its output is logged when it exits.
+func (l *CommandLauncher) Launch(ctx context.Context, h Host) error {
+	text := h.Launch
+	if text == "" {
+		text = l.Command
+	}
+	tmpl, err := template.New(h.Name).Parse(text)
+	if err != nil {
+		return errors.E("launch", h.Name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, commandArgs{h.Name, h.Addr, h.Hostname(), h.Port(), h.Dir}); err != nil {
+		return errors.E("launch", h.Name, err)
+	}
+	// The command is not bound to ctx: it may run the reflowlet in the
+	// foreground, which should outlive the launch.
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", b.String())
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	l.Log.Debugf("launch %s: %s", h.Name, b.String())
+	if err := cmd.Start(); err != nil {
+		return errors.E("launch", h.Name, err)
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			l.Log.Errorf("launch %s: %v: %s", h.Name, err, strings.TrimSpace(out.String()))
+		}
+	}()
+	return nil
+}
+
+// host is the state of a host in the inventory.
+type host struct {
+	Host
+	// pool is the client of the host's reflowlet; it is
+	// non-nil if the reflowlet is live.
+	pool pool.Pool
+	// launching is true while the host's reflowlet is being launched.
+	launching bool
+}
+
+// A Cluster implements a runner.Cluster over a fixed inventory of hosts.
+// Cluster implements ec2cluster.ManagedCluster: it is managed by
+// an ec2cluster.Manager, which launches reflowlets on idle hosts
+// as they are needed.
+type Cluster struct {
+	pool.Mux `yaml:"-"`
+	// HTTPClient is used to communicate with the hosts' reflowlets.
+	HTTPClient *http.Client `yaml:"-"`
+	// Log is the logger for cluster events.
+	Log *log.Logger `yaml:"-"`
+	// Launcher starts reflowlets on hosts. It defaults to a
+	// CommandLauncher that runs LaunchCommand.
+	Launcher Launcher `yaml:"-"`
+	// Status is used to report cluster status.
+	Status *status.Group `yaml:"-"`
+
+	// Hosts is the cluster's inventory.
+	Hosts []Host `yaml:"hosts"`
+	// LaunchCommand is the command template used to launch reflowlets.
+	LaunchCommand string `yaml:"launch,omitempty"`
+	// Dir is the default runtime data directory of reflowlets.
+	Dir string `yaml:"dir,omitempty"`
+	// Insecure tells whether reflowlets serve HTTP instead of HTTPS.
+	Insecure bool `yaml:"insecure,omitempty"`
+	// MaxPendingInstances is the maximum number of concurrently launching hosts.
+	MaxPendingInstances int `yaml:"maxpendinginstances,omitempty"`
+
+	mu    sync.Mutex
+	hosts map[string]*host
+
+	manager  *ec2cluster.Manager
+	initOnce once.Task
+}
+
+// Help implements infra.Provider.
+func (*Cluster) Help() string {
+	return "configure a cluster using a fixed inventory of hosts"
+}
+
+// Config implements infra.Provider.
+func (c *Cluster) Config() interface{} {
+	return c
+}
+
+// Init implements infra.Provider.
+func (c *Cluster) Init(tls tls.Certs, logger *log.Logger) error {
+	if c.Insecure {
+		c.HTTPClient = &http.Client{}
+	} else {
+		clientConfig, _, err := tls.HTTPS()
+		if err != nil {
+			return err
+		}
+		transport := &http.Transport{TLSClientConfig: clientConfig}
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return err
+		}
+		c.HTTPClient = &http.Client{Transport: transport}
+	}
+	c.Log = logger.Tee(nil, "hostcluster: ")
+	return c.init()
+}
+
+// init validates the cluster's inventory and initializes its state.
+func (c *Cluster) init() error {
+	if len(c.Hosts) == 0 {
+		return errors.New("hostcluster: no hosts configured")
+	}
+	if c.LaunchCommand == "" {
+		c.LaunchCommand = defaultLaunch
+	}
+	if c.Dir == "" {
+		c.Dir = defaultDir
+	}
+	if c.Launcher == nil {
+		c.Launcher = &CommandLauncher{Command: c.LaunchCommand, Log: c.Log}
+	}
+	if c.MaxPendingInstances == 0 {
+		c.MaxPendingInstances = len(c.Hosts)
+	}
+	c.hosts = make(map[string]*host)
+	for _, h := range c.Hosts {
+		if h.Addr == "" {
+			return errors.Errorf("hostcluster: host %q has no address", h.Name)
+		}
+		if h.Name == "" {
+			h.Name = h.Addr
+		}
+		if h.Dir == "" {
+			h.Dir = c.Dir
+		}
+		if _, ok := c.hosts[h.Name]; ok {
+			return errors.Errorf("hostcluster: duplicate host %s", h.Name)
+		}
+		if h.CPU <= 0 || h.Mem <= 0 {
+			return errors.Errorf("hostcluster: host %s: cpu and mem must be specified", h.Name)
+		}
+		c.hosts[h.Name] = &host{Host: h}
+	}
+	c.manager = ec2cluster.NewManager(c, len(c.hosts), c.MaxPendingInstances, c.Log)
+	return nil
+}
+
+// VerifyAndInit starts the cluster's manager. It should be called
+// before any pool.Pool operations are performed on the cluster.
+func (c *Cluster) VerifyAndInit() error {
+	return c.initOnce.Do(func() error {
+		c.manager.Start()
+		return nil
+	})
+}
+
+// Allocate reserves an alloc within the resource requirement
+// boundaries from this cluster. If a live host can serve the
+// request, it is returned immediately; otherwise reflowlets are
+// launched on idle hosts to handle the allocation.
+func (c *Cluster) Allocate(ctx context.Context, req reflow.Requirements, labels pool.Labels) (alloc pool.Alloc, err error) {
+	if err = c.VerifyAndInit(); err != nil {
+		return
+	}
+	c.Log.Debugf("allocate %s", req)
+	if !c.satisfiable(req.Min) {
+		return nil, errors.E(errors.ResourcesExhausted,
+			errors.Errorf("requested resources %s not satisfiable by any host", req))
+	}
+	const allocTimeout = 30 * time.Second
+	if c.Size() > 0 {
+		actx, acancel := context.WithTimeout(ctx, allocTimeout)
+		alloc, err := pool.Allocate(actx, c, req, labels)
+		acancel()
+		if err == nil {
+			return alloc, nil
+		}
+		c.Log.Debugf("failed to allocate from live hosts: %v; launching hosts", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ticker := time.NewTicker(allocAttemptInterval)
+	defer ticker.Stop()
+	needch := c.manager.Allocate(ctx, req)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-needch:
+			actx, acancel := context.WithTimeout(ctx, allocTimeout)
+			alloc, err := pool.Allocate(actx, c, req, labels)
+			acancel()
+			if err == nil {
+				return alloc, nil
+			}
+			c.Log.Errorf("failed to allocate from pool: %v; launching hosts", err)
+			needch = c.manager.Allocate(ctx, req)
+		case <-ticker.C:
+			actx, acancel := context.WithTimeout(ctx, allocTimeout)
+			alloc, err := pool.Allocate(actx, c, req, labels)
+			acancel()
+			if err == nil {
+				return alloc, nil
+			}
+		}
+	}
+}
+
+// Shutdown shuts down the cluster's manager. Reflowlets are left
+// running on their hosts; they are rediscovered by later clusters.
+func (c *Cluster) Shutdown() error {
+	c.manager.Shutdown()
+	return nil
+}
+
+// satisfiable tells whether any host in the inventory can satisfy need.
+func (c *Cluster) satisfiable(need reflow.Resources) bool {
+	for _, h := range c.hosts {
+		if h.Resources().Available(need) {
+			return true
+		}
+	}
+	return false
+}
+
+// Available implements ec2cluster.ManagedCluster. It returns the
+// smallest idle host (neither live nor launching) with at least
+// the needed resources. The returned InstanceSpec's type is the
+// host's name.
+func (c *Cluster) Available(need reflow.Resources) (ec2cluster.InstanceSpec, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var best *host
+	for _, h := range c.hosts {
+		if h.pool != nil || h.launching || !h.Resources().Available(need) {
+			continue
+		}
+		if best == nil || less(h, best) {
+			best = h
+		}
+	}
+	if best == nil {
+		return ec2cluster.InstanceSpec{}, false
+	}
+	return ec2cluster.InstanceSpec{Type: best.Name, Resources: best.Resources()}, true
+}
+
+// less orders hosts by size, then by name.
+func less(h, g *host) bool {
+	hd, gd := h.Resources().ScaledDistance(nil), g.Resources().ScaledDistance(nil)
+	if hd != gd {
+		return hd < gd
+	}
+	return h.Name < g.Name
+}
+
+// Launch implements ec2cluster.ManagedCluster. It launches a
+// reflowlet on the host named by the spec's type and waits for it
+// to become live. Launch returns an invalid instance if the host is
+// not idle, or if its reflowlet could not be launched.
+func (c *Cluster) Launch(ctx context.Context, spec ec2cluster.InstanceSpec) ec2cluster.ManagedInstance {
+	c.mu.Lock()
+	h := c.hosts[spec.Type]
+	if h == nil || h.pool != nil || h.launching {
+		c.mu.Unlock()
+		return spec.Instance("")
+	}
+	h.launching = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		h.launching = false
+		c.mu.Unlock()
+	}()
+
+	var task *status.Task
+	if c.Status != nil {
+		task = c.Status.Startf("%s", h.Name)
+		defer task.Done()
+	}
+	c.Log.Printf("launching reflowlet on host %s (%s)", h.Name, h.Addr)
+	if err := c.Launcher.Launch(ctx, h.Host); err != nil {
+		c.Log.Errorf("launch %s: %v", h.Name, err)
+		return spec.Instance("")
+	}
+	if task != nil {
+		task.Print("waiting for reflowlet")
+	}
+	for {
+		if _, err := c.probe(ctx, h.Host); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			c.Log.Errorf("launch %s: reflowlet did not become live: %v", h.Name, ctx.Err())
+			return spec.Instance("")
+		case <-time.After(launchProbeInterval):
+		}
+	}
+	return spec.Instance(h.Name)
+}
+
+// Notify implements ec2cluster.ManagedCluster.
+func (c *Cluster) Notify(waiting, pending reflow.Resources) {
+	c.printState(fmt.Sprintf("waiting%s, pending%s", waiting, pending))
+}
+
+// Refresh implements ec2cluster.ManagedCluster. It probes the
+// liveness of each host's reflowlet, and returns the set of live
+// hosts.
+func (c *Cluster) Refresh(ctx context.Context) (map[string]bool, error) {
+	hosts := make([]Host, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		hosts = append(hosts, h.Host)
+	}
+	pools := make([]pool.Pool, len(hosts))
+	_ = traverse.Each(len(hosts), func(i int) error {
+		pools[i], _ = c.probe(ctx, hosts[i])
+		return nil
+	})
+	defer c.printState("")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		live = make(map[string]bool)
+		mux  []pool.Pool
+	)
+	for i, h := range hosts {
+		state := c.hosts[h.Name]
+		switch p := pools[i]; {
+		case p == nil && state.pool != nil:
+			c.Log.Printf("host %s is no longer live", h.Name)
+			state.pool = nil
+		case p != nil && state.pool == nil:
+			c.Log.Printf("discovered host %s (%s)", h.Name, h.Addr)
+			state.pool = p
+		}
+		if state.pool != nil {
+			live[h.Name] = true
+			mux = append(mux, state.pool)
+		}
+	}
+	c.SetPools(mux)
+	return live, nil
+}
+
+// probe returns a client for the host's reflowlet if it is live.
+func (c *Cluster) probe(ctx context.Context, h Host) (pool.Pool, error) {
+	scheme := "https"
+	if c.Insecure {
+		scheme = "http"
+	}
+	clnt, err := client.New(fmt.Sprintf("%s://%s/v1/", scheme, h.Addr), c.HTTPClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if _, err := clnt.Offers(ctx); err != nil {
+		return nil, err
+	}
+	return clnt, nil
+}
+
+func (c *Cluster) printState(suffix string) {
+	c.mu.Lock()
+	var (
+		live, launching int
+		total           reflow.Resources
+	)
+	for _, h := range c.hosts {
+		switch {
+		case h.pool != nil:
+			live++
+			total.Add(total, h.Resources())
+		case h.launching:
+			launching++
+		}
+	}
+	n := len(c.hosts)
+	c.mu.Unlock()
+	msg := fmt.Sprintf("%d/%d hosts live, %d launching, total%s", live, n, launching, total)
+	if suffix != "" {
+		msg = fmt.Sprintf("%s, %s", msg, suffix)
+	}
+	if c.Status != nil {
+		c.Status.Print(msg)
+	}
+	c.Log.Debug(msg)
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package hostcluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/pool/server"
+	"github.com/grailbio/reflow/rest"
+)
+
+// testAlloc is an alloc that is never used.
+type testAlloc struct {
+	pool.Alloc
+	id        string
+	resources reflow.Resources
+}
+
+func (a *testAlloc) ID() string                  { return a.id }
+func (a *testAlloc) Resources() reflow.Resources { return a.resources }
+
+// testPool is a pool with a single offer of all of its resources.
+type testPool struct {
+	pool.Pool
+	mu        sync.Mutex
+	resources reflow.Resources
+	accepted  bool
+}
+
+func (p *testPool) Offer(ctx context.Context, id string) (pool.Offer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id != "offer" || p.accepted {
+		return nil, errors.E(errors.NotExist)
+	}
+	return testOffer{p}, nil
+}
+
+func (p *testPool) Offers(ctx context.Context) ([]pool.Offer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accepted {
+		return nil, nil
+	}
+	return []pool.Offer{testOffer{p}}, nil
+}
+
+type testOffer struct{ p *testPool }
+
+func (o testOffer) ID() string                  { return "offer" }
+func (o testOffer) Pool() pool.Pool             { return o.p }
+func (o testOffer) Available() reflow.Resources { return o.p.resources }
+func (o testOffer) Accept(ctx context.Context, meta pool.AllocMeta) (pool.Alloc, error) {
+	o.p.mu.Lock()
+	defer o.p.mu.Unlock()
+	if o.p.accepted {
+		return nil, errors.E(errors.NotExist)
+	}
+	o.p.accepted = true
+	return &testAlloc{id: "alloc", resources: meta.Want}, nil
+}
+
+// testLauncher launches test reflowlets, serving a testPool, on
+// local addresses.
+type testLauncher struct {
+	mu       sync.Mutex
+	servers  map[string]*http.Server
+	launched []string
+}
+
+func (l *testLauncher) Launch(ctx context.Context, h Host) error {
+	l.mu.Lock()
+	l.launched = append(l.launched, h.Name)
+	l.mu.Unlock()
+	return l.start(h)
+}
+
+func (l *testLauncher) start(h Host) error {
+	lis, err := net.Listen("tcp", h.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: rest.Handler(server.NewNode(&testPool{resources: h.Resources()}), nil)}
+	go srv.Serve(lis)
+	l.mu.Lock()
+	l.servers[h.Name] = srv
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *testLauncher) stop(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.servers[name].Close()
+	delete(l.servers, name)
+}
+
+// freeAddr returns a local address that is not in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func TestHostCluster(t *testing.T) {
+	launcher := &testLauncher{servers: make(map[string]*http.Server)}
+	c := &Cluster{
+		HTTPClient: &http.Client{},
+		Log:        log.Std,
+		Launcher:   launcher,
+		Insecure:   true,
+		Hosts: []Host{
+			{Name: "small", Addr: freeAddr(t), CPU: 2, Mem: 4},
+			{Name: "large", Addr: freeAddr(t), CPU: 16, Mem: 64},
+			{Name: "huge", Addr: freeAddr(t), CPU: 64, Mem: 512},
+			{Name: "running", Addr: freeAddr(t), CPU: 4, Mem: 8},
+		},
+	}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	// The reflowlet on host "running" was started outside of the cluster.
+	if err := launcher.start(c.hosts["running"].Host); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyAndInit(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+	if got, want := c.Size(), 1; got != want {
+		t.Fatalf("got %v live hosts, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req := reflow.Requirements{Min: reflow.Resources{"cpu": 8, "mem": float64(16 * data.GiB)}}
+	alloc, err := c.Allocate(ctx, req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The smallest idle host that satisfies the request is launched.
+	if got, want := launcher.launched, []string{"large"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got launched %v, want %v", got, want)
+	}
+	if got, want := alloc.Resources()["cpu"], 16.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if addr := c.hosts["large"].Addr; !strings.Contains(alloc.Pool().ID(), addr) {
+		t.Errorf("alloc %s is not on host large (%s)", alloc.ID(), addr)
+	}
+
+	req = reflow.Requirements{Min: reflow.Resources{"cpu": 128}}
+	if _, err := c.Allocate(ctx, req, nil); !errors.Is(errors.ResourcesExhausted, err) {
+		t.Errorf("got %v, want resources exhausted", err)
+	}
+
+	// Hosts whose reflowlets have gone away are removed from the pool,
+	// and may be launched again.
+	launcher.stop("running")
+	live, err := c.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live["running"] || !live["large"] {
+		t.Errorf("got live %v, want large only", live)
+	}
+	if spec, ok := c.Available(reflow.Resources{"cpu": 3}); !ok || spec.Type != "running" {
+		t.Errorf("got %v, %v, want running", spec, ok)
+	}
+}
+
+func TestHostClusterConfig(t *testing.T) {
+	for _, hosts := range [][]Host{
+		nil,
+		{{Name: "noaddr", CPU: 1, Mem: 1}},
+		{{Addr: "a:9000", CPU: 1, Mem: 1}, {Addr: "a:9000", CPU: 1, Mem: 1}},
+		{{Addr: "a:9000", CPU: 1}},
+	} {
+		c := &Cluster{Log: log.Std, Hosts: hosts}
+		if err := c.init(); err == nil {
+			t.Errorf("hosts %v: expected error", hosts)
+		}
+	}
+	c := &Cluster{Log: log.Std, Hosts: []Host{{Addr: "lab01:9000", CPU: 1, Mem: 1}}}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	h := c.hosts["lab01:9000"]
+	if h == nil {
+		t.Fatal("host not named by its address")
+	}
+	if got, want := h.Dir, defaultDir; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := h.Hostname()+" "+h.Port(), "lab01 9000"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	golog "log"
+	"net/http"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/grailbio/infra"
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/rest"
+	"github.com/grailbio/reflow/sched"
 )
 
 func init() {
@@ -30,30 +34,37 @@ func init() {
 	infra.Register("docker", new(DockerConfig))
 	infra.Register("predictorconfig", new(PredictorConfig))
 	infra.Register("testpredictorconfig", new(PredictorTestConfig))
+	infra.Register("budgetconfig", new(BudgetConfig))
+	infra.Register("poolauthconfig", new(PoolAuthConfig))
+	infra.Register("assertpolicyconfig", new(AssertPolicyConfig))
 }
 
 // Reflow infra schema key names.
 const (
-	AWSCreds   = "awscreds"
-	AWSRegion  = "awsregion"
-	Assoc      = "assoc"
-	AWSTool    = "awstool"
-	Cache      = "cache"
-	Cluster    = "cluster"
-	Labels     = "labels"
-	Log        = "logger"
-	Repository = "repository"
-	Reflow     = "reflow"
-	Reflowlet  = "reflowlet"
-	Bootstrap  = "bootstrap"
-	Session    = "session"
-	SSHKey     = "sshkey"
-	Username   = "user"
-	TLS        = "tls"
-	Tracer     = "tracer"
-	TaskDB     = "taskdb"
-	Docker     = "docker"
-	Predictor  = "predictor"
+	AWSCreds     = "awscreds"
+	AWSRegion    = "awsregion"
+	Assoc        = "assoc"
+	AWSTool      = "awstool"
+	Cache        = "cache"
+	Cluster      = "cluster"
+	Labels       = "labels"
+	Log          = "logger"
+	Repository   = "repository"
+	Reflow       = "reflow"
+	Reflowlet    = "reflowlet"
+	Bootstrap    = "bootstrap"
+	Session      = "session"
+	SSHKey       = "sshkey"
+	Username     = "user"
+	TLS          = "tls"
+	Tracer       = "tracer"
+	TaskDB       = "taskdb"
+	Docker       = "docker"
+	Predictor    = "predictor"
+	Budget       = "budget"
+	PoolAuth     = "poolauth"
+	AssertPolicy = "assertpolicy"
+	Tiers        = "tiers"
 )
 
 // User is the infrastructure provider for username.
@@ -551,3 +562,237 @@ func (p *PredictorTestConfig) Init() error {
 func (p *PredictorTestConfig) InstanceConfig() interface{} {
 	return p
 }
+
+// BudgetConfig configures the budgets that limit the resources spent
+// by runs. A run that exceeds its budget, or its user's, is halted
+// and fails with an error of kind errors.BudgetExceeded.
+//
+// For example:
+//
+//	budget: budgetconfig
+//	budgetconfig:
+//	  run:
+//	    maxallocs: 50
+//	    maxdollars: 500
+//	  user:
+//	    maxinstancehours: 2000
+//	    kill: true
+type BudgetConfig struct {
+	// Run is the budget of each run.
+	Run sched.Budget `yaml:"run,omitempty"`
+	// User is the budget of each user: it limits the resources spent by
+	// each run together with those spent by the user's other runs that
+	// were active within the last UserPeriod.
+	User sched.Budget `yaml:"user,omitempty"`
+	// UserPeriod is the period over which users' spend is accounted.
+	UserPeriod time.Duration `yaml:"userperiod,omitempty"`
+}
+
+// Help implements infra.Provider.
+func (b BudgetConfig) Help() string {
+	return "configure the budgets of runs and users"
+}
+
+// Init implements infra.Provider.
+func (b *BudgetConfig) Init() error {
+	if b.UserPeriod == 0 {
+		b.UserPeriod = 24 * time.Hour
+	}
+	for _, budget := range []sched.Budget{b.Run, b.User} {
+		if budget.MaxAllocs < 0 || budget.MaxInstanceHours < 0 || budget.MaxDollars < 0 {
+			return fmt.Errorf("budget limits must be non-negative")
+		}
+	}
+	return nil
+}
+
+// InstanceConfig implements infra.Provider.
+func (b *BudgetConfig) InstanceConfig() interface{} {
+	return b
+}
+
+// PoolAuthConfig configures token authentication and authorization
+// of the calls made to reflowlets and scheduling services (see
+// "reflow serve -sched"). Tokens maps each user permitted
+// to use reflowlets to the SHA-256 digest of the user's token (see
+// rest.HashToken); users present their own tokens, which are read
+// from a file (by default $HOME/.reflow/pooltoken), and are never
+// included in the configuration. Users may operate only on their own
+// allocs, except for admins, who may operate on any alloc. Users may
+// submit tasks to scheduling services on behalf of only the projects
+// to which they are entitled by Projects.
+//
+// Reflowlets transfer data between allocs' repositories with their
+// own identity, authenticated by the transfer token. The transfer
+// token grants access only to allocs' repositories; unlike users'
+// tokens, it is included in the configuration (which is passed to
+// reflowlets), and so should be kept as private as the configuration.
+//
+// For example:
+//
+//	poolauth: poolauthconfig
+//	poolauthconfig:
+//	  tokens:
+//	    alice: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    bob: 60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752
+//	  admins: [alice]
+//	  projects:
+//	    bob: [genomics]
+//	  transfertoken: 1f3870be274f6c49b3e31a0c6728957f
+type PoolAuthConfig struct {
+	// Tokens maps users to the digests of their tokens.
+	Tokens map[string]string `yaml:"tokens,omitempty"`
+	// Admins lists the users that may operate on any alloc.
+	Admins []string `yaml:"admins,omitempty"`
+	// Projects maps users to the projects on whose behalf they may
+	// submit tasks to scheduling services.
+	Projects map[string][]string `yaml:"projects,omitempty"`
+	// TransferToken is the token with which reflowlets authenticate
+	// transfers between allocs' repositories.
+	TransferToken string `yaml:"transfertoken,omitempty"`
+
+	tokenFile string
+	user      string
+	token     string
+}
+
+// Help implements infra.Provider.
+func (c PoolAuthConfig) Help() string {
+	return "configure token authentication of calls to reflowlets and scheduling services"
+}
+
+// Flags implements infra.Provider.
+func (c *PoolAuthConfig) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&c.tokenFile, "tokenfile", "", "file containing the user's token (default $HOME/.reflow/pooltoken)")
+}
+
+// Init implements infra.Provider.
+func (c *PoolAuthConfig) Init(user *User) error {
+	c.user = user.User()
+	path := c.tokenFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".reflow", "pooltoken")
+	}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		c.token = strings.TrimSpace(string(b))
+	case os.IsNotExist(err) && c.tokenFile == "":
+		// Users need not have tokens, e.g., on reflowlets.
+	default:
+		return fmt.Errorf("read token: %v", err)
+	}
+	return nil
+}
+
+// InstanceConfig implements infra.Provider.
+func (c *PoolAuthConfig) InstanceConfig() interface{} {
+	return c
+}
+
+// Authenticator returns the authenticator for calls to reflowlets,
+// or nil if no tokens are configured.
+func (c *PoolAuthConfig) Authenticator() rest.Authenticator {
+	if len(c.Tokens) == 0 {
+		return nil
+	}
+	return rest.Tokens(c.Tokens)
+}
+
+// Transport returns an http.RoundTripper that presents the user's
+// token in the requests made through the provided transport, or the
+// transport itself if the user has no token.
+func (c *PoolAuthConfig) Transport(base http.RoundTripper) http.RoundTripper {
+	if c.token == "" {
+		return base
+	}
+	return &rest.TokenTransport{User: c.user, Token: c.token, Base: base}
+}
+
+// transferUser is the name of the identity with which reflowlets
+// transfer data between allocs' repositories.
+const transferUser = "reflowlet"
+
+// TransferAuthenticator returns the authenticator for the transfers
+// between allocs' repositories made by reflowlets, or nil if no
+// transfer token is configured.
+func (c *PoolAuthConfig) TransferAuthenticator() rest.Authenticator {
+	if c.TransferToken == "" {
+		return nil
+	}
+	return rest.Tokens{transferUser: rest.HashToken(c.TransferToken)}
+}
+
+// TransferTransport returns an http.RoundTripper that presents the
+// transfer token in the requests made through the provided
+// transport, or the transport itself if no transfer token is
+// configured.
+func (c *PoolAuthConfig) TransferTransport(base http.RoundTripper) http.RoundTripper {
+	if c.TransferToken == "" {
+		return base
+	}
+	return &rest.TokenTransport{User: transferUser, Token: c.TransferToken, Base: base}
+}
+
+// AssertPolicyConfig configures how strictly cached results are
+// asserted, by the subjects and namespaces of their assertions. Each
+// rule names a policy, as for the -assert run flag ("never",
+// "exact", "content", or "size"), which governs the assertions whose
+// subjects begin with the rule's prefix and (if it is given) belong
+// to the rule's namespace. Assertions that match no rule are governed
+// by the -assert flag's policy.
+//
+// For example:
+//
+//	assertpolicy: assertpolicyconfig
+//	assertpolicyconfig:
+//	  rules:
+//	  - prefix: s3://references/
+//	    policy: never
+//	  - prefix: s3://samples/
+//	    policy: content
+//	  - prefix: s3://scratch/
+//	    policy: size
+//	  - namespace: docker
+//	    policy: exact
+type AssertPolicyConfig struct {
+	// Rules is the set of rules of the policy.
+	Rules []AssertRuleConfig `yaml:"rules,omitempty"`
+}
+
+// AssertRuleConfig configures a rule of an AssertPolicyConfig.
+type AssertRuleConfig struct {
+	// Prefix is the subject prefix to which the rule applies.
+	Prefix string `yaml:"prefix,omitempty"`
+	// Namespace is the namespace to which the rule applies.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Policy is the name of the rule's policy.
+	Policy string `yaml:"policy"`
+}
+
+// Help implements infra.Provider.
+func (c AssertPolicyConfig) Help() string {
+	return "configure the policies used to assert cached results by subject prefix and namespace"
+}
+
+// Init implements infra.Provider.
+func (c *AssertPolicyConfig) Init() error {
+	for _, r := range c.Rules {
+		if r.Policy == "" {
+			return fmt.Errorf("assert rule (prefix %q, namespace %q) has no policy", r.Prefix, r.Namespace)
+		}
+		if r.Prefix == "" && r.Namespace == "" {
+			return fmt.Errorf("assert rule with policy %s has neither prefix nor namespace", r.Policy)
+		}
+	}
+	return nil
+}
+
+// InstanceConfig implements infra.Provider.
+func (c *AssertPolicyConfig) InstanceConfig() interface{} {
+	return c
+}
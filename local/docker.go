@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"strconv"
@@ -76,6 +77,9 @@ type dockerExec struct {
 	Manifest
 	err         error
 	promoteOnce once.Task
+	// gpusHeld tells whether the exec's GPUs (Manifest.GPUs) are
+	// currently assigned to it.
+	gpusHeld bool
 }
 
 var retryPolicy = retry.MaxTries(retry.Backoff(time.Second, 10*time.Second, 1.5), 5)
@@ -167,6 +171,14 @@ func (e *dockerExec) create(ctx context.Context) (execState, error) {
 			flat := iv.Fileset.Flatten()
 			argv := make([]string, len(flat))
 			for j, jv := range flat {
+				// Lazy inputs that are references are passed by URL;
+				// they are not loaded into the repository.
+				if e.Config.LazyInputs {
+					if u, ok := jv.RefURL(); ok {
+						argv[j] = u
+						continue
+					}
+				}
 				argPath := fmt.Sprintf("arg/%d/%d", i, j)
 				binds := map[string]digest.Digest{}
 				for path, file := range jv.Map {
@@ -247,6 +259,9 @@ func (e *dockerExec) create(ctx context.Context) (execState, error) {
 		Labels:     map[string]string{"reflow-id": e.id.Hex()},
 		User:       dockerUser,
 	}
+	if err := e.configureGPUs(config, hostConfig); err != nil {
+		return execInit, err
+	}
 	networkingConfig := &network.NetworkingConfig{}
 	if _, err := e.client.ContainerCreate(ctx, config, hostConfig, networkingConfig, e.containerName()); err != nil {
 		return execInit, errors.E(
@@ -588,6 +603,39 @@ func (e *dockerExec) Go(ctx context.Context) {
 			}
 		}
 	}
+	e.releaseGPUs()
+}
+
+// configureGPUs assigns to the exec the number of GPUs required by
+// its "gpu" resource, and configures its container to use them.
+func (e *dockerExec) configureGPUs(config *container.Config, hostConfig *container.HostConfig) error {
+	n := int(math.Ceil(e.Config.Resources["gpu"]))
+	if n == 0 {
+		return nil
+	}
+	if e.Executor.GPUs.Len() == 0 {
+		return errors.E("run", e.id, errors.NotSupported,
+			errors.Errorf("exec requires %d GPUs, but the executor has none", n))
+	}
+	if !e.gpusHeld {
+		ids, err := e.Executor.GPUs.acquire(n)
+		if err != nil {
+			return errors.E("run", e.id, err)
+		}
+		e.GPUs = ids
+		e.gpusHeld = true
+	}
+	e.Executor.GPUs.plugin.Configure(e.GPUs, config, hostConfig)
+	return nil
+}
+
+// releaseGPUs releases the GPUs assigned to the exec, if any.
+func (e *dockerExec) releaseGPUs() {
+	if !e.gpusHeld {
+		return
+	}
+	e.Executor.GPUs.release(e.GPUs)
+	e.gpusHeld = false
 }
 
 // Logs returns the stdout and/or stderr log files. Logs returns live
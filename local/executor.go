@@ -101,6 +101,10 @@ type Executor struct {
 
 	Blob blob.Mux
 
+	// GPUs, if non-nil, are assigned to the execs that require
+	// them (through the "gpu" resource).
+	GPUs *GPUs
+
 	// remoteStream is the client used to write logs to a remote cloud
 	// stream.
 	remoteStream remoteStream
@@ -213,6 +217,11 @@ func (e *Executor) Start() error {
 			dx := newDockerExec(id, e, reflow.ExecConfig{},
 				log.New(stdout, log.InfoLevel), log.New(stderr, log.InfoLevel))
 			dx.Manifest = m
+			// Execs that are still in progress continue to use their GPUs.
+			if len(m.GPUs) > 0 && m.State != execComplete && e.GPUs != nil {
+				e.GPUs.claim(m.GPUs)
+				dx.gpusHeld = true
+			}
 			x = dx
 		case execBlob:
 			_, stderr := e.getRemoteStreams(id, false, true)
@@ -0,0 +1,140 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"docker.io/go-docker/api/types/container"
+	"github.com/grailbio/reflow/errors"
+)
+
+// DevicePlugin provides a host's GPUs to Docker containers.
+type DevicePlugin interface {
+	// Devices returns the IDs of the GPUs available on the host.
+	Devices() ([]string, error)
+	// Configure configures a container so that the GPUs with
+	// the provided IDs (and only those) are available to it.
+	Configure(ids []string, config *container.Config, hostConfig *container.HostConfig)
+}
+
+// NvidiaPlugin is a DevicePlugin for NVIDIA GPUs. Containers are run
+// with the "nvidia" runtime (provided by the NVIDIA container
+// toolkit), which must be registered with the Docker daemon; the
+// runtime exposes the devices named by NVIDIA_VISIBLE_DEVICES, along
+// with the host's driver libraries, to the container.
+type NvidiaPlugin struct{}
+
+// Devices returns the indices of the host's NVIDIA GPUs, as
+// enumerated by the device files /dev/nvidia0, /dev/nvidia1, etc.
+func (NvidiaPlugin) Devices() ([]string, error) {
+	paths, err := filepath.Glob("/dev/nvidia[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	var indices []int
+	for _, path := range paths {
+		index, err := strconv.Atoi(strings.TrimPrefix(path, "/dev/nvidia"))
+		if err != nil {
+			continue
+		}
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	ids := make([]string, len(indices))
+	for i, index := range indices {
+		ids[i] = strconv.Itoa(index)
+	}
+	return ids, nil
+}
+
+// Configure sets the container's runtime to "nvidia" and exposes
+// the provided GPUs through NVIDIA_VISIBLE_DEVICES.
+func (NvidiaPlugin) Configure(ids []string, config *container.Config, hostConfig *container.HostConfig) {
+	hostConfig.Runtime = "nvidia"
+	config.Env = append(config.Env,
+		"NVIDIA_VISIBLE_DEVICES="+strings.Join(ids, ","),
+		"NVIDIA_DRIVER_CAPABILITIES=compute,utility",
+	)
+}
+
+// GPUs assigns a host's GPUs to execs, so that each GPU is used by at
+// most one exec at a time. A single GPUs is shared by the executors
+// of all of a pool's allocs. A nil *GPUs has no GPUs.
+type GPUs struct {
+	plugin DevicePlugin
+	ids    []string
+
+	mu    sync.Mutex
+	inuse map[string]bool
+}
+
+// NewGPUs returns a GPUs that assigns the devices provided by
+// the given plugin.
+func NewGPUs(plugin DevicePlugin) (*GPUs, error) {
+	ids, err := plugin.Devices()
+	if err != nil {
+		return nil, err
+	}
+	return &GPUs{plugin: plugin, ids: ids, inuse: make(map[string]bool)}, nil
+}
+
+// Len returns the number of GPUs managed by g.
+func (g *GPUs) Len() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.ids)
+}
+
+// acquire assigns n free GPUs, returning their IDs. It returns an
+// error of kind errors.ResourcesExhausted if fewer than n GPUs are
+// free.
+func (g *GPUs) acquire(n int) ([]string, error) {
+	if n > g.Len() {
+		return nil, errors.E(errors.ResourcesExhausted, errors.Errorf("need %d GPUs, but the host has %d", n, g.Len()))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ids []string
+	for _, id := range g.ids {
+		if len(ids) == n {
+			break
+		}
+		if !g.inuse[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < n {
+		return nil, errors.E(errors.ResourcesExhausted, errors.Errorf("need %d GPUs, but only %d are free", n, len(ids)))
+	}
+	for _, id := range ids {
+		g.inuse[id] = true
+	}
+	return ids, nil
+}
+
+// claim marks the provided GPUs as in use. It is used to reclaim the
+// GPUs of execs that are restored from disk.
+func (g *GPUs) claim(ids []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		g.inuse[id] = true
+	}
+}
+
+// release frees the provided GPUs.
+func (g *GPUs) release(ids []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		delete(g.inuse, id)
+	}
+}
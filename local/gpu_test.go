@@ -0,0 +1,135 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"reflect"
+	"testing"
+
+	"docker.io/go-docker/api/types/container"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+)
+
+// fakePlugin is a DevicePlugin that records the devices
+// it is asked to configure.
+type fakePlugin struct {
+	ids []string
+}
+
+func (p fakePlugin) Devices() ([]string, error) { return p.ids, nil }
+
+func (p fakePlugin) Configure(ids []string, config *container.Config, hostConfig *container.HostConfig) {
+	hostConfig.Runtime = "fake"
+	for _, id := range ids {
+		hostConfig.Devices = append(hostConfig.Devices, container.DeviceMapping{PathOnHost: "/dev/fake" + id})
+	}
+}
+
+func newGPUExec(t *testing.T, x *Executor, gpu float64) *dockerExec {
+	t.Helper()
+	e := &dockerExec{Executor: x}
+	e.Config = reflow.ExecConfig{
+		Type:      "exec",
+		Resources: reflow.Resources{"mem": 1 << 30, "cpu": 1, "gpu": gpu},
+	}
+	return e
+}
+
+func TestGPUs(t *testing.T) {
+	gpus, err := NewGPUs(fakePlugin{[]string{"0", "1", "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gpus.Len(), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	x := &Executor{GPUs: gpus}
+
+	e1 := newGPUExec(t, x, 2)
+	var (
+		config     container.Config
+		hostConfig container.HostConfig
+	)
+	if err := e1.configureGPUs(&config, &hostConfig); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e1.GPUs, []string{"0", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := hostConfig.Runtime, "fake"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(hostConfig.Devices), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only one GPU remains.
+	e2 := newGPUExec(t, x, 2)
+	if err := e2.configureGPUs(&container.Config{}, &container.HostConfig{}); !errors.Is(errors.ResourcesExhausted, err) {
+		t.Errorf("expected ResourcesExhausted, got %v", err)
+	}
+	e3 := newGPUExec(t, x, 0.5)
+	hostConfig = container.HostConfig{}
+	if err := e3.configureGPUs(&container.Config{}, &hostConfig); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e3.GPUs, []string{"2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := hostConfig.Devices[0].PathOnHost, "/dev/fake2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	e1.releaseGPUs()
+	e1.releaseGPUs() // Releasing is idempotent.
+	if err := e2.configureGPUs(&container.Config{}, &container.HostConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e2.GPUs, []string{"0", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Execs that do not require GPUs are left alone.
+	e4 := newGPUExec(t, x, 0)
+	hostConfig = container.HostConfig{}
+	if err := e4.configureGPUs(&container.Config{}, &hostConfig); err != nil {
+		t.Fatal(err)
+	}
+	if e4.GPUs != nil || hostConfig.Runtime != "" {
+		t.Errorf("unexpected GPU configuration %v, %v", e4.GPUs, hostConfig.Runtime)
+	}
+}
+
+func TestGPUsUnsupported(t *testing.T) {
+	e := newGPUExec(t, &Executor{}, 1)
+	if err := e.configureGPUs(&container.Config{}, &container.HostConfig{}); !errors.Is(errors.NotSupported, err) {
+		t.Errorf("expected NotSupported, got %v", err)
+	}
+	gpus, err := NewGPUs(fakePlugin{[]string{"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = newGPUExec(t, &Executor{GPUs: gpus}, 2)
+	if err := e.configureGPUs(&container.Config{}, &container.HostConfig{}); !errors.Is(errors.ResourcesExhausted, err) {
+		t.Errorf("expected ResourcesExhausted, got %v", err)
+	}
+}
+
+func TestNvidiaPluginConfigure(t *testing.T) {
+	var (
+		config     container.Config
+		hostConfig container.HostConfig
+	)
+	config.Env = []string{"HOME=/tmp"}
+	NvidiaPlugin{}.Configure([]string{"1", "3"}, &config, &hostConfig)
+	if got, want := hostConfig.Runtime, "nvidia"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := []string{"HOME=/tmp", "NVIDIA_VISIBLE_DEVICES=1,3", "NVIDIA_DRIVER_CAPABILITIES=compute,utility"}
+	if got := config.Env; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -36,4 +36,6 @@ type Manifest struct {
 	Resources reflow.Resources
 	Stats     stats
 	Gauges    reflow.Gauges
+	// GPUs holds the IDs of the GPUs assigned to the exec, if any.
+	GPUs []string `json:",omitempty"`
 }
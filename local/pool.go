@@ -86,10 +86,18 @@ type Pool struct {
 
 	HardMemLimit bool
 
+	// GPUPlugin, if non-nil, provides the host's GPUs, which are
+	// then offered as the pool's "gpu" resource.
+	GPUPlugin DevicePlugin
+
+	gpus      *GPUs
 	mu        sync.Mutex
 	allocs    map[string]*alloc // the set of active allocs
 	resources reflow.Resources  // the total amount of available resources
 	stopped   bool
+	// terminating is the time at which the pool's host is scheduled
+	// to be terminated, if any.
+	terminating time.Time
 }
 
 // saveState saves the current state of the pool to Prefix/Dir/state.json.
@@ -149,6 +157,14 @@ func (p *Pool) Start() error {
 		// Add one feature per CPU.
 		p.resources[feature] = p.resources["cpu"]
 	}
+	if p.GPUPlugin != nil {
+		if p.gpus, err = NewGPUs(p.GPUPlugin); err != nil {
+			return err
+		}
+		if n := p.gpus.Len(); n > 0 {
+			p.resources["gpu"] = float64(n)
+		}
+	}
 	root := filepath.Join(p.Prefix, p.Dir)
 	if err := os.MkdirAll(root, 0777); err != nil {
 		log.Printf("mkdir %s: %v", root, err)
@@ -339,6 +355,24 @@ func (p *Pool) free(a *alloc) error {
 	return nil
 }
 
+// SetTerminationTime records that the pool's host is scheduled to be
+// terminated at time t. The pool makes no further offers, and its
+// allocs report the pending termination through Inspect, so that
+// their users may move work elsewhere.
+func (p *Pool) SetTerminationTime(t time.Time) {
+	p.mu.Lock()
+	p.terminating = t
+	p.mu.Unlock()
+}
+
+// TerminationTime returns the time at which the pool's host is
+// scheduled to be terminated, or the zero time.
+func (p *Pool) TerminationTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.terminating
+}
+
 // alive tells whether an alloc's lease is current.
 func (p *Pool) alive(a *alloc) bool {
 	p.mu.Lock()
@@ -372,7 +406,7 @@ func (p *Pool) Offer(ctx context.Context, id string) (pool.Offer, error) {
 func (p *Pool) Offers(ctx context.Context) ([]pool.Offer, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.stopped {
+	if p.stopped || !p.terminating.IsZero() {
 		return nil, nil
 	}
 	var reserved reflow.Resources
@@ -459,6 +493,7 @@ type alloc struct {
 	lastKeepalive time.Time
 	freed         bool
 	meta          pool.AllocMeta
+	state         pool.AllocState
 	remoteStream
 }
 
@@ -477,6 +512,7 @@ func (p *Pool) newAlloc(id string, keepalive time.Duration) *alloc {
 		Blob:          p.Blob,
 		Log:           p.Log.Tee(nil, id+": "),
 		HardMemLimit:  p.HardMemLimit,
+		GPUs:          p.gpus,
 	}
 
 	// TODO(pgopal) - Get this info from Config.
@@ -595,19 +631,36 @@ func (a *alloc) Keepalive(ctx context.Context, next time.Duration) (time.Duratio
 
 // Inspect returns the alloc's status.
 func (a *alloc) Inspect(ctx context.Context) (pool.AllocInspect, error) {
+	terminating := a.p.TerminationTime()
 	a.mu.Lock()
 	i := pool.AllocInspect{
-		ID:            a.id,
-		Resources:     a.meta.Want,
-		Meta:          a.meta,
-		Created:       a.created,
-		Expires:       a.expires,
-		LastKeepalive: a.lastKeepalive,
+		ID:              a.id,
+		Resources:       a.meta.Want,
+		Meta:            a.meta,
+		Created:         a.created,
+		Expires:         a.expires,
+		LastKeepalive:   a.lastKeepalive,
+		TerminationTime: terminating,
+		State:           a.state,
 	}
 	a.mu.Unlock()
 	return i, nil
 }
 
+// SetState sets the alloc's administrative state. The state is
+// advisory: it is reported by Inspect so that the alloc's owner may
+// act on it.
+func (a *alloc) SetState(ctx context.Context, state pool.AllocState) error {
+	if !a.p.alive(a) {
+		return errors.E("setstate", a.id, errors.NotExist, errAllocExpired)
+	}
+	a.mu.Lock()
+	a.state = state
+	a.mu.Unlock()
+	a.Log.Printf("state set to %s", state)
+	return nil
+}
+
 // Free relinquishes this alloc from its pool and kills its
 // resources. The alloc's repository is removed, but its metadata and
 // logs are kept intact so that they may be examined posthumously.
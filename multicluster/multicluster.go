@@ -0,0 +1,378 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package multicluster implements a runner.Cluster that federates a
+// set of member clusters, for example a fixed inventory of
+// on-premises hosts together with an elastic EC2 cluster.
+//
+// Member clusters are tried in priority order: an allocation is
+// served by the first member that can satisfy it. Each member may
+// cap the total resources that are allocated from it, and may be
+// designated a spill member, which is used only when the scheduler's
+// queue of tasks waiting for an alloc is at least a configured
+// length. Allocs are labeled with the name of the member from which
+// they were allocated.
+//
+// Allocs from different members have distinct repositories; the
+// scheduler transfers task inputs and outputs to and from the
+// scheduler's repository as it does for any alloc, so that data
+// locality is maintained regardless of where a task runs.
+//
+// A multicluster may be configured as follows:
+//
+//	cluster: multicluster
+//	multicluster:
+//	  clusters:
+//	  - name: lab
+//	    cluster: hostcluster
+//	  - name: cloud
+//	    cluster: ec2cluster
+//	    maxcpu: 1024
+//	    maxmem: 4096
+//	    spillqueue: 20
+//
+// Member clusters are configured by their own providers' keys (here,
+// "hostcluster" and "ec2cluster").
+package multicluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/runner"
+)
+
+// ClusterLabel is the alloc label that names the member cluster
+// from which the alloc was allocated.
+const ClusterLabel = "cluster"
+
+var (
+	// attemptTimeout bounds each allocation attempt on a member
+	// cluster, after which the members' eligibility is reevaluated.
+	attemptTimeout = time.Minute
+	// pollInterval is the interval at which allocation is reattempted
+	// when no member cluster could serve a request.
+	pollInterval = 10 * time.Second
+)
+
+func init() {
+	infra.Register("multicluster", new(Cluster))
+}
+
+// Member is a member cluster of a multicluster.
+type Member struct {
+	// Name names the member. Allocs from the member are labeled
+	// with its name.
+	Name string `yaml:"name"`
+	// Provider is the name of the member's cluster provider,
+	// e.g., "hostcluster" or "ec2cluster".
+	Provider string `yaml:"cluster"`
+	// MaxCPU is the maximum number of CPUs that may be allocated
+	// from the member at any time. Zero means no limit.
+	MaxCPU float64 `yaml:"maxcpu,omitempty"`
+	// MaxMem is the maximum amount of memory (GiB) that may be
+	// allocated from the member at any time. Zero means no limit.
+	MaxMem float64 `yaml:"maxmem,omitempty"`
+	// SpillQueue is the scheduler queue length at or above which the
+	// member is used. Zero means that the member is always used.
+	SpillQueue int `yaml:"spillqueue,omitempty"`
+
+	// Cluster is the member's cluster instance.
+	Cluster runner.Cluster `yaml:"-"`
+
+	// used is the amount of resources currently allocated (or being
+	// allocated) from the member. It is guarded by the multicluster's
+	// mutex.
+	used reflow.Resources
+}
+
+// fits tells whether need may be allocated from the member, given
+// that the resources used are already allocated, without exceeding
+// its limits.
+func (m *Member) fits(used, need reflow.Resources) bool {
+	if m.MaxCPU > 0 && used["cpu"]+need["cpu"] > m.MaxCPU {
+		return false
+	}
+	if m.MaxMem > 0 && used["mem"]+need["mem"] > m.MaxMem*float64(data.GiB) {
+		return false
+	}
+	return true
+}
+
+// Cluster implements a runner.Cluster over a prioritized set of
+// member clusters.
+type Cluster struct {
+	pool.Mux `yaml:"-"`
+
+	// Members is the set of member clusters, in priority order.
+	Members []*Member `yaml:"clusters"`
+	// Log is the cluster's logger.
+	Log *log.Logger `yaml:"-"`
+	// QueueLen returns the length of the scheduler's queue of tasks
+	// that are waiting for an alloc. If QueueLen is nil, spill
+	// thresholds are ignored and members are tried purely in
+	// priority order.
+	QueueLen func() int `yaml:"-"`
+
+	mu sync.Mutex
+}
+
+// Help implements infra.Provider.
+func (*Cluster) Help() string {
+	return "configure a cluster that federates a prioritized set of clusters"
+}
+
+// Config implements infra.Provider.
+func (c *Cluster) Config() interface{} {
+	return c
+}
+
+// Init implements infra.Provider.
+func (c *Cluster) Init(logger *log.Logger) error {
+	c.Log = logger.Tee(nil, "multicluster: ")
+	return c.init()
+}
+
+// init validates the cluster's members.
+func (c *Cluster) init() error {
+	if len(c.Members) == 0 {
+		return errors.New("multicluster: no clusters configured")
+	}
+	names := make(map[string]bool)
+	for _, m := range c.Members {
+		if m.Name == "" {
+			m.Name = m.Provider
+		}
+		if m.Name == "" {
+			return errors.New("multicluster: cluster has neither name nor provider")
+		}
+		if names[m.Name] {
+			return errors.Errorf("multicluster: duplicate cluster %s", m.Name)
+		}
+		names[m.Name] = true
+		if m.Provider == "multicluster" {
+			return errors.Errorf("multicluster: cluster %s: multiclusters cannot be nested", m.Name)
+		}
+		if m.Provider == "" && m.Cluster == nil {
+			return errors.Errorf("multicluster: cluster %s: no provider", m.Name)
+		}
+	}
+	return nil
+}
+
+// Configure instantiates the member clusters that have not yet been
+// instantiated, using the provided function to create each member's
+// cluster from its provider.
+func (c *Cluster) Configure(cluster func(m *Member) (runner.Cluster, error)) error {
+	pools := make([]pool.Pool, len(c.Members))
+	for i, m := range c.Members {
+		if m.Cluster == nil {
+			var err error
+			if m.Cluster, err = cluster(m); err != nil {
+				return errors.E(fmt.Sprintf("multicluster: cluster %s", m.Name), err)
+			}
+		}
+		pools[i] = m.Cluster
+	}
+	c.SetPools(pools)
+	return nil
+}
+
+// VerifyAndInit verifies and initializes the member clusters that
+// require it.
+func (c *Cluster) VerifyAndInit() error {
+	for _, m := range c.Members {
+		v, ok := m.Cluster.(interface{ VerifyAndInit() error })
+		if !ok {
+			continue
+		}
+		if err := v.VerifyAndInit(); err != nil {
+			return errors.E(fmt.Sprintf("multicluster: cluster %s", m.Name), err)
+		}
+	}
+	return nil
+}
+
+// Allocate reserves an alloc from the first member cluster, in
+// priority order, that is eligible for and can satisfy the request.
+// A member is eligible if allocating the request from it would not
+// exceed its resource limits, and if the scheduler's queue has
+// reached its spill threshold. Allocate returns an error of kind
+// errors.ResourcesExhausted if no member can ever satisfy the
+// request.
+func (c *Cluster) Allocate(ctx context.Context, req reflow.Requirements, labels pool.Labels) (pool.Alloc, error) {
+	exhausted := make(map[*Member]bool)
+	for {
+		for _, m := range c.Members {
+			if !m.fits(nil, req.Min) {
+				exhausted[m] = true
+			}
+			if exhausted[m] || !c.reserve(m, req.Min) {
+				continue
+			}
+			actx, cancel := context.WithTimeout(ctx, attemptTimeout)
+			alloc, err := m.Cluster.Allocate(actx, req, labels.Add(ClusterLabel, m.Name))
+			cancel()
+			if err == nil {
+				c.Log.Printf("allocated %s (%s) from cluster %s", alloc.ID(), alloc.Resources(), m.Name)
+				return c.track(m, req.Min, alloc), nil
+			}
+			c.release(m, req.Min)
+			switch {
+			case ctx.Err() != nil:
+				return nil, ctx.Err()
+			case errors.Is(errors.ResourcesExhausted, err):
+				exhausted[m] = true
+			case err != context.DeadlineExceeded:
+				c.Log.Errorf("cluster %s: allocate %s: %v", m.Name, req, err)
+			}
+		}
+		if len(exhausted) == len(c.Members) {
+			return nil, errors.E(errors.ResourcesExhausted,
+				errors.Errorf("requested resources %s not satisfiable by any cluster", req))
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reserve reserves need from member m if m is eligible for it.
+func (c *Cluster) reserve(m *Member, need reflow.Resources) bool {
+	if c.QueueLen != nil && m.SpillQueue > 0 && c.QueueLen() < m.SpillQueue {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !m.fits(m.used, need) {
+		return false
+	}
+	m.used.Add(m.used, need)
+	return true
+}
+
+// release returns resources r to member m.
+func (c *Cluster) release(m *Member, r reflow.Resources) {
+	c.mu.Lock()
+	m.used.Sub(m.used, r)
+	c.mu.Unlock()
+}
+
+// track replaces the reservation of resources reserved from member m
+// with the resources of the provided alloc, and returns an alloc
+// that releases them when the alloc is freed or expires.
+func (c *Cluster) track(m *Member, reserved reflow.Resources, a pool.Alloc) pool.Alloc {
+	resources := a.Resources()
+	c.mu.Lock()
+	m.used.Sub(m.used, reserved)
+	m.used.Add(m.used, resources)
+	c.mu.Unlock()
+	return &alloc{Alloc: a, member: m, release: func() { c.release(m, resources) }}
+}
+
+// Used returns the resources currently allocated from each member
+// cluster, keyed by member name.
+func (c *Cluster) Used() map[string]reflow.Resources {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	used := make(map[string]reflow.Resources)
+	for _, m := range c.Members {
+		var r reflow.Resources
+		r.Set(m.used)
+		used[m.Name] = r
+	}
+	return used
+}
+
+// Alloc returns the alloc named by id from the member cluster that
+// manages it.
+func (c *Cluster) Alloc(ctx context.Context, id string) (pool.Alloc, error) {
+	for _, p := range c.Pools() {
+		alloc, err := p.Alloc(ctx, id)
+		if err == nil || !errors.Is(errors.NotExist, err) {
+			return alloc, err
+		}
+	}
+	return nil, errors.E("alloc", id, errors.NotExist)
+}
+
+// Offer returns the offer named by id from the member cluster that
+// manages it.
+func (c *Cluster) Offer(ctx context.Context, id string) (pool.Offer, error) {
+	for _, p := range c.Pools() {
+		offer, err := p.Offer(ctx, id)
+		if err == nil || !errors.Is(errors.NotExist, err) {
+			return offer, err
+		}
+	}
+	return nil, errors.E("offer", id, errors.NotExist)
+}
+
+// Price returns the estimated hourly price, in US dollars, of the
+// provided alloc, as priced by the member cluster from which it was
+// allocated. Allocs from members that do not price their allocs are
+// not priced.
+func (c *Cluster) Price(a pool.Alloc) (float64, bool) {
+	ma, ok := a.(*alloc)
+	if !ok {
+		return 0, false
+	}
+	pricer, ok := ma.member.Cluster.(interface {
+		Price(pool.Alloc) (float64, bool)
+	})
+	if !ok {
+		return 0, false
+	}
+	return pricer.Price(ma.Alloc)
+}
+
+// Shutdown shuts down all member clusters.
+func (c *Cluster) Shutdown() error {
+	var err error
+	for _, m := range c.Members {
+		if m.Cluster == nil {
+			continue
+		}
+		if merr := m.Cluster.Shutdown(); merr != nil && err == nil {
+			err = merr
+		}
+	}
+	return err
+}
+
+// alloc is an alloc from a member cluster. It releases the
+// resources it accounts for when it is freed or expired.
+type alloc struct {
+	pool.Alloc
+	member  *Member
+	once    sync.Once
+	release func()
+}
+
+// Keepalive implements pool.Alloc.
+func (a *alloc) Keepalive(ctx context.Context, interval time.Duration) (time.Duration, error) {
+	d, err := a.Alloc.Keepalive(ctx, interval)
+	// A zero interval requests that the alloc expire.
+	if interval == 0 || errors.Is(errors.NotExist, err) || errors.Is(errors.Fatal, err) {
+		a.once.Do(a.release)
+	}
+	return d, err
+}
+
+// Free implements pool.Alloc.
+func (a *alloc) Free(ctx context.Context) error {
+	err := a.Alloc.Free(ctx)
+	a.once.Do(a.release)
+	return err
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package multicluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/runner"
+)
+
+// testAlloc is an alloc of a testCluster.
+type testAlloc struct {
+	pool.Alloc
+	c         *testCluster
+	id        string
+	resources reflow.Resources
+	labels    pool.Labels
+}
+
+func (a *testAlloc) ID() string                  { return a.id }
+func (a *testAlloc) Resources() reflow.Resources { return a.resources }
+
+func (a *testAlloc) Keepalive(ctx context.Context, interval time.Duration) (time.Duration, error) {
+	return interval, nil
+}
+
+func (a *testAlloc) Free(ctx context.Context) error {
+	a.c.mu.Lock()
+	a.c.available.Add(a.c.available, a.resources)
+	a.c.mu.Unlock()
+	return nil
+}
+
+// testCluster is a cluster of fixed size. Allocations that cannot
+// be satisfied by its available resources block until they time out.
+type testCluster struct {
+	pool.Mux
+	name      string
+	size      reflow.Resources
+	mu        sync.Mutex
+	available reflow.Resources
+	n         int
+}
+
+func newTestCluster(name string, cpu float64) *testCluster {
+	size := reflow.Resources{"cpu": cpu}
+	c := &testCluster{name: name, size: size}
+	c.available.Set(size)
+	return c
+}
+
+func (c *testCluster) Allocate(ctx context.Context, req reflow.Requirements, labels pool.Labels) (pool.Alloc, error) {
+	if !c.size.Available(req.Min) {
+		return nil, errors.E(errors.ResourcesExhausted)
+	}
+	c.mu.Lock()
+	if !c.available.Available(req.Min) {
+		c.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer c.mu.Unlock()
+	c.available.Sub(c.available, req.Min)
+	c.n++
+	return &testAlloc{c: c, id: fmt.Sprintf("%s/%d", c.name, c.n), resources: req.Min, labels: labels}, nil
+}
+
+func (c *testCluster) Shutdown() error { return nil }
+
+func TestMultiCluster(t *testing.T) {
+	attemptTimeout, pollInterval = 50*time.Millisecond, 10*time.Millisecond
+	var (
+		lab   = newTestCluster("lab", 8)
+		cloud = newTestCluster("cloud", 100)
+		queue int64
+		c     = &Cluster{
+			Log: log.Std,
+			Members: []*Member{
+				{Name: "lab", Cluster: lab},
+				{Name: "cloud", Cluster: cloud, MaxCPU: 16, SpillQueue: 5},
+			},
+			QueueLen: func() int { return int(atomic.LoadInt64(&queue)) },
+		}
+	)
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Configure(func(*Member) (runner.Cluster, error) { panic("unexpected") }); err != nil {
+		t.Fatal(err)
+	}
+	allocate := func(cpu float64) (*testAlloc, pool.Alloc, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		a, err := c.Allocate(ctx, reflow.Requirements{Min: reflow.Resources{"cpu": cpu}}, pool.Labels{"user": "test"})
+		if err != nil {
+			return nil, nil, err
+		}
+		return a.(*alloc).Alloc.(*testAlloc), a, nil
+	}
+
+	// The lab is used until it is full.
+	for i := 0; i < 2; i++ {
+		a, _, err := allocate(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := a.c, lab; got != want {
+			t.Errorf("got %s, want %s", got.name, want.name)
+		}
+		if got, want := a.labels[ClusterLabel], "lab"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := a.labels["user"], "test"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	// The cloud is not used until the queue reaches its spill threshold.
+	if _, _, err := allocate(4); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	atomic.StoreInt64(&queue, 5)
+	a, cloudAlloc, err := allocate(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.labels[ClusterLabel], "cloud"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Allocations may not exceed the cloud's limit.
+	if _, _, err := allocate(16); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got, want := c.Used()["cloud"]["cpu"], 4.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := cloudAlloc.Free(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Used()["cloud"]["cpu"], 0.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, err := allocate(16); err != nil {
+		t.Fatal(err)
+	}
+	// Requests that no member can satisfy fail immediately.
+	if _, _, err := allocate(32); !errors.Is(errors.ResourcesExhausted, err) {
+		t.Errorf("got %v, want resources exhausted", err)
+	}
+}
+
+func TestMultiClusterConfig(t *testing.T) {
+	for _, members := range [][]*Member{
+		nil,
+		{{}},
+		{{Name: "a", Provider: "hostcluster"}, {Name: "a", Provider: "ec2cluster"}},
+		{{Provider: "multicluster"}},
+	} {
+		c := &Cluster{Log: log.Std, Members: members}
+		if err := c.init(); err == nil {
+			t.Errorf("members %v: expected error", members)
+		}
+	}
+	c := &Cluster{Log: log.Std, Members: []*Member{{Provider: "hostcluster"}, {Name: "cloud", Provider: "ec2cluster"}}}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	var providers []string
+	err := c.Configure(func(m *Member) (runner.Cluster, error) {
+		providers = append(providers, m.Provider)
+		return newTestCluster(m.Name, 1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(providers), "[hostcluster ec2cluster]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := c.Members[0].Name, "hostcluster"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := c.Size(), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
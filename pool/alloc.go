@@ -51,6 +51,53 @@ type Alloc interface {
 	Free(ctx context.Context) error
 }
 
+// AllocState is the administrative state of an alloc.
+type AllocState int
+
+const (
+	// AllocActive indicates that the alloc may be assigned new work.
+	AllocActive AllocState = iota
+	// AllocCordoned indicates that the alloc should not be assigned
+	// new work. Work in progress is unaffected.
+	AllocCordoned
+	// AllocDraining indicates that the alloc should not be assigned
+	// new work, and that it should be freed once work in progress
+	// is complete.
+	AllocDraining
+)
+
+var allocStates = [...]string{
+	AllocActive:   "active",
+	AllocCordoned: "cordoned",
+	AllocDraining: "draining",
+}
+
+// String returns the state's name.
+func (s AllocState) String() string {
+	if s < 0 || int(s) >= len(allocStates) {
+		return fmt.Sprintf("AllocState(%d)", s)
+	}
+	return allocStates[s]
+}
+
+// StateSetter is implemented by allocs whose administrative state
+// may be changed.
+type StateSetter interface {
+	// SetState sets the alloc's administrative state.
+	SetState(ctx context.Context, state AllocState) error
+}
+
+// SetState sets the administrative state of the provided alloc.
+// SetState returns an error of kind errors.NotSupported if the alloc
+// does not implement StateSetter.
+func SetState(ctx context.Context, alloc Alloc, state AllocState) error {
+	s, ok := alloc.(StateSetter)
+	if !ok {
+		return errors.E("setstate", alloc.ID(), errors.NotSupported)
+	}
+	return s.SetState(ctx, state)
+}
+
 // Labels represents a set of metadata labels for a run.
 type Labels map[string]string
 
@@ -85,6 +132,13 @@ type AllocInspect struct {
 	Created       time.Time
 	LastKeepalive time.Time
 	Expires       time.Time
+	// TerminationTime is the time at which the alloc's host is
+	// scheduled to be terminated, for example because its spot
+	// instance is being interrupted. It is zero if no termination is
+	// scheduled.
+	TerminationTime time.Time
+	// State is the alloc's administrative state.
+	State AllocState
 }
 
 // keepalive returns the interval to the next keepalive.
@@ -214,6 +214,23 @@ func (a *clientAlloc) Free(ctx context.Context) error {
 	return nil
 }
 
+// SetState sets the administrative state of a remote alloc.
+func (a *clientAlloc) SetState(ctx context.Context, state pool.AllocState) error {
+	call := a.Call("POST", "allocs/%s/state", a.id)
+	defer call.Close()
+	arg := struct {
+		State pool.AllocState
+	}{state}
+	code, err := call.DoJSON(ctx, arg)
+	if err != nil {
+		return errors.E("setstate", a.ID(), fmt.Sprint(state), err)
+	}
+	if code != http.StatusOK {
+		return call.Error()
+	}
+	return nil
+}
+
 // Inspect returns metadata for the alloc.
 func (a *clientAlloc) Inspect(ctx context.Context) (pool.AllocInspect, error) {
 	call := a.Call("GET", "allocs/%s", a.id)
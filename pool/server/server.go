@@ -8,6 +8,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -16,25 +17,148 @@ import (
 	"github.com/grailbio/base/digest"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
 	repositoryserver "github.com/grailbio/reflow/repository/server"
 	"github.com/grailbio/reflow/rest"
 )
 
 // NewNode returns a rest.Node that implements the pool REST API.
+// Calls are not authenticated.
 func NewNode(p pool.Pool) rest.Node {
+	return NewAuthNode(p, Auth{})
+}
+
+// NewAuthNode returns a rest.Node that implements the pool REST
+// API, authenticating and authorizing calls as configured by auth.
+func NewAuthNode(p pool.Pool, auth Auth) rest.Node {
+	a := newAuthorizer(auth)
 	v1 := rest.Mux{
-		"allocs": allocsNode{p},
-		"offers": offersNode{p},
+		"allocs": allocsNode{p, a},
+		"offers": offersNode{p, a},
 	}
 	return rest.Mux{"v1": v1}
 }
 
+// Auth configures the authentication and authorization of calls to
+// a pool server. Authenticated users may inspect the pool, and may
+// create allocs; they may operate on (keep alive, kill, run execs
+// in, or shell into) only the allocs that they created, unless they
+// are admins. Any authenticated user may access allocs'
+// repositories, which are used for transfers between pools; so may
+// reflowlets, which authenticate with the transfer identity.
+type Auth struct {
+	// Authenticator authenticates the users making calls. If nil,
+	// calls are neither authenticated nor authorized.
+	Authenticator rest.Authenticator
+	// Transfer, if not nil, authenticates the identity with which
+	// reflowlets transfer data between allocs' repositories. Calls
+	// so authenticated may access only allocs' repositories.
+	Transfer rest.Authenticator
+	// Admins is the set of users that may operate on any alloc.
+	Admins []string
+	// Audit, if not nil, logs privileged calls: alloc creation,
+	// state changes, and kills, as well as exec creation and shells.
+	Audit *log.Logger
+}
+
+// userLabel is the alloc label that records the (authenticated)
+// user that created the alloc.
+const userLabel = "user"
+
+type authorizer struct {
+	Auth
+	admins map[string]bool
+}
+
+func newAuthorizer(auth Auth) *authorizer {
+	a := &authorizer{Auth: auth, admins: make(map[string]bool)}
+	for _, user := range auth.Admins {
+		a.admins[user] = true
+	}
+	return a
+}
+
+// Authenticate returns the user making the call; it fails the call
+// and returns false if the call cannot be authenticated.
+// Authenticate returns the empty user if calls are not
+// authenticated.
+func (a *authorizer) Authenticate(call *rest.Call) (string, bool) {
+	if a.Authenticator == nil {
+		return "", true
+	}
+	return call.Authenticate(a.Authenticator)
+}
+
+// AuthenticateRepository returns the user or the transfer identity
+// making a call to an alloc's repository; it fails the call and
+// returns false if the call can be authenticated as neither.
+func (a *authorizer) AuthenticateRepository(call *rest.Call) (string, bool) {
+	if a.Authenticator == nil {
+		return "", true
+	}
+	if a.Transfer == nil {
+		return call.Authenticate(a.Authenticator)
+	}
+	return call.Authenticate(firstAuthenticator{a.Authenticator, a.Transfer})
+}
+
+// firstAuthenticator is a rest.Authenticator that authenticates
+// requests with the first of its authenticators that succeeds.
+type firstAuthenticator []rest.Authenticator
+
+// Authenticate implements rest.Authenticator.
+func (auths firstAuthenticator) Authenticate(r *http.Request) (string, error) {
+	var err error
+	for _, auth := range auths {
+		var user string
+		if user, err = auth.Authenticate(r); err == nil {
+			return user, nil
+		}
+	}
+	return "", err
+}
+
+// Authorize tells whether the provided (authenticated) user may
+// operate on the provided alloc; it fails the call if not.
+func (a *authorizer) Authorize(ctx context.Context, call *rest.Call, user string, alloc pool.Alloc) bool {
+	if a.Authenticator == nil || a.admins[user] {
+		return true
+	}
+	inspect, err := alloc.Inspect(ctx)
+	if err != nil {
+		call.Error(err)
+		return false
+	}
+	if owner := inspect.Meta.Labels[userLabel]; owner != user {
+		call.Reply(http.StatusForbidden, errors.E("authorize", alloc.ID(), user, errors.NotAllowed,
+			errors.Errorf("alloc is owned by %q", owner)))
+		return false
+	}
+	return true
+}
+
+// Printf logs a privileged call made by the provided user.
+func (a *authorizer) Printf(user string, format string, args ...interface{}) {
+	if a.Audit == nil {
+		return
+	}
+	if user == "" {
+		user = "(unauthenticated)"
+	}
+	a.Audit.Printf("user %s: %s", user, fmt.Sprintf(format, args...))
+}
+
 type offersNode struct {
-	p pool.Pool
+	p    pool.Pool
+	auth *authorizer
 }
 
 func (n offersNode) Walk(ctx context.Context, call *rest.Call, path string) rest.Node {
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return nil
+	}
 	offer, err := n.p.Offer(ctx, path)
 	if err != nil {
 		call.Error(err)
@@ -56,11 +180,17 @@ func (n offersNode) Walk(ctx context.Context, call *rest.Call, path string) rest
 			if call.Unmarshal(&meta) != nil {
 				return
 			}
+			if n.auth.Authenticator != nil {
+				// Record the authenticated user, not the one claimed by the caller.
+				meta.Labels = meta.Labels.Copy()
+				meta.Labels[userLabel] = user
+			}
 			alloc, err := offer.Accept(ctx, meta)
 			if err != nil {
 				call.Error(err)
 				return
 			}
+			n.auth.Printf(user, "create alloc %s (%s)", alloc.ID(), alloc.Resources())
 			call.Reply(http.StatusOK, pool.AllocInspect{
 				ID:        alloc.ID(),
 				Resources: alloc.Resources(),
@@ -73,6 +203,9 @@ func (n offersNode) Do(ctx context.Context, call *rest.Call) {
 	if !call.Allow("GET") {
 		return
 	}
+	if _, ok := n.auth.Authenticate(call); !ok {
+		return
+	}
 	offers, err := n.p.Offers(ctx)
 	if err != nil {
 		call.Error(err)
@@ -87,7 +220,8 @@ func (n offersNode) Do(ctx context.Context, call *rest.Call) {
 }
 
 type allocsNode struct {
-	m pool.Pool
+	m    pool.Pool
+	auth *authorizer
 }
 
 func (n allocsNode) Walk(ctx context.Context, call *rest.Call, path string) rest.Node {
@@ -96,13 +230,16 @@ func (n allocsNode) Walk(ctx context.Context, call *rest.Call, path string) rest
 		call.Error(err)
 		return nil
 	}
-	return allocNode{alloc}
+	return allocNode{alloc, n.auth}
 }
 
 func (n allocsNode) Do(ctx context.Context, call *rest.Call) {
 	if !call.Allow("GET") {
 		return
 	}
+	if _, ok := n.auth.Authenticate(call); !ok {
+		return
+	}
 	allocs, err := n.m.Allocs(ctx)
 	if err != nil {
 		call.Error(err)
@@ -119,16 +256,34 @@ func (n allocsNode) Do(ctx context.Context, call *rest.Call) {
 }
 
 type allocNode struct {
-	a pool.Alloc
+	a    pool.Alloc
+	auth *authorizer
 }
 
 func (n allocNode) Walk(ctx context.Context, call *rest.Call, path string) rest.Node {
+	if path == "repository" {
+		if _, ok := n.auth.AuthenticateRepository(call); !ok {
+			return nil
+		}
+		repo := n.a.Repository()
+		if repo == nil {
+			return nil
+		}
+		return repositoryserver.Node{repo}
+	}
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return nil
+	}
 	switch path {
 	case "keepalive":
 		return rest.DoFunc(func(ctx context.Context, call *rest.Call) {
 			if !call.Allow("POST") {
 				return
 			}
+			if !n.auth.Authorize(ctx, call, user, n.a) {
+				return
+			}
 			var arg struct {
 				Interval time.Duration
 			}
@@ -142,19 +297,37 @@ func (n allocNode) Walk(ctx context.Context, call *rest.Call, path string) rest.
 			}
 			call.Reply(http.StatusOK, struct{ Interval time.Duration }{d})
 		})
+	case "state":
+		return rest.DoFunc(func(ctx context.Context, call *rest.Call) {
+			if !call.Allow("POST") {
+				return
+			}
+			if !n.auth.Authorize(ctx, call, user, n.a) {
+				return
+			}
+			var arg struct {
+				State pool.AllocState
+			}
+			if call.Unmarshal(&arg) != nil {
+				return
+			}
+			n.auth.Printf(user, "set alloc %s state %s", n.a.ID(), arg.State)
+			if err := pool.SetState(ctx, n.a, arg.State); err != nil {
+				call.Error(err)
+				return
+			}
+			call.Replyf(http.StatusOK, "alloc %s", arg.State)
+		})
 	case "execs":
-		return execsNode{n.a}
-	case "repository":
-		repo := n.a.Repository()
-		if repo == nil {
-			return nil
-		}
-		return repositoryserver.Node{repo}
+		return execsNode{n.a, n.auth, user}
 	case "load":
 		return rest.DoFunc(func(ctx context.Context, call *rest.Call) {
 			if !call.Allow("POST") {
 				return
 			}
+			if !n.auth.Authorize(ctx, call, user, n.a) {
+				return
+			}
 			arg := struct {
 				Fileset reflow.Fileset
 				SrcUrl  *url.URL
@@ -174,6 +347,9 @@ func (n allocNode) Walk(ctx context.Context, call *rest.Call, path string) rest.
 			if !call.Allow("POST") {
 				return
 			}
+			if !n.auth.Authorize(ctx, call, user, n.a) {
+				return
+			}
 			var fs reflow.Fileset
 			if call.Unmarshal(&fs) != nil {
 				return
@@ -190,6 +366,9 @@ func (n allocNode) Walk(ctx context.Context, call *rest.Call, path string) rest.
 			if !call.Allow("POST") {
 				return
 			}
+			if !n.auth.Authorize(ctx, call, user, n.a) {
+				return
+			}
 			var fs reflow.Fileset
 			if call.Unmarshal(&fs) != nil {
 				return
@@ -210,6 +389,10 @@ func (n allocNode) Do(ctx context.Context, call *rest.Call) {
 	if !call.Allow("DELETE", "GET") {
 		return
 	}
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return
+	}
 	switch call.Method() {
 	case "GET":
 		inspect, err := n.a.Inspect(ctx)
@@ -219,6 +402,10 @@ func (n allocNode) Do(ctx context.Context, call *rest.Call) {
 		}
 		call.Reply(http.StatusOK, inspect)
 	case "DELETE":
+		if !n.auth.Authorize(ctx, call, user, n.a) {
+			return
+		}
+		n.auth.Printf(user, "kill alloc %s", n.a.ID())
 		err := n.a.Free(ctx)
 		if err != nil {
 			call.Error(err)
@@ -228,7 +415,11 @@ func (n allocNode) Do(ctx context.Context, call *rest.Call) {
 	}
 }
 
-type execsNode struct{ a pool.Alloc }
+type execsNode struct {
+	a    pool.Alloc
+	auth *authorizer
+	user string
+}
 
 func (n execsNode) Walk(ctx context.Context, call *rest.Call, path string) rest.Node {
 	id, err := reflow.Digester.Parse(path)
@@ -239,14 +430,14 @@ func (n execsNode) Walk(ctx context.Context, call *rest.Call, path string) rest.
 	switch call.Method() {
 	case "PUT":
 		// TODO: validate exec ID
-		return putExecNode{n.a, id}
+		return putExecNode{n.a, id, n.auth, n.user}
 	default:
 		o, err := n.a.Get(context.TODO(), id)
 		if err != nil {
 			call.Error(err)
 			return nil
 		}
-		return execNode{o}
+		return execNode{o, n.a, n.auth, n.user}
 	}
 }
 
@@ -267,8 +458,10 @@ func (n execsNode) Do(ctx context.Context, call *rest.Call) {
 }
 
 type putExecNode struct {
-	e  reflow.Executor
-	id digest.Digest
+	a    pool.Alloc
+	id   digest.Digest
+	auth *authorizer
+	user string
 }
 
 func (n putExecNode) Walk(ctx context.Context, call *rest.Call, path string) rest.Node {
@@ -279,11 +472,15 @@ func (n putExecNode) Do(ctx context.Context, call *rest.Call) {
 	if !call.Allow("PUT") {
 		return
 	}
+	if !n.auth.Authorize(ctx, call, n.user, n.a) {
+		return
+	}
 	var cfg reflow.ExecConfig
 	if call.Unmarshal(&cfg) != nil {
 		return
 	}
-	if _, err := n.e.Put(ctx, n.id, cfg); err != nil {
+	n.auth.Printf(n.user, "put exec %s in alloc %s (image %s)", n.id, n.a.ID(), cfg.Image)
+	if _, err := n.a.Put(ctx, n.id, cfg); err != nil {
 		call.Error(err)
 	} else {
 		call.Replyf(http.StatusOK, "exec %s created", n.id)
@@ -291,7 +488,10 @@ func (n putExecNode) Do(ctx context.Context, call *rest.Call) {
 }
 
 type execNode struct {
-	e reflow.Exec
+	e    reflow.Exec
+	a    pool.Alloc
+	auth *authorizer
+	user string
 }
 
 func (n execNode) logNode(stdout, stderr bool, follow string) rest.Node {
@@ -324,6 +524,10 @@ func (n execNode) shellNode() rest.Node {
 		if !call.Allow("POST") {
 			return
 		}
+		if !n.auth.Authorize(ctx, call, n.user, n.a) {
+			return
+		}
+		n.auth.Printf(n.user, "shell exec %s in alloc %s", n.e.ID(), n.a.ID())
 		rwc, err := n.e.Shell(ctx)
 		if err != nil {
 			call.Error(err)
@@ -383,6 +587,9 @@ func (n execNode) Walk(ctx context.Context, call *rest.Call, path string) rest.N
 			if !call.Allow("POST") {
 				return
 			}
+			if !n.auth.Authorize(ctx, call, n.user, n.a) {
+				return
+			}
 			if err := n.e.Promote(ctx); err != nil {
 				call.Error(err)
 				return
@@ -7,12 +7,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	golog "log"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/base/digest"
@@ -22,6 +25,7 @@ import (
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/pool/client"
+	repositoryclient "github.com/grailbio/reflow/repository/client"
 	"github.com/grailbio/reflow/rest"
 	rtestutil "github.com/grailbio/reflow/test/testutil"
 )
@@ -47,10 +51,23 @@ type testAlloc struct {
 	pool.Alloc
 	files    map[digest.Digest]bool
 	executor rtestutil.Executor
+	state    pool.AllocState
+	owner    string
+	repo     reflow.Repository
 }
 
-func (*testAlloc) Inspect(ctx context.Context) (pool.AllocInspect, error) {
-	return pool.AllocInspect{}, nil
+func (t *testAlloc) ID() string { return "testalloc" }
+
+func (t *testAlloc) Inspect(ctx context.Context) (pool.AllocInspect, error) {
+	return pool.AllocInspect{
+		State: t.state,
+		Meta:  pool.AllocMeta{Labels: pool.Labels{"user": t.owner}},
+	}, nil
+}
+
+func (t *testAlloc) SetState(ctx context.Context, state pool.AllocState) error {
+	t.state = state
+	return nil
 }
 
 func (t *testAlloc) Load(ctx context.Context, repo *url.URL, fs reflow.Fileset) (reflow.Fileset, error) {
@@ -79,6 +96,10 @@ func (t *testAlloc) Unload(ctx context.Context, fs reflow.Fileset) error {
 	return nil
 }
 
+func (t *testAlloc) Repository() reflow.Repository {
+	return t.repo
+}
+
 func (t *testAlloc) Get(ctx context.Context, id digest.Digest) (reflow.Exec, error) {
 	return t.executor.Get(ctx, id)
 }
@@ -97,7 +118,11 @@ func (t *testPool) Alloc(ctx context.Context, id string) (pool.Alloc, error) {
 		if t.testalloc != nil {
 			return t.testalloc, nil
 		}
-		testalloc := &testAlloc{files: make(map[digest.Digest]bool)}
+		testalloc := &testAlloc{
+			files: make(map[digest.Digest]bool),
+			owner: "alice",
+			repo:  rtestutil.NewInmemoryRepository(),
+		}
 		testalloc.executor.Init()
 		t.testalloc = testalloc
 		exec, err := t.testalloc.Put(ctx, reflow.Digester.FromString("testexec"), reflow.ExecConfig{})
@@ -145,6 +170,32 @@ func TestClientServerLoad(t *testing.T) {
 	}
 }
 
+func TestClientServerState(t *testing.T) {
+	srv := httptest.NewServer(rest.Handler(NewNode(&testPool{}), log.Std))
+	defer srv.Close()
+	clientPool, err := client.New(srv.URL+"/v1/", srv.Client(), log.Std)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	alloc, err := clientPool.Alloc(ctx, "testalloc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []pool.AllocState{pool.AllocCordoned, pool.AllocDraining, pool.AllocActive} {
+		if err := pool.SetState(ctx, alloc, state); err != nil {
+			t.Fatal(err)
+		}
+		inspect, err := alloc.Inspect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := inspect.State, state; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
 func TestEndToEnd(t *testing.T) {
 	srv := httptest.NewServer(rest.Handler(NewNode(&testPool{}), nil))
 	defer srv.Close()
@@ -174,3 +225,123 @@ func TestEndToEnd(t *testing.T) {
 		call.Close()
 	}
 }
+
+func TestClientServerAuth(t *testing.T) {
+	var audit bytes.Buffer
+	auth := Auth{
+		Authenticator: rest.Tokens{
+			"alice": rest.HashToken("alicetoken"),
+			"bob":   rest.HashToken("bobtoken"),
+			"carol": rest.HashToken("caroltoken"),
+		},
+		Admins:   []string{"carol"},
+		Audit:    log.New(golog.New(&audit, "", 0), log.InfoLevel),
+		Transfer: rest.Tokens{"reflowlet": rest.HashToken("transfertoken")},
+	}
+	srv := httptest.NewServer(rest.Handler(NewAuthNode(&testPool{}, auth), nil))
+	defer srv.Close()
+	newAlloc := func(user, token string) pool.Alloc {
+		t.Helper()
+		httpClient := &http.Client{Transport: &rest.TokenTransport{User: user, Token: token}}
+		clientPool, err := client.New(srv.URL+"/v1/", httpClient, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alloc, err := clientPool.Alloc(context.Background(), "testalloc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alloc
+	}
+	ctx := context.Background()
+
+	// Users must authenticate.
+	clientPool, err := client.New(srv.URL+"/v1/", srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientPool.Alloc(ctx, "testalloc"); !errors.Is(errors.NotAllowed, err) {
+		t.Errorf("expected NotAllowed, got %v", err)
+	}
+	if _, err := clientPool.Allocs(ctx); !errors.Is(errors.NotAllowed, err) {
+		t.Errorf("expected NotAllowed, got %v", err)
+	}
+	resp, err := srv.Client().Get(srv.URL + "/v1/allocs/testalloc/repository/" + testDigest.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("unauthenticated repository get: got %v, want %v", got, want)
+	}
+
+	// Any user, as well as the transfer identity, may access allocs'
+	// repositories; the transfer identity may access nothing else.
+	id, err := newAlloc("alice", "alicetoken").Repository().Put(ctx, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAlloc("bob", "bobtoken").Repository().Stat(ctx, id); err != nil {
+		t.Errorf("bob: stat: %v", err)
+	}
+	httpClient := &http.Client{Transport: &rest.TokenTransport{User: "reflowlet", Token: "transfertoken"}}
+	u, err := url.Parse(srv.URL + "/v1/allocs/testalloc/repository/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &repositoryclient.Client{Client: rest.NewClient(httpClient, u, nil)}
+	if _, err := repo.Stat(ctx, id); err != nil {
+		t.Errorf("reflowlet: stat: %v", err)
+	}
+	clientPool, err = client.New(srv.URL+"/v1/", httpClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientPool.Allocs(ctx); !errors.Is(errors.NotAllowed, err) {
+		t.Errorf("reflowlet: expected NotAllowed, got %v", err)
+	}
+
+	// Only the alloc's owner, or an admin, may operate on it;
+	// other users may only inspect it.
+	for _, tc := range []struct {
+		user, token string
+		ok          bool
+	}{
+		{"alice", "alicetoken", true},
+		{"bob", "bobtoken", false},
+		{"carol", "caroltoken", true},
+	} {
+		alloc := newAlloc(tc.user, tc.token)
+		if _, err := alloc.Inspect(ctx); err != nil {
+			t.Errorf("%s: inspect: %v", tc.user, err)
+		}
+		err := pool.SetState(ctx, alloc, pool.AllocCordoned)
+		if tc.ok && err != nil {
+			t.Errorf("%s: set state: %v", tc.user, err)
+		}
+		if !tc.ok && !errors.Is(errors.NotAllowed, err) {
+			t.Errorf("%s: expected NotAllowed, got %v", tc.user, err)
+		}
+		_, err = alloc.Put(ctx, reflow.Digester.FromString(tc.user), reflow.ExecConfig{Image: "ubuntu"})
+		if tc.ok && err != nil {
+			t.Errorf("%s: put: %v", tc.user, err)
+		}
+		if !tc.ok && !errors.Is(errors.NotAllowed, err) {
+			t.Errorf("%s: expected NotAllowed, got %v", tc.user, err)
+		}
+	}
+
+	log := audit.String()
+	for _, want := range []string{
+		"user alice: set alloc testalloc state cordoned",
+		"user alice: put exec " + reflow.Digester.FromString("alice").String(),
+		"user carol: set alloc testalloc state cordoned",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("audit log %q does not contain %q", log, want)
+		}
+	}
+	if strings.Contains(log, "user bob") {
+		t.Errorf("audit log %q contains unauthorized calls", log)
+	}
+}
@@ -7,9 +7,9 @@ package reflowlet
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -95,35 +95,69 @@ func (s *Server) AddFlags(flags *flag.FlagSet) {
 	flags.BoolVar(&s.HTTPDebug, "httpdebug", false, "turn on HTTP debug logging")
 }
 
-// spotNoticeWatcher watches for a spot termination notice and logs if found.
+// spotNoticeURL is the EC2 instance metadata URL from which spot
+// instance interruption notices are retrieved.
+const spotNoticeURL = "http://169.254.169.254/latest/meta-data/spot/instance-action"
+
+// spotNoticeInterval is the interval at which spot instance
+// interruption notices are polled.
+const spotNoticeInterval = 30 * time.Second
+
+// spotNotice is a spot instance interruption notice.
+type spotNotice struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// spotNoticeWatcher polls url for a spot instance interruption notice.
+// When one is found, it is propagated to the pool p, whose allocs then
+// report the instance's pending termination, and the watcher returns.
 // See https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/spot-interruptions.html#instance-action-metadata
-// TODO(swami):  When flagged for termination, put server in lameduck mode.
-func (s *Server) spotNoticeWatcher(ctx context.Context) {
+func spotNoticeWatcher(ctx context.Context, url string, interval time.Duration, p *local.Pool) {
 	logger := log.Std.Tee(nil, "spot notice: ")
-	tick := time.NewTicker(30 * time.Second)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-tick.C:
 		}
-		resp, err := http.Get("http://169.254.169.254/latest/meta-data/spot/instance-action")
-		if err != nil {
+		notice, ok := getSpotNotice(ctx, url, logger)
+		if !ok {
 			continue
 		}
-		// The following is done in a func to defer closing the response body.
-		func() {
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				return
-			}
-			b, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				logger.Debugf("read %v", err)
-				return
-			}
-			logger.Print(string(b))
-		}()
+		logger.Printf("instance scheduled to %s at %s", notice.Action, notice.Time.Format(time.RFC3339))
+		p.SetTerminationTime(notice.Time)
+		return
+	}
+}
+
+// getSpotNotice retrieves a spot instance interruption notice from
+// url. It returns false if there is no (valid) notice.
+func getSpotNotice(ctx context.Context, url string, logger *log.Logger) (spotNotice, bool) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return spotNotice{}, false
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return spotNotice{}, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return spotNotice{}, false
+	}
+	var notice spotNotice
+	if err := json.NewDecoder(resp.Body).Decode(&notice); err != nil {
+		logger.Debugf("decode: %v", err)
+		return spotNotice{}, false
+	}
+	switch notice.Action {
+	case "terminate", "stop", "hibernate":
+		return notice, true
+	default:
+		return spotNotice{}, false
 	}
 }
 
@@ -240,6 +274,8 @@ func (s *Server) ListenAndServe() error {
 		},
 		Log:          log.Std.Tee(nil, "executor: "),
 		HardMemLimit: hardMemLimit,
+		// GPUs are offered only on hosts that have them.
+		GPUPlugin: local.NvidiaPlugin{},
 	}
 	if err := p.Start(); err != nil {
 		return err
@@ -251,7 +287,7 @@ func (s *Server) ListenAndServe() error {
 		if err := s.setupWatcher(ctx, sess, filepath.Join(s.Prefix, s.Dir), rc.VolumeWatcher); err != nil {
 			log.Fatal(err)
 		}
-		go s.spotNoticeWatcher(ctx)
+		go spotNoticeWatcher(ctx, spotNoticeURL, spotNoticeInterval, p)
 		go func() {
 			const period = time.Minute
 			// Always give the instance an expiry period to receive work,
@@ -287,7 +323,15 @@ func (s *Server) ListenAndServe() error {
 		log.Std.Level = log.DebugLevel
 	}
 
-	http.Handle("/", rest.Handler(server.NewNode(p), httpLog))
+	auth := server.Auth{Audit: log.Std.Tee(nil, "audit: ")}
+	var poolauth *infra2.PoolAuthConfig
+	if err := s.Config.Instance(&poolauth); err == nil {
+		auth.Authenticator = poolauth.Authenticator()
+		auth.Admins = poolauth.Admins
+		auth.Transfer = poolauth.TransferAuthenticator()
+		repositoryhttp.HTTPClient.Transport = poolauth.TransferTransport(repositoryhttp.HTTPClient.Transport)
+	}
+	http.Handle("/", rest.Handler(server.NewAuthNode(p, auth), httpLog))
 	// Create a servlet node for this reflowlet's config.
 	cfgNode, err := newConfigNode(s.Config)
 	if err != nil {
@@ -0,0 +1,51 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package reflowlet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/reflow/local"
+)
+
+func TestSpotNoticeWatcher(t *testing.T) {
+	// The fake metadata endpoint serves a notice only after a few
+	// requests, as the instance metadata service does.
+	var (
+		n    int64
+		when = time.Date(2020, 6, 1, 8, 22, 0, 0, time.UTC)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&n, 1) {
+		case 1, 2:
+			http.NotFound(w, r)
+		case 3:
+			fmt.Fprint(w, `{"action": "none"}`)
+		default:
+			fmt.Fprintf(w, `{"action": "terminate", "time": %q}`, when.Format(time.RFC3339))
+		}
+	}))
+	defer srv.Close()
+
+	p := new(local.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	spotNoticeWatcher(ctx, srv.URL, 10*time.Millisecond, p)
+	if err := ctx.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.TerminationTime(), when; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := atomic.LoadInt64(&n), int64(4); got != want {
+		t.Errorf("got %v requests, want %v", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package filerepo
+
+import (
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
+)
+
+func init() {
+	infra.Register("filerepo", new(Provider))
+	repository.RegisterScheme("file", Dial)
+}
+
+// Provider is an infra provider for a filesystem-backed repository,
+// e.g., to keep a local cache.
+type Provider struct {
+	*Repository
+	// Dir is the repository's root directory.
+	Dir string
+}
+
+// Help implements infra.Provider.
+func (*Provider) Help() string {
+	return "configure a repository in a local directory"
+}
+
+// Flags implements infra.Provider.
+func (p *Provider) Flags(flags *flag.FlagSet) {
+	flags.StringVar(&p.Dir, "dir", "", "repository directory")
+}
+
+// Init implements infra.Provider.
+func (p *Provider) Init(logger *log.Logger) error {
+	if p.Dir == "" {
+		return errors.New("filerepo: no directory configured")
+	}
+	dir, err := filepath.Abs(p.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	p.Repository = &Repository{
+		Root:    dir,
+		Log:     logger,
+		RepoURL: &url.URL{Scheme: "file", Path: dir},
+	}
+	return nil
+}
+
+// Dial dials the filesystem-backed repository named by a URL of the
+// form file:///path/to/repository.
+func Dial(u *url.URL) (reflow.Repository, error) {
+	if u.Path == "" {
+		return nil, errors.E("dial", u.String(), errors.Invalid, errors.New("no path"))
+	}
+	return &Repository{Root: u.Path, RepoURL: u}, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package rest
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+// Authenticator authenticates the principals that make REST calls.
+type Authenticator interface {
+	// Authenticate returns the name of the principal that made the
+	// provided request, or an error if the request cannot be
+	// authenticated.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// Tokens is an Authenticator that authenticates users by the tokens
+// they present in the Authorization header of their requests, as
+// "Bearer <user>:<token>" (see TokenTransport). Tokens maps each
+// user to the hex-encoded SHA-256 digest of the user's token (as
+// computed by HashToken), so that the tokens themselves need not be
+// stored by servers.
+type Tokens map[string]string
+
+// HashToken returns the digest of the provided token, as stored in
+// Tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate implements Authenticator.
+func (t Tokens) Authenticate(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", errors.New("no token presented")
+	}
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("malformed authorization header")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(auth, "Bearer "), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", errors.New("malformed token")
+	}
+	user, token := parts[0], parts[1]
+	want, ok := t[user]
+	// We compare digests, so that the comparison need not be
+	// constant-time in the length of the token.
+	if !ok || subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(strings.ToLower(want))) != 1 {
+		return "", errors.E(user, errors.New("invalid token"))
+	}
+	return user, nil
+}
+
+// TokenTransport is an http.RoundTripper that presents a user's
+// token, as accepted by Tokens, in each request.
+type TokenTransport struct {
+	// User is the name of the user making requests.
+	User string
+	// Token is the user's token.
+	Token string
+	// Base is the transport used to perform requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request; we make a
+	// shallow copy with a new set of headers.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set("Authorization", "Bearer "+t.User+":"+t.Token)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r2)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+func TestTokens(t *testing.T) {
+	tokens := Tokens{
+		"alice": HashToken("secret1"),
+		"bob":   HashToken("secret2"),
+	}
+	h := DoFuncHandler(DoFunc(func(ctx context.Context, call *Call) {
+		user, ok := call.Authenticate(tokens)
+		if !ok {
+			return
+		}
+		call.Reply(http.StatusOK, user)
+	}), nil)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		transport http.RoundTripper
+		code      int
+		user      string
+	}{
+		{&TokenTransport{User: "alice", Token: "secret1"}, http.StatusOK, "alice"},
+		{&TokenTransport{User: "bob", Token: "secret2"}, http.StatusOK, "bob"},
+		{&TokenTransport{User: "bob", Token: "secret1"}, http.StatusUnauthorized, ""},
+		{&TokenTransport{User: "eve", Token: "secret1"}, http.StatusUnauthorized, ""},
+		{&TokenTransport{User: "alice", Token: ""}, http.StatusUnauthorized, ""},
+		{nil, http.StatusUnauthorized, ""},
+	} {
+		client := NewClient(&http.Client{Transport: tc.transport}, u, nil)
+		call := client.Call("GET", "")
+		code, err := call.Do(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := code, tc.code; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if code != http.StatusOK {
+			if err := call.Error(); !errors.Is(errors.NotAllowed, err) {
+				t.Errorf("expected NotAllowed, got %v", err)
+			}
+			call.Close()
+			continue
+		}
+		user, err := call.Message()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := user, tc.user; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		call.Close()
+	}
+}
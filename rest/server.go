@@ -67,6 +67,20 @@ func (c *Call) Allow(methods ...string) bool {
 	return false
 }
 
+// Authenticate authenticates the principal making this call using
+// the provided authenticator, returning the principal's name. If
+// authentication fails, Authenticate returns false and fails the
+// call with a http.StatusUnauthorized error.
+func (c *Call) Authenticate(auth Authenticator) (string, bool) {
+	user, err := auth.Authenticate(c.req)
+	if err != nil {
+		c.code = http.StatusUnauthorized
+		c.reply = errors.E("authenticate", c.req.URL.String(), errors.NotAllowed, err)
+		return "", false
+	}
+	return user, true
+}
+
 // StreamingCall implements the writer interface to write a chunk of
 // bytes to the response writer and flush.
 type StreamingCall struct {
@@ -9,10 +9,21 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/grailbio/base/digest"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/pool"
 )
 
+const (
+	// localExpiry is the time for which the files that were loaded
+	// into, or produced in, an alloc's repository by its completed
+	// tasks are assumed to remain there.
+	localExpiry = 30 * time.Minute
+	// maxLocal is the maximum number of such files that are indexed
+	// for each alloc.
+	maxLocal = 1 << 16
+)
+
 // Allocq implements a priority queue of allocs, ordered by the
 // scaled distance of available resources in the alloc.
 type allocq []*alloc
@@ -70,6 +81,27 @@ type alloc struct {
 	// Pending is the number of running tasks on this alloc.
 	Pending int
 
+	// State is the alloc's administrative state, as last inspected.
+	State pool.AllocState
+
+	// affinity and antiAffinity count the alloc's assigned tasks by
+	// their affinity and anti-affinity groups.
+	affinity, antiAffinity map[string]int
+	// inputs counts the alloc's assigned tasks by their input files.
+	// Together with local, it serves as an index of the files that
+	// are present in the alloc's repository: files are loaded into
+	// the repository for the tasks that use them.
+	inputs map[digest.Digest]int
+	// local indexes the input and output files of the alloc's
+	// completed tasks by the time they were last used. These remain
+	// in the alloc's repository until they are collected, and so
+	// entries expire after localExpiry.
+	local map[digest.Digest]time.Time
+
+	// terminating is closed when the alloc is about to be terminated.
+	terminating chan struct{}
+	terminated  bool
+
 	idleTime time.Time
 	index    int
 	// id is the alloc id. It is the same as Alloc.ID(). It is present here
@@ -96,8 +128,25 @@ func (a *alloc) Assign(task *Task) {
 		panic(fmt.Sprintf("sched: task %v already assigned to alloc %v", task.ID.IDShort(), a))
 	}
 	task.alloc = a
+	task.mu.Lock()
+	task.allocID = a.id
+	task.mu.Unlock()
 	a.Pending++
 	a.Available.Sub(a.Available, task.Config.Resources)
+	if task.Affinity != "" {
+		a.affinity[task.Affinity]++
+	}
+	if task.AntiAffinity != "" {
+		a.antiAffinity[task.AntiAffinity]++
+	}
+	// The task's input files are recorded here as they are at the time
+	// of assignment, since they are resolved when they are loaded.
+	task.inputs = task.inputs[:0]
+	for _, file := range task.inputFiles() {
+		d := file.Digest()
+		task.inputs = append(task.inputs, d)
+		a.inputs[d]++
+	}
 }
 
 // Unassign updates this alloc to account for the completion of the
@@ -108,12 +157,99 @@ func (a *alloc) Unassign(task *Task) {
 	}
 	a.Pending--
 	a.Available.Add(a.Available, task.Config.Resources)
+	decr(a.affinity, task.Affinity)
+	decr(a.antiAffinity, task.AntiAffinity)
+	for _, d := range task.inputs {
+		if a.inputs[d]--; a.inputs[d] == 0 {
+			delete(a.inputs, d)
+		}
+	}
+	task.inputs = nil
 	if a.Pending == 0 {
 		a.idleTime = time.Now()
 	}
 	task.alloc = nil
 }
 
+// Complete records the input and output files of the provided task,
+// which has completed successfully on the alloc, as held by the
+// alloc. It must be called before the task is unassigned.
+func (a *alloc) Complete(task *Task) {
+	now := time.Now()
+	for d, t := range a.local {
+		if now.Sub(t) > localExpiry {
+			delete(a.local, d)
+		}
+	}
+	for _, d := range task.inputs {
+		a.local[d] = now
+	}
+	for _, file := range task.Result.Fileset.Files() {
+		a.local[file.Digest()] = now
+	}
+	for d := range a.local {
+		if len(a.local) <= maxLocal {
+			break
+		}
+		delete(a.local, d)
+	}
+}
+
+// Holds tells whether the file with digest d is likely to be present
+// in the alloc's repository.
+func (a *alloc) Holds(d digest.Digest) bool {
+	if a.inputs[d] > 0 {
+		return true
+	}
+	t, ok := a.local[d]
+	return ok && time.Since(t) <= localExpiry
+}
+
+// Admits tells whether the task may be assigned to the alloc
+// without violating its anti-affinity constraint, or its exclusion
+// of the alloc.
+func (a *alloc) Admits(task *Task) bool {
+	if task.ExcludeAlloc != "" && task.ExcludeAlloc == a.id {
+		return false
+	}
+	return task.AntiAffinity == "" || a.antiAffinity[task.AntiAffinity] == 0
+}
+
+// Affinity returns the affinity of the task to the alloc: the number
+// of assigned tasks in the task's affinity group, and the number of
+// bytes of the task's input files that are held by the alloc, either
+// for its assigned tasks or since its recently completed ones.
+func (a *alloc) Affinity(task *Task) (group int, bytes int64) {
+	if task.Affinity != "" {
+		group = a.affinity[task.Affinity]
+	}
+	for _, file := range task.inputFiles() {
+		if a.Holds(file.Digest()) {
+			bytes += file.Size
+		}
+	}
+	return
+}
+
+// Terminate marks the alloc as being about to be terminated.
+func (a *alloc) Terminate() {
+	if !a.terminated {
+		a.terminated = true
+		close(a.terminating)
+	}
+}
+
+// Assignable tells whether tasks may be assigned to the alloc.
+func (a *alloc) Assignable() bool {
+	return !a.terminated && a.State == pool.AllocActive
+}
+
+// Retiring tells whether the alloc should be collected once
+// it has no assigned tasks.
+func (a *alloc) Retiring() bool {
+	return a.terminated || a.State == pool.AllocDraining
+}
+
 // IdleFor returns the time passed since the alloc had zero
 // assigned tasks.
 func (a *alloc) IdleFor() time.Duration {
@@ -124,5 +260,23 @@ func (a *alloc) IdleFor() time.Duration {
 }
 
 func newAlloc() *alloc {
-	return &alloc{index: -1}
+	return &alloc{
+		index:        -1,
+		terminating:  make(chan struct{}),
+		affinity:     make(map[string]int),
+		antiAffinity: make(map[string]int),
+		inputs:       make(map[digest.Digest]int),
+		local:        make(map[digest.Digest]time.Time),
+	}
+}
+
+// decr decrements the count of key k in m, removing it when it
+// reaches zero.
+func decr(m map[string]int, k string) {
+	if k == "" {
+		return
+	}
+	if m[k]--; m[k] <= 0 {
+		delete(m, k)
+	}
 }
@@ -0,0 +1,125 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sched
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+// Budget limits the resources that are spent on allocs. A scheduler
+// whose budget is exhausted halts: it stops allocating, and fails the
+// tasks that are waiting to run with errors of kind
+// errors.BudgetExceeded. Its running tasks are left to complete,
+// unless the budget's Kill policy is set.
+type Budget struct {
+	// MaxAllocs is the maximum number of allocs that may be held (or
+	// requested) at any time. Tasks that need more allocs wait for
+	// others to complete; MaxAllocs does not halt the scheduler.
+	MaxAllocs int `yaml:"maxallocs,omitempty"`
+	// MaxInstanceHours is the maximum total number of hours for which
+	// allocs may be held.
+	MaxInstanceHours float64 `yaml:"maxinstancehours,omitempty"`
+	// MaxDollars is the maximum estimated cost, in US dollars, of the
+	// allocs held. It is enforced only if the scheduler has a Pricer.
+	MaxDollars float64 `yaml:"maxdollars,omitempty"`
+	// Kill determines whether running tasks are preempted, and then
+	// failed, once the budget is exceeded.
+	Kill bool `yaml:"kill,omitempty"`
+}
+
+// IsZero tells whether the budget imposes no limits.
+func (b *Budget) IsZero() bool {
+	return b == nil || b.MaxAllocs == 0 && b.MaxInstanceHours == 0 && b.MaxDollars == 0
+}
+
+// allows tells whether n more allocs may be held by a scheduler
+// that holds spent.Allocs.
+func (b *Budget) allows(spent taskdb.Spend, n int) bool {
+	return b.IsZero() || b.MaxAllocs == 0 || spent.Allocs+n <= b.MaxAllocs
+}
+
+// check returns an error if the provided spend, by the named spender,
+// exceeds the budget.
+func (b *Budget) check(who string, spent taskdb.Spend) error {
+	switch {
+	case b.IsZero():
+	case b.MaxInstanceHours > 0 && spent.InstanceHours > b.MaxInstanceHours:
+		return fmt.Errorf("%s spent %.2f instance-hours, exceeding the budget of %.2f", who, spent.InstanceHours, b.MaxInstanceHours)
+	case b.MaxDollars > 0 && spent.Dollars > b.MaxDollars:
+		return fmt.Errorf("%s spent $%.2f, exceeding the budget of $%.2f", who, spent.Dollars, b.MaxDollars)
+	}
+	return nil
+}
+
+// Pricer estimates the cost of allocs.
+type Pricer interface {
+	// Price returns the estimated hourly price, in US dollars, of the
+	// provided alloc, or false if it cannot be priced.
+	Price(alloc pool.Alloc) (float64, bool)
+}
+
+// ledger accounts for the resources spent on a scheduler's allocs.
+// A ledger is safe for concurrent use; its zero value is an empty
+// ledger.
+type ledger struct {
+	mu sync.Mutex
+	// allocs holds the start time and hourly price of each live alloc.
+	allocs map[*alloc]allocCost
+	// spent is the spend of the allocs that are no longer live.
+	spent taskdb.Spend
+}
+
+type allocCost struct {
+	start time.Time
+	price float64
+}
+
+// Open accounts for the provided alloc from the provided time.
+func (l *ledger) Open(a *alloc, pricer Pricer, now time.Time) {
+	var price float64
+	if pricer != nil {
+		price, _ = pricer.Price(a.Alloc)
+	}
+	l.mu.Lock()
+	if l.allocs == nil {
+		l.allocs = make(map[*alloc]allocCost)
+	}
+	l.allocs[a] = allocCost{now, price}
+	l.mu.Unlock()
+}
+
+// Close stops accounting for the provided alloc as of the provided
+// time.
+func (l *ledger) Close(a *alloc, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cost, ok := l.allocs[a]
+	if !ok {
+		return
+	}
+	delete(l.allocs, a)
+	hours := now.Sub(cost.start).Hours()
+	l.spent.InstanceHours += hours
+	l.spent.Dollars += hours * cost.price
+}
+
+// Spend returns the spend as of the provided time.
+func (l *ledger) Spend(now time.Time) taskdb.Spend {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	spent := l.spent
+	spent.Allocs = len(l.allocs)
+	for _, cost := range l.allocs {
+		hours := now.Sub(cost.start).Hours()
+		spent.InstanceHours += hours
+		spent.Dollars += hours * cost.price
+	}
+	return spent
+}
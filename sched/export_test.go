@@ -4,8 +4,21 @@
 
 package sched
 
-import "github.com/grailbio/reflow"
+import (
+	"time"
+
+	"github.com/grailbio/reflow"
+)
 
 func Requirements(tasks []*Task) reflow.Requirements {
 	return requirements(tasks)
 }
+
+// SetServicePollTimeout sets the poll timeout of the service nodes
+// that are subsequently created, and returns a function that restores
+// it.
+func SetServicePollTimeout(d time.Duration) (restore func()) {
+	saved := servicePollTimeout
+	servicePollTimeout = d
+	return func() { servicePollTimeout = saved }
+}
@@ -0,0 +1,225 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sched
+
+import (
+	"fmt"
+
+	"github.com/grailbio/reflow"
+)
+
+// FairShare configures how a scheduler shares its capacity among the
+// users and projects whose tasks it schedules, as when many runs
+// submit tasks to a shared scheduling service (see NewServiceNode).
+//
+// Tasks of equal priority are scheduled in weighted fair share order:
+// each user receives a share of the scheduler's capacity in proportion
+// to its weight, as measured by the (scaled) resources of the tasks
+// that are run on its behalf. Quotas limit the resources that may be
+// used concurrently by the tasks of a user or project; tasks that would
+// exceed their quotas wait for others to complete, and do not cause
+// new allocs to be created.
+type FairShare struct {
+	// Weights are the users' fair share weights. Users that are not
+	// listed have a weight of 1.
+	Weights map[string]float64 `yaml:"weights,omitempty"`
+	// UserQuotas and ProjectQuotas are the quotas of users and
+	// projects, respectively. Quotas are given only for the resources
+	// that are limited. The quota named "*", if any, applies to users
+	// (projects) that are not otherwise listed.
+	UserQuotas    map[string]reflow.Resources `yaml:"userquotas,omitempty"`
+	ProjectQuotas map[string]reflow.Resources `yaml:"projectquotas,omitempty"`
+	// Preempt permits the scheduler to preempt running tasks in favor
+	// of higher priority tasks that cannot otherwise be assigned. The
+	// preempted tasks are rescheduled.
+	Preempt bool `yaml:"preempt,omitempty"`
+}
+
+// fairShare maintains the scheduler's fair share state. It uses
+// start-time fair queueing: each task is tagged with a virtual start
+// time when it is submitted, and tasks are run in tag order. A user's
+// tasks are tagged at a virtual time that advances by the cost of each
+// task divided by the user's weight, and that is never behind the
+// scheduler's virtual time, the tag of the most recently assigned
+// task. All methods are safe to call on a nil *fairShare, which
+// imposes no constraints.
+type fairShare struct {
+	*FairShare
+
+	vtime  float64
+	finish map[string]float64
+
+	users, projects map[string]reflow.Resources
+}
+
+// newFairShare returns the fair share state for the provided
+// configuration, or nil if it is nil.
+func newFairShare(config *FairShare) *fairShare {
+	if config == nil {
+		return nil
+	}
+	return &fairShare{
+		FairShare: config,
+		finish:    make(map[string]float64),
+		users:     make(map[string]reflow.Resources),
+		projects:  make(map[string]reflow.Resources),
+	}
+}
+
+// Tag tags the provided task with its virtual start time. Tasks that
+// are already tagged (e.g., because they were lost and are being
+// rescheduled) retain their tags.
+func (f *fairShare) Tag(task *Task) {
+	if f == nil || task.tagged {
+		return
+	}
+	weight := 1.0
+	if w, ok := f.Weights[task.User]; ok && w > 0 {
+		weight = w
+	}
+	start := f.finish[task.User]
+	if start < f.vtime {
+		start = f.vtime
+	}
+	task.tag, task.tagged = start, true
+	f.finish[task.User] = start + task.Config.Resources.ScaledDistance(nil)/weight
+}
+
+// Check returns an error if the provided task can never be run
+// because it alone exceeds its user's or project's quota.
+func (f *fairShare) Check(task *Task) error {
+	if f == nil {
+		return nil
+	}
+	if quota, ok := lookupQuota(f.UserQuotas, task.User); ok && !within(quota, nil, task.Config.Resources) {
+		return fmt.Errorf("task requires %s, which exceeds the quota %s of user %q", task.Config.Resources, quota, task.User)
+	}
+	if quota, ok := lookupQuota(f.ProjectQuotas, task.Project); ok && !within(quota, nil, task.Config.Resources) {
+		return fmt.Errorf("task requires %s, which exceeds the quota %s of project %q", task.Config.Resources, quota, task.Project)
+	}
+	return nil
+}
+
+// Admits tells whether the provided task may be assigned without
+// exceeding its user's or project's quota.
+func (f *fairShare) Admits(task *Task) bool {
+	if f == nil {
+		return true
+	}
+	if quota, ok := lookupQuota(f.UserQuotas, task.User); ok && !within(quota, f.users[task.User], task.Config.Resources) {
+		return false
+	}
+	if quota, ok := lookupQuota(f.ProjectQuotas, task.Project); ok && !within(quota, f.projects[task.Project], task.Config.Resources) {
+		return false
+	}
+	return true
+}
+
+// Admitted returns the tasks in tasks that are admitted.
+func (f *fairShare) Admitted(tasks []*Task) []*Task {
+	if f == nil {
+		return tasks
+	}
+	var admitted []*Task
+	for _, task := range tasks {
+		if f.Admits(task) {
+			admitted = append(admitted, task)
+		}
+	}
+	return admitted
+}
+
+// Charge accounts for the assignment of the provided task.
+func (f *fairShare) Charge(task *Task) {
+	if f == nil {
+		return
+	}
+	if task.tag > f.vtime {
+		f.vtime = task.tag
+	}
+	charge(f.users, task.User, task.Config.Resources, 1)
+	charge(f.projects, task.Project, task.Config.Resources, 1)
+}
+
+// Release accounts for the completion of the provided (charged) task.
+func (f *fairShare) Release(task *Task) {
+	if f == nil {
+		return
+	}
+	charge(f.users, task.User, task.Config.Resources, -1)
+	charge(f.projects, task.Project, task.Config.Resources, -1)
+}
+
+// Preempts tells whether running tasks may be preempted.
+func (f *fairShare) Preempts() bool {
+	return f != nil && f.Preempt
+}
+
+// Victim returns the running task that should be preempted so that
+// the provided task may be assigned, or nil if there is none. Only
+// tasks of lower priority that run on assignable allocs which would
+// then fit the task are considered; among these, Victim picks the
+// task with the lowest priority and then the latest tag.
+func (f *fairShare) Victim(task *Task, running TaskSet) *Task {
+	var (
+		victim *Task
+		avail  reflow.Resources
+	)
+	for t := range running {
+		if t.preempted || t.Priority <= task.Priority || t.alloc == nil {
+			continue
+		}
+		if !t.alloc.Assignable() || !t.alloc.Admits(task) {
+			continue
+		}
+		avail.Add(t.alloc.Available, t.Config.Resources)
+		if !avail.Available(task.Config.Resources) {
+			continue
+		}
+		switch {
+		case victim == nil:
+		case t.Priority != victim.Priority:
+			if t.Priority < victim.Priority {
+				continue
+			}
+		case t.tag <= victim.tag:
+			continue
+		}
+		victim = t
+	}
+	return victim
+}
+
+// lookupQuota returns the quota for the provided name in quotas.
+func lookupQuota(quotas map[string]reflow.Resources, name string) (reflow.Resources, bool) {
+	if quota, ok := quotas[name]; ok {
+		return quota, true
+	}
+	quota, ok := quotas["*"]
+	return quota, ok
+}
+
+// within tells whether the resources used, together with need, are
+// within the provided quota.
+func within(quota, used, need reflow.Resources) bool {
+	for key, limit := range quota {
+		if used[key]+need[key] > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// charge adds sign times resources to the usage of name in usage.
+func charge(usage map[string]reflow.Resources, name string, resources reflow.Resources, sign float64) {
+	used := usage[name]
+	if used == nil {
+		used = make(reflow.Resources)
+		usage[name] = used
+	}
+	for key, val := range resources {
+		used[key] += sign * val
+	}
+}
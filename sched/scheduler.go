@@ -28,6 +28,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/grailbio/base/data"
@@ -86,16 +87,50 @@ type Scheduler struct {
 	// the scheduler.
 	MinAlloc reflow.Resources
 
+	// AllocCheckInterval is the interval at which live allocs are
+	// inspected for notices of their pending termination (e.g., spot
+	// instance interruptions) and for changes to their administrative
+	// state (e.g., cordoning). Allocs are not inspected if it is zero.
+	AllocCheckInterval time.Duration
+
 	// PostUseChecksum indicates whether input filesets are checksummed after use.
 	PostUseChecksum bool
 
 	// Labels is the set of labels applied to newly created allocs.
 	Labels pool.Labels
 
+	// FairShare, if set, configures fair sharing of the scheduler's
+	// capacity among the users and projects whose tasks it runs.
+	FairShare *FairShare
+
+	// Remote, if set, is a scheduling service to which the scheduler
+	// submits its tasks instead of scheduling them itself. Budgets are
+	// not enforced by schedulers with a Remote.
+	Remote *Remote
+
+	// Budget, if set, limits the resources spent by the scheduler.
+	Budget *Budget
+	// UserBudget, if set, limits the resources spent by the user on
+	// whose behalf the scheduler runs: the scheduler's spend together
+	// with UserSpend, the resources spent by the user's other runs.
+	UserBudget *Budget
+	UserSpend  taskdb.Spend
+	// Pricer, if set, is used to estimate the cost of allocs.
+	Pricer Pricer
+	// BudgetCheckInterval is the interval at which the scheduler's
+	// spend is checked against its budgets.
+	BudgetCheckInterval time.Duration
+
 	// Stats is the scheduler stats.
 	Stats *Stats
 
 	submitc chan []*Task
+	cancelc chan []*Task
+	// queued is the number of tasks that could not be assigned
+	// to a live alloc on the last scheduling round.
+	queued int64
+	// ledger accounts for the resources spent on allocs.
+	ledger ledger
 }
 
 // New returns a new Scheduler instance. The caller may customize its
@@ -103,10 +138,14 @@ type Scheduler struct {
 func New() *Scheduler {
 	return &Scheduler{
 		submitc:          make(chan []*Task),
+		cancelc:          make(chan []*Task),
 		MaxPendingAllocs: 5,
 		MaxAllocIdleTime: 5 * time.Minute,
 		MinAlloc:         reflow.Resources{"cpu": 1, "mem": 1 << 30, "disk": 1 << 30},
 		Stats:            newStats(),
+
+		AllocCheckInterval:  30 * time.Second,
+		BudgetCheckInterval: 30 * time.Second,
 	}
 }
 
@@ -121,6 +160,53 @@ func (s *Scheduler) Submit(tasks ...*Task) {
 	s.submitc <- tasksCopy
 }
 
+// Cancel cancels the provided tasks, which were submitted to the
+// scheduler. Tasks that are waiting for an alloc are failed; running
+// tasks are preempted, and then failed, unless they have already
+// completed their execs. Canceled tasks fail with errors.Canceled.
+func (s *Scheduler) Cancel(tasks ...*Task) {
+	tasksCopy := append([]*Task{}, tasks...)
+	s.cancelc <- tasksCopy
+}
+
+// QueueLen returns the number of tasks that are waiting for an
+// alloc: that is, the tasks that could not be assigned to any live
+// alloc on the scheduler's last round.
+func (s *Scheduler) QueueLen() int {
+	return int(atomic.LoadInt64(&s.queued))
+}
+
+// Spend returns the resources spent by the scheduler on allocs
+// thus far.
+func (s *Scheduler) Spend() taskdb.Spend {
+	return s.ledger.Spend(time.Now())
+}
+
+// exceeded returns an error if the scheduler has exceeded its
+// budgets.
+func (s *Scheduler) exceeded() error {
+	spent := s.Spend()
+	if err := s.Budget.check("run", spent); err != nil {
+		return errors.E(errors.BudgetExceeded, err)
+	}
+	spent.Add(s.UserSpend)
+	if err := s.UserBudget.check("user", spent); err != nil {
+		return errors.E(errors.BudgetExceeded, err)
+	}
+	return nil
+}
+
+// allocates tells whether n more allocs may be requested by the
+// scheduler without exceeding its budgets.
+func (s *Scheduler) allocates(n int) bool {
+	spent := s.ledger.Spend(time.Now())
+	if !s.Budget.allows(spent, n) {
+		return false
+	}
+	spent.Add(s.UserSpend)
+	return s.UserBudget.allows(spent, n)
+}
+
 // ExportStats exports scheduler stats as expvars.
 func (s *Scheduler) ExportStats() {
 	s.Stats.Publish()
@@ -152,6 +238,9 @@ func (s *Scheduler) configString() string {
 // Do commences scheduling. The scheduler runs until the provided
 // context is canceled, after which the context error is returned.
 func (s *Scheduler) Do(ctx context.Context) error {
+	if s.Remote != nil {
+		return s.Remote.do(ctx, s)
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -175,14 +264,29 @@ func (s *Scheduler) Do(ctx context.Context) error {
 		todo          taskq
 
 		nrunning int
+		running  = make(TaskSet)
+		// preempting is the number of tasks that have been preempted
+		// but not yet returned.
+		preempting int
+		share      = newFairShare(s.FairShare)
+		// halt is the error with which tasks are failed once the
+		// scheduler's budget is exceeded.
+		halt    error
+		budgetc <-chan time.Time
 
-		notifyc = make(chan *alloc)
-		deadc   = make(chan *alloc)
-		returnc = make(chan *Task)
+		notifyc  = make(chan *alloc)
+		deadc    = make(chan *alloc)
+		inspectc = make(chan allocInspect)
+		returnc  = make(chan *Task)
 
 		tick = time.NewTicker(s.MaxAllocIdleTime / 2)
 	)
 	defer tick.Stop()
+	if !s.Budget.IsZero() || !s.UserBudget.IsZero() {
+		budgetTick := time.NewTicker(s.BudgetCheckInterval)
+		defer budgetTick.Stop()
+		budgetc = budgetTick.C
+	}
 
 	s.Log.Debugf("starting with configuration: %s", s.configString())
 	for {
@@ -221,6 +325,7 @@ func (s *Scheduler) Do(ctx context.Context) error {
 					alloc.Cancel()
 				}
 			}
+		case <-budgetc:
 		case tasks := <-s.submitc:
 			s.Stats.AddTasks(tasks)
 			for _, task := range tasks {
@@ -228,11 +333,49 @@ func (s *Scheduler) Do(ctx context.Context) error {
 					go s.directTransfer(ctx, task)
 					continue
 				}
+				if halt != nil {
+					task.Err = halt
+					task.set(TaskDone)
+					continue
+				}
+				if err := share.Check(task); err != nil {
+					task.Err = errors.E(errors.ResourcesExhausted, err)
+					task.set(TaskDone)
+					continue
+				}
+				share.Tag(task)
 				heap.Push(&todo, task)
 			}
+		case tasks := <-s.cancelc:
+			for _, task := range tasks {
+				if task.canceled || task.State() == TaskDone {
+					continue
+				}
+				task.canceled = true
+				switch {
+				case task.index >= 0 && task.index < len(todo) && todo[task.index] == task:
+					heap.Remove(&todo, task.index)
+					task.Err = errors.E("cancel", task.ID.ID(), errors.Canceled)
+					task.set(TaskDone)
+				case running[task] && !task.preempted:
+					task.Log.Printf("canceling")
+					task.preempted, task.preemptReason = true, "canceled"
+					close(task.preemptc)
+					preempting++
+				}
+			}
 		case task := <-returnc:
 			nrunning--
+			delete(running, task)
+			if task.preempted {
+				task.preempted = false
+				preempting--
+			}
+			share.Release(task)
 			alloc := task.alloc
+			if task.State() == TaskDone && task.Err == nil && task.Result.Err == nil {
+				alloc.Complete(task)
+			}
 			alloc.Unassign(task)
 			if alloc.index != -1 {
 				heap.Fix(&live, alloc.index)
@@ -241,6 +384,16 @@ func (s *Scheduler) Do(ctx context.Context) error {
 			default:
 				panic("illegal task state")
 			case TaskLost:
+				if task.canceled {
+					task.Err = errors.E("cancel", task.ID.ID(), errors.Canceled, task.Err)
+					task.set(TaskDone)
+					break
+				}
+				if halt != nil {
+					task.Err = halt
+					task.set(TaskDone)
+					break
+				}
 				task.set(TaskInit)
 				heap.Push(&todo, task)
 			case TaskDone:
@@ -255,12 +408,36 @@ func (s *Scheduler) Do(ctx context.Context) error {
 				heap.Remove(&live, alloc.index)
 				alloc.index = -1
 			}
+			// Terminating and draining allocs are collected once their
+			// tasks have returned.
+			if alloc.Retiring() && alloc.Pending == 0 {
+				s.retire(alloc)
+			}
 		case alloc := <-notifyc:
 			heap.Remove(&pending, alloc.index)
 			if alloc.Alloc != nil {
 				alloc.Init()
 				heap.Push(&live, alloc)
 				s.Stats.AddAlloc(alloc)
+				s.ledger.Open(alloc, s.Pricer, time.Now())
+			}
+		case inspect := <-inspectc:
+			alloc := inspect.alloc
+			if !inspect.TerminationTime.IsZero() {
+				// The alloc is about to be terminated. We stop assigning tasks
+				// to it and preempt the tasks it is running, which are returned
+				// as lost and then rescheduled onto other allocs.
+				if alloc.index != -1 {
+					heap.Remove(&live, alloc.index)
+				}
+				alloc.Terminate()
+			} else {
+				// Cordoned and draining allocs remain live, but are not
+				// assigned new tasks; their running tasks are unaffected.
+				alloc.State = inspect.State
+			}
+			if alloc.Retiring() && alloc.Pending == 0 {
+				s.retire(alloc)
 			}
 		case alloc := <-deadc:
 			// The allocs tasks will be returned with state TaskLost.
@@ -268,14 +445,59 @@ func (s *Scheduler) Do(ctx context.Context) error {
 				heap.Remove(&live, alloc.index)
 			}
 			s.Stats.MarkAllocDead(alloc)
+			s.ledger.Close(alloc, time.Now())
 		}
 
-		assigned := s.assign(&todo, &live, s.Stats)
+		// Once the scheduler's budget is exceeded, it halts: waiting
+		// tasks are failed, as are running tasks if the budget's policy
+		// is to kill them, and no more allocs are requested.
+		if halt == nil {
+			if halt = s.exceeded(); halt != nil {
+				s.Log.Errorf("halting: %v", halt)
+				for _, task := range todo {
+					task.Err = halt
+					task.set(TaskDone)
+				}
+				todo = todo[:0]
+				if s.Budget != nil && s.Budget.Kill || s.UserBudget != nil && s.UserBudget.Kill {
+					for task := range running {
+						if task.preempted {
+							continue
+						}
+						task.Log.Printf("killing: budget exceeded")
+						task.preempted, task.preemptReason = true, "budget exceeded"
+						close(task.preemptc)
+						preempting++
+					}
+				}
+			}
+		}
+
+		assigned := s.assign(&todo, &live, s.Stats, share)
 		for _, task := range assigned {
 			task.Log.Debugf("assigning to alloc %v", task.alloc)
 			nrunning++
+			running[task] = true
+			task.preemptc = make(chan struct{})
 			go s.run(task, returnc)
 		}
+		atomic.StoreInt64(&s.queued, int64(len(todo)))
+
+		// If preemption is enabled, we preempt a lower priority task in
+		// favor of the first task that could not be assigned. Preemptions
+		// are made one at a time, so that no more tasks are preempted than
+		// are needed.
+		if share.Preempts() && preempting == 0 {
+			if task := first(share.Admitted(todo)); task != nil {
+				if victim := share.Victim(task, running); victim != nil {
+					victim.Log.Printf("preempting in favor of task %s (priority %d)", task.ID.IDShort(), task.Priority)
+					victim.preempted = true
+					victim.preemptReason = "preempted by a higher priority task"
+					close(victim.preemptc)
+					preempting++
+				}
+			}
+		}
 
 		// At this point, we've scheduled everything we can onto the current
 		// set of allocs. If we have more work, we'll need to try to create more
@@ -286,64 +508,175 @@ func (s *Scheduler) Do(ctx context.Context) error {
 
 		// We have more to do, and potential to allocate. We mock allocate remaining
 		// tasks to pending allocs, and then allocate any remaining (if any).
-		assigned = s.assign(&todo, &pending, nil)
-		var (
-			req reflow.Requirements
-			// needMore tells whether any tasks remain after mock allocation.
-			// This is needed in addition to `req` because if all tasks have empty resources
-			// we end up getting empty requirements, but we should trigger at least one allocation.
-			needMore bool
-		)
+		assigned = s.assign(&todo, &pending, nil, nil)
+		// Tasks that remain after mock allocation need more allocs,
+		// unless they would exceed their quotas. Tasks in the same
+		// anti-affinity group are spread across separate allocs. Note
+		// that we allocate even if all tasks have empty resources (and
+		// thus empty requirements).
+		var groups [][]*Task
 		if len(todo) > 0 {
-			req = requirements(todo)
-			needMore = true
+			groups = spread(share.Admitted(todo))
 		}
 		for _, task := range assigned {
 			task.alloc.Unassign(task)
 			heap.Push(&todo, task)
 		}
-		if req.Equal(reflow.Requirements{}) && !needMore {
-			continue
+		for _, tasks := range groups {
+			if len(pending) >= s.MaxPendingAllocs || !s.allocates(len(pending)+1) {
+				break
+			}
+			req := requirements(tasks)
+			req.Min.Max(s.MinAlloc, req.Min)
+			alloc := newAlloc()
+			alloc.Requirements = req
+			alloc.Available = req.Min
+			heap.Push(&pending, alloc)
+			go s.allocate(ctx, alloc, notifyc, deadc, inspectc)
 		}
-
-		req.Min.Max(s.MinAlloc, req.Min)
-		alloc := newAlloc()
-		alloc.Requirements = req
-		alloc.Available = req.Min
-		heap.Push(&pending, alloc)
-		go s.allocate(ctx, alloc, notifyc, deadc)
 	}
 }
 
-func (s *Scheduler) assign(tasks *taskq, allocs *allocq, stats *Stats) (assigned []*Task) {
-	var unassigned []*alloc
+// assign assigns tasks to allocs, and returns the assigned tasks.
+// Assignments are accounted to stats and share, if they are non-nil;
+// tasks that are not admitted by share are not assigned.
+func (s *Scheduler) assign(tasks *taskq, allocs *allocq, stats *Stats, share *fairShare) (assigned []*Task) {
+	var (
+		unassigned []*alloc
+		deferred   []*Task
+	)
 	for len(*tasks) > 0 && len(*allocs) > 0 {
 		var (
 			task  = (*tasks)[0]
 			alloc = (*allocs)[0]
 		)
-		if !alloc.Available.Available(task.Config.Resources) {
-			// We can't fit the smallest task in the smallest alloc.
-			// Remove the alloc from consideration.
+		if !share.Admits(task) {
+			// The task would exceed its quota; it is reconsidered
+			// in the next round.
+			heap.Pop(tasks)
+			deferred = append(deferred, task)
+			continue
+		}
+		if !alloc.Assignable() || !alloc.Available.Available(task.Config.Resources) {
+			// We can't fit the smallest task in the smallest alloc, or
+			// the alloc may not be assigned tasks. Remove the alloc from
+			// consideration.
 			heap.Pop(allocs)
 			unassigned = append(unassigned, alloc)
 			continue
 		}
+		// The smallest alloc fits the task, but another may be a better
+		// placement.
+		if alloc = place(task, *allocs); alloc == nil {
+			// The task cannot be placed in any of the allocs; it is
+			// reconsidered in the next round.
+			heap.Pop(tasks)
+			deferred = append(deferred, task)
+			continue
+		}
 		heap.Pop(tasks)
+		_, saved := alloc.Affinity(task)
 		alloc.Assign(task)
 		if stats != nil {
-			stats.AssignTask(task, alloc)
+			stats.AssignTask(task, alloc, saved)
 		}
+		share.Charge(task)
 		assigned = append(assigned, task)
-		heap.Fix(allocs, 0)
+		heap.Fix(allocs, alloc.index)
 	}
 	for _, alloc := range unassigned {
 		heap.Push(allocs, alloc)
 	}
+	for _, task := range deferred {
+		heap.Push(tasks, task)
+	}
 	return
 }
 
-func (s *Scheduler) allocate(ctx context.Context, alloc *alloc, notify, dead chan<- *alloc) {
+// first returns the first of the provided tasks in scheduling
+// order, or nil if there are none.
+func first(tasks []*Task) *Task {
+	var min *Task
+	for _, task := range tasks {
+		if min == nil || task.before(min) {
+			min = task
+		}
+	}
+	return min
+}
+
+// place returns the alloc among allocs to which the provided task
+// should be assigned, or nil if there is none. Allocs that run tasks
+// in the task's anti-affinity group, or that the task excludes, are
+// not considered. Among the
+// remaining allocs that can fit the task, place prefers allocs that
+// run tasks in the task's affinity group, then allocs that require
+// the fewest bytes of the task's input files to be transferred
+// (i.e., that already hold the most), and then the alloc with the
+// fewest available resources (by scaled distance).
+func place(task *Task, allocs allocq) *alloc {
+	var (
+		best         *alloc
+		bestGroup    int
+		bestBytes    int64
+		bestDistance float64
+	)
+	for _, alloc := range allocs {
+		if !alloc.Assignable() || !alloc.Admits(task) || !alloc.Available.Available(task.Config.Resources) {
+			continue
+		}
+		group, bytes := alloc.Affinity(task)
+		if group > 0 {
+			group = 1
+		}
+		distance := alloc.Available.ScaledDistance(nil)
+		switch {
+		case best == nil:
+		case group != bestGroup:
+			if group < bestGroup {
+				continue
+			}
+		case bytes != bestBytes:
+			if bytes < bestBytes {
+				continue
+			}
+		case distance >= bestDistance:
+			continue
+		}
+		best, bestGroup, bestBytes, bestDistance = alloc, group, bytes, distance
+	}
+	return best
+}
+
+// spread partitions tasks into groups that may each be allocated
+// a single alloc: no group contains more than one task in any
+// anti-affinity group. Tasks without an anti-affinity group are
+// placed in the first group.
+func spread(tasks []*Task) [][]*Task {
+	var (
+		groups [][]*Task
+		seen   []map[string]bool
+	)
+	for _, task := range tasks {
+		i := 0
+		if task.AntiAffinity != "" {
+			for i < len(groups) && seen[i][task.AntiAffinity] {
+				i++
+			}
+		}
+		if i == len(groups) {
+			groups = append(groups, nil)
+			seen = append(seen, make(map[string]bool))
+		}
+		groups[i] = append(groups[i], task)
+		if task.AntiAffinity != "" {
+			seen[i][task.AntiAffinity] = true
+		}
+	}
+	return groups
+}
+
+func (s *Scheduler) allocate(ctx context.Context, alloc *alloc, notify, dead chan<- *alloc, inspectc chan<- allocInspect) {
 	var err error
 	alloc.Alloc, err = s.Cluster.Allocate(ctx, alloc.Requirements, s.Labels)
 	if err != nil {
@@ -354,6 +687,9 @@ func (s *Scheduler) allocate(ctx context.Context, alloc *alloc, notify, dead cha
 	}
 	alloc.Context, alloc.Cancel = context.WithCancel(ctx)
 	notify <- alloc
+	if s.AllocCheckInterval > 0 {
+		go s.watchAlloc(alloc, inspectc)
+	}
 	err = pool.Keepalive(alloc.Context, s.Log, alloc.Alloc)
 	alloc.Cancel()
 	if err != nil && err == ctx.Err() {
@@ -368,6 +704,70 @@ func (s *Scheduler) allocate(ctx context.Context, alloc *alloc, notify, dead cha
 	dead <- alloc
 }
 
+// allocInspect is an inspection of an alloc that requires
+// action by the scheduler.
+type allocInspect struct {
+	pool.AllocInspect
+	alloc *alloc
+}
+
+// watchAlloc periodically inspects alloc for a notice of its pending
+// termination or a change in its administrative state. Inspections
+// that report either are sent on inspectc. watchAlloc returns after
+// a termination notice is sent.
+func (s *Scheduler) watchAlloc(alloc *alloc, inspectc chan<- allocInspect) {
+	tick := time.NewTicker(s.AllocCheckInterval)
+	defer tick.Stop()
+	state := pool.AllocActive
+	for {
+		select {
+		case <-alloc.Context.Done():
+			return
+		case <-tick.C:
+		}
+		inspect, err := alloc.Inspect(alloc.Context)
+		if err != nil {
+			s.Log.Debugf("alloc %s: inspect: %v", alloc.Alloc.ID(), err)
+			continue
+		}
+		terminating := !inspect.TerminationTime.IsZero()
+		switch {
+		case terminating:
+			s.Log.Printf("alloc %s is terminating at %s; preempting its tasks",
+				alloc.Alloc.ID(), inspect.TerminationTime.Format(time.RFC3339))
+		case inspect.State != state:
+			s.Log.Printf("alloc %s is %s", alloc.Alloc.ID(), inspect.State)
+			state = inspect.State
+		default:
+			continue
+		}
+		select {
+		case inspectc <- allocInspect{inspect, alloc}:
+		case <-alloc.Context.Done():
+			return
+		}
+		if terminating {
+			return
+		}
+	}
+}
+
+// retire cancels the context of an alloc that is to be collected.
+// Draining allocs are also freed.
+func (s *Scheduler) retire(alloc *alloc) {
+	alloc.Cancel()
+	if alloc.State != pool.AllocDraining {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := alloc.Free(ctx); err != nil {
+			s.Log.Errorf("free drained alloc %s: %v", alloc.id, err)
+		}
+	}()
+}
+
 type execState int
 
 const (
@@ -447,15 +847,21 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 		loadedData     sync.Map
 		resultUnloaded bool
 	)
-	defer func() {
-		if tcancel == nil {
+	// Tasks that are loading or executing are preempted when their
+	// alloc is about to be terminated, so that they may be rescheduled
+	// onto other allocs, or in favor of higher priority tasks. Tasks
+	// whose execs have completed are left to finish, so that their
+	// results are not lost.
+	xctx, xcancel := context.WithCancel(ctx)
+	defer xcancel()
+	go func() {
+		select {
+		case <-alloc.terminating:
+		case <-task.preemptc:
+		case <-xctx.Done():
 			return
 		}
-		// Use background context for setting task completion status.
-		if taskdbErr := s.TaskDB.SetTaskComplete(context.Background(), task.ID, err, time.Now()); taskdbErr != nil {
-			task.Log.Errorf("taskdb settaskcomplete: %v", taskdbErr)
-		}
-		tcancel()
+		xcancel()
 	}()
 	// Save the original fileset. In cases, where we fail, we need to restore the original fileset,
 	// since after the load all the interned files are technically resolved w.r.t. the current alloc.
@@ -472,9 +878,15 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 			if s.TaskDB != nil && tctx == nil {
 				// disable govet check due to https://github.com/golang/go/issues/29587
 				tctx, tcancel = context.WithCancel(ctx) //nolint: govet
-				if taskdbErr := s.TaskDB.CreateTask(tctx, task.ID, task.RunID, task.FlowID, taskdb.NewImgCmdID(task.Config.Image, task.Config.Cmd), task.Config.Ident, ""); taskdbErr != nil {
+				// Rescheduled tasks keep the record created for their
+				// first attempt, so that earlier preemptions remain
+				// recorded.
+				if task.recorded {
+					go func() { _ = taskdb.KeepTaskAlive(tctx, s.TaskDB, task.ID) }()
+				} else if taskdbErr := s.TaskDB.CreateTask(tctx, task.ID, task.RunID, task.FlowID, taskdb.NewImgCmdID(task.Config.Image, task.Config.Cmd), task.Config.Ident, ""); taskdbErr != nil {
 					task.Log.Errorf("taskdb createtask: %v", taskdbErr)
 				} else {
+					task.recorded = true
 					go func() { _ = taskdb.KeepTaskAlive(tctx, s.TaskDB, task.ID) }()
 				}
 			}
@@ -484,7 +896,7 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 				}
 				loadedData.Store(i, false)
 			}
-			g, gctx := errgroup.WithContext(ctx)
+			g, gctx := errgroup.WithContext(xctx)
 			loadedData.Range(func(key, value interface{}) bool {
 				if value.(bool) {
 					return true
@@ -493,10 +905,16 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 				arg := task.Config.Args[i]
 				g.Go(func() error {
 					task.Log.Debugf("loading %s", (*arg.Fileset).Short())
-					fs, lerr := alloc.Load(gctx, s.Repository.URL(), *arg.Fileset)
+					load := loadable(task.Config, *arg.Fileset)
+					fs, lerr := alloc.Load(gctx, s.Repository.URL(), load)
 					if lerr != nil {
 						return lerr
 					}
+					if task.Config.LazyInputs {
+						sub := make(map[digest.Digest]reflow.File)
+						loaded(sub, load, fs)
+						fs, _ = arg.Fileset.Subst(sub)
+					}
 					task.Log.Debugf("loaded %s", fs.Short())
 					task.Config.Args[i].Fileset = &fs
 					loadedData.Store(i, true)
@@ -506,7 +924,7 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 			})
 			err = g.Wait()
 		case statePut:
-			x, err = alloc.Put(ctx, digest.Digest(task.ID), task.Config)
+			x, err = alloc.Put(xctx, digest.Digest(task.ID), task.Config)
 		case stateWait:
 			if s.TaskDB != nil {
 				if taskdbErr := s.TaskDB.SetTaskUri(tctx, task.ID, x.URI()); taskdbErr != nil {
@@ -515,7 +933,7 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 			}
 			task.Exec = x
 			task.set(TaskRunning)
-			err = x.Wait(ctx)
+			err = x.Wait(xctx)
 			if s.TaskDB != nil {
 				if taskdbErr := s.TaskDB.SetTaskResult(tctx, task.ID, x.ID()); taskdbErr != nil {
 					task.Log.Errorf("taskdb settaskresult: %v", taskdbErr)
@@ -525,7 +943,7 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 			g, gctx := errgroup.WithContext(ctx)
 			loadedData.Range(func(key, value interface{}) bool {
 				i := key.(int)
-				fs := *task.Config.Args[i].Fileset
+				fs := loadable(task.Config, *task.Config.Args[i].Fileset)
 				g.Go(func() error {
 					task.Log.Debugf("verifying %v", fs.Short())
 					uerr := alloc.VerifyIntegrity(gctx, fs)
@@ -551,6 +969,16 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 			err = unload(ctx, task, &loadedData, alloc, &resultUnloaded)
 		}
 		next, msg := state.next(ctx, err, s.PostUseChecksum)
+		if err != nil && state <= stateWait && xctx.Err() != nil && ctx.Err() == nil {
+			reason := fmt.Sprintf("alloc %s is terminating", alloc.id)
+			select {
+			case <-task.preemptc:
+				reason = task.preemptReason
+			default:
+			}
+			err = errors.E(errors.Preempted, reason, err)
+			next, msg = stateDone, "preempted"
+		}
 		task.Log.Debugf("%s (try %d): %s, next state: %s", state, n, msg, next)
 		if next == state {
 			n++
@@ -559,16 +987,41 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 		}
 		state = next
 	}
+	// Preempted execs are killed, so that they release the alloc's
+	// resources (and do not compete with their rescheduled attempts).
+	// If the alloc is being terminated, it may already be gone, and
+	// its execs with it.
+	if x != nil && errors.Is(errors.Preempted, err) {
+		kctx, kcancel := context.WithTimeout(ctx, 10*time.Second)
+		if kerr := alloc.Remove(kctx, x.ID()); kerr != nil {
+			task.Log.Debugf("remove preempted exec %s: %v", x.ID(), kerr)
+		}
+		kcancel()
+	}
 	// Clean up the loaded data in case we exited early without unloading (usually due to an error in an earlier state)
 	if err != nil {
 		if unloadErr := unload(ctx, task, &loadedData, alloc, &resultUnloaded); unloadErr != nil {
 			task.Log.Debugf("error unloading data after task failure, this wastes disk space on the alloc: %s", unloadErr)
 		}
 	}
+	// The task's record is completed before the task itself, so that
+	// its submitter may amend it (e.g., with the result of a
+	// determinism verification). Use background context for setting
+	// task completion status. Preemptions are recorded (as such) on
+	// the task's record, which is kept when the task is rescheduled.
+	if tcancel != nil {
+		if taskdbErr := s.TaskDB.SetTaskComplete(context.Background(), task.ID, err, time.Now()); taskdbErr != nil {
+			task.Log.Errorf("taskdb settaskcomplete: %v", taskdbErr)
+		}
+		tcancel()
+	}
 	task.Err = err
 	switch {
 	case err == nil:
 		task.set(TaskDone)
+	case errors.Is(errors.Preempted, err):
+		task.Config.Args = savedArgs
+		task.set(TaskLost)
 	case errors.Is(errors.Canceled, err):
 		task.Config.Args = savedArgs
 		task.set(TaskLost)
@@ -581,11 +1034,40 @@ func (s *Scheduler) run(task *Task, returnc chan<- *Task) {
 	returnc <- task
 }
 
+// loadable returns the part of the argument fileset fs that must be
+// loaded onto an alloc in order to run an exec with the provided
+// config: if the exec's inputs are lazy, the parts of fs that are
+// passed by reference are not loaded (see reflow.ExecConfig.LazyInputs).
+func loadable(config reflow.ExecConfig, fs reflow.Fileset) reflow.Fileset {
+	if !config.LazyInputs {
+		return fs
+	}
+	load := reflow.Fileset{List: []reflow.Fileset{}}
+	for _, v := range fs.Flatten() {
+		if _, ok := v.RefURL(); !ok {
+			load.List = append(load.List, v)
+		}
+	}
+	return load
+}
+
+// loaded adds to sub the substitutions of the files of fileset fs by
+// their counterparts in the loaded fileset, which has the same
+// structure.
+func loaded(sub map[digest.Digest]reflow.File, fs, load reflow.Fileset) {
+	for i := range fs.List {
+		loaded(sub, fs.List[i], load.List[i])
+	}
+	for path, file := range fs.Map {
+		sub[file.Digest()] = load.Map[path]
+	}
+}
+
 func unload(ctx context.Context, task *Task, loadedData *sync.Map, alloc *alloc, resultUnloaded *bool) error {
 	g, gctx := errgroup.WithContext(ctx)
 	loadedData.Range(func(key, value interface{}) bool {
 		i := key.(int)
-		fs := *task.Config.Args[i].Fileset
+		fs := loadable(task.Config, *task.Config.Args[i].Fileset)
 		g.Go(func() error {
 			task.Log.Debugf("unloading %v", fs.Short())
 			uerr := alloc.Unload(gctx, fs)
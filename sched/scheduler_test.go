@@ -10,6 +10,7 @@ import (
 	golog "log"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -179,6 +180,9 @@ func TestSchedulerAlloc(t *testing.T) {
 			t.Errorf("task %d: got %v, want %v", i, got, want)
 		}
 	}
+	if got, want := scheduler.QueueLen(), len(tasks); got != want {
+		t.Errorf("got queue length %v, want %v", got, want)
+	}
 	// Partially satisfy the request: we can fit some tasks, but not all in this alloc.
 	// task[2] since it has a higher priority than others and
 	// task[0] since it is has the smallest resource requirements in the lower priority group.
@@ -294,6 +298,665 @@ func TestTaskLost(t *testing.T) {
 	}
 }
 
+func TestTaskPreempted(t *testing.T) {
+	tdb := newTestTaskDB()
+	repo := testutil.NewInmemoryRepository()
+	cluster := newTestCluster()
+	scheduler := sched.New()
+	scheduler.Transferer = testutil.Transferer
+	scheduler.Repository = repo
+	scheduler.Cluster = cluster
+	scheduler.TaskDB = tdb
+	scheduler.MinAlloc = reflow.Resources{}
+	scheduler.AllocCheckInterval = 10 * time.Millisecond
+	scheduler.Log = log.New(golog.New(os.Stderr, "scheduler: ", golog.LstdFlags), log.DebugLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = scheduler.Do(ctx)
+		wg.Done()
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := newTask(1, 1, 0)
+	scheduler.Submit(task)
+	req := <-cluster.Req()
+	spot := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	req.Reply <- testClusterAllocReply{Alloc: spot}
+	if err := task.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the alloc is about to be terminated, its task is
+	// preempted and rescheduled onto a new alloc.
+	spot.terminate(time.Now().Add(2 * time.Minute))
+	req = <-cluster.Req()
+	if got, want := req.Requirements, newRequirements(1, 1, 1); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := task.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	alloc := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	alloc.exec(digest.Digest(task.ID)).complete(reflow.Result{}, nil)
+	if err := task.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if task.Err != nil {
+		t.Errorf("task %v: %v", task.ID, task.Err)
+	}
+
+	// The preempted exec was killed.
+	if spot.has(digest.Digest(task.ID)) {
+		t.Error("preempted exec was not removed")
+	}
+	// The preemption is recorded on the task's own record, which
+	// is kept when the task is rescheduled.
+	dbtasks, errs := tdb.Complete()
+	if got, want := len(dbtasks), 1; got != want {
+		t.Fatalf("got %v taskdb tasks, want %v", got, want)
+	}
+	if got, want := dbtasks[0].ID, task.ID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !dbtasks[0].Preempted {
+		t.Error("task not recorded as preempted")
+	}
+	if errs[0] != nil {
+		t.Errorf("task %v: unexpected error %v", dbtasks[0].ID, errs[0])
+	}
+}
+
+func TestAllocCordonDrain(t *testing.T) {
+	repo := testutil.NewInmemoryRepository()
+	cluster := newTestCluster()
+	scheduler := sched.New()
+	scheduler.Transferer = testutil.Transferer
+	scheduler.Repository = repo
+	scheduler.Cluster = cluster
+	scheduler.MinAlloc = reflow.Resources{}
+	scheduler.AllocCheckInterval = 10 * time.Millisecond
+	scheduler.Log = log.New(golog.New(os.Stderr, "scheduler: ", golog.LstdFlags), log.DebugLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = scheduler.Do(ctx)
+		wg.Done()
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := newTask(1, 1, 0)
+	scheduler.Submit(task)
+	req := <-cluster.Req()
+	alloc := newTestAlloc(reflow.Resources{"cpu": 2, "mem": 2})
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := task.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cordoned allocs are not assigned new tasks, even if they fit.
+	alloc.setState(pool.AllocCordoned)
+	next := newTask(1, 1, 0)
+	scheduler.Submit(next)
+	req = <-cluster.Req()
+	if got, want := req.Requirements, newRequirements(1, 1, 1); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	other := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	req.Reply <- testClusterAllocReply{Alloc: other}
+	if err := next.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	other.exec(digest.Digest(next.ID)).complete(reflow.Result{}, nil)
+	if err := next.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+
+	// Draining allocs are freed once their running tasks complete.
+	alloc.setState(pool.AllocDraining)
+	if alloc.isFreed() {
+		t.Fatal("alloc freed while running a task")
+	}
+	alloc.exec(digest.Digest(task.ID)).complete(reflow.Result{}, nil)
+	if err := task.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if task.Err != nil {
+		t.Errorf("task %v: %v", task.ID, task.Err)
+	}
+	alloc.waitFreed()
+}
+
+func TestTaskAntiAffinity(t *testing.T) {
+	scheduler, cluster, _, shutdown := newTestScheduler(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	tasks := []*sched.Task{newTask(1, 1, 0), newTask(1, 1, 0)}
+	for _, task := range tasks {
+		task.AntiAffinity = "io"
+	}
+	scheduler.Submit(tasks...)
+	// The tasks are allocated separately.
+	var reqs []testClusterAllocReq
+	for i := 0; i < 2; i++ {
+		req := <-cluster.Req()
+		if got, want := req.Requirements, newRequirements(1, 1, 1); !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		reqs = append(reqs, req)
+	}
+	// Only one of them runs on an alloc that could fit both.
+	big := newTestAlloc(reflow.Resources{"cpu": 4, "mem": 4})
+	reqs[0].Reply <- testClusterAllocReply{Alloc: big}
+	statusCtx, statusCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	for _, task := range tasks {
+		_ = task.Wait(statusCtx, sched.TaskRunning)
+	}
+	statusCancel()
+	var running, waiting *sched.Task
+	for _, task := range tasks {
+		switch task.State() {
+		case sched.TaskRunning:
+			running = task
+		case sched.TaskInit:
+			waiting = task
+		}
+	}
+	if running == nil || waiting == nil {
+		t.Fatalf("got states %v, %v", tasks[0].State(), tasks[1].State())
+	}
+	small := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	reqs[1].Reply <- testClusterAllocReply{Alloc: small}
+	if err := waiting.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if !big.has(digest.Digest(running.ID)) || !small.has(digest.Digest(waiting.ID)) {
+		t.Error("tasks were not spread across allocs")
+	}
+}
+
+func TestTaskAffinity(t *testing.T) {
+	scheduler, cluster, repo, shutdown := newTestScheduler(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	in := randomFileset(repo)
+	run := func(task *sched.Task, alloc *testAlloc) {
+		t.Helper()
+		scheduler.Submit(task)
+		if alloc != nil {
+			req := <-cluster.Req()
+			req.Reply <- testClusterAllocReply{Alloc: alloc}
+		}
+		if err := task.Wait(ctx, sched.TaskRunning); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref := newTask(1, 1, 0)
+	ref.Affinity = "ref"
+	ref.Config.Args = []reflow.Arg{{Fileset: &in}}
+	a := newTestAlloc(reflow.Resources{"cpu": 4, "mem": 4})
+	run(ref, a)
+	b := newTestAlloc(reflow.Resources{"cpu": 5, "mem": 5})
+	run(newTask(4, 4, 0), b)
+
+	// Alloc b is the tightest fit for the following tasks, but they
+	// are placed on alloc a: the first because it is in the same
+	// affinity group as a task on a, the second because its input
+	// is held by a.
+	group := newTask(1, 1, 0)
+	group.Affinity = "ref"
+	run(group, nil)
+	if !a.has(digest.Digest(group.ID)) {
+		t.Error("task not placed with its affinity group")
+	}
+	data := newTask(1, 1, 0)
+	data.Config.Args = []reflow.Arg{{Fileset: &in}}
+	run(data, nil)
+	if !a.has(digest.Digest(data.ID)) {
+		t.Error("task not placed with its input data")
+	}
+	var size int64
+	for _, file := range in.Files() {
+		size += file.Size
+	}
+	if got, want := scheduler.Stats.GetStats().BytesSaved, size; got != want {
+		t.Errorf("got %v bytes saved, want %v", got, want)
+	}
+}
+
+func TestTaskAffinityChain(t *testing.T) {
+	scheduler, cluster, _, shutdown := newTestScheduler(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	producer := newTask(1, 1, 0)
+	scheduler.Submit(producer)
+	a := newTestAlloc(reflow.Resources{"cpu": 4, "mem": 4})
+	req := <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: a}
+	if err := producer.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	other := newTask(4, 4, 0)
+	scheduler.Submit(other)
+	b := newTestAlloc(reflow.Resources{"cpu": 5, "mem": 5})
+	req = <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: b}
+	if err := other.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	out := randomFileset(a.Repository())
+	for _, f := range out.Files() {
+		a.refCount[f.ID]++
+	}
+	a.exec(digest.Digest(producer.ID)).complete(reflow.Result{Fileset: out}, nil)
+	if err := producer.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if producer.Err != nil {
+		t.Fatal(producer.Err)
+	}
+
+	// Alloc b is the tightest fit for the consumer, but it is placed
+	// on alloc a, which produced its input.
+	consumer := newTask(1, 1, 0)
+	consumer.Config.Args = []reflow.Arg{{Fileset: &out}}
+	scheduler.Submit(consumer)
+	if err := consumer.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if !a.has(digest.Digest(consumer.ID)) {
+		t.Error("task not placed with its producer's output")
+	}
+	var size int64
+	for _, file := range out.Files() {
+		size += file.Size
+	}
+	if got, want := scheduler.Stats.GetStats().BytesSaved, size; got != want {
+		t.Errorf("got %v bytes saved, want %v", got, want)
+	}
+}
+
+func TestTaskExcludeAlloc(t *testing.T) {
+	scheduler, cluster, _, shutdown := newTestScheduler(t)
+	defer shutdown()
+	ctx := context.Background()
+
+	first := newTask(1, 1, 0)
+	scheduler.Submit(first)
+	a := newTestAlloc(reflow.Resources{"cpu": 4, "mem": 4})
+	req := <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: a}
+	if err := first.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := first.AllocID(), a.ID(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The second task fits alloc a, but excludes it, and so is
+	// given an alloc of its own.
+	second := newTask(1, 1, 0)
+	second.ExcludeAlloc = first.AllocID()
+	scheduler.Submit(second)
+	b := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	req = <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: b}
+	if err := second.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if a.has(digest.Digest(second.ID)) || !b.has(digest.Digest(second.ID)) {
+		t.Error("task was assigned to its excluded alloc")
+	}
+	if got, want := second.AllocID(), b.ID(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func newTestFairShareScheduler(t *testing.T, share *sched.FairShare) (scheduler *sched.Scheduler, cluster *testCluster, shutdown func()) {
+	t.Helper()
+	cluster = newTestCluster()
+	scheduler = sched.New()
+	scheduler.Transferer = testutil.Transferer
+	scheduler.Repository = testutil.NewInmemoryRepository()
+	scheduler.Cluster = cluster
+	scheduler.MinAlloc = reflow.Resources{}
+	scheduler.FairShare = share
+	scheduler.Log = log.New(golog.New(os.Stderr, "scheduler: ", golog.LstdFlags), log.DebugLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = scheduler.Do(ctx)
+		wg.Done()
+	}()
+	shutdown = func() {
+		cancel()
+		wg.Wait()
+	}
+	return
+}
+
+// waitRunning returns the first of tasks that is found running.
+func waitRunning(t *testing.T, tasks []*sched.Task) *sched.Task {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		for _, task := range tasks {
+			if task.State() == sched.TaskRunning {
+				return task
+			}
+		}
+	}
+	t.Fatal("no task is running")
+	return nil
+}
+
+func TestFairShare(t *testing.T) {
+	scheduler, cluster, shutdown := newTestFairShareScheduler(t, &sched.FairShare{
+		Weights: map[string]float64{"a": 2},
+	})
+	defer shutdown()
+	ctx := context.Background()
+
+	// The alloc runs one task at a time, and so runs the tasks that
+	// are queued behind the first in fair share order.
+	alloc := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	first := newTask(1, 1, 0)
+	first.User = "c"
+	scheduler.Submit(first)
+	req := <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := first.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []*sched.Task
+	for i := 0; i < 4; i++ {
+		for _, user := range []string{"a", "b"} {
+			task := newTask(1, 1, 0)
+			task.User = user
+			tasks = append(tasks, task)
+		}
+	}
+	scheduler.Submit(tasks...)
+	alloc.exec(digest.Digest(first.ID)).complete(reflow.Result{}, nil)
+
+	// User a has twice the weight of user b, and so runs
+	// twice as many tasks while both are queued.
+	var users string
+	for len(tasks) > 0 {
+		task := waitRunning(t, tasks)
+		users += task.User
+		alloc.exec(digest.Digest(task.ID)).complete(reflow.Result{}, nil)
+		if err := task.Wait(ctx, sched.TaskDone); err != nil {
+			t.Fatal(err)
+		}
+		for i := range tasks {
+			if tasks[i] == task {
+				tasks = append(tasks[:i], tasks[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, n := range []int{3, 5} {
+		if got, want := strings.Count(users[:n], "a"), n-n/2; got != want {
+			t.Errorf("got %v tasks of user a among the first %d (%s), want %v", got, n, users, want)
+		}
+	}
+}
+
+func TestFairShareQuota(t *testing.T) {
+	scheduler, cluster, shutdown := newTestFairShareScheduler(t, &sched.FairShare{
+		UserQuotas:    map[string]reflow.Resources{"a": {"cpu": 2}},
+		ProjectQuotas: map[string]reflow.Resources{"*": {"cpu": 3}},
+	})
+	defer shutdown()
+	ctx := context.Background()
+
+	// A task that alone exceeds its quota fails.
+	big := newTask(3, 1, 0)
+	big.User = "a"
+	scheduler.Submit(big)
+	if err := big.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(errors.ResourcesExhausted, big.Err) {
+		t.Errorf("got %v, want %v", big.Err, errors.ResourcesExhausted)
+	}
+
+	alloc := newTestAlloc(reflow.Resources{"cpu": 8, "mem": 8})
+	a1, a2, a3 := newTask(1, 1, 0), newTask(1, 1, 0), newTask(1, 1, 0)
+	b1 := newTask(1, 1, 0)
+	for _, task := range []*sched.Task{a1, a2, a3} {
+		task.User = "a"
+	}
+	b1.User = "b"
+	scheduler.Submit(a1)
+	req := <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := a1.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// The alloc can fit all of the tasks, but user a may run only
+	// two of them at a time. The waiting task does not cause a new
+	// alloc to be created.
+	scheduler.Submit(a2, a3, b1)
+	for _, task := range []*sched.Task{a2, b1} {
+		if err := task.Wait(ctx, sched.TaskRunning); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case req := <-cluster.Req():
+		t.Errorf("unexpected alloc request %v", req.Requirements)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got, want := a3.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Project quotas apply to all users: the project "" may use
+	// three cpus, and so only one of a3 and b2 may run once a1 is
+	// done. User b has run fewer tasks, so b2 is run first.
+	b2 := newTask(1, 1, 0)
+	b2.User = "b"
+	scheduler.Submit(b2)
+	alloc.exec(digest.Digest(a1.ID)).complete(reflow.Result{}, nil)
+	if err := b2.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a3.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	alloc.exec(digest.Digest(b1.ID)).complete(reflow.Result{}, nil)
+	if err := a3.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFairSharePreempt(t *testing.T) {
+	scheduler, cluster, shutdown := newTestFairShareScheduler(t, &sched.FairShare{Preempt: true})
+	defer shutdown()
+	ctx := context.Background()
+
+	alloc := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	low := newTask(1, 1, 1)
+	scheduler.Submit(low)
+	req := <-cluster.Req()
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := low.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// The higher priority task preempts the lower priority task,
+	// which is rescheduled.
+	high := newTask(1, 1, 0)
+	scheduler.Submit(high)
+	if err := high.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if !alloc.has(digest.Digest(high.ID)) {
+		t.Error("high priority task not placed on the preempted alloc")
+	}
+	if !errors.Is(errors.Preempted, low.Err) {
+		t.Errorf("got %v, want %v", low.Err, errors.Preempted)
+	}
+	if got, want := low.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	alloc.exec(digest.Digest(high.ID)).complete(reflow.Result{}, nil)
+	if err := low.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	alloc.exec(digest.Digest(low.ID)).complete(reflow.Result{}, nil)
+	if err := low.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if low.Err != nil {
+		t.Errorf("task %v: %v", low.ID, low.Err)
+	}
+}
+
+// testPricer prices all allocs at the same hourly price.
+type testPricer float64
+
+func (p testPricer) Price(pool.Alloc) (float64, bool) {
+	return float64(p), true
+}
+
+func newTestBudgetScheduler(t *testing.T, budget *sched.Budget) (scheduler *sched.Scheduler, cluster *testCluster, shutdown func()) {
+	t.Helper()
+	cluster = newTestCluster()
+	scheduler = sched.New()
+	scheduler.Transferer = testutil.Transferer
+	scheduler.Repository = testutil.NewInmemoryRepository()
+	scheduler.Cluster = cluster
+	scheduler.MinAlloc = reflow.Resources{}
+	scheduler.Budget = budget
+	// Allocs cost $1 per millisecond.
+	scheduler.Pricer = testPricer(3.6e6)
+	scheduler.BudgetCheckInterval = 10 * time.Millisecond
+	scheduler.Log = log.New(golog.New(os.Stderr, "scheduler: ", golog.LstdFlags), log.DebugLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = scheduler.Do(ctx)
+		wg.Done()
+	}()
+	shutdown = func() {
+		cancel()
+		wg.Wait()
+	}
+	return
+}
+
+func TestBudgetMaxAllocs(t *testing.T) {
+	scheduler, cluster, shutdown := newTestBudgetScheduler(t, &sched.Budget{MaxAllocs: 1})
+	defer shutdown()
+	ctx := context.Background()
+
+	a, b := newTask(1, 1, 0), newTask(1, 1, 0)
+	scheduler.Submit(a)
+	req := <-cluster.Req()
+	alloc := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := a.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	// The scheduler holds its maximum number of allocs, and so
+	// b waits for a to complete instead of causing an allocation.
+	scheduler.Submit(b)
+	select {
+	case <-cluster.Req():
+		t.Fatal("unexpected alloc request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got, want := b.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	alloc.exec(digest.Digest(a.ID)).complete(reflow.Result{}, nil)
+	if err := b.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	alloc.exec(digest.Digest(b.ID)).complete(reflow.Result{}, nil)
+	for _, task := range []*sched.Task{a, b} {
+		if err := task.Wait(ctx, sched.TaskDone); err != nil {
+			t.Fatal(err)
+		}
+		if task.Err != nil {
+			t.Errorf("task %v: %v", task.ID, task.Err)
+		}
+	}
+	if got, want := scheduler.Spend().Allocs, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	for _, kill := range []bool{false, true} {
+		t.Run(fmt.Sprintf("kill=%v", kill), func(t *testing.T) {
+			scheduler, cluster, shutdown := newTestBudgetScheduler(t, &sched.Budget{MaxDollars: 100, Kill: kill})
+			defer shutdown()
+			ctx := context.Background()
+
+			a, b := newTask(1, 1, 0), newTask(2, 2, 0)
+			scheduler.Submit(a)
+			req := <-cluster.Req()
+			alloc := newTestAlloc(reflow.Resources{"cpu": 1, "mem": 1})
+			req.Reply <- testClusterAllocReply{Alloc: alloc}
+			if err := a.Wait(ctx, sched.TaskRunning); err != nil {
+				t.Fatal(err)
+			}
+			// b waits for an alloc, which is never granted, until the
+			// budget is exceeded, and then fails.
+			scheduler.Submit(b)
+			<-cluster.Req()
+			if err := b.Wait(ctx, sched.TaskDone); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(errors.BudgetExceeded, b.Err) {
+				t.Errorf("got %v, want %v", b.Err, errors.BudgetExceeded)
+			}
+			if spend := scheduler.Spend(); spend.Dollars <= 100 || spend.InstanceHours <= 0 {
+				t.Errorf("unexpected spend %v", spend)
+			}
+			// Running tasks are left to complete unless they are killed.
+			if !kill {
+				if got, want := a.State(), sched.TaskRunning; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				alloc.exec(digest.Digest(a.ID)).complete(reflow.Result{}, nil)
+			}
+			if err := a.Wait(ctx, sched.TaskDone); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := errors.Is(errors.BudgetExceeded, a.Err), kill; got != want {
+				t.Errorf("task %v: got error %v, want budget exceeded: %v", a.ID, a.Err, want)
+			}
+			// Tasks submitted after the scheduler halts fail immediately.
+			c := newTask(1, 1, 0)
+			scheduler.Submit(c)
+			if err := c.Wait(ctx, sched.TaskDone); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(errors.BudgetExceeded, c.Err) {
+				t.Errorf("got %v, want %v", c.Err, errors.BudgetExceeded)
+			}
+		})
+	}
+}
+
 func TestTaskNetError(t *testing.T) {
 	scheduler, cluster, _, shutdown := newTestScheduler(t)
 	defer shutdown()
@@ -854,6 +1517,61 @@ func TestSchedulerLoadUnloadFiles(t *testing.T) {
 	expectExists(t, repo, out)
 }
 
+func TestSchedulerLazyInputs(t *testing.T) {
+	scheduler, cluster, repo, shutdown := newTestScheduler(t)
+	defer shutdown()
+	ctx := context.Background()
+	in := randomFileset(repo)
+
+	remote := testutil.NewInmemoryRepository()
+	remotes := randomRepoFileset(remote)
+	// The references of directory refs do not share a prefix, and
+	// thus must be loaded.
+	refs := reflow.Fileset{Map: make(map[string]reflow.File)}
+	for k := range remotes.Map {
+		refs.Map[k] = reflow.File{Source: remotes.Map[k].Source}
+	}
+	lazy := randomRepoFileset(remote)
+	var file reflow.File
+	for _, file = range lazy.Map {
+		break
+	}
+	ref := reflow.Fileset{Map: map[string]reflow.File{".": {Source: file.Source}}}
+
+	task := newTask(10, 10<<30, 0)
+	task.Config.LazyInputs = true
+	task.Config.Args = []reflow.Arg{{Fileset: &in}, {Fileset: &ref}, {Fileset: &refs}}
+	scheduler.Submit(task)
+	req := <-cluster.Req()
+	alloc := newTestAlloc(reflow.Resources{"cpu": 25, "mem": 20 << 30})
+	req.Reply <- testClusterAllocReply{Alloc: alloc, Err: nil}
+	if err := task.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	expectExists(t, alloc.Repository(), in)
+	expectExists(t, alloc.Repository(), remotes)
+	if _, err := alloc.Repository().Stat(ctx, file.ID); !errors.Is(errors.NotExist, err) {
+		t.Errorf("lazy input %v was loaded", file)
+	}
+	exec := alloc.exec(digest.Digest(task.ID))
+	if got, want := exec.Config.Args[1].Fileset.Map["."], ref.Map["."]; got.Source != want.Source || !got.IsRef() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, f := range exec.Config.Args[2].Fileset.Files() {
+		if f.IsRef() {
+			t.Errorf("unexpected reference %v", f)
+		}
+	}
+
+	exec.complete(reflow.Result{Fileset: reflow.Fileset{Map: map[string]reflow.File{}}}, nil)
+	if err := task.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if task.Err != nil {
+		t.Errorf("unexpected task error: %v", task.Err)
+	}
+}
+
 func TestTaskSet(t *testing.T) {
 	var (
 		tasks = newTasks(20)
@@ -0,0 +1,529 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sched
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/pool"
+	"github.com/grailbio/reflow/rest"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+// serviceExpiry is the time after which the service forgets a
+// completed task whose state has not been retrieved.
+const serviceExpiry = time.Hour
+
+// servicePollTimeout is the time after which the service cancels a
+// pending task whose state has not been retrieved: its submitter is
+// presumed to have gone away.
+var servicePollTimeout = 10 * time.Minute
+
+// taskJSON is the wire representation of a task that is submitted
+// to a scheduling service.
+type taskJSON struct {
+	ID               taskdb.TaskID
+	RunID            taskdb.RunID
+	FlowID           digest.Digest
+	Config           reflow.ExecConfig
+	Priority         int
+	ExpectedDuration time.Duration
+	Affinity         string `json:",omitempty"`
+	AntiAffinity     string `json:",omitempty"`
+	ExcludeAlloc     string `json:",omitempty"`
+	User             string `json:",omitempty"`
+	Project          string `json:",omitempty"`
+}
+
+// taskStateJSON is the wire representation of the state of a task
+// that was submitted to a scheduling service.
+type taskStateJSON struct {
+	ID    taskdb.TaskID
+	State TaskState
+	// ExecURI is the URI of the task's exec, once it is running.
+	ExecURI string `json:",omitempty"`
+	// AllocID is the ID of the alloc to which the task was most
+	// recently assigned.
+	AllocID string        `json:",omitempty"`
+	Err     *errors.Error `json:",omitempty"`
+	Result  reflow.Result
+	Inspect reflow.ExecInspect
+}
+
+// NewServiceNode returns a rest.Node that implements a scheduling
+// service on top of the provided scheduler: the tasks of many runs,
+// each with its own (remote) scheduler, may thus be scheduled
+// together, and share the scheduler's allocs according to its
+// FairShare configuration.
+//
+// Tasks are submitted by POSTing a list of tasks to v1/tasks; their
+// states are retrieved by POSTing a list of task IDs to v1/states;
+// and they are canceled by POSTing a list of task IDs to v1/cancel.
+// Completed tasks are forgotten once their states are retrieved.
+// Pending tasks whose states are not retrieved for some time are
+// canceled, as their submitters are presumed to have gone away.
+//
+// Calls to the service are not authenticated: tasks are run on
+// behalf of the users and projects named by their submitters. See
+// NewAuthServiceNode.
+func NewServiceNode(s *Scheduler) rest.Node {
+	return NewAuthServiceNode(s, ServiceAuth{})
+}
+
+// NewAuthServiceNode returns a rest.Node that implements a
+// scheduling service (see NewServiceNode), authenticating and
+// authorizing calls as configured by auth.
+func NewAuthServiceNode(s *Scheduler, auth ServiceAuth) rest.Node {
+	n := &serviceNode{
+		scheduler:   s,
+		auth:        auth,
+		pollTimeout: servicePollTimeout,
+		tasks:       make(map[taskdb.TaskID]*serviceTask),
+	}
+	go n.expire()
+	v1 := rest.Mux{
+		"tasks":  rest.DoFunc(n.submit),
+		"states": rest.DoFunc(n.states),
+		"cancel": rest.DoFunc(n.cancel),
+	}
+	return rest.Mux{"v1": v1}
+}
+
+// ServiceAuth configures the authentication and authorization of
+// calls to a scheduling service. Tasks are run on behalf of the
+// authenticated users that submit them, who may retrieve the states
+// of, and cancel, only their own tasks.
+type ServiceAuth struct {
+	// Authenticator authenticates the users making calls. If nil,
+	// calls are neither authenticated nor authorized.
+	Authenticator rest.Authenticator
+	// Projects maps each user to the projects on whose behalf the
+	// user may submit tasks. Tasks that do not name a project may be
+	// submitted by any user.
+	Projects map[string][]string
+}
+
+// Authenticate returns the user making the call; it fails the call
+// and returns false if the call cannot be authenticated.
+// Authenticate returns the empty user if calls are not
+// authenticated.
+func (a ServiceAuth) Authenticate(call *rest.Call) (string, bool) {
+	if a.Authenticator == nil {
+		return "", true
+	}
+	return call.Authenticate(a.Authenticator)
+}
+
+// Entitled tells whether the provided (authenticated) user may
+// submit tasks on behalf of the provided project.
+func (a ServiceAuth) Entitled(user, project string) bool {
+	if a.Authenticator == nil || project == "" {
+		return true
+	}
+	for _, p := range a.Projects[user] {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceTask is a task that is managed by a scheduling service.
+type serviceTask struct {
+	*Task
+	// accessed is the last time the task's state was retrieved.
+	accessed time.Time
+	// submitter is the authenticated user that submitted the task.
+	submitter string
+	// abandoned tells whether the task was canceled because its
+	// state was not retrieved.
+	abandoned bool
+}
+
+type serviceNode struct {
+	scheduler   *Scheduler
+	auth        ServiceAuth
+	pollTimeout time.Duration
+
+	mu    sync.Mutex
+	tasks map[taskdb.TaskID]*serviceTask
+}
+
+func (n *serviceNode) submit(ctx context.Context, call *rest.Call) {
+	if !call.Allow("POST") {
+		return
+	}
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return
+	}
+	var jsons []taskJSON
+	if call.Unmarshal(&jsons) != nil {
+		return
+	}
+	tasks := make([]*Task, len(jsons))
+	for i, json := range jsons {
+		if n.auth.Authenticator != nil {
+			json.User = user
+		}
+		if !n.auth.Entitled(json.User, json.Project) {
+			call.Reply(http.StatusForbidden, errors.E("submit", json.ID.ID(), json.User, errors.NotAllowed,
+				errors.Errorf("user is not entitled to project %q", json.Project)))
+			return
+		}
+		task := NewTask()
+		task.ID = json.ID
+		task.RunID = json.RunID
+		task.FlowID = json.FlowID
+		task.Config = json.Config
+		task.Priority = json.Priority
+		task.ExpectedDuration = json.ExpectedDuration
+		task.Affinity = json.Affinity
+		task.AntiAffinity = json.AntiAffinity
+		task.ExcludeAlloc = json.ExcludeAlloc
+		task.User = json.User
+		task.Project = json.Project
+		task.Log = n.scheduler.Log.Tee(nil, fmt.Sprintf("task %s (user %s): ", task.ID.IDShort(), task.User))
+		tasks[i] = task
+	}
+	n.mu.Lock()
+	for _, task := range tasks {
+		if t, ok := n.tasks[task.ID]; ok && t.State() != TaskDone {
+			n.mu.Unlock()
+			call.Error(errors.E(errors.Invalid, "submit", task.ID.ID(), errors.New("task is already scheduled")))
+			return
+		}
+	}
+	now := time.Now()
+	for _, task := range tasks {
+		n.tasks[task.ID] = &serviceTask{Task: task, accessed: now, submitter: user}
+	}
+	n.mu.Unlock()
+	n.scheduler.Submit(tasks...)
+	call.Reply(http.StatusOK, nil)
+}
+
+func (n *serviceNode) states(ctx context.Context, call *rest.Call) {
+	if !call.Allow("POST") {
+		return
+	}
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return
+	}
+	var ids []taskdb.TaskID
+	if call.Unmarshal(&ids) != nil {
+		return
+	}
+	states := make([]taskStateJSON, len(ids))
+	now := time.Now()
+	n.mu.Lock()
+	for i, id := range ids {
+		states[i].ID = id
+		t, ok := n.tasks[id]
+		// Other users' tasks are reported, as are forgotten tasks, as
+		// not existing.
+		if !ok || t.submitter != user {
+			states[i].State = TaskDone
+			states[i].Err = errors.Recover(errors.E(errors.NotExist, "task", id.ID()))
+			continue
+		}
+		t.accessed = now
+		t.mu.Lock()
+		states[i].State = t.state
+		states[i].AllocID = t.allocID
+		t.mu.Unlock()
+		// The task's exec is set before it enters the running state,
+		// and its results before it is done.
+		if states[i].State >= TaskRunning && t.Exec != nil {
+			states[i].ExecURI = t.Exec.URI()
+		}
+		if states[i].State == TaskDone {
+			if t.Err != nil {
+				states[i].Err = errors.Recover(t.Err)
+			}
+			states[i].Result = t.Result
+			states[i].Inspect = t.Inspect
+			delete(n.tasks, id)
+		}
+	}
+	n.mu.Unlock()
+	call.Reply(http.StatusOK, states)
+}
+
+func (n *serviceNode) cancel(ctx context.Context, call *rest.Call) {
+	if !call.Allow("POST") {
+		return
+	}
+	user, ok := n.auth.Authenticate(call)
+	if !ok {
+		return
+	}
+	var ids []taskdb.TaskID
+	if call.Unmarshal(&ids) != nil {
+		return
+	}
+	var tasks []*Task
+	n.mu.Lock()
+	for _, id := range ids {
+		// Other users' tasks, and forgotten tasks, are ignored.
+		if t, ok := n.tasks[id]; ok && t.submitter == user {
+			tasks = append(tasks, t.Task)
+		}
+	}
+	n.mu.Unlock()
+	if len(tasks) > 0 {
+		n.scheduler.Cancel(tasks...)
+	}
+	call.Reply(http.StatusOK, nil)
+}
+
+// expire periodically forgets the completed tasks whose states
+// have not been retrieved for serviceExpiry, and cancels the pending
+// tasks whose states have not been retrieved for the node's poll
+// timeout.
+func (n *serviceNode) expire() {
+	tick := time.NewTicker(n.pollTimeout / 2)
+	defer tick.Stop()
+	for range tick.C {
+		var abandoned []*Task
+		now := time.Now()
+		n.mu.Lock()
+		for id, t := range n.tasks {
+			switch idle := now.Sub(t.accessed); {
+			case t.State() == TaskDone:
+				if idle > serviceExpiry {
+					delete(n.tasks, id)
+				}
+			case idle > n.pollTimeout && !t.abandoned:
+				t.abandoned = true
+				abandoned = append(abandoned, t.Task)
+			}
+		}
+		n.mu.Unlock()
+		if len(abandoned) > 0 {
+			n.scheduler.Log.Printf("canceling %d tasks whose states were not retrieved for %s", len(abandoned), n.pollTimeout)
+			n.scheduler.Cancel(abandoned...)
+		}
+	}
+}
+
+// Remote is a client of a scheduling service (see NewServiceNode).
+// A Scheduler with a Remote submits its tasks to the service, and
+// maintains their states as they are scheduled and run by it.
+type Remote struct {
+	// Client is the REST client of the scheduling service.
+	Client *rest.Client
+	// Pool is used to retrieve the execs of running tasks. If it is
+	// nil, the tasks' Exec fields are not set.
+	Pool pool.Pool
+	// PollInterval is the interval at which the states of the
+	// submitted tasks are retrieved.
+	PollInterval time.Duration
+}
+
+// NewRemote returns a new Remote for the scheduling service with
+// the provided client, retrieving execs from the provided pool.
+func NewRemote(client *rest.Client, pool pool.Pool) *Remote {
+	return &Remote{Client: client, Pool: pool, PollInterval: 5 * time.Second}
+}
+
+// do runs the scheduler s's tasks on the remote scheduling service
+// until the provided context is canceled.
+func (r *Remote) do(ctx context.Context, s *Scheduler) error {
+	tick := time.NewTicker(r.PollInterval)
+	defer tick.Stop()
+	tasks := make(map[taskdb.TaskID]*Task)
+	for {
+		select {
+		case <-ctx.Done():
+			// The service is asked to cancel the tasks, so that they
+			// do not keep running on its allocs.
+			ids := make([]taskdb.TaskID, 0, len(tasks))
+			for id, task := range tasks {
+				ids = append(ids, id)
+				task.Err = ctx.Err()
+				task.set(TaskDone)
+			}
+			if len(ids) > 0 {
+				cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				if err := r.cancel(cctx, ids); err != nil {
+					s.Log.Errorf("cancel %d tasks: %v", len(ids), err)
+				}
+				cancel()
+			}
+			return ctx.Err()
+		case submitted := <-s.submitc:
+			s.Stats.AddTasks(submitted)
+			if err := r.submit(ctx, submitted); err != nil {
+				s.Log.Errorf("submit %d tasks: %v", len(submitted), err)
+				for _, task := range submitted {
+					task.Err = err
+					task.set(TaskDone)
+				}
+				continue
+			}
+			for _, task := range submitted {
+				tasks[task.ID] = task
+			}
+		case canceled := <-s.cancelc:
+			var ids []taskdb.TaskID
+			for _, task := range canceled {
+				if tasks[task.ID] == task {
+					ids = append(ids, task.ID)
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			// The tasks' states are updated as they are canceled
+			// by the service.
+			if err := r.cancel(ctx, ids); err != nil {
+				s.Log.Errorf("cancel %d tasks: %v", len(ids), err)
+			}
+		case <-tick.C:
+			if len(tasks) == 0 {
+				continue
+			}
+			ids := make([]taskdb.TaskID, 0, len(tasks))
+			for id := range tasks {
+				ids = append(ids, id)
+			}
+			states, err := r.states(ctx, ids)
+			if err != nil {
+				// Errors are presumed to be transient: the states are
+				// retrieved again on the next tick.
+				s.Log.Errorf("retrieve task states: %v", err)
+				continue
+			}
+			for _, state := range states {
+				task := tasks[state.ID]
+				if task == nil {
+					continue
+				}
+				r.update(ctx, s.Log, task, state)
+				if state.State == TaskDone {
+					delete(tasks, state.ID)
+				}
+			}
+		}
+	}
+}
+
+func (r *Remote) submit(ctx context.Context, tasks []*Task) error {
+	jsons := make([]taskJSON, len(tasks))
+	for i, task := range tasks {
+		jsons[i] = taskJSON{
+			ID:               task.ID,
+			RunID:            task.RunID,
+			FlowID:           task.FlowID,
+			Config:           task.Config,
+			Priority:         task.Priority,
+			ExpectedDuration: task.ExpectedDuration,
+			Affinity:         task.Affinity,
+			AntiAffinity:     task.AntiAffinity,
+			ExcludeAlloc:     task.ExcludeAlloc,
+			User:             task.User,
+			Project:          task.Project,
+		}
+	}
+	call := r.Client.Call("POST", "v1/tasks")
+	defer call.Close()
+	code, err := call.DoJSON(ctx, jsons)
+	if err != nil {
+		return errors.E("submit", errors.Net, err)
+	}
+	if code != http.StatusOK {
+		return call.Error()
+	}
+	return nil
+}
+
+func (r *Remote) cancel(ctx context.Context, ids []taskdb.TaskID) error {
+	call := r.Client.Call("POST", "v1/cancel")
+	defer call.Close()
+	code, err := call.DoJSON(ctx, ids)
+	if err != nil {
+		return errors.E("cancel", errors.Net, err)
+	}
+	if code != http.StatusOK {
+		return call.Error()
+	}
+	return nil
+}
+
+func (r *Remote) states(ctx context.Context, ids []taskdb.TaskID) ([]taskStateJSON, error) {
+	call := r.Client.Call("POST", "v1/states")
+	defer call.Close()
+	code, err := call.DoJSON(ctx, ids)
+	if err != nil {
+		return nil, errors.E("states", errors.Net, err)
+	}
+	if code != http.StatusOK {
+		return nil, call.Error()
+	}
+	var states []taskStateJSON
+	err = call.Unmarshal(&states)
+	return states, err
+}
+
+// update updates the provided task to reflect its state as reported
+// by the scheduling service. Lost tasks are rescheduled by the service,
+// and so are reflected as being in their initial state.
+func (r *Remote) update(ctx context.Context, log *log.Logger, task *Task, state taskStateJSON) {
+	if state.State >= TaskRunning && task.Exec == nil && state.ExecURI != "" && r.Pool != nil {
+		x, err := r.exec(ctx, state.ExecURI)
+		if err != nil {
+			log.Debugf("task %s: exec %s: %v", task.ID.IDShort(), state.ExecURI, err)
+		} else {
+			task.Exec = x
+		}
+	}
+	if state.AllocID != "" {
+		task.mu.Lock()
+		task.allocID = state.AllocID
+		task.mu.Unlock()
+	}
+	switch state.State {
+	case TaskLost:
+		state.State = TaskInit
+	case TaskDone:
+		if state.Err != nil {
+			task.Err = state.Err
+		}
+		task.Result = state.Result
+		task.Inspect = state.Inspect
+	}
+	if task.State() != state.State {
+		task.set(state.State)
+	}
+}
+
+// exec retrieves the exec with the provided URI from the remote's pool.
+// Exec URIs comprise the ID of the exec's alloc and the exec's digest.
+func (r *Remote) exec(ctx context.Context, uri string) (reflow.Exec, error) {
+	i := strings.LastIndex(uri, "/")
+	if i < 0 {
+		return nil, errors.E(errors.Invalid, "exec", uri, errors.New("malformed exec URI"))
+	}
+	id, err := reflow.Digester.Parse(uri[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	alloc, err := r.Pool.Alloc(ctx, uri[:i])
+	if err != nil {
+		return nil, err
+	}
+	return alloc.Get(ctx, id)
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sched_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/rest"
+	"github.com/grailbio/reflow/sched"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func TestService(t *testing.T) {
+	scheduler, cluster, shutdown := newTestFairShareScheduler(t, &sched.FairShare{
+		UserQuotas: map[string]reflow.Resources{"a": {"cpu": 1}},
+	})
+	defer shutdown()
+	srv := httptest.NewServer(rest.Handler(sched.NewServiceNode(scheduler), nil))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := sched.New()
+	remote.Remote = sched.NewRemote(rest.NewClient(http.DefaultClient, u, nil), nil)
+	remote.Remote.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = remote.Do(ctx)
+		wg.Done()
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Tasks submitted to the remote scheduler are scheduled by the
+	// service, and their states and results are reflected remotely.
+	ok, failed := newTask(1, 1, 0), newTask(1, 1, 0)
+	ok.User, failed.User = "a", "a"
+	remote.Submit(ok, failed)
+	req := <-cluster.Req()
+	alloc := newTestAlloc(reflow.Resources{"cpu": 2, "mem": 2})
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	// User a may run one task at a time, in the order submitted.
+	if err := ok.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := failed.State(), sched.TaskInit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	alloc.exec(digest.Digest(ok.ID)).complete(reflow.Result{}, nil)
+	alloc.exec(digest.Digest(failed.ID)).complete(reflow.Result{Err: errors.Recover(errors.New("failed"))}, nil)
+	for _, task := range []*sched.Task{ok, failed} {
+		if err := task.Wait(ctx, sched.TaskDone); err != nil {
+			t.Fatal(err)
+		}
+		if task.Err != nil {
+			t.Errorf("task %v: %v", task.ID, task.Err)
+		}
+	}
+	if ok.Result.Err != nil {
+		t.Errorf("task %v: unexpected result error %v", ok.ID, ok.Result.Err)
+	}
+	if failed.Result.Err == nil {
+		t.Error("expected result error")
+	}
+
+	// Tasks that exceed their quotas fail remotely.
+	big := newTask(2, 1, 0)
+	big.User = "a"
+	remote.Submit(big)
+	if err := big.Wait(ctx, sched.TaskDone); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(errors.ResourcesExhausted, big.Err) {
+		t.Errorf("got %v, want %v", big.Err, errors.ResourcesExhausted)
+	}
+}
+
+func TestServiceCancel(t *testing.T) {
+	const pollTimeout = 200 * time.Millisecond
+	defer sched.SetServicePollTimeout(pollTimeout)()
+	scheduler, cluster, _, shutdown := newTestScheduler(t)
+	defer shutdown()
+	srv := httptest.NewServer(rest.Handler(sched.NewServiceNode(scheduler), nil))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rest.NewClient(http.DefaultClient, u, nil)
+	ctx := context.Background()
+	// state retrieves the state of the task with the provided ID
+	// from the service.
+	state := func(id taskdb.TaskID) (sched.TaskState, error) {
+		t.Helper()
+		call := client.Call("POST", "v1/states")
+		defer call.Close()
+		if _, err := call.DoJSON(ctx, []taskdb.TaskID{id}); err != nil {
+			t.Fatal(err)
+		}
+		var states []struct {
+			State sched.TaskState
+			Err   *errors.Error
+		}
+		if err := call.Unmarshal(&states); err != nil {
+			t.Fatal(err)
+		}
+		if states[0].Err == nil {
+			return states[0].State, nil
+		}
+		return states[0].State, states[0].Err
+	}
+	// canceled waits for the task with the provided ID to be
+	// canceled by the service.
+	canceled := func(id taskdb.TaskID) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if s, err := state(id); s == sched.TaskDone {
+				if !errors.Is(errors.Canceled, err) {
+					t.Errorf("got %v, want %v", err, errors.Canceled)
+				}
+				return
+			}
+		}
+		t.Fatalf("task %v was not canceled", id.IDShort())
+	}
+	start := func(pollInterval time.Duration) (remote *sched.Scheduler, stop func()) {
+		remote = sched.New()
+		remote.Remote = sched.NewRemote(client, nil)
+		remote.Remote.PollInterval = pollInterval
+		ctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			_ = remote.Do(ctx)
+			wg.Done()
+		}()
+		return remote, func() {
+			cancel()
+			wg.Wait()
+		}
+	}
+
+	// The tasks of a remote scheduler are canceled when it is: both
+	// those that are running and those that are waiting for an
+	// alloc.
+	remote, stop := start(10 * time.Millisecond)
+	running, queued := newTask(1, 1, 0), newTask(2, 2, 0)
+	remote.Submit(running, queued)
+	req := <-cluster.Req()
+	alloc := newTestAlloc(reflow.Resources{"cpu": 2, "mem": 2})
+	req.Reply <- testClusterAllocReply{Alloc: alloc}
+	if err := running.Wait(ctx, sched.TaskRunning); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	canceled(running.ID)
+	canceled(queued.ID)
+	if alloc.has(digest.Digest(running.ID)) {
+		t.Error("canceled exec was not removed")
+	}
+
+	// Tasks whose states are not retrieved are canceled. (Retrieving
+	// their states defers their cancellation.)
+	remote, stop = start(time.Hour)
+	defer stop()
+	abandoned := newTask(1, 1, 0)
+	remote.Submit(abandoned)
+	time.Sleep(2 * pollTimeout)
+	canceled(abandoned.ID)
+}
+
+func TestServiceAuth(t *testing.T) {
+	scheduler, _, shutdown := newTestFairShareScheduler(t, &sched.FairShare{
+		UserQuotas: map[string]reflow.Resources{"alice": {"cpu": 1}},
+	})
+	defer shutdown()
+	auth := sched.ServiceAuth{
+		Authenticator: rest.Tokens{"alice": rest.HashToken("alicetoken")},
+		Projects:      map[string][]string{"alice": {"genomics"}},
+	}
+	srv := httptest.NewServer(rest.Handler(sched.NewAuthServiceNode(scheduler, auth), nil))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	submit := func(client *http.Client, task *sched.Task) {
+		t.Helper()
+		remote := sched.New()
+		remote.Remote = sched.NewRemote(rest.NewClient(client, u, nil), nil)
+		remote.Remote.PollInterval = 10 * time.Millisecond
+		wg.Add(1)
+		go func() {
+			_ = remote.Do(ctx)
+			wg.Done()
+		}()
+		remote.Submit(task)
+		if err := task.Wait(ctx, sched.TaskDone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice := &http.Client{Transport: &rest.TokenTransport{User: "alice", Token: "alicetoken"}}
+
+	// Users must authenticate.
+	task := newTask(1, 1, 0)
+	submit(http.DefaultClient, task)
+	if !errors.Is(errors.NotAllowed, task.Err) {
+		t.Errorf("got %v, want %v", task.Err, errors.NotAllowed)
+	}
+
+	// Users may submit tasks only on behalf of their projects.
+	task = newTask(1, 1, 0)
+	task.Project = "imaging"
+	submit(alice, task)
+	if !errors.Is(errors.NotAllowed, task.Err) {
+		t.Errorf("got %v, want %v", task.Err, errors.NotAllowed)
+	}
+
+	// Tasks are run on behalf of the authenticated user, whatever
+	// user the client names: this task exceeds alice's quota.
+	task = newTask(2, 1, 0)
+	task.User, task.Project = "mallory", "genomics"
+	submit(alice, task)
+	if !errors.Is(errors.ResourcesExhausted, task.Err) {
+		t.Errorf("got %v, want %v", task.Err, errors.ResourcesExhausted)
+	}
+	if task.Err != nil && !strings.Contains(task.Err.Error(), `user "alice"`) {
+		t.Errorf("task %v: error %v does not name user alice", task.ID, task.Err)
+	}
+}
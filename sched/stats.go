@@ -17,6 +17,10 @@ type OverallStats struct {
 	TotalAllocs int64
 	// TotalTasks is the total number of tasks (pending, running or completed).
 	TotalTasks int64
+	// BytesSaved is the total number of bytes of task inputs that did
+	// not need to be transferred, because they were already present
+	// on the allocs to which the tasks were assigned.
+	BytesSaved int64
 }
 
 // AllocStatsData is the per alloc stats snapshot.
@@ -190,10 +194,12 @@ func (s *Stats) ReturnTask(task *Task, alloc *alloc) {
 	a.RemoveTask(task)
 }
 
-// AssignTask assigns a task to an alloc.
-func (s *Stats) AssignTask(task *Task, alloc *alloc) {
+// AssignTask assigns a task to an alloc. Saved is the number of
+// bytes of the task's inputs that are already present on the alloc.
+func (s *Stats) AssignTask(task *Task, alloc *alloc, saved int64) {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
+	s.BytesSaved += saved
 	t := s.Tasks[task.ID.ID()]
 	t.Update(task)
 	a := s.Allocs[alloc.id]
@@ -86,6 +86,25 @@ type Task struct {
 	// by the scheduler for better scheduling.
 	ExpectedDuration time.Duration
 
+	// Affinity is the task's affinity group, if any. The scheduler
+	// prefers to assign the task to an alloc that is running other
+	// tasks in the same affinity group, or else to one that holds
+	// the most of the task's input data.
+	Affinity string
+	// AntiAffinity is the task's anti-affinity group, if any. The
+	// scheduler never assigns the task to an alloc that is running
+	// another task in the same anti-affinity group.
+	AntiAffinity string
+	// ExcludeAlloc is the ID of an alloc to which the task may not be
+	// assigned, e.g., so that an exec is re-executed on a different
+	// alloc than the one that first ran it.
+	ExcludeAlloc string
+
+	// User and Project identify the user and project on whose behalf
+	// the task is run. They are accounted against when the scheduler
+	// enforces fair share (see FairShare).
+	User, Project string
+
 	// RunID that created this task.
 	RunID taskdb.RunID
 	// FlowID is the digest (flow.Digest) of the flow for which this task was created.
@@ -98,9 +117,32 @@ type Task struct {
 	alloc *alloc
 	index int
 	stats *TaskStats
+	// inputs is the set of input files that are accounted to the
+	// task's alloc.
+	inputs []digest.Digest
+	// allocID is the ID of the alloc to which the task was most
+	// recently assigned.
+	allocID string
+	// recorded tells whether the task's record has been created in
+	// the scheduler's taskdb.
+	recorded bool
 
 	// nonDirectTransfer represents a task which cannot be executed as a direct transfer.
 	nonDirectTransfer bool
+
+	// tag is the task's virtual start time under fair share; tagged
+	// tells whether it has been assigned.
+	tag    float64
+	tagged bool
+	// preemptc is closed when the task is preempted, e.g., in favor
+	// of a higher priority task; preempted tells whether it has been,
+	// and preemptReason why.
+	preemptc      chan struct{}
+	preempted     bool
+	preemptReason string
+	// canceled tells whether the task has been canceled (see
+	// Scheduler.Cancel).
+	canceled bool
 }
 
 // NewTask returns a new, initialized task. The Task may be populated
@@ -118,6 +160,14 @@ func (t *Task) State() TaskState {
 	return t.state
 }
 
+// AllocID returns the ID of the alloc to which the task was most
+// recently assigned, or an empty string if it has not been assigned.
+func (t *Task) AllocID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.allocID
+}
+
 // Wait returns after the task's state is at least the provided state. Wait
 // returns an error if the context was canceled while waiting.
 func (t *Task) Wait(ctx context.Context, state TaskState) error {
@@ -138,6 +188,29 @@ func (t *Task) set(state TaskState) {
 	t.mu.Unlock()
 }
 
+// before tells whether the task t should be scheduled before u.
+func (t *Task) before(u *Task) bool {
+	if t.Priority != u.Priority {
+		return t.Priority < u.Priority
+	}
+	if t.tag != u.tag {
+		return t.tag < u.tag
+	}
+	return t.Config.Resources.ScaledDistance(nil) < u.Config.Resources.ScaledDistance(nil)
+}
+
+// inputFiles returns the task's input files, as given by its
+// exec config.
+func (t *Task) inputFiles() []reflow.File {
+	var files []reflow.File
+	for _, arg := range t.Config.Args {
+		if arg.Fileset != nil {
+			files = append(files, arg.Fileset.Files()...)
+		}
+	}
+	return files
+}
+
 // TaskSet is a set of tasks.
 type TaskSet map[*Task]bool
 
@@ -171,17 +244,14 @@ func (s TaskSet) Len() int {
 	return len(s)
 }
 
-// Taskq defines a priority queue of tasks, ordered by
-// scaled resource distance.
+// Taskq defines a priority queue of tasks, ordered by priority,
+// fair share tag, and then scaled resource distance.
 type taskq []*Task
 
 func (q taskq) Len() int { return len(q) }
 
 func (q taskq) Less(i, j int) bool {
-	if q[i].Priority != q[j].Priority {
-		return q[i].Priority < q[j].Priority
-	}
-	return q[i].Config.Resources.ScaledDistance(nil) < q[j].Config.Resources.ScaledDistance(nil)
+	return q[i].before(q[j])
 }
 
 func (q taskq) Swap(i, j int) {
@@ -9,7 +9,6 @@ import (
 	"context"
 	"crypto"
 	"encoding/binary"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -25,6 +24,7 @@ import (
 	"github.com/grailbio/base/digest"
 	"github.com/grailbio/base/sync/ctxsync"
 	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/sched"
@@ -165,6 +165,68 @@ func (c *testCluster) Allocate(ctx context.Context, req reflow.Requirements, lab
 	}
 }
 
+// testTaskDB is a TaskDB that records the completion of tasks. Like
+// dynamodbtask, it marks tasks completed with preemption errors as
+// preempted, and clears the errors of tasks completed successfully.
+type testTaskDB struct {
+	taskdb.TaskDB
+	mu    sync.Mutex
+	tasks map[taskdb.TaskID]taskdb.Task
+	errs  map[taskdb.TaskID]error
+}
+
+func newTestTaskDB() *testTaskDB {
+	return &testTaskDB{tasks: make(map[taskdb.TaskID]taskdb.Task), errs: make(map[taskdb.TaskID]error)}
+}
+
+func (db *testTaskDB) CreateTask(ctx context.Context, id taskdb.TaskID, runID taskdb.RunID, flowID digest.Digest, imgCmdID taskdb.ImgCmdID, ident, uri string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tasks[id] = taskdb.Task{ID: id, RunID: runID, FlowID: flowID, ImgCmdID: imgCmdID, Ident: ident, URI: uri}
+	delete(db.errs, id)
+	return nil
+}
+
+func (db *testTaskDB) SetTaskUri(ctx context.Context, id taskdb.TaskID, uri string) error {
+	return nil
+}
+
+func (db *testTaskDB) SetTaskResult(ctx context.Context, id taskdb.TaskID, result digest.Digest) error {
+	return nil
+}
+
+func (db *testTaskDB) KeepTaskAlive(ctx context.Context, id taskdb.TaskID, keepalive time.Time) error {
+	return nil
+}
+
+func (db *testTaskDB) SetTaskComplete(ctx context.Context, id taskdb.TaskID, err error, end time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	task := db.tasks[id]
+	task.End = end
+	if errors.Is(errors.Preempted, err) {
+		task.Preempted = true
+	}
+	db.tasks[id] = task
+	db.errs[id] = err
+	return nil
+}
+
+// Complete returns the tasks that have completed with errors, and
+// their errors.
+func (db *testTaskDB) Complete() (tasks []taskdb.Task, errs []error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for id, task := range db.tasks {
+		if task.End.IsZero() {
+			continue
+		}
+		tasks = append(tasks, task)
+		errs = append(errs, db.errs[id])
+	}
+	return
+}
+
 type testExec struct {
 	reflow.Exec
 	Config reflow.ExecConfig
@@ -189,6 +251,10 @@ func (e *testExec) ID() digest.Digest {
 	return e.id
 }
 
+func (e *testExec) URI() string {
+	return "test/" + e.id.Hex()
+}
+
 func (e *testExec) Result(ctx context.Context) (reflow.Result, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -240,6 +306,10 @@ type testAlloc struct {
 	execs      map[digest.Digest]*testExec
 	err        error
 	hung       bool
+	terminates time.Time
+	state      pool.AllocState
+	inspects   int
+	freed      bool
 	refCountMu sync.Mutex
 	refCount   map[digest.Digest]int64
 }
@@ -382,6 +452,18 @@ func (a *testAlloc) Put(ctx context.Context, id digest.Digest, config reflow.Exe
 	return a.execs[id], nil
 }
 
+// Remove kills and removes the exec with the provided id.
+func (a *testAlloc) Remove(ctx context.Context, id digest.Digest) error {
+	a.mu.Lock()
+	x := a.execs[id]
+	delete(a.execs, id)
+	a.mu.Unlock()
+	if x != nil {
+		x.complete(reflow.Result{}, errors.E(errors.Canceled, "exec removed"))
+	}
+	return nil
+}
+
 func (a *testAlloc) Keepalive(ctx context.Context, interval time.Duration) (time.Duration, error) {
 	a.mu.Lock()
 	hung, err := a.hung, a.err
@@ -396,6 +478,29 @@ func (a *testAlloc) Keepalive(ctx context.Context, interval time.Duration) (time
 	return 50 * time.Millisecond, err
 }
 
+func (a *testAlloc) Inspect(ctx context.Context) (pool.AllocInspect, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inspects++
+	a.cond.Broadcast()
+	return pool.AllocInspect{ID: a.ID(), Resources: a.resources, TerminationTime: a.terminates, State: a.state}, nil
+}
+
+func (a *testAlloc) SetState(ctx context.Context, state pool.AllocState) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.state = state
+	return nil
+}
+
+func (a *testAlloc) Free(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.freed = true
+	a.cond.Broadcast()
+	return nil
+}
+
 func (a *testAlloc) exec(id digest.Digest) *testExec {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -405,12 +510,52 @@ func (a *testAlloc) exec(id digest.Digest) *testExec {
 	return a.execs[id]
 }
 
+// has tells whether the alloc has an exec with the provided id.
+func (a *testAlloc) has(id digest.Digest) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.execs[id] != nil
+}
+
 func (a *testAlloc) error(err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	a.err = err
 }
 
+func (a *testAlloc) terminate(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.terminates = t
+}
+
+// setState sets the alloc's state and waits until it has been
+// inspected by the scheduler.
+func (a *testAlloc) setState(state pool.AllocState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.state = state
+	// The scheduler handles an inspection before it inspects the
+	// alloc again.
+	for n := a.inspects + 2; a.inspects < n; {
+		a.cond.Wait()
+	}
+}
+
+func (a *testAlloc) isFreed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.freed
+}
+
+func (a *testAlloc) waitFreed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for !a.freed {
+		a.cond.Wait()
+	}
+}
+
 func (a *testAlloc) hang() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
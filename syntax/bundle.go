@@ -28,6 +28,10 @@ type bundleManifest struct {
 	// of the the file's contents. The files are stored by hash directly
 	// in the zip file.
 	Files map[string]digest.Digest
+	// Images is the bundle's image lock: it maps each image
+	// referenced by the module to a digest reference, as resolved
+	// when the bundle was locked.
+	Images map[string]string `json:",omitempty"`
 }
 
 // Bundle represents a self-contained Reflow module. A bundle
@@ -65,6 +69,17 @@ func (b *Bundle) Entrypoint() (source []byte, args []string, path string, err er
 	return p, b.manifest.Args, b.manifest.EntrypointPath, nil
 }
 
+// ImageLock returns the bundle's image lock, mapping image names to
+// digest references. ImageLock returns nil if the bundle is not locked.
+func (b *Bundle) ImageLock() map[string]string {
+	return b.manifest.Images
+}
+
+// SetImageLock sets the bundle's image lock.
+func (b *Bundle) SetImageLock(lock map[string]string) {
+	b.manifest.Images = lock
+}
+
 // WriteTo writes an archive (ZIP formatted) of this bundle to the provided
 // io.Writer. Archives written by Write can be opened by OpenBundle.
 func (b *Bundle) WriteTo(w io.Writer) error {
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/grailbio/reflow/types"
@@ -134,3 +135,44 @@ func TestBundleParam(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestBundleImageLock(t *testing.T) {
+	lock := map[string]string{
+		"ubuntu": "index.docker.io/library/ubuntu@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}
+	sess := NewSession(nil)
+	if _, err := sess.Open("testdata/bundle/main.rf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sess.ImageLock(); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+	sess.SetImageLock(lock)
+	var buf bytes.Buffer
+	if err := sess.Bundle().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	sess = NewSession(memorySourcer{"foo.rfx": buf.Bytes()})
+	if _, err := sess.Open("foo.rfx"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sess.ImageLock(), lock; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The lock is preserved when the bundle is rebundled.
+	bundle := sess.Bundle()
+	if got, want := bundle.ImageLock(), lock; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Bundles imported by other modules do not lock their importers.
+	sess = NewSession(memorySourcer{
+		"main.rf": []byte(`val Main = make("./foo.rfx").Main`),
+		"foo.rfx": buf.Bytes(),
+	})
+	if _, err := sess.Open("main.rf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sess.ImageLock(); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
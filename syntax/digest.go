@@ -216,7 +216,7 @@ func (e *Expr) digest(w io.Writer, env *values.Env) {
 			// we digest the second argument before the first.
 			e.Fields[1].Expr.digest(w, env)
 			e.Fields[0].Expr.digest(w, env)
-		case "reduce":
+		case "reduce", "treereduce":
 			e.Fields[0].Expr.digest(w, env)
 			e.Fields[1].Expr.digest(w, env)
 		case "fold":
@@ -54,6 +54,15 @@ expressions, d1, d2, .. are declarations; t1, t2, .. are types):
 	                                   // deparsed as id := id.
 	                                   // takes an optional declaration nondeterministic bool, which tags
 	                                   // this exec as being non-deterministic.
+	                                   // takes optional declarations affinity string and antiaffinity
+	                                   // string, which name the exec's scheduling affinity groups: execs
+	                                   // in the same affinity group are preferably run on the same alloc;
+	                                   // execs in the same anti-affinity group never share an alloc.
+	                                   // takes an optional declaration lazy bool, which passes input
+	                                   // files and dirs that are (unloaded) references by their source
+	                                   // URLs, e.g., s3://bucket/key, instead of downloading them, so
+	                                   // that the exec reads only what it needs; such execs usually need
+	                                   // AWS credentials (the image's $aws qualifier).
 	e1 <op> e2                         // a binary op (||, &&, <, >, <=, >=, !=, ==, +, /, %, &, <<, >>)
 	<op> e1                            // unary expression (!)
 	if e1 { d1; d2; ..; e2 }
@@ -76,6 +85,10 @@ expressions, d1, d2, .. are declarations; t1, t2, .. are types):
 	trace(e1)                          // trace expression e1: evaluate it, print it to console,
 	                                   // and return it. Can be used for debugging.
 	range(e1, e2)                      // produce a list of integers with the range of the two expressions.
+	reduce(e1, e2)                     // reduce the non-empty list e2 with the function e1, left to right
+	fold(e1, e2, e3)                   // left fold the list e2 with the function e1, starting with e3
+	treereduce(e1, e2)                 // reduce the non-empty list (or dir) e2 with the associative function
+	                                   // e1, in a tree of chunks; changes to e2 reuse the unchanged chunks.
 
 A comprehension clause is one of the following:
 
@@ -96,9 +109,10 @@ annotations, each of which takes a list of declarations.
 	@requires(...)                     // resource requirement annotation,
 	                                   // takes declarations mem int,
 	                                   // cpu int or cpu float, disk int,
-	                                   // cpufeatures[string, and wide
-	                                   // bool. They indicate resource
-	                                   // requirements for computing the
+	                                   // gpu int, cpufeatures [string],
+	                                   // and wide bool. They indicate
+	                                   // resource requirements for
+	                                   // computing the
 	                                   // declaration; if wide is set to
 	                                   // true, then the resource
 	                                   // requirements have no
@@ -381,7 +381,7 @@ func (e *Expr) eval(sess *Session, env *values.Env, ident string) (val values.T,
 			for i := len(e.Decls); i < len(vs); i++ {
 				args[argIndex[i]] = vs[i]
 			}
-			return e.exec(sess, env, image, ident, args, makeResources(penv))
+			return e.exec(sess, env, image, ident, args, penv)
 		}, tvals...)
 		kf := k.(*flow.Flow)
 
@@ -603,6 +603,26 @@ func (e *Expr) eval(sess *Session, env *values.Env, ident string) (val values.T,
 				}
 				return args[0], nil
 			}, e.Fields[0].Expr, e.Fields[1].Expr)
+		case "treereduce":
+			return e.k(sess, env, ident, func(vs []values.T) (values.T, error) {
+				var (
+					l    values.List
+					elem = e.Fields[1].Expr.Type.Elem
+				)
+				switch v := vs[1].(type) {
+				case values.List:
+					l = v
+				case values.Dir:
+					elem = types.File
+					for scan := v.Scan(); scan.Scan(); {
+						l = append(l, scan.File())
+					}
+				}
+				if len(l) == 0 {
+					return nil, fmt.Errorf("%v: cannot reduce empty list", e.Position)
+				}
+				return treeReduce(values.Location{Position: e.Position.String()}, vs[0].(values.Func), l, elem, e.Fields[0].Expr.Type.Elem)
+			}, e.Fields[0].Expr, e.Fields[1].Expr)
 		case "fold":
 			return e.k(sess, env, ident, func(vs []values.T) (values.T, error) {
 				fn := vs[0].(values.Func)
@@ -711,7 +731,8 @@ func (e *Expr) eval(sess *Session, env *values.Env, ident string) (val values.T,
 
 // Exec returns a Flow value for an exec expression. The resolved
 // image and resources are passed by the caller.
-func (e *Expr) exec(sess *Session, env *values.Env, image string, ident string, args map[int]values.T, resources reflow.Resources) (values.T, error) {
+func (e *Expr) exec(sess *Session, env *values.Env, image string, ident string, args map[int]values.T, params *values.Env) (values.T, error) {
+	resources := makeResources(params)
 	// Execs are special. The interpolation environment also has the
 	// output ids.
 	narg := len(e.Template.Args)
@@ -842,6 +863,9 @@ func (e *Expr) exec(sess *Session, env *values.Env, image string, ident string,
 			Argstrs:          argstrs,
 			OutputIsDir:      dirs,
 			NonDeterministic: e.NonDeterministic,
+			Affinity:         stringParam(params, "affinity"),
+			AntiAffinity:     stringParam(params, "antiaffinity"),
+			LazyInputs:       boolParam(params, "lazy"),
 		}},
 
 		Op:         flow.Coerce,
@@ -1577,10 +1601,31 @@ var stdEvalK evalK = func(e *Expr, env *values.Env, dw io.Writer) {
 	}
 }
 
+// stringParam returns the value of the string parameter id in env,
+// or the empty string if it is not defined.
+func stringParam(env *values.Env, id string) string {
+	v := env.Value(id)
+	if v == nil {
+		return ""
+	}
+	return v.(string)
+}
+
+// boolParam returns the value of the boolean parameter id in env,
+// or false if it is not defined.
+func boolParam(env *values.Env, id string) bool {
+	v := env.Value(id)
+	if v == nil {
+		return false
+	}
+	return v.(bool)
+}
+
 // makeResources constructs a resource specification
-// from a value environment, where "mem", "cpu", and
-// "disk" are integers; "cpufeatures" is a list of strings.
-// Missing values are taken to be the zero value.
+// from a value environment, where "mem", "cpu", "disk",
+// and "gpu" are integers; "cpufeatures" is a list of strings.
+// Missing values are taken to be the zero value; "gpu" is
+// included only when it is nonzero.
 func makeResources(env *values.Env) reflow.Resources {
 	f64 := func(id string) float64 {
 		v := env.Value(id)
@@ -1602,6 +1647,9 @@ func makeResources(env *values.Env) reflow.Resources {
 		"cpu":  f64("cpu"),
 		"disk": f64("disk"),
 	}
+	if gpu := f64("gpu"); gpu > 0 {
+		resources["gpu"] = gpu
+	}
 	v := env.Value("cpufeatures")
 	if v == nil {
 		return resources
@@ -84,6 +84,8 @@ func TestEvalSimple(t *testing.T) {
 			&values.Variant{Tag: "Foo", Elem: big.NewInt(3)},
 		},
 		{`switch 123 { case i: i + 333 }`, types.Int, values.NewInt(456)},
+		{`treereduce(func(x, y string) => x+y, ["a", "b", "c", "d", "e", "f", "g"])`, types.String, "abcdefg"},
+		{`treereduce(func(i, j int) => i+j, range(0, 100))`, types.Int, values.NewInt(4950)},
 	} {
 		v, typ, _, err := eval(c.e)
 		if err != nil {
@@ -150,6 +152,52 @@ func TestFileCompare(t *testing.T) {
 	}
 }
 
+func TestExecAffinity(t *testing.T) {
+	v, _, _, err := eval(`
+		exec(image := "ubuntu", affinity := "ref-" + "hg38", antiaffinity := "io") (out file) {"
+			echo > {{out}}
+		"}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := v.(*flow.Flow)
+	if got, want := f.Op, flow.Coerce; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	f = f.Deps[0]
+	if got, want := f.Op, flow.Exec; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := f.Affinity, "ref-hg38"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f.AntiAffinity, "io"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExecLazy(t *testing.T) {
+	v, _, _, err := eval(`
+		exec(image := "ubuntu", lazy := true) (out file) {"
+			echo > {{out}}
+		"}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := v.(*flow.Flow).Deps[0]
+	if got, want := f.Op, flow.Exec; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !f.LazyInputs {
+		t.Error("exec inputs are not lazy")
+	}
+	if !f.ExecConfig().LazyInputs {
+		t.Error("exec config inputs are not lazy")
+	}
+}
+
 func TestExec(t *testing.T) {
 	v, typ, sess, err := eval(`
 		exec(image := "ubuntu", mem := 32*GiB, cpu := 32) (out file) {"
@@ -581,6 +629,7 @@ func TestTypeErr(t *testing.T) {
 		{"testdata/typerr17.rf", `testdata/typerr17.rf:2:14: fold expects a list as its second argument, got {a int}`},
 		{"testdata/typerr18.rf", `testdata/typerr18.rf:2:14: fold expects first argument of type func\({a int}, {a int}\) {a int}, got func\(i, j {a, b int}\) {a, b int}`},
 		{"testdata/typerr19.rf", `testdata/typerr19.rf:2:7: nondeterministic must be a bool`},
+		{"testdata/typerr20.rf", `testdata/typerr20.rf:2:7: antiaffinity must be a string`},
 	} {
 		_, terr := sess.Open(c.file)
 		if terr == nil {
@@ -18,18 +18,19 @@ import (
 
 func init() {
 	builtins = map[string]bool{
-		"delay":   true,
-		"fold":    true,
-		"flatten": true,
-		"len":     true,
-		"list":    true,
-		"map":     true,
-		"panic":   true,
-		"range":   true,
-		"reduce":  true,
-		"trace":   true,
-		"unzip":   true,
-		"zip":     true,
+		"delay":      true,
+		"fold":       true,
+		"flatten":    true,
+		"len":        true,
+		"list":       true,
+		"map":        true,
+		"panic":      true,
+		"range":      true,
+		"reduce":     true,
+		"trace":      true,
+		"treereduce": true,
+		"unzip":      true,
+		"zip":        true,
 	}
 }
 
@@ -606,7 +607,7 @@ func (e *Expr) init(sess *Session, env *types.Env) {
 					e.Type = types.Errorf("%s must be integer or floating point", ident)
 					return
 				}
-			case "mem", "disk":
+			case "mem", "disk", "gpu":
 				if d.Type.Kind != types.IntKind {
 					e.Type = types.Errorf("%s must be an integer", ident)
 					return
@@ -616,11 +617,16 @@ func (e *Expr) init(sess *Session, env *types.Env) {
 					e.Type = types.Errorf("%s must be a list of strings", ident)
 					return
 				}
-			case "nondeterministic":
+			case "nondeterministic", "lazy":
 				if d.Type.Kind != types.BoolKind {
 					e.Type = types.Errorf("%s must be a bool", ident)
 					return
 				}
+			case "affinity", "antiaffinity":
+				if d.Type.Kind != types.StringKind {
+					e.Type = types.Errorf("%s must be a string", ident)
+					return
+				}
 			default:
 				e.Type = types.Errorf("unrecognized exec parameter %s", ident)
 				return
@@ -929,30 +935,39 @@ func (e *Expr) init(sess *Session, env *types.Env) {
 				e.Type.Level = types.CanConst
 				e.Type = types.Swizzle(e.Type, types.Const, arg0.Type, arg1.Type)
 			}
-		case "reduce":
+		case "reduce", "treereduce":
 			if len(e.Fields) != 2 {
-				e.Type = types.Errorf("reduce expects two arguments, got %v", len(e.Fields))
+				e.Type = types.Errorf("%s expects two arguments, got %v", e.Op, len(e.Fields))
 				return
 			}
 			if e.Fields[0].Expr.Type.Kind != types.FuncKind {
-				e.Type = types.Errorf("reduce expects a function as its first argument, got %v", e.Fields[0].Expr.Type)
+				e.Type = types.Errorf("%s expects a function as its first argument, got %v", e.Op, e.Fields[0].Expr.Type)
 				return
 			}
-			if e.Fields[1].Expr.Type.Kind != types.ListKind {
+			var elemType *types.T
+			switch kind := e.Fields[1].Expr.Type.Kind; {
+			case kind == types.ListKind:
+				elemType = e.Fields[1].Expr.Type.Elem
+			case kind == types.DirKind && e.Op == "treereduce":
+				// Directories are reduced over their files, in path order.
+				elemType = types.File
+			case e.Op == "treereduce":
+				e.Type = types.Errorf("treereduce expects a list or dir as its second argument, got %v", e.Fields[1].Expr.Type)
+				return
+			default:
 				e.Type = types.Errorf("reduce expects a list as its second argument, got %v", e.Fields[1].Expr.Type)
 				return
 			}
-			elemType := e.Fields[1].Expr.Type.Elem
 			reduceType := e.Fields[0].Expr.Type.Elem
 			if !elemType.Sub(reduceType) {
 				fType := types.Func(elemType, &types.Field{T: elemType}, &types.Field{T: elemType})
-				e.Type = types.Errorf("reduce expects first argument of type %v, got %v", fType, e.Fields[0].Expr.Type)
+				e.Type = types.Errorf("%s expects first argument of type %v, got %v", e.Op, fType, e.Fields[0].Expr.Type)
 				return
 			}
 			// Allow function subtyping.
 			fType := types.Func(reduceType, &types.Field{T: reduceType}, &types.Field{T: reduceType})
 			if !e.Fields[0].Expr.Type.Sub(fType) {
-				e.Type = types.Errorf("reduce expects first argument of type %v, got %v", fType, e.Fields[0].Expr.Type)
+				e.Type = types.Errorf("%s expects first argument of type %v, got %v", e.Op, fType, e.Fields[0].Expr.Type)
 				return
 			}
 			e.Type = reduceType
@@ -1020,7 +1035,7 @@ func (e *Expr) initResources(sess *Session, env *types.Env) error {
 			default:
 				return fmt.Errorf("%s must be integer or floating point", ident)
 			}
-		case "mem", "disk":
+		case "mem", "disk", "gpu":
 			if d.Type.Kind != types.IntKind {
 				return fmt.Errorf("%s must be an integer", ident)
 			}
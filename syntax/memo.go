@@ -0,0 +1,538 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package syntax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+)
+
+// memoDigest is mixed into the keys of memoized values; it should be
+// changed whenever their encoding changes.
+var memoDigest = reflow.Digester.FromString("grail.com/reflow/syntax.Memo.v1")
+
+// Memo memoizes the values of immediate (non-flow) module-level
+// declarations, so that expensive pure computations, e.g., building
+// large maps of sample records, need not be recomputed every time a
+// module is instantiated. Values are keyed by the digest of the
+// declaration's expression in its environment (see Expr.Digest):
+// their encodings are stored in the repository, and mapped by the
+// assoc with kind assoc.Eval.
+//
+// Only declarations whose types are data (i.e., not functions,
+// modules, or filesets, and not derived from flows), and whose values
+// contain no (unforced) flows, are memoized.
+// Declarations that instantiate modules directly are not memoized,
+// since their digests do not reflect the modules' contents. Errors in
+// memoization are logged, and cause the declaration to be evaluated
+// as usual.
+type Memo struct {
+	// Assoc maps expression digests to encoded values.
+	Assoc assoc.Assoc
+	// Repository stores encoded values.
+	Repository reflow.Repository
+	// Log is used to report memoization errors.
+	Log *log.Logger
+
+	mu                    sync.Mutex
+	hits, misses, skipped int
+}
+
+// String returns a summary of the memo's activity.
+func (m *Memo) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("memo: %d hits, %d misses, %d not memoizable", m.hits, m.misses, m.skipped)
+}
+
+// evalDecl evaluates the module-level declaration d in environment
+// env, memoizing its value if the session has a Memo.
+func (s *Session) evalDecl(d *Decl, env *values.Env) (values.T, error) {
+	if s == nil || s.Memo == nil {
+		return d.Expr.eval(s, env, d.ID(""))
+	}
+	return s.Memo.eval(s, d.Expr, env, d.ID(""))
+}
+
+// eval evaluates expression e in environment env, returning its
+// memoized value, if any. Otherwise the value is memoized after
+// evaluation.
+func (m *Memo) eval(sess *Session, e *Expr, env *values.Env, ident string) (values.T, error) {
+	switch e.Kind {
+	case ExprLit, ExprIdent, ExprFunc:
+		// These are cheaper to evaluate than to look up.
+		return e.eval(sess, env, ident)
+	}
+	if !memoizableType(e.Type) || !memoizableExpr(e) {
+		m.count(&m.skipped)
+		return e.eval(sess, env, ident)
+	}
+	ctx := context.Background()
+	key := memoDigest
+	key.Mix(reflow.Digester.FromString(e.Type.String()))
+	key.Mix(e.Digest(env))
+	if _, d, err := m.Assoc.Get(ctx, assoc.Eval, key); err == nil {
+		v, err := m.get(ctx, d, e.Type)
+		if err == nil {
+			m.count(&m.hits)
+			return v, nil
+		}
+		m.Log.Debugf("memo %s: %v", ident, err)
+	} else if !errors.Is(errors.NotExist, err) {
+		m.Log.Debugf("memo %s: %v", ident, err)
+	}
+	v, err := e.eval(sess, env, ident)
+	if err != nil {
+		return nil, err
+	}
+	// Types do not always tell whether values contain flows: e.g.,
+	// a declared type [file] admits a list of unforced files.
+	if containsFlow(v) {
+		m.count(&m.skipped)
+		return v, nil
+	}
+	m.count(&m.misses)
+	if err := m.put(ctx, key, v, e.Type); err != nil {
+		m.Log.Debugf("memo %s: %v", ident, err)
+	}
+	return v, nil
+}
+
+func (m *Memo) count(n *int) {
+	m.mu.Lock()
+	*n++
+	m.mu.Unlock()
+}
+
+// get retrieves and decodes the value of type t stored in the
+// repository object d.
+func (m *Memo) get(ctx context.Context, d digest.Digest, t *types.T) (values.T, error) {
+	rc, err := m.Repository.Get(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	p, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(p, t)
+}
+
+// put encodes and stores the value v of type t, and maps the key to
+// it.
+func (m *Memo) put(ctx context.Context, key digest.Digest, v values.T, t *types.T) error {
+	enc, err := encodeValue(v, t)
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(enc)
+	if err != nil {
+		return err
+	}
+	d, err := m.Repository.Put(ctx, bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	return m.Assoc.Store(ctx, assoc.Eval, key, d)
+}
+
+// memoizableType tells whether values of type t can be memoized:
+// that is, whether they are immediate data values. Values of
+// memoizable types may nevertheless contain flows, which are
+// detected by containsFlow once the values are computed.
+func memoizableType(t *types.T) bool {
+	if t == nil || t.Flow {
+		return false
+	}
+	switch t.Kind {
+	case types.IntKind, types.FloatKind, types.StringKind, types.BoolKind,
+		types.FileKind, types.DirKind, types.UnitKind:
+		return true
+	case types.ListKind:
+		return memoizableType(t.Elem)
+	case types.MapKind:
+		return memoizableType(t.Index) && memoizableType(t.Elem)
+	case types.TupleKind, types.StructKind:
+		for _, f := range t.Fields {
+			if !memoizableType(f.T) {
+				return false
+			}
+		}
+		return true
+	case types.SumKind:
+		for _, v := range t.Variants {
+			if v.Elem != nil && !memoizableType(v.Elem) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// memoizableExpr tells whether expression e can be memoized. An
+// expression cannot be memoized if it instantiates a module, since
+// its digest includes only the module's path, or if it traces
+// values, which are then not printed on memo hits.
+func memoizableExpr(e *Expr) bool {
+	if e == nil {
+		return true
+	}
+	switch e.Kind {
+	case ExprMake:
+		return false
+	case ExprBuiltin:
+		if e.Op == "trace" {
+			return false
+		}
+	}
+	exprs := []*Expr{e.Cond, e.Left, e.Right, e.ComprExpr}
+	exprs = append(exprs, e.List...)
+	for k, v := range e.Map {
+		exprs = append(exprs, k, v)
+	}
+	for _, f := range e.Fields {
+		exprs = append(exprs, f.Expr)
+	}
+	for _, d := range e.Decls {
+		exprs = append(exprs, d.Expr)
+	}
+	for _, c := range e.CaseClauses {
+		exprs = append(exprs, c.Expr)
+	}
+	for _, c := range e.ComprClauses {
+		exprs = append(exprs, c.Expr)
+	}
+	for _, x := range exprs {
+		if !memoizableExpr(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFlow tells whether the value v is, or contains, a flow.
+func containsFlow(v values.T) bool {
+	switch v := v.(type) {
+	case *flow.Flow:
+		return true
+	case values.List:
+		for _, e := range v {
+			if containsFlow(e) {
+				return true
+			}
+		}
+	case *values.Map:
+		var ok bool
+		v.Each(func(k, e values.T) {
+			ok = ok || containsFlow(k) || containsFlow(e)
+		})
+		return ok
+	case values.Tuple:
+		for _, e := range v {
+			if containsFlow(e) {
+				return true
+			}
+		}
+	case values.Struct:
+		for _, e := range v {
+			if containsFlow(e) {
+				return true
+			}
+		}
+	case *values.Variant:
+		return v.Elem != nil && containsFlow(v.Elem)
+	}
+	return false
+}
+
+// notImmediate returns an error reporting that the value v cannot be
+// encoded as a value of type t.
+func notImmediate(v values.T, t *types.T) error {
+	return errors.Errorf("value of type %T is not an immediate %s", v, t)
+}
+
+// memoVariant is the encoding of a variant value.
+type memoVariant struct {
+	Tag  string
+	Elem json.RawMessage `json:",omitempty"`
+}
+
+// encodeValue returns a JSON-encodable representation of the value
+// v of type t. Integers and floats are encoded exactly.
+func encodeValue(v values.T, t *types.T) (interface{}, error) {
+	switch t.Kind {
+	case types.IntKind:
+		i, ok := v.(*big.Int)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		return i.String(), nil
+	case types.FloatKind:
+		f, ok := v.(*big.Float)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		return f.GobEncode()
+	case types.StringKind:
+		if _, ok := v.(string); !ok {
+			return nil, notImmediate(v, t)
+		}
+		return v, nil
+	case types.BoolKind:
+		if _, ok := v.(bool); !ok {
+			return nil, notImmediate(v, t)
+		}
+		return v, nil
+	case types.UnitKind:
+		return nil, nil
+	case types.FileKind:
+		f, ok := v.(reflow.File)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		return f, nil
+	case types.DirKind:
+		d, ok := v.(values.Dir)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		dir := make(map[string]reflow.File)
+		for scan := d.Scan(); scan.Scan(); {
+			dir[scan.Path()] = scan.File()
+		}
+		return dir, nil
+	case types.ListKind:
+		list, ok := v.(values.List)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		enc := make([]interface{}, len(list))
+		for i := range list {
+			var err error
+			if enc[i], err = encodeValue(list[i], t.Elem); err != nil {
+				return nil, err
+			}
+		}
+		return enc, nil
+	case types.MapKind:
+		m, ok := v.(*values.Map)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		var (
+			enc [][2]interface{}
+			err error
+		)
+		m.Each(func(k, v values.T) {
+			var kv [2]interface{}
+			if err != nil {
+				return
+			}
+			if kv[0], err = encodeValue(k, t.Index); err != nil {
+				return
+			}
+			if kv[1], err = encodeValue(v, t.Elem); err != nil {
+				return
+			}
+			enc = append(enc, kv)
+		})
+		return enc, err
+	case types.TupleKind:
+		tuple, ok := v.(values.Tuple)
+		if !ok || len(tuple) != len(t.Fields) {
+			return nil, notImmediate(v, t)
+		}
+		enc := make([]interface{}, len(t.Fields))
+		for i, f := range t.Fields {
+			var err error
+			if enc[i], err = encodeValue(tuple[i], f.T); err != nil {
+				return nil, err
+			}
+		}
+		return enc, nil
+	case types.StructKind:
+		s, ok := v.(values.Struct)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		enc := make(map[string]interface{})
+		for _, f := range t.Fields {
+			var err error
+			if enc[f.Name], err = encodeValue(s[f.Name], f.T); err != nil {
+				return nil, err
+			}
+		}
+		return enc, nil
+	case types.SumKind:
+		variant, ok := v.(*values.Variant)
+		if !ok {
+			return nil, notImmediate(v, t)
+		}
+		enc := memoVariant{Tag: variant.Tag}
+		if elem := t.VariantMap()[variant.Tag]; elem != nil {
+			e, err := encodeValue(variant.Elem, elem)
+			if err != nil {
+				return nil, err
+			}
+			if enc.Elem, err = json.Marshal(e); err != nil {
+				return nil, err
+			}
+		}
+		return enc, nil
+	default:
+		return nil, errors.Errorf("values of type %s cannot be memoized", t)
+	}
+}
+
+// decodeValue decodes a value of type t from its JSON encoding, as
+// produced by encodeValue.
+func decodeValue(p []byte, t *types.T) (values.T, error) {
+	switch t.Kind {
+	case types.IntKind:
+		var s string
+		if err := json.Unmarshal(p, &s); err != nil {
+			return nil, err
+		}
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, errors.Errorf("invalid integer %q", s)
+		}
+		return i, nil
+	case types.FloatKind:
+		var b []byte
+		if err := json.Unmarshal(p, &b); err != nil {
+			return nil, err
+		}
+		f := new(big.Float)
+		if err := f.GobDecode(b); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case types.StringKind:
+		var s string
+		err := json.Unmarshal(p, &s)
+		return s, err
+	case types.BoolKind:
+		var b bool
+		err := json.Unmarshal(p, &b)
+		return b, err
+	case types.UnitKind:
+		return values.Unit, nil
+	case types.FileKind:
+		var f reflow.File
+		err := json.Unmarshal(p, &f)
+		return f, err
+	case types.DirKind:
+		var files map[string]reflow.File
+		if err := json.Unmarshal(p, &files); err != nil {
+			return nil, err
+		}
+		var dir values.Dir
+		for path, file := range files {
+			dir.Set(path, file)
+		}
+		return dir, nil
+	case types.ListKind:
+		var raw []json.RawMessage
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, err
+		}
+		list := make(values.List, len(raw))
+		for i := range raw {
+			var err error
+			if list[i], err = decodeValue(raw[i], t.Elem); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case types.MapKind:
+		var raw [][2]json.RawMessage
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, err
+		}
+		m := new(values.Map)
+		for _, kv := range raw {
+			k, err := decodeValue(kv[0], t.Index)
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(kv[1], t.Elem)
+			if err != nil {
+				return nil, err
+			}
+			m.Insert(values.Digest(k, t.Index), k, v)
+		}
+		return m, nil
+	case types.TupleKind:
+		var raw []json.RawMessage
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, err
+		}
+		if len(raw) != len(t.Fields) {
+			return nil, errors.Errorf("expected %d tuple fields, got %d", len(t.Fields), len(raw))
+		}
+		tuple := make(values.Tuple, len(raw))
+		for i, f := range t.Fields {
+			var err error
+			if tuple[i], err = decodeValue(raw[i], f.T); err != nil {
+				return nil, err
+			}
+		}
+		return tuple, nil
+	case types.StructKind:
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, err
+		}
+		s := make(values.Struct)
+		for _, f := range t.Fields {
+			fp, ok := raw[f.Name]
+			if !ok {
+				return nil, errors.Errorf("missing struct field %s", f.Name)
+			}
+			var err error
+			if s[f.Name], err = decodeValue(fp, f.T); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case types.SumKind:
+		var raw memoVariant
+		if err := json.Unmarshal(p, &raw); err != nil {
+			return nil, err
+		}
+		elem, ok := t.VariantMap()[raw.Tag]
+		if !ok {
+			return nil, errors.Errorf("unknown variant #%s", raw.Tag)
+		}
+		variant := &values.Variant{Tag: raw.Tag}
+		if elem != nil {
+			var err error
+			if variant.Elem, err = decodeValue(raw.Elem, elem); err != nil {
+				return nil, err
+			}
+		}
+		return variant, nil
+	default:
+		return nil, errors.Errorf("values of type %s cannot be memoized", t)
+	}
+}
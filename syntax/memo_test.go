@@ -0,0 +1,127 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package syntax
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/reflow/assoc/fileassoc"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/repository/filerepo"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+)
+
+func makeMemoModule(t *testing.T, memo *Memo, n int64) values.Module {
+	t.Helper()
+	return makeMemoModulePath(t, memo, "testdata/memo.rf", n)
+}
+
+func makeMemoModulePath(t *testing.T, memo *Memo, path string, n int64) values.Module {
+	t.Helper()
+	sess := NewSession(nil)
+	sess.Memo = memo
+	sess.Stderr = ioutil.Discard
+	m, err := sess.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := sess.Values.Push()
+	env.Bind("n", values.NewInt(n))
+	v, err := m.Make(sess, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(values.Module)
+}
+
+func TestMemo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ass := &fileassoc.Assoc{Dir: filepath.Join(dir, "assoc")}
+	if err := ass.Init(); err != nil {
+		t.Fatal(err)
+	}
+	repo := &filerepo.Repository{Root: filepath.Join(dir, "repo")}
+
+	want := makeMemoModule(t, nil, 10)
+	memo := &Memo{Assoc: ass, Repository: repo}
+	if got := makeMemoModule(t, memo, 10); !values.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// nums, squares, byName, total, ratio, record, and Main are
+	// memoized; sampled is traced, and strings is a module.
+	if got, want := memo.misses, 7; got != want {
+		t.Errorf("got %v misses, want %v", got, want)
+	}
+	if got, want := memo.hits, 0; got != want {
+		t.Errorf("got %v hits, want %v", got, want)
+	}
+
+	memo = &Memo{Assoc: ass, Repository: repo}
+	if got := makeMemoModule(t, memo, 10); !values.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := memo.hits, 7; got != want {
+		t.Errorf("got %v hits, want %v", got, want)
+	}
+	if got, want := memo.misses, 0; got != want {
+		t.Errorf("got %v misses, want %v", got, want)
+	}
+
+	// Changing a parameter invalidates the declarations that depend
+	// on it; here, all but ratio.
+	memo = &Memo{Assoc: ass, Repository: repo}
+	want = makeMemoModule(t, nil, 5)
+	if got := makeMemoModule(t, memo, 5); !values.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := memo.hits, 1; got != want {
+		t.Errorf("got %v hits, want %v", got, want)
+	}
+}
+
+func TestMemoFlows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ass := &fileassoc.Assoc{Dir: filepath.Join(dir, "assoc")}
+	if err := ass.Init(); err != nil {
+		t.Fatal(err)
+	}
+	repo := &filerepo.Repository{Root: filepath.Join(dir, "repo")}
+
+	// files is a list of (unforced) files, execs a struct of exec
+	// results, and Main a struct of both: none are immediate, and so
+	// none are memoized, nor are the exec results themselves.
+	memo := &Memo{Assoc: ass, Repository: repo}
+	m := makeMemoModulePath(t, memo, "testdata/memoflow.rf", 0)
+	if got, want := memo.skipped, 5; got != want {
+		t.Errorf("got %v skipped, want %v", got, want)
+	}
+	if got, want := memo.misses, 0; got != want {
+		t.Errorf("got %v misses, want %v", got, want)
+	}
+	files := m["Main"].(values.Struct)["files"].(values.List)
+	if _, ok := files[0].(*flow.Flow); !ok {
+		t.Errorf("expected files to contain flows, got %v", files)
+	}
+	if _, err := encodeValue(files, types.List(types.File)); err == nil {
+		t.Error("expected error encoding flows")
+	}
+	memo = &Memo{Assoc: ass, Repository: repo}
+	makeMemoModulePath(t, memo, "testdata/memoflow.rf", 0)
+	if got, want := memo.hits, 0; got != want {
+		t.Errorf("got %v hits, want %v", got, want)
+	}
+}
@@ -560,7 +560,7 @@ func (m *ModuleImpl) Make(sess *Session, params *values.Env) (values.T, error) {
 		if d.Kind == DeclType {
 			continue
 		}
-		v, err := d.Expr.eval(sess, env, d.ID(""))
+		v, err := sess.evalDecl(d, env)
 		if err != nil {
 			return nil, err
 		}
@@ -65,6 +65,9 @@ func TestRequirements(t *testing.T) {
 			"disk": float64(val["disk"].(*big.Int).Uint64()),
 		}
 		expect.Min["cpu"], _ = val["cpu"].(*big.Float).Float64()
+		if gpu, ok := val["gpu"]; ok {
+			expect.Min["gpu"] = float64(gpu.(*big.Int).Uint64())
+		}
 		for _, feature := range val["cpufeatures"].(values.List) {
 			expect.Min[feature.(string)] = expect.Min["cpu"]
 		}
@@ -51,6 +51,13 @@ type Session struct {
 	// images is a collection of Docker image names from exec expressions.
 	// It's populated during expression evaluation. Values are all true.
 	images map[string]bool
+
+	// imageLock is the image lock of the entrypoint bundle, if any.
+	imageLock map[string]string
+
+	// Memo, if not nil, memoizes the values of the immediate
+	// module-level declarations evaluated in this session.
+	Memo *Memo
 }
 
 // NewSession creates and initializes a session, reading
@@ -94,6 +101,7 @@ func (s *Session) Open(path string) (Module, error) {
 		mod              Module
 		modulePath       = filepath.Dir(path)
 		assignEntrypoint = s.entrypoint == nil
+		imageLock        map[string]string
 	)
 	switch ext := filepath.Ext(path); ext {
 	default:
@@ -157,6 +165,7 @@ func (s *Session) Open(path string) (Module, error) {
 		lx.Module.source = source
 		s.modules[path] = lx.Module
 		mod = lx.Module
+		imageLock = bundle.ImageLock()
 	case ".reflow": // Reflow "v0" script.
 		prog := &lang.Program{
 			File: path,
@@ -197,6 +206,7 @@ func (s *Session) Open(path string) (Module, error) {
 	if assignEntrypoint {
 		s.entrypoint = mod
 		s.entrypointPath = modulePath
+		s.imageLock = imageLock
 	}
 	return mod, nil
 }
@@ -221,9 +231,27 @@ func (s *Session) Bundle() *Bundle {
 		}
 	}
 	bundle.manifest.EntrypointPath = s.entrypointPath
+	bundle.manifest.Images = s.imageLock
 	return bundle
 }
 
+// ImageLock returns the session's image lock: the lock embedded in the
+// entrypoint bundle, or the one set by SetImageLock. ImageLock returns
+// nil if the session's entrypoint is not locked.
+func (s *Session) ImageLock() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.imageLock
+}
+
+// SetImageLock sets the session's image lock, which is embedded
+// in bundles created by the session.
+func (s *Session) SetImageLock(lock map[string]string) {
+	s.mu.Lock()
+	s.imageLock = lock
+	s.mu.Unlock()
+}
+
 // SeeImage records an image name. Call during expression evaluation.
 func (s *Session) SeeImage(image string) {
 	s.mu.Lock()
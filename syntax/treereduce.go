@@ -0,0 +1,83 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package syntax
+
+import (
+	"encoding/binary"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+)
+
+// treeFanout is the expected number of items combined by each node
+// of a reduction tree.
+const treeFanout = 4
+
+var treeLevelDigest = reflow.Digester.FromString("grail.com/reflow/syntax.treeReduce")
+
+// treeReduce reduces the list of values l, of type elem, with the
+// associative function fn. Unlike reduce, which combines the list's
+// elements from left to right, treeReduce combines them in a tree
+// whose shape is determined by the elements' digests: each level of
+// the tree is split into chunks at items whose digests (salted by
+// the level) are multiples of treeFanout, and each chunk is combined
+// into a single item of the next level.
+//
+// Since chunk boundaries depend only on the items near them, adding,
+// removing, or changing an element of the list changes only the
+// chunks that contain it, one at each level, and thus only O(log n)
+// combinations: the digests of the others, and thus their cached
+// results, are unchanged. The function's results are of type acc.
+func treeReduce(loc values.Location, fn values.Func, l values.List, elem, acc *types.T) (values.T, error) {
+	for level, t := 0, elem; len(l) > 1; level, t = level+1, acc {
+		var next, chunk values.List
+		for i, v := range l {
+			chunk = append(chunk, v)
+			// Chunks have at least two items, so that each level is at
+			// most half the size of the one below it; the last chunk
+			// also takes any single item left after it.
+			if rest := len(l) - i - 1; rest != 0 && (len(chunk) < 2 || rest < 2 || !treeBoundary(v, t, level)) {
+				continue
+			}
+			v, err := treeCombine(loc, fn, chunk)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, v)
+			chunk = nil
+		}
+		l = next
+	}
+	return l[0], nil
+}
+
+// treeBoundary tells whether the item v, of type t, ends a chunk at
+// the provided level of a reduction tree.
+func treeBoundary(v values.T, t *types.T, level int) bool {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(level))
+	w := reflow.Digester.NewWriter()
+	digest.WriteDigest(w, treeLevelDigest)
+	w.Write(b[:])
+	digest.WriteDigest(w, values.Digest(v, t))
+	// The digest's encoding is prefixed by its hash function.
+	p := w.Digest().Bytes()
+	return binary.LittleEndian.Uint64(p[len(p)-8:])%treeFanout == 0
+}
+
+// treeCombine combines the items of a chunk from left to right.
+func treeCombine(loc values.Location, fn values.Func, chunk values.List) (values.T, error) {
+	args := []values.T{chunk[0], nil}
+	for _, v := range chunk[1:] {
+		args[1] = v
+		var err error
+		if args[0], err = fn.Apply(loc, args); err != nil {
+			return nil, err
+		}
+	}
+	return args[0], nil
+}
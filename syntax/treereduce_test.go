@@ -0,0 +1,78 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package syntax
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/types"
+	"github.com/grailbio/reflow/values"
+)
+
+// concat is an associative function that concatenates strings and
+// records the digests of its applications.
+type concat map[digest.Digest]bool
+
+func (c concat) Apply(loc values.Location, args []values.T) (values.T, error) {
+	v := args[0].(string) + "," + args[1].(string)
+	c[values.Digest(values.Tuple{args[0], args[1]}, types.Tuple(&types.Field{T: types.String}, &types.Field{T: types.String}))] = true
+	return v, nil
+}
+
+func (c concat) Digest() digest.Digest {
+	return reflow.Digester.FromString("concat")
+}
+
+func TestTreeReduce(t *testing.T) {
+	const n = 1000
+	var list values.List
+	for i := 0; i < n; i++ {
+		list = append(list, big.NewInt(int64(i)).String())
+	}
+	before := make(concat)
+	v, err := treeReduce(values.Location{}, before, list, types.String, types.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.(string), strings.Join(stringList(list), ","); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(before), n-1; got != want {
+		t.Errorf("got %v combinations, want %v", got, want)
+	}
+
+	// Insert an element in the middle of the list: only the chunks
+	// that contain it, one per level, are recombined.
+	list = append(list[:n/2], append(values.List{"new"}, list[n/2:]...)...)
+	after := make(concat)
+	v, err = treeReduce(values.Location{}, after, list, types.String, types.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.(string), strings.Join(stringList(list), ","); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var changed int
+	for d := range after {
+		if !before[d] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 50 {
+		t.Errorf("%d of %d combinations changed", changed, len(after))
+	}
+}
+
+func stringList(l values.List) []string {
+	s := make([]string, len(l))
+	for i := range l {
+		s[i] = l[i].(string)
+	}
+	return s
+}
@@ -11,8 +11,8 @@
 // buckets stored. "Date-Keepalive-index" index allows querying runs/tasks based on time
 // buckets. Dynamodbtask also uses a bunch of secondary indices to help with run/task querying.
 // Schema:
-// run:  {ID, ID4, Labels, Bundle, Args, Date, Keepalive, StartTime, EndTime, Type="run", User}
-// task: {ID, ID4, Labels, Date, Keepalive, StartTime, EndTime, Type="task", FlowID, Inspect, Error, ResultID, RunID, RunID4, ImgCmdID, Ident, Stderr, Stdout, URI}
+// run:  {ID, ID4, Labels, Bundle, Args, Date, Keepalive, StartTime, EndTime, Type="run", User, Allocs, InstanceHours, Dollars}
+// task: {ID, ID4, Labels, Date, Keepalive, StartTime, EndTime, Type="task", FlowID, Inspect, Error, ResultID, RunID, RunID4, ImgCmdID, Ident, Stderr, Stdout, URI, Preempted}
 // Indexes:
 // 1. Date-Keepalive-index - for time-based queries.
 // 2. RunID-index - for finding all tasks that belong to a run.
@@ -23,6 +23,7 @@ package dynamodbtask
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -72,6 +73,10 @@ const (
 	ExecLog
 	SysLog
 	EvalGraph
+	Preempted
+	Allocs
+	InstanceHours
+	Dollars
 )
 
 func init() {
@@ -123,6 +128,11 @@ const (
 	colExecLog   = "ExecLog"
 	colSysLog    = "Syslog"
 	colEvalGraph = "EvalGraph"
+	colPreempted = "Preempted"
+
+	colAllocs        = "Allocs"
+	colInstanceHours = "InstanceHours"
+	colDollars       = "Dollars"
 )
 
 var colmap = map[taskdb.Kind]string{
@@ -151,6 +161,11 @@ var colmap = map[taskdb.Kind]string{
 	ExecLog:     colExecLog,
 	SysLog:      colSysLog,
 	EvalGraph:   colEvalGraph,
+	Preempted:   colPreempted,
+
+	Allocs:        colAllocs,
+	InstanceHours: colInstanceHours,
+	Dollars:       colDollars,
 }
 
 // Index names used in dynamodb table.
@@ -302,6 +317,27 @@ func (t *TaskDB) SetRunComplete(ctx context.Context, id taskdb.RunID, execLog, s
 	return err
 }
 
+// SetRunSpend records the resources spent so far by the run.
+func (t *TaskDB) SetRunSpend(ctx context.Context, id taskdb.RunID, spend taskdb.Spend) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			colID: {
+				S: aws.String(id.ID()),
+			},
+		},
+		UpdateExpression: aws.String(fmt.Sprintf("SET %s = :allocs, %s = :instancehours, %s = :dollars",
+			colAllocs, colInstanceHours, colDollars)),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":allocs":        {N: aws.String(fmt.Sprint(spend.Allocs))},
+			":instancehours": {N: aws.String(fmt.Sprint(spend.InstanceHours))},
+			":dollars":       {N: aws.String(fmt.Sprint(spend.Dollars))},
+		},
+	}
+	_, err := t.DB.UpdateItemWithContext(ctx, input)
+	return err
+}
+
 // CreateTask creates a new task in the taskdb with the provided taskID, runID and flowID, imgCmdID, ident, and uri.
 func (t *TaskDB) CreateTask(ctx context.Context, id taskdb.TaskID, runID taskdb.RunID, flowID digest.Digest, imgCmdID taskdb.ImgCmdID, ident, uri string) error {
 	now := time.Now().UTC()
@@ -410,16 +446,18 @@ func (t *TaskDB) SetTaskAttrs(ctx context.Context, id taskdb.TaskID, stdout, std
 }
 
 // SetTaskComplete mark the task as completed as of the given end time.
+// Successful completions clear the error of any earlier (preempted)
+// attempt of the task.
 func (t *TaskDB) SetTaskComplete(ctx context.Context, id taskdb.TaskID, err error, end time.Time) error {
 	if end.IsZero() {
 		end = time.Now()
 	}
 	var (
-		update = aws.String(fmt.Sprintf("SET %s = :endtime", colEndTime))
+		update = aws.String(fmt.Sprintf("SET %s = :endtime REMOVE #Err", colEndTime))
 		values = map[string]*dynamodb.AttributeValue{
 			":endtime": {S: aws.String(end.UTC().Format(timeLayout))},
 		}
-		keys map[string]*string
+		keys = map[string]*string{"#Err": aws.String(colError)}
 	)
 	if err != nil {
 		update = aws.String(fmt.Sprintf("SET %s = :endtime, #Err = :error", colEndTime))
@@ -427,7 +465,10 @@ func (t *TaskDB) SetTaskComplete(ctx context.Context, id taskdb.TaskID, err erro
 			":endtime": {S: aws.String(end.UTC().Format(timeLayout))},
 			":error":   {S: aws.String(err.Error())},
 		}
-		keys = map[string]*string{"#Err": aws.String(colError)}
+		if errors.Is(errors.Preempted, err) {
+			update = aws.String(fmt.Sprintf("SET %s = :endtime, #Err = :error, %s = :preempted", colEndTime, colPreempted))
+			values[":preempted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		}
 	}
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(t.TableName),
@@ -755,6 +796,10 @@ func (t *TaskDB) Tasks(ctx context.Context, taskQuery taskdb.TaskQuery) ([]taskd
 		if v, ok := it[colURI]; ok {
 			uri = *v.S
 		}
+		var preempted bool
+		if v, ok := it[colPreempted]; ok && v.BOOL != nil {
+			preempted = *v.BOOL
+		}
 		tasks = append(tasks, taskdb.Task{
 			ID:        taskdb.TaskID(id),
 			RunID:     taskdb.RunID(runid),
@@ -769,6 +814,7 @@ func (t *TaskDB) Tasks(ctx context.Context, taskQuery taskdb.TaskQuery) ([]taskd
 			Stdout:    stdout,
 			Stderr:    stderr,
 			Inspect:   inspect,
+			Preempted: preempted,
 		})
 	}
 	if len(errs) == 0 {
@@ -895,6 +941,22 @@ func (t *TaskDB) Runs(ctx context.Context, runQuery taskdb.RunQuery) ([]taskdb.R
 				errs = append(errs, fmt.Errorf("parse evalGraph %v: %v", *v.S, err))
 			}
 		}
+		var spend taskdb.Spend
+		if v, ok := it[colAllocs]; ok && v.N != nil {
+			if spend.Allocs, err = strconv.Atoi(*v.N); err != nil {
+				errs = append(errs, fmt.Errorf("parse allocs %v: %v", *v.N, err))
+			}
+		}
+		if v, ok := it[colInstanceHours]; ok && v.N != nil {
+			if spend.InstanceHours, err = strconv.ParseFloat(*v.N, 64); err != nil {
+				errs = append(errs, fmt.Errorf("parse instancehours %v: %v", *v.N, err))
+			}
+		}
+		if v, ok := it[colDollars]; ok && v.N != nil {
+			if spend.Dollars, err = strconv.ParseFloat(*v.N, 64); err != nil {
+				errs = append(errs, fmt.Errorf("parse dollars %v: %v", *v.N, err))
+			}
+		}
 		runs = append(runs, taskdb.Run{
 			ID:        taskdb.RunID(id),
 			Labels:    l,
@@ -905,6 +967,7 @@ func (t *TaskDB) Runs(ctx context.Context, runQuery taskdb.RunQuery) ([]taskdb.R
 			ExecLog:   execLog,
 			SysLog:    sysLog,
 			EvalGraph: evalGraph,
+			Spend:     spend,
 		})
 	}
 	if len(errs) == 0 {
@@ -146,6 +146,33 @@ func TestSetRunAttrs(t *testing.T) {
 
 }
 
+func TestSetRunSpend(t *testing.T) {
+	var (
+		mockdb = mockDynamoDBUpdate{}
+		taskb  = &TaskDB{DB: &mockdb, TableName: mockTableName}
+		runID  = taskdb.NewRunID()
+		spend  = taskdb.Spend{Allocs: 3, InstanceHours: 12.5, Dollars: 4.25}
+	)
+	if err := taskb.SetRunSpend(context.Background(), runID, spend); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		actual   string
+		expected string
+	}{
+		{*mockdb.uInput.TableName, "mockdynamodb"},
+		{*mockdb.uInput.Key[colID].S, runID.ID()},
+		{*mockdb.uInput.ExpressionAttributeValues[":allocs"].N, "3"},
+		{*mockdb.uInput.ExpressionAttributeValues[":instancehours"].N, "12.5"},
+		{*mockdb.uInput.ExpressionAttributeValues[":dollars"].N, "4.25"},
+		{*mockdb.uInput.UpdateExpression, fmt.Sprintf("SET %s = :allocs, %s = :instancehours, %s = :dollars", colAllocs, colInstanceHours, colDollars)},
+	} {
+		if test.expected != test.actual {
+			t.Errorf("expected %s, got %v", test.expected, test.actual)
+		}
+	}
+}
+
 func TestTaskCreate(t *testing.T) {
 	var (
 		labels   = []string{"test=label"}
@@ -295,7 +322,8 @@ func TestSetTaskComplete(t *testing.T) {
 		{*mockdb.uInput.TableName, "mockdynamodb"},
 		{*mockdb.uInput.Key[colID].S, taskID.ID()},
 		{*mockdb.uInput.ExpressionAttributeValues[":endtime"].S, end.UTC().Format(timeLayout)},
-		{*mockdb.uInput.UpdateExpression, "SET EndTime = :endtime"},
+		{*mockdb.uInput.ExpressionAttributeNames["#Err"], "Error"},
+		{*mockdb.uInput.UpdateExpression, "SET EndTime = :endtime REMOVE #Err"},
 	} {
 		if test.want != test.got {
 			t.Errorf("got %v, want %v", test.got, test.want)
@@ -321,6 +349,17 @@ func TestSetTaskComplete(t *testing.T) {
 			t.Errorf("got %v, want %v", test.got, test.want)
 		}
 	}
+	tdbErr = errors.E(errors.Preempted, "alloc terminating")
+	err = taskb.SetTaskComplete(context.Background(), taskID, tdbErr, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *mockdb.uInput.UpdateExpression, "SET EndTime = :endtime, #Err = :error, Preempted = :preempted"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if v := mockdb.uInput.ExpressionAttributeValues[":preempted"]; v == nil || v.BOOL == nil || !*v.BOOL {
+		t.Errorf("got %v, want preempted", v)
+	}
 }
 
 func TestKeepalive(t *testing.T) {
@@ -357,6 +396,7 @@ type mockDynamodbQueryRuns struct {
 	keepalive time.Time
 	starttime time.Time
 	id        digest.Digest
+	spend     *taskdb.Spend
 	err       error
 }
 
@@ -373,17 +413,21 @@ func (m *mockDynamodbQueryRuns) QueryWithContext(ctx aws.Context, input *dynamod
 	} else {
 		id4 = m.id.HexN(4)
 	}
+	item := map[string]*dynamodb.AttributeValue{
+		colID:        &dynamodb.AttributeValue{S: aws.String(id)},
+		colID4:       &dynamodb.AttributeValue{S: aws.String(id4)},
+		colUser:      &dynamodb.AttributeValue{S: aws.String(m.user)},
+		colLabels:    &dynamodb.AttributeValue{SS: []*string{aws.String("label=test")}},
+		colKeepalive: &dynamodb.AttributeValue{S: aws.String(m.keepalive.Format(timeLayout))},
+		colStartTime: &dynamodb.AttributeValue{S: aws.String(m.starttime.Format(timeLayout))},
+	}
+	if m.spend != nil {
+		item[colAllocs] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(m.spend.Allocs))}
+		item[colInstanceHours] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(m.spend.InstanceHours))}
+		item[colDollars] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(m.spend.Dollars))}
+	}
 	return &dynamodb.QueryOutput{
-		Items: []map[string]*dynamodb.AttributeValue{
-			map[string]*dynamodb.AttributeValue{
-				colID:        &dynamodb.AttributeValue{S: aws.String(id)},
-				colID4:       &dynamodb.AttributeValue{S: aws.String(id4)},
-				colUser:      &dynamodb.AttributeValue{S: aws.String(m.user)},
-				colLabels:    &dynamodb.AttributeValue{SS: []*string{aws.String("label=test")}},
-				colKeepalive: &dynamodb.AttributeValue{S: aws.String(m.keepalive.Format(timeLayout))},
-				colStartTime: &dynamodb.AttributeValue{S: aws.String(m.starttime.Format(timeLayout))},
-			},
-		},
+		Items: []map[string]*dynamodb.AttributeValue{item},
 	}, m.err
 }
 
@@ -427,6 +471,27 @@ func TestRunsIDQuery(t *testing.T) {
 	}
 }
 
+func TestRunsSpend(t *testing.T) {
+	var (
+		runID  = taskdb.NewRunID()
+		mockdb = getMockRunTaskDB()
+		taskb  = &TaskDB{DB: mockdb, TableName: mockTableName}
+		spend  = taskdb.Spend{Allocs: 2, InstanceHours: 1.5, Dollars: 0.75}
+	)
+	mockdb.id = digest.Digest(runID)
+	mockdb.spend = &spend
+	runs, err := taskb.Runs(context.Background(), taskdb.RunQuery{ID: runID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v runs, want %v", got, want)
+	}
+	if got, want := runs[0].Spend, spend; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func TestRunsIDShortQuery(t *testing.T) {
 	var (
 		id     = reflow.Digester.Rand(nil)
@@ -82,6 +82,16 @@ func (t TaskID) IsValid() bool {
 	return !digest.Digest(t).IsZero()
 }
 
+// MarshalJSON marshals the TaskID into JSON format.
+func (t TaskID) MarshalJSON() ([]byte, error) {
+	return digest.Digest(t).MarshalJSON()
+}
+
+// UnmarshalJSON unmarshals a TaskID from JSON data.
+func (t *TaskID) UnmarshalJSON(b []byte) error {
+	return (*digest.Digest)(t).UnmarshalJSON(b)
+}
+
 // ImgCmdID describes the behavior of an exec.
 // It is a digest of an exec's docker image + cmd.
 type ImgCmdID digest.Digest
@@ -135,6 +145,8 @@ type TaskDB interface {
 	SetRunAttrs(ctx context.Context, id RunID, bundle digest.Digest, args []string) error
 	// SetRunComplete marsk the run as complete.
 	SetRunComplete(ctx context.Context, id RunID, execLog, sysLog, evalGraph digest.Digest, end time.Time) error
+	// SetRunSpend records the resources spent so far by the run.
+	SetRunSpend(ctx context.Context, id RunID, spend Spend) error
 	// CreateTask creates a new task in the taskdb with the provided taskID, runID and flowID, imgCmdID, ident, and uri.
 	CreateTask(ctx context.Context, id TaskID, runID RunID, flowID digest.Digest, imgCmdID ImgCmdID, ident, uri string) error
 	// SetTaskResult sets the result of the task post completion.
@@ -179,6 +191,8 @@ type Run struct {
 	End time.Time
 	// Various logs and other run info generated for the run.
 	ExecLog, SysLog, EvalGraph digest.Digest
+	// Spend is the run's spend, as last recorded.
+	Spend Spend
 }
 
 func (r Run) String() string {
@@ -193,6 +207,29 @@ func (r Run) String() string {
 	return fmt.Sprintf("run %s %s %s %s %s", r.ID.IDShort(), r.User, strings.Join(labels, ","), r.Start.String(), et.String())
 }
 
+// Spend describes the resources spent by a run.
+type Spend struct {
+	// Allocs is the number of allocs held by the run.
+	Allocs int
+	// InstanceHours is the total number of hours for which
+	// the run has held allocs.
+	InstanceHours float64
+	// Dollars is the estimated cost, in US dollars, of the
+	// allocs held by the run.
+	Dollars float64
+}
+
+// Add adds the spend t to s.
+func (s *Spend) Add(t Spend) {
+	s.Allocs += t.Allocs
+	s.InstanceHours += t.InstanceHours
+	s.Dollars += t.Dollars
+}
+
+func (s Spend) String() string {
+	return fmt.Sprintf("%d allocs, %.2f instance-hours, $%.2f", s.Allocs, s.InstanceHours, s.Dollars)
+}
+
 // Task is the task info stored in the taskdb.
 type Task struct {
 	// ID is the task id.
@@ -219,6 +256,11 @@ type Task struct {
 	URI string
 	// Stdout, Stderr and Inspect are the stdout, stderr and inspect ids of the task.
 	Stdout, Stderr, Inspect digest.Digest
+	// Preempted tells whether the task was preempted (e.g., because
+	// its alloc was about to be terminated). Preempted tasks are
+	// rescheduled under the same ID, so that the task may since have
+	// failed or completed.
+	Preempted bool
 }
 
 func (t Task) String() string {
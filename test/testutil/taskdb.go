@@ -30,6 +30,11 @@ func (n nopTaskDB) SetRunComplete(ctx context.Context, id taskdb.RunID, execLog,
 	return nil
 }
 
+// SetRunSpend is a no op.
+func (n nopTaskDB) SetRunSpend(ctx context.Context, id taskdb.RunID, spend taskdb.Spend) error {
+	return nil
+}
+
 // CreateTask is a no op.
 func (n nopTaskDB) CreateTask(ctx context.Context, id taskdb.TaskID, runID taskdb.RunID, flowID digest.Digest, imgCmdID taskdb.ImgCmdID, ident, uri string) error {
 	return nil
@@ -0,0 +1,126 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"context"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/liveset"
+)
+
+// tieredAssoc implements assoc.Assoc for a tiered cache. Lookups
+// consult each tier in order; all other operations apply to the
+// write tier only.
+type tieredAssoc struct {
+	*Cache
+}
+
+// Store stores the mapping in the write tier.
+func (a *tieredAssoc) Store(ctx context.Context, kind assoc.Kind, k, v digest.Digest) error {
+	return a.writer().Assoc.Store(ctx, kind, k, v)
+}
+
+// Get returns the mapping for the key k from the first tier in which
+// it is found. Errors from tiers other than NotExist are returned
+// only if no tier has the mapping.
+func (a *tieredAssoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (kexp, v digest.Digest, err error) {
+	var first error
+	for i, tier := range a.Tiers {
+		kexp, v, err = tier.Assoc.Get(ctx, kind, k)
+		if err == nil {
+			a.hit(ctx, kind, kexp, v, i)
+			return kexp, v, nil
+		}
+		if !errors.Is(errors.NotExist, err) && first == nil {
+			first = err
+		}
+	}
+	a.miss()
+	if first != nil {
+		return k, digest.Digest{}, first
+	}
+	return k, digest.Digest{}, errors.E("get", k, errors.NotExist)
+}
+
+// BatchGet looks up each key of the batch in the tiers in order: the
+// keys that are not found in a tier are looked up in the next. A tier
+// that fails the lookup is skipped, and its error is set on the keys
+// that are not found in any tier; BatchGet returns an error only if
+// every tier fails.
+func (a *tieredAssoc) BatchGet(ctx context.Context, batch assoc.Batch) error {
+	pending := make(assoc.Batch, len(batch))
+	for k := range batch {
+		pending.Add(k)
+	}
+	var (
+		first  error
+		failed int
+	)
+	for i, tier := range a.Tiers {
+		if len(pending) == 0 {
+			break
+		}
+		next := make(assoc.Batch)
+		if err := tier.Assoc.BatchGet(ctx, pending); err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+			for k := range pending {
+				if batch[k].Error == nil {
+					batch[k] = assoc.Result{Error: err}
+				}
+				next.Add(k)
+			}
+			pending = next
+			continue
+		}
+		for k, r := range pending {
+			if pending.Found(k) {
+				batch[k] = r
+				a.hit(ctx, k.Kind, k.Digest, r.Digest, i)
+				continue
+			}
+			// Keep the first tier's error, if any, for keys that
+			// are not found in any tier.
+			if r.Error != nil && !errors.Is(errors.NotExist, r.Error) && batch[k].Error == nil {
+				batch[k] = r
+			}
+			next.Add(k)
+		}
+		pending = next
+	}
+	if failed > 0 && failed == len(a.Tiers) {
+		return first
+	}
+	for range pending {
+		a.miss()
+	}
+	return nil
+}
+
+// CollectWithThreshold collects the write tier.
+func (a *tieredAssoc) CollectWithThreshold(ctx context.Context, live, dead liveset.Liveset, threshold time.Time, rate int64, dryrun bool) error {
+	return a.writer().Assoc.CollectWithThreshold(ctx, live, dead, threshold, rate, dryrun)
+}
+
+// Count returns the number of mappings in the write tier.
+func (a *tieredAssoc) Count(ctx context.Context) (int64, error) {
+	return a.writer().Assoc.Count(ctx)
+}
+
+// Scan scans the write tier.
+func (a *tieredAssoc) Scan(ctx context.Context, kind assoc.Kind, handler assoc.MappingHandler) error {
+	return a.writer().Assoc.Scan(ctx, kind, handler)
+}
+
+// Delete deletes the key k from the write tier.
+func (a *tieredAssoc) Delete(ctx context.Context, k digest.Digest) error {
+	return a.writer().Assoc.Delete(ctx, k)
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grailbio/infra"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+)
+
+func init() {
+	infra.Register("tiered", new(Cache))
+	infra.Register("tieredassoc", new(Assoc))
+	infra.Register("tieredrepository", new(Repository))
+}
+
+// Help implements infra.Provider.
+func (*Cache) Help() string {
+	return "configure a cache that reads through a prioritized set of assocs and repositories; " +
+		"lookups that hit slower tiers block until promoted, unless async is set (with reflow run -local only)"
+}
+
+// Config implements infra.Provider.
+func (c *Cache) Config() interface{} {
+	return c
+}
+
+// Init implements infra.Provider.
+func (c *Cache) Init(logger *log.Logger) error {
+	c.Log = logger.Tee(nil, "tiered: ")
+	return c.init()
+}
+
+// init validates the cache's tiers.
+func (c *Cache) init() error {
+	if len(c.Tiers) == 0 {
+		return errors.New("tiered: no tiers configured")
+	}
+	names := make(map[string]bool)
+	for i := range c.Tiers {
+		t := &c.Tiers[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("tier%d", i)
+		}
+		if names[t.Name] {
+			return errors.Errorf("tiered: duplicate tier %s", t.Name)
+		}
+		names[t.Name] = true
+		if t.Assoc == nil && t.AssocProvider == "" || t.Repository == nil && t.RepositoryProvider == "" {
+			return errors.Errorf("tiered: tier %s: both an assoc and a repository must be configured", t.Name)
+		}
+		if isTiered(t.AssocProvider) || isTiered(t.RepositoryProvider) {
+			return errors.Errorf("tiered: tier %s: tiered caches cannot be nested", t.Name)
+		}
+	}
+	if c.Write != "" && !names[c.Write] {
+		return errors.Errorf("tiered: write tier %s is not configured", c.Write)
+	}
+	return nil
+}
+
+func isTiered(provider string) bool {
+	name := strings.SplitN(provider, ",", 2)[0]
+	return name == "tieredassoc" || name == "tieredrepository"
+}
+
+// Configure instantiates the assocs and repositories of the tiers
+// that have not yet been instantiated, using the provided function,
+// which instantiates them from the tier's providers.
+func (c *Cache) Configure(tier func(t *Tier) error) error {
+	for i := range c.Tiers {
+		t := &c.Tiers[i]
+		if t.Assoc != nil && t.Repository != nil {
+			continue
+		}
+		if err := tier(t); err != nil {
+			return errors.E(fmt.Sprintf("tiered: tier %s", t.Name), err)
+		}
+	}
+	return nil
+}
+
+// Assoc is an infra provider for the assoc of a tiered cache.
+type Assoc struct {
+	assoc.Assoc
+}
+
+// Help implements infra.Provider.
+func (*Assoc) Help() string {
+	return "configure an assoc that reads through the tiers of the tiered cache"
+}
+
+// Init implements infra.Provider.
+func (a *Assoc) Init(c *Cache) error {
+	a.Assoc = c.Assoc()
+	return nil
+}
+
+// Repository is an infra provider for the repository of a tiered
+// cache.
+type Repository struct {
+	reflow.Repository
+}
+
+// Help implements infra.Provider.
+func (*Repository) Help() string {
+	return "configure a repository that reads through the tiers of the tiered cache"
+}
+
+// Init implements infra.Provider.
+func (r *Repository) Init(c *Cache) error {
+	r.Repository = c.Repository()
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/liveset"
+)
+
+// tieredRepository implements reflow.Repository for a tiered cache.
+// Reads consult each tier in order; writes are made to the write
+// tier only.
+type tieredRepository struct {
+	*Cache
+}
+
+// Stat returns the metadata of the object from the first tier in
+// which it is found.
+func (r *tieredRepository) Stat(ctx context.Context, id digest.Digest) (reflow.File, error) {
+	var file reflow.File
+	err := r.each(func(repo reflow.Repository) (err error) {
+		file, err = repo.Stat(ctx, id)
+		return
+	})
+	return file, err
+}
+
+// Get reads the object from the first tier in which it is found.
+func (r *tieredRepository) Get(ctx context.Context, id digest.Digest) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := r.each(func(repo reflow.Repository) (err error) {
+		rc, err = repo.Get(ctx, id)
+		return
+	})
+	return rc, err
+}
+
+// Put writes the object to the write tier.
+func (r *tieredRepository) Put(ctx context.Context, body io.Reader) (digest.Digest, error) {
+	return r.writer().Repository.Put(ctx, body)
+}
+
+// WriteTo writes the object from the first tier in which it is
+// found to the repository named by u.
+func (r *tieredRepository) WriteTo(ctx context.Context, id digest.Digest, u *url.URL) error {
+	return r.each(func(repo reflow.Repository) error {
+		return repo.WriteTo(ctx, id, u)
+	})
+}
+
+// ReadFrom reads the object into the write tier.
+func (r *tieredRepository) ReadFrom(ctx context.Context, id digest.Digest, u *url.URL) error {
+	return r.writer().Repository.ReadFrom(ctx, id, u)
+}
+
+// Collect collects the write tier.
+func (r *tieredRepository) Collect(ctx context.Context, live liveset.Liveset) error {
+	return r.writer().Repository.Collect(ctx, live)
+}
+
+// CollectWithThreshold collects the write tier.
+func (r *tieredRepository) CollectWithThreshold(ctx context.Context, live liveset.Liveset, dead liveset.Liveset, threshold time.Time, dryrun bool) error {
+	return r.writer().Repository.CollectWithThreshold(ctx, live, dead, threshold, dryrun)
+}
+
+// URL returns the URL of the write tier. Objects that reside only in
+// other tiers are not accessible through it until they are promoted;
+// caches that promote asynchronously thus return an in-process URL
+// that names the cache's repository itself.
+func (r *tieredRepository) URL() *url.URL {
+	return r.repositoryURL()
+}
+
+// each calls fn with the repository of each tier in order until fn
+// succeeds or fails with an error other than NotExist.
+func (r *tieredRepository) each(fn func(reflow.Repository) error) error {
+	var err error
+	for _, tier := range r.Tiers {
+		if err = fn(tier.Repository); err == nil || !errors.Is(errors.NotExist, err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,326 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package tiered implements an assoc and a repository that are
+// layered over an ordered list of tiers, each of which is an
+// assoc and repository pair. Tiers are ordered from fastest to
+// slowest, e.g., a local cache, a team's shared cache, and an
+// organization-wide read-only cache. Lookups consult the tiers in
+// order, and mappings found in tiers slower than the cache's write
+// tier are promoted to the write tier, together with the repository
+// objects they reference, so that subsequent lookups are satisfied
+// by faster tiers. Writes are made to the write tier only.
+//
+// A tiered cache may be configured as follows:
+//
+//	assoc: tieredassoc
+//	repository: tieredrepository
+//	tiers: tiered
+//	tiered:
+//	  async: true
+//	  tiers:
+//	  - name: local
+//	    assoc: fileassoc,dir=/home/user/.reflow/assoc
+//	    repository: filerepo,dir=/home/user/.reflow/repository
+//	  - name: team
+//	    assoc: dynamodbassoc,table=team-reflow
+//	    repository: s3,bucket=team-reflow
+//
+// Each tier's assoc and repository are configured by their own
+// providers' keys. Here, "reflow run -local" reads through to the
+// team's cache and writes only to the local one.
+//
+// By default, promotion is synchronous: a lookup that is satisfied
+// by a slower tier blocks until the mapping, and all of the objects
+// it references, have been copied to the write tier. Asynchronous
+// promotion ("async: true") returns such mappings immediately, but
+// is supported only by "reflow run -local": the executors of other
+// runs cannot access the objects that are not yet promoted, and so
+// such runs promote synchronously regardless.
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository"
+)
+
+// maxPromotions is the maximum number of concurrent asynchronous
+// promotions.
+const maxPromotions = 16
+
+// Tier is a single tier of a cache.
+type Tier struct {
+	// Name is the name of the tier, used in logs and reports.
+	Name string `yaml:"name"`
+	// AssocProvider and RepositoryProvider are the names of the
+	// tier's assoc and repository providers, e.g., "dynamodbassoc"
+	// and "s3".
+	AssocProvider      string `yaml:"assoc"`
+	RepositoryProvider string `yaml:"repository"`
+
+	// Assoc and Repository are the tier's assoc and repository.
+	Assoc      assoc.Assoc       `yaml:"-"`
+	Repository reflow.Repository `yaml:"-"`
+}
+
+// Cache is a tiered cache. Its assoc and repository, returned by
+// (*Cache).Assoc and (*Cache).Repository, read through the cache's
+// tiers. A Cache must have at least one tier.
+type Cache struct {
+	// Tiers is the ordered list of tiers, from fastest to slowest.
+	Tiers []Tier `yaml:"tiers"`
+	// Write names the tier to which all writes are made. If empty,
+	// writes are made to the first tier.
+	Write string `yaml:"write,omitempty"`
+	// Async enables asynchronous promotion: mappings found in slower
+	// tiers are returned immediately, and are promoted in the
+	// background. The cache's repository is then addressed by a URL
+	// that is valid only in the current process, since its objects
+	// may not yet have been copied to the write tier; thus
+	// asynchronous promotion is suitable only for executors that run
+	// in-process, i.e., with "reflow run -local". When Async is
+	// false (the default), lookups that are satisfied by slower tiers
+	// block until the mappings and their objects have been promoted.
+	Async bool `yaml:"async,omitempty"`
+	// NoPromote disables promotion: mappings found in slower tiers
+	// are returned but not copied to the write tier.
+	NoPromote bool `yaml:"-"`
+	// Visit, if not nil, is called for each key that is found by the
+	// cache's assoc, with the index of the tier in which it was found.
+	// Visit may be called concurrently.
+	Visit func(kind assoc.Kind, k digest.Digest, tier int) `yaml:"-"`
+	// Log is used to report promotion errors.
+	Log *log.Logger `yaml:"-"`
+
+	mu      sync.Mutex
+	stats   Stats
+	pending map[assoc.Key]bool
+	limit   chan struct{}
+	wg      sync.WaitGroup
+
+	urlOnce sync.Once
+	url     *url.URL
+}
+
+// Stats reports the activity of a tiered cache.
+type Stats struct {
+	// Hits is the number of keys found in each tier.
+	Hits []int64
+	// Misses is the number of keys that were found in no tier.
+	Misses int64
+	// Promoted is the number of mappings promoted to the write tier.
+	Promoted int64
+	// Objects and Bytes are the number and total size of the
+	// repository objects copied to the write tier.
+	Objects, Bytes int64
+}
+
+// String returns a summary of the stats, e.g.,
+// "hits 10/5, misses 2, promoted 5 (12 objects, 1.2GiB)".
+func (s Stats) String() string {
+	hits := ""
+	for i, n := range s.Hits {
+		if i > 0 {
+			hits += "/"
+		}
+		hits += fmt.Sprint(n)
+	}
+	return fmt.Sprintf("hits %s, misses %d, promoted %d (%d objects, %s)",
+		hits, s.Misses, s.Promoted, s.Objects, data.Size(s.Bytes))
+}
+
+// Stats returns a snapshot of the cache's stats.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Hits = make([]int64, len(c.Tiers))
+	copy(stats.Hits, c.stats.Hits)
+	return stats
+}
+
+// Assoc returns an assoc that reads through the cache's tiers.
+func (c *Cache) Assoc() assoc.Assoc {
+	return &tieredAssoc{c}
+}
+
+// Repository returns a repository that reads through the cache's
+// tiers.
+func (c *Cache) Repository() reflow.Repository {
+	return &tieredRepository{c}
+}
+
+// Wait waits for the cache's pending asynchronous promotions to
+// complete.
+func (c *Cache) Wait() {
+	c.wg.Wait()
+}
+
+// writeIndex returns the index of the write tier.
+func (c *Cache) writeIndex() int {
+	for i, tier := range c.Tiers {
+		if tier.Name == c.Write {
+			return i
+		}
+	}
+	return 0
+}
+
+// writer returns the write tier.
+func (c *Cache) writer() Tier {
+	return c.Tiers[c.writeIndex()]
+}
+
+// hit records that the mapping (kind, k, v) was found in the
+// provided tier and, if the tier is slower than the write tier,
+// promotes it.
+func (c *Cache) hit(ctx context.Context, kind assoc.Kind, k, v digest.Digest, tier int) {
+	c.mu.Lock()
+	if c.stats.Hits == nil {
+		c.stats.Hits = make([]int64, len(c.Tiers))
+	}
+	c.stats.Hits[tier]++
+	c.mu.Unlock()
+	if c.Visit != nil {
+		c.Visit(kind, k, tier)
+	}
+	if tier <= c.writeIndex() || c.NoPromote {
+		return
+	}
+	if !c.Async {
+		if err := c.promote(ctx, kind, k, v, c.Tiers[tier]); err != nil && c.Log != nil {
+			c.Log.Errorf("promote %v %v from tier %s: %v", kind, k, c.Tiers[tier].Name, err)
+		}
+		return
+	}
+	key := assoc.Key{Kind: kind, Digest: k}
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = make(map[assoc.Key]bool)
+		c.limit = make(chan struct{}, maxPromotions)
+	}
+	if c.pending[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.pending[key] = true
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.limit <- struct{}{}
+		// Promotions outlive the lookups that trigger them.
+		err := c.promote(context.Background(), kind, k, v, c.Tiers[tier])
+		<-c.limit
+		if err != nil && c.Log != nil {
+			c.Log.Errorf("promote %v %v from tier %s: %v", kind, k, c.Tiers[tier].Name, err)
+		}
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+}
+
+func (c *Cache) miss() {
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+}
+
+// promote copies the mapping (kind, k, v) and the objects that it
+// references from the provided tier to the write tier. The mapping
+// is stored only after all of its objects have been copied.
+func (c *Cache) promote(ctx context.Context, kind assoc.Kind, k, v digest.Digest, from Tier) error {
+	to := c.writer()
+	files := []reflow.File{{ID: v}}
+	if kind == assoc.Fileset {
+		var fs reflow.Fileset
+		if err := repository.Unmarshal(ctx, from.Repository, v, &fs); err != nil {
+			return err
+		}
+		for _, f := range fs.Files() {
+			if !f.IsRef() {
+				files = append(files, f)
+			}
+		}
+	}
+	missing, err := repository.Missing(ctx, to.Repository, files...)
+	if err != nil {
+		return err
+	}
+	var (
+		copied = make(map[digest.Digest]bool)
+		n, sz  int64
+	)
+	for _, f := range missing {
+		if copied[f.ID] {
+			continue
+		}
+		copied[f.ID] = true
+		if err := repository.Transfer(ctx, to.Repository, from.Repository, f.ID); err != nil {
+			return err
+		}
+		n++
+		if f.Size > 0 {
+			sz += f.Size
+		} else if file, err := to.Repository.Stat(ctx, f.ID); err == nil {
+			sz += file.Size
+		}
+	}
+	if err := to.Assoc.Store(ctx, kind, k, v); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.stats.Promoted++
+	c.stats.Objects += n
+	c.stats.Bytes += sz
+	c.mu.Unlock()
+	return nil
+}
+
+// Caches that promote asynchronously are registered so that their
+// repositories may be dialed in-process by their URLs.
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Cache)
+)
+
+func init() {
+	repository.RegisterScheme("tiered", func(u *url.URL) (reflow.Repository, error) {
+		registryMu.Lock()
+		c := registry[u.Host]
+		registryMu.Unlock()
+		if c == nil {
+			return nil, fmt.Errorf("tiered: no cache %s in this process", u.Host)
+		}
+		return c.Repository(), nil
+	})
+}
+
+// repositoryURL returns the URL of the cache's repository: the write
+// tier's URL or, if the cache promotes asynchronously, an in-process
+// URL that names the cache itself.
+func (c *Cache) repositoryURL() *url.URL {
+	if !c.Async {
+		return c.writer().Repository.URL()
+	}
+	c.urlOnce.Do(func() {
+		registryMu.Lock()
+		name := strconv.Itoa(len(registry))
+		registry[name] = c
+		registryMu.Unlock()
+		c.url = &url.URL{Scheme: "tiered", Host: name}
+	})
+	return c.url
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tiered_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/test/testutil"
+	"github.com/grailbio/reflow/tiered"
+)
+
+func newTier(name string) tiered.Tier {
+	return tiered.Tier{Name: name, Assoc: testutil.NewInmemoryAssoc(), Repository: testutil.NewInmemoryRepository()}
+}
+
+// putFileset stores a fileset with the provided file contents in
+// the tier's repository and maps it to key k in the tier's assoc.
+func putFileset(t *testing.T, tier tiered.Tier, k string, contents ...string) reflow.Fileset {
+	t.Helper()
+	ctx := context.Background()
+	fs := reflow.Fileset{Map: make(map[string]reflow.File)}
+	for _, c := range contents {
+		d, err := tier.Repository.Put(ctx, bytes.NewReader([]byte(c)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.Map[c] = reflow.File{ID: d, Size: int64(len(c))}
+	}
+	fsid, err := repository.Marshal(ctx, tier.Repository, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tier.Assoc.Store(ctx, assoc.Fileset, reflow.Digester.FromString(k), fsid); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestCacheGet(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newTier("primary"), newTier("secondary")
+	putFileset(t, primary, "a", "a")
+	fs := putFileset(t, secondary, "b", "b1", "b2")
+	c := &tiered.Cache{Tiers: []tiered.Tier{primary, secondary}}
+	visited := make(map[string]int)
+	c.Visit = func(_ assoc.Kind, k digest.Digest, tier int) {
+		visited[k.Short()] = tier
+	}
+	ass := c.Assoc()
+	for _, k := range []string{"a", "b"} {
+		if _, _, err := ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString("c")); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+	if got, want := visited[reflow.Digester.FromString("b").Short()], 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The mapping and its objects were promoted to the primary tier.
+	_, fsid, err := primary.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got reflow.Fileset
+	if err := repository.Unmarshal(ctx, primary.Repository, fsid, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(fs) {
+		t.Errorf("got %v, want %v", got, fs)
+	}
+	if missing, err := repository.Missing(ctx, primary.Repository, got.Files()...); err != nil {
+		t.Fatal(err)
+	} else if len(missing) > 0 {
+		t.Errorf("missing %v", missing)
+	}
+	stats := c.Stats()
+	if got, want := stats.Hits, []int64{1, 1}; got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Misses, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The fileset and its two files.
+	if got, want := stats.Objects, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Subsequent lookups are satisfied by the primary tier.
+	if _, _, err := ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString("b")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Stats().Hits[0], int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCacheBatchGet(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newTier("primary"), newTier("secondary")
+	putFileset(t, primary, "a", "a")
+	putFileset(t, secondary, "b", "b")
+	c := &tiered.Cache{Tiers: []tiered.Tier{primary, secondary}, NoPromote: true}
+	batch := make(assoc.Batch)
+	for _, k := range []string{"a", "b", "c"} {
+		batch.Add(assoc.Key{Kind: assoc.Fileset, Digest: reflow.Digester.FromString(k)})
+	}
+	if err := c.Assoc().BatchGet(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	for k, found := range map[string]bool{"a": true, "b": true, "c": false} {
+		if got, want := batch.Found(assoc.Key{Kind: assoc.Fileset, Digest: reflow.Digester.FromString(k)}), found; got != want {
+			t.Errorf("%s: got %v, want %v", k, got, want)
+		}
+	}
+	// Promotion is disabled.
+	if _, _, err := primary.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString("b")); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+}
+
+// unavailableAssoc is an assoc whose lookups fail.
+type unavailableAssoc struct {
+	assoc.Assoc
+}
+
+func (unavailableAssoc) Get(ctx context.Context, kind assoc.Kind, k digest.Digest) (digest.Digest, digest.Digest, error) {
+	return k, digest.Digest{}, errors.E("get", k, errors.Unavailable)
+}
+
+func (unavailableAssoc) BatchGet(ctx context.Context, batch assoc.Batch) error {
+	return errors.E("batchget", errors.Unavailable)
+}
+
+func TestCacheBatchGetUnavailable(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newTier("primary"), newTier("secondary")
+	putFileset(t, secondary, "b", "b")
+	unavailable := primary
+	unavailable.Assoc = unavailableAssoc{primary.Assoc}
+	c := &tiered.Cache{Tiers: []tiered.Tier{unavailable, secondary}, NoPromote: true}
+	batch := make(assoc.Batch)
+	for _, k := range []string{"b", "c"} {
+		batch.Add(assoc.Key{Kind: assoc.Fileset, Digest: reflow.Digester.FromString(k)})
+	}
+	// The unavailable tier is skipped.
+	if err := c.Assoc().BatchGet(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	b := assoc.Key{Kind: assoc.Fileset, Digest: reflow.Digester.FromString("b")}
+	if !batch.Found(b) {
+		t.Errorf("b: not found: %v", batch[b].Error)
+	}
+	// Keys that are not found carry the unavailable tier's error.
+	cKey := assoc.Key{Kind: assoc.Fileset, Digest: reflow.Digester.FromString("c")}
+	if batch.Found(cKey) {
+		t.Error("c: found")
+	}
+	if err := batch[cKey].Error; !errors.Is(errors.Unavailable, err) {
+		t.Errorf("c: got %v, want unavailable", err)
+	}
+	// The lookup fails only if every tier does.
+	c = &tiered.Cache{Tiers: []tiered.Tier{unavailable}, NoPromote: true}
+	if err := c.Assoc().BatchGet(ctx, assoc.Batch{b: assoc.Result{}}); !errors.Is(errors.Unavailable, err) {
+		t.Errorf("got %v, want unavailable", err)
+	}
+}
+
+func TestCacheRepository(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newTier("primary"), newTier("secondary")
+	d, err := secondary.Repository.Put(ctx, bytes.NewReader([]byte("secondary")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &tiered.Cache{Tiers: []tiered.Tier{primary, secondary}}
+	repo := c.Repository()
+	rc, err := repo.Get(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "secondary"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Writes are made to the primary tier.
+	d, err = repo.Put(ctx, bytes.NewReader([]byte("primary")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Repository.Stat(ctx, d); err != nil {
+		t.Error(err)
+	}
+	if _, err := secondary.Repository.Stat(ctx, d); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+}
+
+func TestCacheWriteTier(t *testing.T) {
+	ctx := context.Background()
+	local, team, org := newTier("local"), newTier("team"), newTier("org")
+	putFileset(t, local, "a", "a")
+	putFileset(t, org, "b", "b")
+	c := &tiered.Cache{Tiers: []tiered.Tier{local, team, org}, Write: "team"}
+	ass := c.Assoc()
+	for _, k := range []string{"a", "b"} {
+		if _, _, err := ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only mappings found in tiers slower than the write tier are
+	// promoted, and only to the write tier.
+	for _, k := range []string{"a", "b"} {
+		_, _, err := team.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString(k))
+		if want := k == "b"; (err == nil) != want {
+			t.Errorf("%s: got %v, want promoted %v", k, err, want)
+		}
+	}
+	if _, _, err := local.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString("b")); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+	if err := ass.Store(ctx, assoc.Fileset, reflow.Digester.FromString("c"), reflow.Digester.FromString("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := team.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString("c")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheAsync(t *testing.T) {
+	ctx := context.Background()
+	local, remote := newTier("local"), newTier("remote")
+	fs := putFileset(t, remote, "a", "a1", "a2")
+	c := &tiered.Cache{Tiers: []tiered.Tier{local, remote}, Async: true}
+	ass := c.Assoc()
+	for i := 0; i < 3; i++ {
+		if _, _, err := ass.Get(ctx, assoc.Fileset, reflow.Digester.FromString("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The cache's repository is addressed by an in-process URL, so that
+	// objects that have not yet been promoted can be read through it.
+	repo, err := repository.Dial(c.Repository().URL().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := repository.Missing(ctx, repo, fs.Files()...); err != nil {
+		t.Fatal(err)
+	} else if len(missing) > 0 {
+		t.Errorf("missing %v", missing)
+	}
+	c.Wait()
+	if _, _, err := local.Assoc.Get(ctx, assoc.Fileset, reflow.Digester.FromString("a")); err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := repository.Missing(ctx, local.Repository, fs.Files()...); err != nil {
+		t.Fatal(err)
+	} else if len(missing) > 0 {
+		t.Errorf("missing %v", missing)
+	}
+	// Concurrent lookups of the same key are promoted at most once
+	// at a time; lookups after promotion are satisfied locally.
+	if got := c.Stats().Promoted; got < 1 || got > 3 {
+		t.Errorf("got %v promotions, want 1-3", got)
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	for _, c := range []struct {
+		cache *tiered.Cache
+		ok    bool
+	}{
+		{&tiered.Cache{}, false},
+		{&tiered.Cache{Tiers: []tiered.Tier{{AssocProvider: "dynamodbassoc"}}}, false},
+		{&tiered.Cache{Tiers: []tiered.Tier{{AssocProvider: "tieredassoc", RepositoryProvider: "s3"}}}, false},
+		{&tiered.Cache{Tiers: []tiered.Tier{{AssocProvider: "fileassoc", RepositoryProvider: "filerepo"}}, Write: "local"}, false},
+		{&tiered.Cache{Tiers: []tiered.Tier{{Name: "x", AssocProvider: "a", RepositoryProvider: "r"}, {Name: "x", AssocProvider: "a", RepositoryProvider: "r"}}}, false},
+		{&tiered.Cache{Tiers: []tiered.Tier{{Name: "local", AssocProvider: "fileassoc", RepositoryProvider: "filerepo"}, {AssocProvider: "dynamodbassoc", RepositoryProvider: "s3"}}, Write: "local"}, true},
+	} {
+		err := c.cache.Init(nil)
+		if got, want := err == nil, c.ok; got != want {
+			t.Errorf("%+v: got %v, want ok %v", c.cache.Tiers, err, want)
+		}
+	}
+}
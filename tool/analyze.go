@@ -0,0 +1,360 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/taskdb"
+)
+
+func (c *Cmd) analyze(ctx context.Context, args ...string) {
+	var (
+		flags    = flag.NewFlagSet("analyze", flag.ExitOnError)
+		jsonFlag = flags.Bool("json", false, "output the analysis as JSON")
+		help     = `Analyze reports on the performance of a completed run.
+
+Analyze combines the run's tasks and exec inspects stored in the
+taskdb with the evaluation graph written at the end of the run to
+report:
+
+	- the run's critical path: the chain of dependent tasks
+	  that determined the run's duration;
+	- per-image resource utilization: requested vs. used CPU
+	  and memory, with reserved and used core- and GiB-hours;
+	- queue vs. run time: the time tasks spent waiting for an
+	  alloc vs. the time they spent executing;
+	- the cache hit rate of the run's execs, interns and externs.
+
+The critical path and cache hit rate are available only for runs
+that recorded an evaluation graph.`
+	)
+	c.Parse(flags, args, help, "analyze [-json] runid")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	n, err := parseName(flags.Arg(0))
+	if err != nil {
+		c.Fatal(err)
+	}
+	if n.Kind != idName {
+		c.Fatalf("%s: not a run id", flags.Arg(0))
+	}
+	ri, err := c.runInfo(ctx, taskdb.RunQuery{ID: taskdb.RunID(n.ID)}, false /* liveOnly */)
+	if err != nil {
+		c.Fatal(err)
+	}
+	switch len(ri) {
+	case 0:
+		c.Fatal(errors.E("analyze", flags.Arg(0), errors.NotExist))
+	case 1:
+	default:
+		c.Fatal(errors.E("analyze", flags.Arg(0), errors.Invalid, errors.New("ambiguous run id")))
+	}
+	run := ri[0]
+	var g *evalGraph
+	if !run.Run.EvalGraph.IsZero() {
+		var repo reflow.Repository
+		c.must(c.Config.Instance(&repo))
+		if g, err = loadEvalGraph(ctx, repo, run.Run.EvalGraph); err != nil {
+			c.Errorf("evalgraph %s: %v\n", run.Run.EvalGraph.Short(), err)
+		}
+	}
+	a := analyzeRun(run.Run, run.taskInfo, g)
+	if *jsonFlag {
+		b, err := json.MarshalIndent(a, "", "  ")
+		c.must(err)
+		c.Stdout.Write(b)
+		fmt.Fprintln(c.Stdout)
+		return
+	}
+	a.write(c.Stdout)
+}
+
+// duration is a time.Duration that is marshaled
+// into JSON in its string representation.
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).Round(time.Second).String()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// runAnalysis is the result of analyzing a run.
+type runAnalysis struct {
+	RunID    string
+	Duration duration
+	// CriticalPath is the chain of tasks, from the first to be
+	// executed to the last, which determined the run's duration.
+	CriticalPath         []criticalStep `json:",omitempty"`
+	CriticalPathDuration duration
+	// Images contains the resource utilization of exec tasks by image.
+	Images []imageUsage
+	// Tasks contains the queue and run time of each task.
+	Tasks []taskTiming
+	// QueueTime and RunTime are the total time spent by
+	// tasks waiting for an alloc and executing.
+	QueueTime, RunTime duration
+	Cache              cacheStats
+}
+
+// criticalStep is a single task on a run's critical path.
+type criticalStep struct {
+	Ident, FlowID string
+	Op            string
+	Duration      duration
+}
+
+// imageUsage summarizes the resources requested and used by
+// the exec tasks of an image.
+type imageUsage struct {
+	Image string
+	Tasks int
+	// CPURequested and CPUUsed are the mean number of cores requested
+	// and used per task; MemRequested and MemUsed are the mean
+	// amount of memory (bytes) requested and used per task.
+	CPURequested, CPUUsed float64
+	MemRequested, MemUsed float64
+	// CPUUtilization and MemUtilization are the fraction of
+	// requested resources which were used.
+	CPUUtilization, MemUtilization float64
+	// CoreHours and MemGiBHours are the resources reserved and used
+	// by the image's tasks, integrated over their runtimes.
+	CoreHoursReserved, CoreHoursUsed     float64
+	MemGiBHoursReserved, MemGiBHoursUsed float64
+}
+
+// taskTiming is the queue and run time of a task.
+type taskTiming struct {
+	Ident, FlowID   string
+	Queue, Run      duration
+	Start, Finished time.Time
+}
+
+// cacheStats counts cache hits and misses among the
+// external (exec, intern, extern) nodes of a run.
+type cacheStats struct {
+	Hits, Misses int
+	// HitRate is the fraction of hits, or -1 if unknown.
+	HitRate float64
+}
+
+// analyzeRun analyzes the run with the provided tasks and, if
+// non-nil, evaluation graph.
+func analyzeRun(run taskdb.Run, tasks []taskInfo, g *evalGraph) runAnalysis {
+	a := runAnalysis{RunID: run.ID.IDShort(), Cache: cacheStats{HitRate: -1}}
+	if !run.Start.IsZero() && !run.End.IsZero() {
+		a.Duration = duration(run.End.Sub(run.Start))
+	}
+	images := make(map[string]*imageUsage)
+	byFlow := make(map[string]time.Duration)
+	for _, t := range tasks {
+		span := taskSpan(t)
+		if span > byFlow[t.FlowID.Short()] {
+			byFlow[t.FlowID.Short()] = span
+		}
+		timing := taskTiming{Ident: t.Task.Ident, FlowID: t.FlowID.Short()}
+		if start := execStart(t.ExecInspect); !start.IsZero() && !t.Task.Start.IsZero() && start.After(t.Task.Start) {
+			timing.Queue = duration(start.Sub(t.Task.Start))
+		}
+		timing.Run = duration(t.Runtime())
+		timing.Start, timing.Finished = t.Task.Start, t.Task.End
+		a.QueueTime += timing.Queue
+		a.RunTime += timing.Run
+		a.Tasks = append(a.Tasks, timing)
+
+		if t.Config.Type != "exec" {
+			continue
+		}
+		image := t.Config.Image
+		if t.Config.OriginalImage != "" {
+			image = t.Config.OriginalImage
+		}
+		u := images[image]
+		if u == nil {
+			u = &imageUsage{Image: image}
+			images[image] = u
+		}
+		u.Tasks++
+		var (
+			cpuReq, memReq = t.Config.Resources["cpu"], t.Config.Resources["mem"]
+			cpuUsed        = t.Profile["cpu"].Mean
+			memUsed        = t.Profile["mem"].Max
+			hours          = t.Runtime().Hours()
+		)
+		u.CPURequested += cpuReq
+		u.CPUUsed += cpuUsed
+		u.MemRequested += memReq
+		u.MemUsed += memUsed
+		u.CoreHoursReserved += cpuReq * hours
+		u.CoreHoursUsed += cpuUsed * hours
+		u.MemGiBHoursReserved += memReq / float64(data.GiB) * hours
+		u.MemGiBHoursUsed += memUsed / float64(data.GiB) * hours
+	}
+	for _, u := range images {
+		if u.CPURequested > 0 {
+			u.CPUUtilization = u.CPUUsed / u.CPURequested
+		}
+		if u.MemRequested > 0 {
+			u.MemUtilization = u.MemUsed / u.MemRequested
+		}
+		n := float64(u.Tasks)
+		u.CPURequested /= n
+		u.CPUUsed /= n
+		u.MemRequested /= n
+		u.MemUsed /= n
+		a.Images = append(a.Images, *u)
+	}
+	sort.Slice(a.Images, func(i, j int) bool { return a.Images[i].Image < a.Images[j].Image })
+	sort.SliceStable(a.Tasks, func(i, j int) bool { return a.Tasks[i].Start.Before(a.Tasks[j].Start) })
+	if g == nil {
+		return a
+	}
+	for _, n := range g.Nodes {
+		if !n.External() {
+			continue
+		}
+		switch {
+		case n.Cached:
+			a.Cache.Hits++
+		case n.State == "done":
+			a.Cache.Misses++
+		}
+	}
+	if total := a.Cache.Hits + a.Cache.Misses; total > 0 {
+		a.Cache.HitRate = float64(a.Cache.Hits) / float64(total)
+	}
+	a.CriticalPath, a.CriticalPathDuration = criticalPath(g, byFlow)
+	return a
+}
+
+// criticalPath returns the path through graph g with the largest
+// total task duration, as given by the map durations (keyed by
+// abbreviated flow digest). Only nodes with a task are returned;
+// the path is ordered from the earliest task to the latest.
+func criticalPath(g *evalGraph, durations map[string]time.Duration) ([]criticalStep, duration) {
+	var (
+		total = make(map[*evalNode]time.Duration)
+		next  = make(map[*evalNode]*evalNode)
+		visit func(n *evalNode) time.Duration
+	)
+	visit = func(n *evalNode) time.Duration {
+		if d, ok := total[n]; ok {
+			return d
+		}
+		// Guard against cycles, which should not occur in a well-formed graph.
+		total[n] = 0
+		var (
+			max  time.Duration
+			maxn *evalNode
+		)
+		for _, dep := range n.Deps {
+			if d := visit(dep); maxn == nil || d > max {
+				max, maxn = d, dep
+			}
+		}
+		next[n] = maxn
+		total[n] = max + durations[n.Short]
+		return total[n]
+	}
+	var (
+		max  time.Duration
+		head *evalNode
+	)
+	for _, n := range g.Roots() {
+		if d := visit(n); head == nil || d > max {
+			max, head = d, n
+		}
+	}
+	var path []criticalStep
+	for n := head; n != nil; n = next[n] {
+		d, ok := durations[n.Short]
+		if !ok {
+			continue
+		}
+		path = append(path, criticalStep{Ident: n.Ident, FlowID: n.Short, Op: n.Op, Duration: duration(d)})
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, duration(max)
+}
+
+// taskSpan returns the wall-clock duration of a task, falling back
+// to its exec's runtime if the task was not completed.
+func taskSpan(t taskInfo) time.Duration {
+	if !t.Task.Start.IsZero() && !t.Task.End.IsZero() && t.Task.End.After(t.Task.Start) {
+		return t.Task.End.Sub(t.Task.Start)
+	}
+	return t.Runtime()
+}
+
+// execStart returns the time at which the exec started running.
+func execStart(inspect reflow.ExecInspect) time.Time {
+	const dockerFmt = "2006-01-02T15:04:05.999999999Z"
+	if inspect.Docker.ContainerJSONBase != nil && inspect.Docker.State != nil {
+		if start, err := time.Parse(dockerFmt, inspect.Docker.State.StartedAt); err == nil {
+			return start
+		}
+	}
+	return inspect.Created
+}
+
+func (a runAnalysis) write(w io.Writer) {
+	var tw tabwriter.Writer
+	tw.Init(w, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintf(&tw, "run %s\tduration %s\n", a.RunID, a.Duration)
+	fmt.Fprintln(&tw)
+
+	fmt.Fprintf(&tw, "critical path (%s):\n", a.CriticalPathDuration)
+	if len(a.CriticalPath) == 0 {
+		fmt.Fprintln(&tw, "\t(unavailable)")
+	} else {
+		fmt.Fprintln(&tw, "\tflowid\top\tident\tduration")
+		for _, s := range a.CriticalPath {
+			fmt.Fprintf(&tw, "\t%s\t%s\t%s\t%s\n", s.FlowID, s.Op, s.Ident, s.Duration)
+		}
+	}
+	fmt.Fprintln(&tw)
+
+	fmt.Fprintln(&tw, "resource utilization by image:")
+	fmt.Fprintln(&tw, "\timage\ttasks\tcpu req\tcpu used\tcpu util\tmem req\tmem used\tmem util\tcore-hours (reserved/used)\tGiB-hours (reserved/used)")
+	for _, u := range a.Images {
+		fmt.Fprintf(&tw, "\t%s\t%d\t%.1f\t%.1f\t%.0f%%\t%s\t%s\t%.0f%%\t%.2f/%.2f\t%.2f/%.2f\n",
+			u.Image, u.Tasks,
+			u.CPURequested, u.CPUUsed, 100*u.CPUUtilization,
+			data.Size(u.MemRequested), data.Size(u.MemUsed), 100*u.MemUtilization,
+			u.CoreHoursReserved, u.CoreHoursUsed, u.MemGiBHoursReserved, u.MemGiBHoursUsed)
+	}
+	fmt.Fprintln(&tw)
+
+	fmt.Fprintf(&tw, "queue vs. run time (total queue %s, run %s):\n", a.QueueTime, a.RunTime)
+	fmt.Fprintln(&tw, "\tflowid\tident\tqueue\trun")
+	for _, t := range a.Tasks {
+		fmt.Fprintf(&tw, "\t%s\t%s\t%s\t%s\n", t.FlowID, t.Ident, t.Queue, t.Run)
+	}
+	fmt.Fprintln(&tw)
+
+	rate := "n/a"
+	if a.Cache.HitRate >= 0 {
+		rate = fmt.Sprintf("%.1f%%", 100*a.Cache.HitRate)
+	}
+	fmt.Fprintf(&tw, "cache hit rate:\t%s (%d hits, %d misses)\n", rate, a.Cache.Hits, a.Cache.Misses)
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/taskdb"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+func TestAnalyzeRun(t *testing.T) {
+	var (
+		a = &flow.Flow{Op: flow.Exec, Ident: "a", Image: "img1", Cmd: "a", State: flow.Done}
+		b = &flow.Flow{Op: flow.Exec, Ident: "b", Image: "img1", Cmd: "b", State: flow.Done, Cached: true}
+		c = &flow.Flow{Op: flow.Exec, Ident: "c", Image: "img2", Cmd: "c", State: flow.Done, Deps: []*flow.Flow{a, b}}
+	)
+	fg := simple.NewDirectedGraph()
+	for _, f := range []*flow.Flow{a, b, c} {
+		fg.AddNode(flow.Node{Flow: f, Analysis: true})
+	}
+	fg.SetEdge(flow.Edge{Edge: fg.NewEdge(flow.Node{Flow: c, Analysis: true}, flow.Node{Flow: a, Analysis: true})})
+	fg.SetEdge(flow.Edge{Edge: fg.NewEdge(flow.Node{Flow: c, Analysis: true}, flow.Node{Flow: b, Analysis: true})})
+	p, err := dot.Marshal(fg, "reflow flowgraph test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := parseEvalGraph(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(g.Nodes), 3; got != want {
+		t.Fatalf("got %v nodes, want %v", got, want)
+	}
+	if n := g.Node(c.Digest()); n == nil || n.Ident != "c" || len(n.Deps) != 2 {
+		t.Fatalf("bad node for c: %+v", n)
+	}
+
+	start := time.Now()
+	task := func(f *flow.Flow, begin, queue, run time.Duration, cpu float64, mem data.Size) taskInfo {
+		inspect := reflow.ExecInspect{
+			Created: start.Add(begin + queue),
+			Config: reflow.ExecConfig{
+				Type:      "exec",
+				Image:     f.Image,
+				Ident:     f.Ident,
+				Resources: reflow.Resources{"cpu": 4, "mem": float64(8 * data.GiB)},
+			},
+			Profile: reflow.Profile{},
+		}
+		inspect.Profile["cpu"] = struct {
+			Max, Mean, Var float64
+			N              int64
+			First, Last    time.Time
+		}{Mean: cpu}
+		inspect.Profile["mem"] = struct {
+			Max, Mean, Var float64
+			N              int64
+			First, Last    time.Time
+		}{Max: float64(mem)}
+		return taskInfo{
+			Task: taskdb.Task{
+				FlowID: f.Digest(),
+				Ident:  f.Ident,
+				Start:  start.Add(begin),
+				End:    start.Add(begin + queue + run),
+			},
+			ExecInspect: inspect,
+		}
+	}
+	tasks := []taskInfo{
+		task(a, 0, time.Minute, 10*time.Minute, 2, 2*data.GiB),
+		task(c, 11*time.Minute, 0, 5*time.Minute, 4, 8*data.GiB),
+	}
+	run := taskdb.Run{ID: taskdb.NewRunID(), Start: start, End: start.Add(16 * time.Minute)}
+	an := analyzeRun(run, tasks, g)
+
+	if got, want := len(an.CriticalPath), 2; got != want {
+		t.Fatalf("got %v critical steps, want %v", got, want)
+	}
+	if got, want := an.CriticalPath[0].Ident, "a"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := an.CriticalPath[1].Ident, "c"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(an.CriticalPathDuration), 16*time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := an.Cache, (cacheStats{Hits: 1, Misses: 2, HitRate: 1.0 / 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := time.Duration(an.QueueTime), time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(an.Images), 2; got != want {
+		t.Fatalf("got %v images, want %v", got, want)
+	}
+	img1 := an.Images[0]
+	if got, want := img1.Image, "img1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := img1.CPUUtilization, 0.5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := img1.MemUtilization, 0.25; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
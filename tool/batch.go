@@ -148,7 +148,13 @@ flags override any parameters in the batch sample file.
 		schedCtx, schedCancel = context.WithCancel(ctx)
 	)
 	if config.Sched {
-		scheduler, err = NewScheduler(schedCtx, c.Config, &wg, nil, nil, c.Status)
+		cluster, err := clusterInstance(c.Config, c.Schema, c.Status)
+		c.must(err)
+		if config.SchedService != "" {
+			scheduler, err = NewRemoteScheduler(schedCtx, c.Config, &wg, config.SchedService, cluster, nil)
+		} else {
+			scheduler, err = NewScheduler(schedCtx, c.Config, &wg, cluster, nil, c.Status)
+		}
 		c.must(err)
 	}
 
@@ -163,6 +169,7 @@ flags override any parameters in the batch sample file.
 			Transferer:         transferer,
 			TaskDB:             tdb,
 			Scheduler:          scheduler,
+			User:               string(*user),
 		},
 		Args:   flags.Args(),
 		Rundir: c.rundir(),
@@ -170,6 +177,7 @@ flags override any parameters in the batch sample file.
 		Status: c.Status.Groupf("batch %s", wd),
 	}
 	c.must(config.Configure(&b.EvalConfig))
+	c.must(configureAssertPolicy(&b.EvalConfig, c.Config, config.Assert))
 	bc.Configure(b)
 	c.must(b.Init(*resetFlag, *retryFlag))
 
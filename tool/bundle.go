@@ -20,7 +20,10 @@ suffix ".rfx".
 Reflow bundles are interchangeable with other Reflow modules: they
 may be run with command run or imported by other Reflow modules. Any
 flags provided as arguments provide default values to the module's
-parameters.`
+parameters.
+
+If the module has a lockfile (see command lock), its image lock is
+embedded in the bundle.`
 	c.Parse(flags, args, help, "bundle [-o output] path [args]")
 	if flags.NArg() == 0 {
 		flags.Usage()
@@ -36,6 +39,11 @@ parameters.`
 	m, err := sess.Open(file)
 	c.must(err)
 	c.must(m.InjectArgs(sess, args))
+	lock, err := readImageLock(lockfilePath(file))
+	c.must(err)
+	if lock != nil {
+		sess.SetImageLock(lock)
+	}
 	if *out == "" {
 		*out = filepath.Base(file) + "x" // ".rfx"
 	}
@@ -8,10 +8,28 @@ import (
 	"bufio"
 	"context"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/batch"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/flow"
+	infra2 "github.com/grailbio/reflow/infra"
+	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/tiered"
 )
 
 func (c *Cmd) rmcache(ctx context.Context, args ...string) {
@@ -45,3 +63,839 @@ Items are digests read from the standard input.`
 	}
 	c.Log.Debugf("removed %d keys", n)
 }
+
+func (c *Cmd) cache(ctx context.Context, args ...string) {
+	var (
+		flags = flag.NewFlagSet("cache", flag.ExitOnError)
+		help  = `Cache inspects and manipulates the cached results of a Reflow program.
+
+The cache subcommands are:
+
+	ls	list the cached nodes of a program
+	rm	remove the cached results of selected nodes of a program
+	why	explain why a node of a program would miss the cache
+	export	export the cached results of a program or run to an archive
+	import	import cached results from an archive
+	warm	copy a program's cached results from another cache
+
+Cache subcommands simulate the evaluation of the given program (with
+its arguments) by performing cache lookups in place of executor
+evaluation, as in "reflow repair". Thus only the nodes that can be
+reached this way are considered: nodes downstream of a cache miss
+are not.
+
+Run "reflow cache <subcommand> -help" for help on each subcommand.`
+	)
+	c.Parse(flags, args, help, "cache ls|rm|why|export|import|warm [flags] program [args]")
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	cmd, args := flags.Arg(0), flags.Args()[1:]
+	switch cmd {
+	case "ls":
+		c.cacheLs(ctx, args...)
+	case "rm":
+		c.cacheRm(ctx, args...)
+	case "why":
+		c.cacheWhy(ctx, args...)
+	case "export":
+		c.cacheExport(ctx, args...)
+	case "import":
+		c.cacheImport(ctx, args...)
+	case "warm":
+		c.cacheWarm(ctx, args...)
+	default:
+		c.Errorf("unknown cache subcommand %s\n", cmd)
+		flags.Usage()
+	}
+}
+
+// cacheEntry is an external flow node reached while walking
+// a program through the cache.
+type cacheEntry struct {
+	// Flow is the flow node.
+	Flow *flow.Flow
+	// Key is the cache key that was hit, and Fileset is the ID of the
+	// cached fileset. Both are zero on cache misses.
+	Key, Fileset digest.Digest
+	// LastAccess and Labels are the last access time and labels of
+	// the cache key, if known.
+	LastAccess time.Time
+	Labels     []string
+}
+
+// Hit tells whether the entry's flow was found in the cache.
+func (e cacheEntry) Hit() bool {
+	return !e.Key.IsZero()
+}
+
+// Size returns the size of the entry's cached value.
+func (e cacheEntry) Size() int64 {
+	if fs, ok := e.Flow.Value.(reflow.Fileset); ok && e.Hit() {
+		return fs.Size()
+	}
+	return 0
+}
+
+// cacheWalk evaluates the program given by args (a path followed by
+// its arguments) and simulates its evaluation through the cache. It
+// returns the external nodes that were reached, ordered by source
+// position and identifier.
+func (c *Cmd) cacheWalk(ctx context.Context, args []string) []cacheEntry {
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	return c.walkCache(ctx, ass, repo, args)
+}
+
+// walkCache is like cacheWalk, but walks the program through the
+// provided assoc and repository.
+func (c *Cmd) walkCache(ctx context.Context, ass assoc.Assoc, repo reflow.Repository, args []string) []cacheEntry {
+	e := Eval{InputArgs: args}
+	c.must(e.Run())
+	c.must(e.ResolveImages(c.Config))
+	var entries []cacheEntry
+	repair := flow.NewRepair(flow.EvalConfig{
+		Log:        c.Log,
+		Repository: repo,
+		Assoc:      ass,
+		ImageMap:   e.ImageMap,
+	})
+	repair.NoWriteback = true
+	repair.Visit = func(f *flow.Flow, key, fsid digest.Digest) {
+		entries = append(entries, cacheEntry{Flow: f, Key: key, Fileset: fsid})
+	}
+	repair.Do(ctx, e.Main())
+	c.must(repair.Done())
+	sort.SliceStable(entries, func(i, j int) bool {
+		fi, fj := entries[i].Flow, entries[j].Flow
+		if fi.Position != fj.Position {
+			return fi.Position < fj.Position
+		}
+		return fi.Ident < fj.Ident
+	})
+	return entries
+}
+
+// scanAccess populates the last access times and labels of the
+// provided entries by scanning the assoc's fileset mappings.
+func (c *Cmd) scanAccess(ctx context.Context, entries []cacheEntry) {
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	index := make(map[digest.Digest][]int)
+	for i, e := range entries {
+		if e.Hit() {
+			index[e.Key] = append(index[e.Key], i)
+		}
+	}
+	if len(index) == 0 {
+		return
+	}
+	var mu sync.Mutex
+	err := ass.Scan(ctx, assoc.Fileset, assoc.MappingHandlerFunc(func(k digest.Digest, _ []digest.Digest, _ assoc.Kind, lastAccess time.Time, labels []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range index[k] {
+			entries[i].LastAccess = lastAccess
+			entries[i].Labels = labels
+		}
+	}))
+	if err != nil {
+		c.Errorf("assoc scan: %v\n", err)
+	}
+}
+
+func (c *Cmd) cacheLs(ctx context.Context, args ...string) {
+	var (
+		flags    = flag.NewFlagSet("cache ls", flag.ExitOnError)
+		allFlag  = flags.Bool("a", false, "also list nodes that are not cached")
+		scanFlag = flags.Bool("scan", true, "scan the cache for the last access time and labels of each entry; this may be slow for large caches")
+		help     = `Cache ls lists the cached nodes of a program: for each exec, intern
+and extern node reached through the cache, it displays the node's flow
+digest, identifier, source position, the size of its cached value and
+the last time its cache entry was accessed.`
+	)
+	c.Parse(flags, args, help, "cache ls [-a] [-scan=false] program [args]")
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	entries := c.cacheWalk(ctx, flags.Args())
+	if *scanFlag {
+		c.scanAccess(ctx, entries)
+	}
+	var tw tabwriter.Writer
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(&tw, "flowid\top\tident\tposition\tsize\tlastaccess\tlabels")
+	for _, e := range entries {
+		if !e.Hit() && !*allFlag {
+			continue
+		}
+		var size, access string
+		switch {
+		case !e.Hit():
+			size = "(miss)"
+		default:
+			size = data.Size(e.Size()).String()
+		}
+		if !e.LastAccess.IsZero() {
+			access = e.LastAccess.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Flow.Digest().Short(), e.Flow.Op, e.Flow.Ident, e.Flow.Position,
+			size, access, strings.Join(e.Labels, ","))
+	}
+}
+
+func (c *Cmd) cacheRm(ctx context.Context, args ...string) {
+	var (
+		flags        = flag.NewFlagSet("cache rm", flag.ExitOnError)
+		identFlag    = flags.String("ident", "", "remove nodes with this identifier")
+		positionFlag = flags.String("position", "", "remove nodes at this source position (file:line or file:line:col)")
+		regexpFlag   = flags.String("regexp", "", "remove nodes whose identifier matches this regular expression")
+		dryRunFlag   = flags.Bool("n", false, "print the entries that would be removed without removing them")
+		help         = `Cache rm removes the cached results of the selected nodes of a program.
+Nodes are selected by identifier, source position or a regular
+expression over identifiers; a node must match all of the provided
+selectors. All of the cache keys of each selected node are removed.`
+	)
+	c.Parse(flags, args, help, "cache rm [-ident ident] [-position pos] [-regexp re] [-n] program [args]")
+	if flags.NArg() == 0 || (*identFlag == "" && *positionFlag == "" && *regexpFlag == "") {
+		flags.Usage()
+	}
+	var re *regexp.Regexp
+	if *regexpFlag != "" {
+		var err error
+		if re, err = regexp.Compile(*regexpFlag); err != nil {
+			c.Fatalf("invalid regular expression %s: %v", *regexpFlag, err)
+		}
+	}
+	match := func(f *flow.Flow) bool {
+		if *identFlag != "" && f.Ident != *identFlag {
+			return false
+		}
+		if *positionFlag != "" && f.Position != *positionFlag && !strings.HasPrefix(f.Position, *positionFlag+":") {
+			return false
+		}
+		return re == nil || re.MatchString(f.Ident)
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var n int
+	for _, e := range c.cacheWalk(ctx, flags.Args()) {
+		if !e.Hit() || !match(e.Flow) {
+			continue
+		}
+		c.Log.Printf("remove %s %s (%s)", e.Flow.Digest().Short(), e.Flow.Ident, e.Flow.Position)
+		if *dryRunFlag {
+			continue
+		}
+		for _, key := range e.Flow.CacheKeys() {
+			if err := ass.Delete(ctx, key); err != nil {
+				c.Log.Errorf("failed to delete %s: %v", key, err)
+				continue
+			}
+			c.Log.Debugf("removed key %v", key)
+			n++
+		}
+	}
+	c.Log.Debugf("removed %d keys", n)
+}
+
+func (c *Cmd) cacheWhy(ctx context.Context, args ...string) {
+	var (
+		flags     = flag.NewFlagSet("cache why", flag.ExitOnError)
+		graphFlag = flags.String("graph", "", "compare against this run's evaluation graph (a run id, graph digest or dot file) instead of the last run's")
+		help      = `Cache why explains why the nodes of a program with the given identifier
+would miss the cache.
+
+A node misses either because one of its dependencies misses, or
+because its own cache keys are missing. In the latter case, cache why
+compares the node with its evaluation in the last run that executed
+it (as recorded in the taskdb or, if no taskdb is configured, in the
+local run directory) and reports which of its dependencies' digests
+changed, recursively, down to the first differing node.`
+	)
+	c.Parse(flags, args, help, "cache why [-graph run] program [args] ident")
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	ident := flags.Arg(flags.NArg() - 1)
+	entries := c.cacheWalk(ctx, flags.Args()[:flags.NArg()-1])
+	byFlow := make(map[*flow.Flow]cacheEntry)
+	var matched []cacheEntry
+	for _, e := range entries {
+		byFlow[e.Flow] = e
+		if e.Flow.Ident == ident {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		fmt.Fprintf(c.Stdout, "%s is not reached through the cache; evaluation first misses at:\n", ident)
+		for _, e := range entries {
+			if !e.Hit() && len(cacheDepMisses(e.Flow, byFlow)) == 0 {
+				fmt.Fprintf(c.Stdout, "\t%s %s (%s)\n", e.Flow.Digest().Short(), e.Flow.Ident, e.Flow.Position)
+			}
+		}
+		return
+	}
+	var (
+		prev     *evalGraph
+		prevErr  error
+		prevOnce sync.Once
+	)
+	prevGraph := func() (*evalGraph, error) {
+		prevOnce.Do(func() {
+			if *graphFlag != "" {
+				prev, prevErr = c.findEvalGraph(ctx, *graphFlag, "")
+			} else {
+				prev, prevErr = c.lastEvalGraph(ctx, ident)
+			}
+		})
+		return prev, prevErr
+	}
+	w := &cacheWhyWriter{Cmd: c, byFlow: byFlow, prevGraph: prevGraph, seen: make(map[*flow.Flow]bool)}
+	for _, e := range matched {
+		w.explain(e, "")
+	}
+}
+
+// cacheWhyWriter explains cache misses.
+type cacheWhyWriter struct {
+	*Cmd
+	byFlow    map[*flow.Flow]cacheEntry
+	prevGraph func() (*evalGraph, error)
+	seen      map[*flow.Flow]bool
+}
+
+// maxWhyDepth bounds the depth at which dependency changes
+// are explained.
+const maxWhyDepth = 16
+
+func (w *cacheWhyWriter) explain(e cacheEntry, indent string) {
+	f := e.Flow
+	name := fmt.Sprintf("%s %s %s (%s)", f.Digest().Short(), f.Op, f.Ident, f.Position)
+	if w.seen[f] {
+		fmt.Fprintf(w.Stdout, "%s%s: see above\n", indent, name)
+		return
+	}
+	w.seen[f] = true
+	if e.Hit() {
+		fmt.Fprintf(w.Stdout, "%s%s: cached under key %s (fileset %s, %s)\n",
+			indent, name, e.Key.Short(), e.Fileset.Short(), data.Size(e.Size()))
+		return
+	}
+	if misses := cacheDepMisses(f, w.byFlow); len(misses) > 0 {
+		fmt.Fprintf(w.Stdout, "%s%s: would miss because its dependencies would miss:\n", indent, name)
+		for _, m := range misses {
+			w.explain(m, indent+"\t")
+		}
+		return
+	}
+	fmt.Fprintf(w.Stdout, "%s%s: would miss: none of its %d cache keys are present\n", indent, name, len(f.CacheKeys()))
+	g, err := w.prevGraph()
+	if err != nil {
+		fmt.Fprintf(w.Stdout, "%s\tno previous evaluation found: %v\n", indent, err)
+		return
+	}
+	p := closestEvalNode(g, f)
+	if p == nil {
+		fmt.Fprintf(w.Stdout, "%s\tnot evaluated by previous run (%s)\n", indent, g.Name)
+		return
+	}
+	if p.Matches(f.Digest()) {
+		fmt.Fprintf(w.Stdout, "%s\tflow digest unchanged since previous run (%s): the cache entry was removed or has expired\n", indent, g.Name)
+		return
+	}
+	fmt.Fprintf(w.Stdout, "%s\tflow digest changed since previous run (%s): %s -> %s\n", indent, g.Name, p.Short, f.Digest().Short())
+	w.diff(f, p, indent+"\t", 0)
+}
+
+// diff reports how the flow f differs from its previous
+// evaluation p by comparing their dependencies.
+func (w *cacheWhyWriter) diff(f *flow.Flow, p *evalNode, indent string, depth int) {
+	type depKey struct{ op, ident string }
+	var (
+		keys    []depKey
+		cur     = make(map[depKey][]*flow.Flow)
+		prev    = make(map[depKey][]*evalNode)
+		changed bool
+	)
+	for _, dep := range f.Deps {
+		k := depKey{dep.Op.String(), dep.Ident}
+		if _, ok := cur[k]; !ok {
+			keys = append(keys, k)
+		}
+		cur[k] = append(cur[k], dep)
+	}
+	for _, dep := range p.Deps {
+		k := depKey{dep.Op, dep.Ident}
+		if _, ok := cur[k]; !ok {
+			if _, ok := prev[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		prev[k] = append(prev[k], dep)
+	}
+	for _, k := range keys {
+		c, p := cur[k], prev[k]
+		switch {
+		case len(p) == 0:
+			changed = true
+			fmt.Fprintf(w.Stdout, "%sdependency %s %s was added\n", indent, k.op, k.ident)
+		case len(c) == 0:
+			changed = true
+			fmt.Fprintf(w.Stdout, "%sdependency %s %s was removed\n", indent, k.op, k.ident)
+		case len(c) == 1 && len(p) == 1:
+			if p[0].Matches(c[0].Digest()) {
+				continue
+			}
+			changed = true
+			fmt.Fprintf(w.Stdout, "%sdependency %s %s (%s) changed: %s -> %s\n",
+				indent, k.op, k.ident, c[0].Position, p[0].Short, c[0].Digest().Short())
+			if depth < maxWhyDepth {
+				w.diff(c[0], p[0], indent+"\t", depth+1)
+			}
+		default:
+			var n int
+			for _, dep := range c {
+				if !matchesAny(p, dep.Digest()) {
+					n++
+				}
+			}
+			if n > 0 || len(c) != len(p) {
+				changed = true
+				fmt.Fprintf(w.Stdout, "%s%d of %d dependencies %s %s changed (previously %d)\n", indent, n, len(c), k.op, k.ident, len(p))
+			}
+		}
+	}
+	if changed {
+		return
+	}
+	if len(p.Components) > 0 {
+		for _, d := range diffComponents(p.Components, f.DigestComponents()) {
+			if d[1].Material() && !d[1].IsDep() {
+				changed = true
+				fmt.Fprintf(w.Stdout, "%s%s\n", indent, describeComponentDiff(d[0], d[1]))
+			}
+		}
+		if changed {
+			return
+		}
+	}
+	switch f.Op {
+	case flow.Exec:
+		fmt.Fprintf(w.Stdout, "%sthe node's own definition changed: image %s, command %q, resources %s\n",
+			indent, f.Image, f.Cmd, f.Resources)
+	case flow.Intern, flow.Extern:
+		fmt.Fprintf(w.Stdout, "%sthe node's own definition changed: url %s\n", indent, f.URL)
+	default:
+		fmt.Fprintf(w.Stdout, "%sthe node's own definition changed\n", indent)
+	}
+}
+
+func matchesAny(nodes []*evalNode, d digest.Digest) bool {
+	for _, n := range nodes {
+		if n.Matches(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheDepMisses returns the nearest external dependencies
+// of f that miss the cache.
+func cacheDepMisses(f *flow.Flow, byFlow map[*flow.Flow]cacheEntry) []cacheEntry {
+	var (
+		misses []cacheEntry
+		seen   = make(map[*flow.Flow]bool)
+		walk   func(f *flow.Flow)
+	)
+	walk = func(f *flow.Flow) {
+		for _, dep := range f.Deps {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if e, ok := byFlow[dep]; ok {
+				if !e.Hit() {
+					misses = append(misses, e)
+				}
+				continue
+			}
+			walk(dep)
+		}
+	}
+	walk(f)
+	return misses
+}
+
+// closestEvalNode returns the node in g that best corresponds to flow
+// f: the node with f's digest, if any, or else the node with f's op
+// and identifier that shares the most dependencies with f.
+func closestEvalNode(g *evalGraph, f *flow.Flow) *evalNode {
+	if n := g.Node(f.Digest()); n != nil {
+		return n
+	}
+	var (
+		best  *evalNode
+		score = -1
+	)
+	for _, n := range g.Nodes {
+		if n.Op != f.Op.String() || n.Ident != f.Ident {
+			continue
+		}
+		var s int
+		for _, dep := range f.Deps {
+			if matchesAny(n.Deps, dep.Digest()) {
+				s++
+			}
+		}
+		if s > score {
+			best, score = n, s
+		}
+	}
+	return best
+}
+
+// lastEvalGraph returns the evaluation graph of the last run that
+// evaluated a node with the provided identifier. The run is looked up
+// in the taskdb if one is configured, and in the local run directory
+// otherwise.
+func (c *Cmd) lastEvalGraph(ctx context.Context, ident string) (*evalGraph, error) {
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err == nil && tdb != nil {
+		tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{Ident: ident})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(tasks, func(i, j int) bool { return tasks[i].Start.After(tasks[j].Start) })
+		for _, task := range tasks {
+			runs, err := tdb.Runs(ctx, taskdb.RunQuery{ID: task.RunID})
+			if err != nil || len(runs) != 1 || runs[0].EvalGraph.IsZero() {
+				continue
+			}
+			var repo reflow.Repository
+			c.must(c.Config.Instance(&repo))
+			return loadEvalGraph(ctx, repo, runs[0].EvalGraph)
+		}
+		return nil, errors.E("cache why", ident, errors.NotExist, errors.New("no previous run with an evaluation graph"))
+	}
+	paths, err := filepath.Glob(filepath.Join(c.rundir(), "*.gv"))
+	if err != nil {
+		return nil, err
+	}
+	mtime := make(map[string]time.Time)
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			mtime[path] = info.ModTime()
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return mtime[paths[i]].After(mtime[paths[j]]) })
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		g, err := parseEvalGraph(b)
+		if err != nil {
+			c.Log.Debugf("%s: %v", path, err)
+			continue
+		}
+		for _, n := range g.Nodes {
+			if n.Ident == ident {
+				return g, nil
+			}
+		}
+	}
+	return nil, errors.E("cache why", ident, errors.NotExist, errors.New("no previous local run"))
+}
+
+func (c *Cmd) cacheExport(ctx context.Context, args ...string) {
+	var (
+		flags   = flag.NewFlagSet("cache export", flag.ExitOnError)
+		outFlag = flags.String("o", "", "write the archive to this file instead of the standard output")
+		help    = `Cache export writes the cached results of a program, or of a run, to a
+portable archive, which may be imported into another Reflow
+installation with "reflow cache import".
+
+When a program (with its arguments) is given, the program is walked
+through the cache as in "reflow cache ls", and each cache hit is
+exported under all of its node's cache keys. When a run id is given,
+the results and exec inspects of the run's tasks, as recorded in the
+taskdb, are exported. In both cases, the archive includes the
+repository objects referenced by the exported results: filesets, the
+files in them, and exec inspects. Results whose objects are missing
+from the repository are skipped.`
+	)
+	c.Parse(flags, args, help, "cache export [-o archive] program [args] | runid")
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	var mappings []cacheMapping
+	if id, err := reflow.Digester.Parse(flags.Arg(0)); err == nil && flags.NArg() == 1 {
+		mappings = c.runMappings(ctx, taskdb.RunID(id))
+	} else {
+		for _, e := range c.cacheWalk(ctx, flags.Args()) {
+			if !e.Hit() {
+				continue
+			}
+			for _, key := range e.Flow.CacheKeys() {
+				mappings = append(mappings, cacheMapping{
+					Kind: assoc.Fileset.String(), Key: key, Value: e.Fileset, Ident: e.Flow.Ident})
+			}
+		}
+	}
+	w := c.Stdout
+	if *outFlag != "" {
+		f, err := os.Create(*outFlag)
+		c.must(err)
+		defer func() { c.must(f.Close()) }()
+		w = f
+	}
+	stats, err := exportCache(ctx, w, repo, mappings)
+	c.must(err)
+	c.Log.Printf("exported %s", stats)
+}
+
+// runMappings returns the fileset and exec inspect mappings of the
+// tasks of the provided run, as recorded in the taskdb.
+func (c *Cmd) runMappings(ctx context.Context, id taskdb.RunID) []cacheMapping {
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil || tdb == nil {
+		c.Fatal("cache export: exporting runs requires a taskdb")
+	}
+	runs, err := tdb.Runs(ctx, taskdb.RunQuery{ID: id})
+	c.must(err)
+	if len(runs) == 0 {
+		c.Fatalf("cache export: run %s not found", id.IDShort())
+	}
+	tasks, err := tdb.Tasks(ctx, taskdb.TaskQuery{RunID: id})
+	c.must(err)
+	var mappings []cacheMapping
+	for _, task := range tasks {
+		if !task.ResultID.IsZero() {
+			mappings = append(mappings, cacheMapping{
+				Kind: assoc.Fileset.String(), Key: task.FlowID, Value: task.ResultID, Ident: task.Ident})
+		}
+		if !task.Inspect.IsZero() {
+			mappings = append(mappings, cacheMapping{
+				Kind: assoc.ExecInspect.String(), Key: task.FlowID, Value: task.Inspect, Ident: task.Ident})
+		}
+	}
+	return mappings
+}
+
+func (c *Cmd) cacheImport(ctx context.Context, args ...string) {
+	var (
+		flags      = flag.NewFlagSet("cache import", flag.ExitOnError)
+		dryRunFlag = flags.Bool("n", false, "verify the archive without importing it")
+		help       = `Cache import imports cached results from an archive written by
+"reflow cache export" into the configured repository and assoc. The
+archive is read from the given file, or from the standard input if
+the file is "-".
+
+The digest of each object in the archive is verified as it is
+written to the repository, and a result's cache mapping is written
+to the assoc only after all of the objects that it references are
+present in the repository. Objects already present in the repository
+are not rewritten.`
+	)
+	c.Parse(flags, args, help, "cache import [-n] archive")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		c.must(err)
+		defer f.Close()
+		r = f
+	}
+	stats, err := importCache(ctx, r, repo, ass, *dryRunFlag)
+	c.must(err)
+	if *dryRunFlag {
+		c.Log.Printf("verified %s", stats)
+	} else {
+		c.Log.Printf("imported %s", stats)
+	}
+}
+
+func (c *Cmd) cacheWarm(ctx context.Context, args ...string) {
+	var (
+		flags      = flag.NewFlagSet("cache warm", flag.ExitOnError)
+		assocFlag  = flags.String("assoc", "", "the assoc provider of the cache to warm from, e.g., dynamodbassoc,table=team-reflow")
+		repoFlag   = flags.String("repository", "", "the repository provider of the cache to warm from, e.g., s3repository,bucket=team-reflow")
+		batchFlag  = flags.String("batch", "", "warm the cache for each run of the batch defined by this batch configuration file")
+		dryRunFlag = flags.Bool("n", false, "report coverage without copying any cached results")
+		help       = `Cache warm copies the cached results of a program, or of each run
+of a batch, from another cache into the configured one, and reports
+how much of the program's evaluation is covered by the cache.
+
+The other cache is given by an assoc provider (-assoc), a repository
+provider (-repository), or both; when only one is given, the other is
+taken from the current configuration. The program is walked through
+both caches as in "reflow cache ls", with the configured cache
+consulted first: each cached result found only in the other cache is
+copied, together with the repository objects it references, into the
+configured cache.
+
+With -batch, each run of the batch is warmed: the batch's program is
+walked with each run's parameters, and any remaining arguments are
+passed to every run, as in "reflow runbatch".
+
+For each program or run, cache warm reports the number of nodes
+reached through the cache; of these, the number found in the
+configured cache, the number copied from the other cache, and the
+number that miss both. Nodes downstream of a miss are not reached.`
+	)
+	c.Parse(flags, args, help, "cache warm [-assoc provider] [-repository provider] [-n] [-batch config] program [args]")
+	if *assocFlag == "" && *repoFlag == "" || *batchFlag == "" && flags.NArg() == 0 {
+		flags.Usage()
+	}
+	var ass assoc.Assoc
+	c.must(c.Config.Instance(&ass))
+	var repo reflow.Repository
+	c.must(c.Config.Instance(&repo))
+	keys := c.Config.Keys.Clone()
+	if *assocFlag != "" {
+		keys[infra2.Assoc] = *assocFlag
+	}
+	if *repoFlag != "" {
+		keys[infra2.Repository] = *repoFlag
+	}
+	config, err := c.Schema.Make(keys)
+	c.must(err)
+	c.must(configureTiers(config, c.Schema))
+	var (
+		fromAss  assoc.Assoc
+		fromRepo reflow.Repository
+	)
+	c.must(config.Instance(&fromAss))
+	c.must(config.Instance(&fromRepo))
+
+	var (
+		mu    sync.Mutex
+		tiers = make(map[digest.Digest]int)
+	)
+	cache := &tiered.Cache{
+		Tiers: []tiered.Tier{
+			{Name: "local", Assoc: ass, Repository: repo},
+			{Name: "remote", Assoc: fromAss, Repository: fromRepo},
+		},
+		NoPromote: *dryRunFlag,
+		Visit: func(kind assoc.Kind, k digest.Digest, tier int) {
+			if kind != assoc.Fileset {
+				return
+			}
+			mu.Lock()
+			if _, ok := tiers[k]; !ok {
+				tiers[k] = tier
+			}
+			mu.Unlock()
+		},
+		Log: c.Log,
+	}
+
+	type run struct {
+		name string
+		args []string
+	}
+	var runs []run
+	if *batchFlag != "" {
+		b := &batch.Batch{}
+		(&batchConfig{configFilepath: *batchFlag}).Configure(b)
+		batchRuns, err := b.ReadRuns()
+		c.must(err)
+		for _, r := range batchRuns {
+			args := []string{r.Program}
+			var names []string
+			for name := range r.Args {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				args = append(args, fmt.Sprintf("-%s=%s", name, r.Args[name]))
+			}
+			args = append(args, flags.Args()...)
+			args = append(args, r.Argv...)
+			runs = append(runs, run{r.ID, args})
+		}
+	} else {
+		runs = append(runs, run{flags.Arg(0), flags.Args()})
+	}
+
+	var tw tabwriter.Writer
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintln(&tw, "run	nodes	cached	copied	missed	coverage")
+	var total cacheCoverage
+	for _, r := range runs {
+		var cov cacheCoverage
+		for _, e := range c.walkCache(ctx, cache.Assoc(), cache.Repository(), r.args) {
+			mu.Lock()
+			tier, ok := tiers[e.Key]
+			mu.Unlock()
+			cov.add(e.Hit(), ok && tier > 0)
+		}
+		fmt.Fprintf(&tw, "%s\t%s\n", r.name, cov)
+		total.merge(cov)
+	}
+	if len(runs) > 1 {
+		fmt.Fprintf(&tw, "total\t%s\n", total)
+	}
+	tw.Flush()
+	stats := cache.Stats()
+	if *dryRunFlag {
+		c.Log.Printf("would copy %d results", total.Copied)
+	} else {
+		c.Log.Printf("copied %d results (%d objects, %s)", stats.Promoted, stats.Objects, data.Size(stats.Bytes))
+	}
+}
+
+// cacheCoverage counts the external nodes of a program that are
+// reached through a tiered cache.
+type cacheCoverage struct {
+	// Nodes is the number of nodes reached.
+	Nodes int
+	// Cached is the number of nodes found in the primary tier, and
+	// Copied the number found only in another tier.
+	Cached, Copied int
+}
+
+func (c *cacheCoverage) add(hit, copied bool) {
+	c.Nodes++
+	switch {
+	case copied:
+		c.Copied++
+	case hit:
+		c.Cached++
+	}
+}
+
+func (c *cacheCoverage) merge(d cacheCoverage) {
+	c.Nodes += d.Nodes
+	c.Cached += d.Cached
+	c.Copied += d.Copied
+}
+
+// Missed returns the number of nodes that missed all tiers.
+func (c cacheCoverage) Missed() int {
+	return c.Nodes - c.Cached - c.Copied
+}
+
+// String formats the coverage as tab-separated columns: nodes, cached,
+// copied, missed, and the percentage of nodes that hit.
+func (c cacheCoverage) String() string {
+	var pct float64
+	if c.Nodes > 0 {
+		pct = 100 * float64(c.Cached+c.Copied) / float64(c.Nodes)
+	}
+	return fmt.Sprintf("%d\t%d\t%d\t%d\t%.1f%%", c.Nodes, c.Cached, c.Copied, c.Missed(), pct)
+}
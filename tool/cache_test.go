@@ -0,0 +1,244 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/repository"
+	"github.com/grailbio/reflow/test/testutil"
+)
+
+func TestCacheDepMisses(t *testing.T) {
+	var (
+		in   = &flow.Flow{Op: flow.Intern, Ident: "in"}
+		hit  = &flow.Flow{Op: flow.Exec, Ident: "hit", Image: "img", Cmd: "hit", Deps: []*flow.Flow{in}}
+		miss = &flow.Flow{Op: flow.Exec, Ident: "miss", Image: "img", Cmd: "miss", Deps: []*flow.Flow{in}}
+		// The merge is not external; misses are found through it.
+		merge = &flow.Flow{Op: flow.Merge, Deps: []*flow.Flow{hit, miss}}
+		root  = &flow.Flow{Op: flow.Exec, Ident: "root", Image: "img", Cmd: "root", Deps: []*flow.Flow{merge}}
+	)
+	key := reflow.Digester.FromString("key")
+	byFlow := map[*flow.Flow]cacheEntry{
+		in:   {Flow: in, Key: key},
+		hit:  {Flow: hit, Key: key},
+		miss: {Flow: miss},
+		root: {Flow: root},
+	}
+	misses := cacheDepMisses(root, byFlow)
+	if got, want := len(misses), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := misses[0].Flow, miss; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := cacheDepMisses(miss, byFlow); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+}
+
+func TestClosestEvalNode(t *testing.T) {
+	var (
+		a    = &flow.Flow{Op: flow.Exec, Ident: "a", Image: "img", Cmd: "a"}
+		b    = &flow.Flow{Op: flow.Exec, Ident: "b", Image: "img", Cmd: "b"}
+		bnew = &flow.Flow{Op: flow.Exec, Ident: "b", Image: "img", Cmd: "b2"}
+		c    = &flow.Flow{Op: flow.Exec, Ident: "c", Image: "img", Cmd: "c", Deps: []*flow.Flow{a, b}}
+		cnew = &flow.Flow{Op: flow.Exec, Ident: "c", Image: "img", Cmd: "c", Deps: []*flow.Flow{a, bnew}}
+		// cother shares no dependencies with cnew.
+		cother = &flow.Flow{Op: flow.Exec, Ident: "c", Image: "img", Cmd: "c", Deps: []*flow.Flow{b}}
+	)
+	node := func(f *flow.Flow) string {
+		return fmt.Sprintf("%q [digest=%q op=%s ident=%s];", f.Digest().Short()+"-exec-"+f.Ident, f.Digest(), f.Op, f.Ident)
+	}
+	edge := func(from, to *flow.Flow) string {
+		return fmt.Sprintf("%q -> %q;", from.Digest().Short()+"-exec-"+from.Ident, to.Digest().Short()+"-exec-"+to.Ident)
+	}
+	src := "strict digraph \"prev\" {\n"
+	for _, f := range []*flow.Flow{a, b, c, cother} {
+		src += node(f) + "\n"
+	}
+	for _, e := range [][2]*flow.Flow{{c, a}, {c, b}, {cother, b}} {
+		src += edge(e[0], e[1]) + "\n"
+	}
+	src += "}"
+	g, err := parseEvalGraph([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := closestEvalNode(g, c); n == nil || !n.Matches(c.Digest()) {
+		t.Errorf("got %v, want node for c", n)
+	}
+	if n := closestEvalNode(g, cnew); n == nil || !n.Matches(c.Digest()) {
+		t.Errorf("got %v, want node for c", n)
+	}
+	if n := closestEvalNode(g, bnew); n == nil || !n.Matches(b.Digest()) {
+		t.Errorf("got %v, want node for b", n)
+	}
+}
+
+func TestCacheExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := testutil.NewInmemoryRepository()
+	put := func(contents string) reflow.File {
+		t.Helper()
+		d, err := src.Put(ctx, bytes.NewReader([]byte(contents)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reflow.File{ID: d, Size: int64(len(contents))}
+	}
+	fs := reflow.Fileset{Map: map[string]reflow.File{
+		"a":   put("a"),
+		"b":   put("b"),
+		"ref": {ContentHash: reflow.Digester.FromString("ref"), Source: "s3://bucket/ref", Size: 3},
+	}}
+	fsid, err := repository.Marshal(ctx, src, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspect := put("inspect")
+	var (
+		key     = reflow.Digester.FromString("key")
+		missing = reflow.Digester.FromString("missing")
+	)
+	mappings := []cacheMapping{
+		{Kind: assoc.Fileset.String(), Key: key, Value: fsid},
+		{Kind: assoc.ExecInspect.String(), Key: key, Value: inspect.ID},
+		{Kind: assoc.Fileset.String(), Key: missing, Value: reflow.Digester.FromString("nonexistent")},
+	}
+	var b bytes.Buffer
+	stats, err := exportCache(ctx, &b, src, mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats.Mappings, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stats.Skipped, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The fileset, its two resolved files, and the inspect.
+	if got, want := stats.Objects, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	dst, ass := testutil.NewInmemoryRepository(), testutil.NewInmemoryAssoc()
+	if _, err := importCache(ctx, bytes.NewReader(b.Bytes()), dst, ass, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Stat(ctx, fsid); !errors.Is(errors.NotExist, err) {
+		t.Errorf("dry run wrote to the repository: %v", err)
+	}
+	stats, err = importCache(ctx, bytes.NewReader(b.Bytes()), dst, ass, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats.Mappings, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, m := range mappings[:2] {
+		kind, err := parseAssocKind(m.Kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, v, err := ass.Get(ctx, kind, m.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := v, m.Value; got != want {
+			t.Errorf("%s: got %v, want %v", m.Kind, got, want)
+		}
+	}
+	var got reflow.Fileset
+	if err := repository.Unmarshal(ctx, dst, fsid, &got); err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := repository.Missing(ctx, dst, got.Files()...); err != nil {
+		t.Fatal(err)
+	} else if len(missing) != 1 || !missing[0].IsRef() {
+		t.Errorf("got missing %v, want only the reference", missing)
+	}
+	// A second import rewrites no objects.
+	stats, err = importCache(ctx, bytes.NewReader(b.Bytes()), dst, ass, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats.Skipped, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCacheImportCorrupt(t *testing.T) {
+	ctx := context.Background()
+	src := testutil.NewInmemoryRepository()
+	d, err := src.Put(ctx, bytes.NewReader([]byte("inspect")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	mappings := []cacheMapping{{Kind: assoc.ExecInspect.String(), Key: reflow.Digester.FromString("key"), Value: d}}
+	if _, err := exportCache(ctx, &b, src, mappings); err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the archive, replacing the object's contents.
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corrupt bytes.Buffer
+	var (
+		tr  = tar.NewReader(gz)
+		gzw = gzip.NewWriter(&corrupt)
+		tw  = tar.NewWriter(gzw)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p bytes.Buffer
+		if _, err := io.Copy(&p, tr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == cacheObjectsPrefix+d.String() {
+			p.Reset()
+			p.WriteString("tampered")
+			hdr.Size = int64(p.Len())
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(p.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ass := testutil.NewInmemoryAssoc()
+	_, err = importCache(ctx, &corrupt, testutil.NewInmemoryRepository(), ass, false)
+	if !errors.Is(errors.Integrity, err) {
+		t.Fatalf("got %v, want integrity error", err)
+	}
+	if _, _, err := ass.Get(ctx, assoc.ExecInspect, mappings[0].Key); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+}
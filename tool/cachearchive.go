@@ -0,0 +1,287 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/assoc"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/repository"
+)
+
+// cacheArchiveVersion is the version of the cache archive format.
+const cacheArchiveVersion = 1
+
+const (
+	cacheManifestName  = "manifest.json"
+	cacheObjectsPrefix = "objects/"
+)
+
+// cacheManifest is the manifest of a cache archive. A cache archive is
+// a portable archive of cached results: a gzipped tar file whose first
+// entry is the archive's manifest, followed by an entry for each of the
+// repository objects named by the manifest. Objects are named by their
+// digests, and so their integrity is verified when they are imported.
+type cacheManifest struct {
+	// Version is the archive format version.
+	Version int
+	// Mappings are the assoc mappings in the archive.
+	Mappings []cacheMapping
+	// Objects are the digests of the repository objects in the
+	// archive, in archive order.
+	Objects []digest.Digest
+}
+
+// cacheMapping is an assoc mapping in a cache archive.
+type cacheMapping struct {
+	// Kind is the name of the mapping's kind, e.g., "Fileset".
+	Kind string
+	// Key and Value are the mapping's key and value.
+	Key, Value digest.Digest
+	// Ident is the identifier of the flow node that produced the
+	// mapping, if known.
+	Ident string `json:",omitempty"`
+}
+
+func parseAssocKind(name string) (assoc.Kind, error) {
+	for _, kind := range []assoc.Kind{assoc.Fileset, assoc.ExecInspect, assoc.Logs, assoc.Bundle, assoc.Eval} {
+		if kind.String() == name {
+			return kind, nil
+		}
+	}
+	return 0, errors.E("parse kind", name, errors.Invalid, errors.New("unknown assoc kind"))
+}
+
+// cacheArchiveStats records the contents of an exported or imported
+// cache archive.
+type cacheArchiveStats struct {
+	// Mappings is the number of mappings exported or imported.
+	Mappings int
+	// Objects and Bytes are the number and total size of the objects
+	// exported or imported.
+	Objects int
+	Bytes   int64
+	// Skipped is the number of mappings that were skipped because
+	// some of the objects they reference are missing, or, on import,
+	// the number of objects already present in the repository.
+	Skipped int
+}
+
+func (s cacheArchiveStats) String() string {
+	return fmt.Sprintf("%d mappings, %d objects (%s), %d skipped",
+		s.Mappings, s.Objects, data.Size(s.Bytes), s.Skipped)
+}
+
+// cacheObjects returns the repository objects referenced by a mapping:
+// the mapping's value and, for filesets, the (resolved) files in the
+// fileset.
+func cacheObjects(ctx context.Context, repo reflow.Repository, m cacheMapping) ([]digest.Digest, error) {
+	objects := []digest.Digest{m.Value}
+	if m.Kind != assoc.Fileset.String() {
+		return objects, nil
+	}
+	var fs reflow.Fileset
+	if err := repository.Unmarshal(ctx, repo, m.Value, &fs); err != nil {
+		return nil, err
+	}
+	for _, f := range fs.Files() {
+		if !f.IsRef() {
+			objects = append(objects, f.ID)
+		}
+	}
+	return objects, nil
+}
+
+// exportCache writes a cache archive to w containing the provided
+// mappings and the objects they reference in repo. Mappings whose
+// objects are missing from the repository are skipped.
+func exportCache(ctx context.Context, w io.Writer, repo reflow.Repository, mappings []cacheMapping) (cacheArchiveStats, error) {
+	var (
+		stats    cacheArchiveStats
+		manifest = cacheManifest{Version: cacheArchiveVersion}
+		sizes    = make(map[digest.Digest]int64)
+	)
+mappings:
+	for _, m := range mappings {
+		objects, err := cacheObjects(ctx, repo, m)
+		if errors.Is(errors.NotExist, err) {
+			stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		added := make(map[digest.Digest]int64)
+		for _, d := range objects {
+			if _, ok := sizes[d]; ok {
+				continue
+			}
+			file, err := repo.Stat(ctx, d)
+			if errors.Is(errors.NotExist, err) {
+				stats.Skipped++
+				continue mappings
+			}
+			if err != nil {
+				return stats, err
+			}
+			added[d] = file.Size
+		}
+		for _, d := range objects {
+			if size, ok := added[d]; ok {
+				sizes[d] = size
+				delete(added, d)
+				manifest.Objects = append(manifest.Objects, d)
+			}
+		}
+		manifest.Mappings = append(manifest.Mappings, m)
+	}
+	stats.Mappings = len(manifest.Mappings)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	b, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return stats, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: cacheManifestName, Mode: 0644, Size: int64(len(b))}); err != nil {
+		return stats, err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return stats, err
+	}
+	for _, d := range manifest.Objects {
+		if err := tw.WriteHeader(&tar.Header{Name: cacheObjectsPrefix + d.String(), Mode: 0644, Size: sizes[d]}); err != nil {
+			return stats, err
+		}
+		rc, err := repo.Get(ctx, d)
+		if err != nil {
+			return stats, err
+		}
+		n, err := io.Copy(tw, rc)
+		rc.Close()
+		if err != nil {
+			return stats, errors.E("export", d, err)
+		}
+		stats.Objects++
+		stats.Bytes += n
+	}
+	if err := tw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, gz.Close()
+}
+
+// importCache reads a cache archive from r, writing its objects to repo
+// and then its mappings to ass. The digest of each object is verified
+// as it is written; a mapping is stored only once all of the objects
+// it references are present in repo. If dryRun is true, the archive is
+// verified but nothing is written.
+func importCache(ctx context.Context, r io.Reader, repo reflow.Repository, ass assoc.Assoc, dryRun bool) (cacheArchiveStats, error) {
+	var stats cacheArchiveStats
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, errors.E("import", errors.Invalid, err)
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return stats, errors.E("import", errors.Invalid, err)
+	}
+	if hdr.Name != cacheManifestName {
+		return stats, errors.E("import", errors.Invalid, errors.Errorf("expected %s, got %s", cacheManifestName, hdr.Name))
+	}
+	var manifest cacheManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return stats, errors.E("import", cacheManifestName, errors.Invalid, err)
+	}
+	if manifest.Version != cacheArchiveVersion {
+		return stats, errors.E("import", errors.NotSupported, errors.Errorf("unsupported archive version %d", manifest.Version))
+	}
+	listed := make(map[digest.Digest]bool)
+	for _, d := range manifest.Objects {
+		listed[d] = true
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, errors.E("import", errors.Invalid, err)
+		}
+		if !strings.HasPrefix(hdr.Name, cacheObjectsPrefix) {
+			return stats, errors.E("import", hdr.Name, errors.Invalid, errors.New("unexpected archive entry"))
+		}
+		want, err := reflow.Digester.Parse(strings.TrimPrefix(hdr.Name, cacheObjectsPrefix))
+		if err != nil {
+			return stats, errors.E("import", hdr.Name, errors.Invalid, err)
+		}
+		if !listed[want] {
+			return stats, errors.E("import", hdr.Name, errors.Invalid, errors.New("object not listed in manifest"))
+		}
+		if _, err := repo.Stat(ctx, want); err == nil {
+			stats.Skipped++
+			continue
+		} else if !errors.Is(errors.NotExist, err) {
+			return stats, err
+		}
+		var got digest.Digest
+		if dryRun {
+			w := reflow.Digester.NewWriter()
+			if _, err := io.Copy(w, tr); err != nil {
+				return stats, errors.E("import", hdr.Name, err)
+			}
+			got = w.Digest()
+		} else if got, err = repo.Put(ctx, tr); err != nil {
+			return stats, errors.E("import", hdr.Name, err)
+		}
+		if got != want {
+			return stats, errors.E("import", hdr.Name, errors.Integrity,
+				errors.Errorf("object has digest %s", got))
+		}
+		stats.Objects++
+		stats.Bytes += hdr.Size
+	}
+	if dryRun {
+		stats.Mappings = len(manifest.Mappings)
+		return stats, nil
+	}
+	for _, m := range manifest.Mappings {
+		kind, err := parseAssocKind(m.Kind)
+		if err != nil {
+			return stats, err
+		}
+		objects, err := cacheObjects(ctx, repo, m)
+		if err != nil {
+			return stats, errors.E("import", m.Key, err)
+		}
+		files := make([]reflow.File, len(objects))
+		for i, d := range objects {
+			files[i] = reflow.File{ID: d}
+		}
+		missing, err := repository.Missing(ctx, repo, files...)
+		if err != nil {
+			return stats, err
+		}
+		if len(missing) > 0 {
+			return stats, errors.E("import", m.Key, errors.Integrity,
+				errors.Errorf("%d referenced objects are missing, including %s", len(missing), missing[0].ID))
+		}
+		if err := ass.Store(ctx, kind, m.Key, m.Value); err != nil {
+			return stats, err
+		}
+		stats.Mappings++
+	}
+	return stats, nil
+}
@@ -15,7 +15,9 @@ import (
 	"github.com/grailbio/reflow"
 	"github.com/grailbio/reflow/blob/s3blob"
 	"github.com/grailbio/reflow/ec2cluster"
+	"github.com/grailbio/reflow/hostcluster"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/multicluster"
 	"github.com/grailbio/reflow/repository/blobrepo"
 	repositoryhttp "github.com/grailbio/reflow/repository/http"
 	"github.com/grailbio/reflow/runner"
@@ -46,9 +48,23 @@ func (c *Cmd) Cluster(status *status.Group) runner.Cluster {
 	if err := c.Config.Instance(&ec); err == nil {
 		ec.Status = status
 		ec.Configuration = c.Config
+		configurePoolAuth(ec.HTTPClient, c.Config)
 		if ierr := ec.VerifyAndInit(); ierr != nil {
 			c.Fatal(ierr)
 		}
+	} else if hc, ok := cluster.(*hostcluster.Cluster); ok {
+		hc.Status = status
+		configurePoolAuth(hc.HTTPClient, c.Config)
+		if ierr := hc.VerifyAndInit(); ierr != nil {
+			c.Fatal(ierr)
+		}
+	} else if mc, ok := cluster.(*multicluster.Cluster); ok {
+		if ierr := configureCluster(mc, c.Config, c.Schema, c.Status, ""); ierr != nil {
+			c.Fatal(ierr)
+		}
+		if ierr := mc.VerifyAndInit(); ierr != nil {
+			c.Fatal(ierr)
+		}
 	} else {
 		log.Printf("not a ec2cluster! : %v", err)
 	}
@@ -62,6 +78,7 @@ func (c *Cmd) Cluster(status *status.Group) runner.Cluster {
 	if err != nil {
 		c.Fatal(err)
 	}
+	configurePoolAuth(repositoryhttp.HTTPClient, c.Config)
 	if n, ok := cluster.(needer); ok {
 		http.HandleFunc("/clusterneed", func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != "GET" {
@@ -0,0 +1,66 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"flag"
+
+	"github.com/grailbio/reflow/pool"
+)
+
+func (c *Cmd) cordon(ctx context.Context, args ...string) {
+	flags := flag.NewFlagSet("cordon", flag.ExitOnError)
+	help := `Cordon marks allocs so that they are not assigned new tasks.
+Tasks that are running on a cordoned alloc are allowed to complete,
+and the alloc remains available until it is idle, killed, or uncordoned.`
+	c.Parse(flags, args, help, "cordon allocs...")
+	c.setAllocState(ctx, flags, pool.AllocCordoned)
+}
+
+func (c *Cmd) uncordon(ctx context.Context, args ...string) {
+	flags := flag.NewFlagSet("uncordon", flag.ExitOnError)
+	help := "Uncordon marks cordoned or draining allocs so that they may again be assigned tasks."
+	c.Parse(flags, args, help, "uncordon allocs...")
+	c.setAllocState(ctx, flags, pool.AllocActive)
+}
+
+func (c *Cmd) drain(ctx context.Context, args ...string) {
+	flags := flag.NewFlagSet("drain", flag.ExitOnError)
+	help := `Drain marks allocs so that they are not assigned new tasks, and
+so that they are freed once the tasks that are running on them
+complete. Unlike kill, drain does not interrupt running tasks.`
+	c.Parse(flags, args, help, "drain allocs...")
+	c.setAllocState(ctx, flags, pool.AllocDraining)
+}
+
+// setAllocState sets the state of the allocs named by the flags'
+// arguments.
+func (c *Cmd) setAllocState(ctx context.Context, flags *flag.FlagSet, state pool.AllocState) {
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	cluster := c.Cluster(nil)
+	for _, arg := range flags.Args() {
+		n, err := parseName(arg)
+		if err != nil {
+			c.Errorf("%s: %s\n", arg, err)
+			continue
+		}
+		if n.Kind != allocName {
+			c.Errorf("%s: only allocs can be %s\n", arg, state)
+			continue
+		}
+		alloc, err := cluster.Alloc(ctx, allocURI(n))
+		if err != nil {
+			c.Errorf("%s: %s\n", arg, err)
+			continue
+		}
+		if err := pool.SetState(ctx, alloc, state); err != nil {
+			c.Errorf("%s: %s\n", arg, err)
+			continue
+		}
+	}
+}
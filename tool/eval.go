@@ -19,6 +19,7 @@ import (
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/flow"
 	"github.com/grailbio/reflow/lang"
+	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/syntax"
 	"github.com/grailbio/reflow/types"
 	"github.com/grailbio/reflow/values"
@@ -45,11 +46,24 @@ type Eval struct {
 	// ImageMap stores a mapping between image names and resolved
 	// image names, to be used in evaluation.
 	ImageMap map[string]string
+	// ImageLock is the image lock of the module: the lock embedded in
+	// a bundle, or the lockfile next to a module. ImageLock is nil if
+	// the module is not locked.
+	ImageLock ImageLock
+	// LockPath is the path of the module's lockfile. It is empty
+	// for bundles, whose locks are embedded.
+	LockPath string
+	// Relock tells ResolveImages to accept images that have drifted
+	// from the image lock, updating the lock, instead of failing.
+	Relock bool
 	// Type is the module type of the toplevel module that has been
 	// evaluated.
 	Type *types.T
 	// Module is the module value that was evaluated.
 	Module values.Module
+	// Memo, if not nil, memoizes the values of the module's
+	// declarations. It applies only to v1 programs.
+	Memo *syntax.Memo
 }
 
 // MainType returns the type of the module's Main identifier.
@@ -138,12 +152,19 @@ func (e *Eval) Run() error {
 		return nil
 	case ".rf", ".rfx":
 		sess := syntax.NewSession(nil)
+		sess.Memo = e.Memo
 		if err := e.evalV1(sess); err != nil {
 			return err
 		}
 		e.Bundle = sess.Bundle()
 		e.Images = sess.Images()
-		return nil
+		if ext == ".rfx" {
+			e.ImageLock = sess.ImageLock()
+			return nil
+		}
+		e.LockPath = lockfilePath(e.Program)
+		e.ImageLock, err = readImageLock(e.LockPath)
+		return err
 	default:
 		return fmt.Errorf("unknown file extension %q", ext)
 	}
@@ -200,23 +221,60 @@ func (e *Eval) evalV1(sess *syntax.Session) error {
 }
 
 // Resolve images resolves the images in an evaluated program.
+// If the program is locked, the resolved images must match
+// the image lock; see applyImageLock.
 func (e *Eval) ResolveImages(config infra.Config) error {
 	// resolve images is only supported for v1 flows as of this writing.
 	if !e.V1 {
 		return nil
 	}
-	var (
-		awsSession *session.Session
-		err        error
-	)
-	if err = config.Instance(&awsSession); err != nil {
+	r, err := imageResolver(config)
+	if err != nil {
 		return err
 	}
-	r := ImageResolver{
-		Authenticator: ec2authenticator.New(awsSession),
+	resolved, err := r.ResolveImages(context.Background(), e.Images)
+	if err != nil {
+		return err
 	}
-	e.ImageMap, err = r.ResolveImages(context.Background(), e.Images)
-	return err
+	return e.applyImageLock(resolved)
+}
+
+// applyImageLock sets the evaluation's image map from the provided
+// resolved images after checking them against the image lock. If an
+// image has drifted from (or is missing in) the lock, applyImageLock
+// returns a Precondition error, unless e.Relock is set, in which case
+// the lock is updated, and the module's lockfile rewritten.
+func (e *Eval) applyImageLock(resolved map[string]string) error {
+	e.ImageMap = resolved
+	if e.ImageLock == nil {
+		return nil
+	}
+	drift := e.ImageLock.Drift(resolved)
+	if len(drift) == 0 {
+		return nil
+	}
+	if !e.Relock {
+		return driftError(drift)
+	}
+	for _, d := range drift {
+		log.Printf("relock: %s", d)
+		e.ImageLock[d.Image] = d.Resolved
+	}
+	if e.LockPath == "" {
+		log.Printf("relock: the lock embedded in bundle %s was not updated; use reflow lock to update it", e.Program)
+		return nil
+	}
+	return writeImageLock(e.LockPath, e.ImageLock)
+}
+
+// imageResolver returns an image resolver configured from the
+// provided config.
+func imageResolver(config infra.Config) (*ImageResolver, error) {
+	var awsSession *session.Session
+	if err := config.Instance(&awsSession); err != nil {
+		return nil, err
+	}
+	return &ImageResolver{Authenticator: ec2authenticator.New(awsSession)}, nil
 }
 
 func sprintval(v values.T, t *types.T) string {
@@ -0,0 +1,470 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/flow"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// evalGraph is a flow graph as recorded by the evaluator at the end of
+// a run (see flow.EvalConfig.EvalGraphWriter). Edges point from a node to
+// its dependencies.
+type evalGraph struct {
+	// Name is the name of the graph.
+	Name string
+	// Nodes contains the graph's nodes, in the order of their IDs.
+	Nodes []*evalNode
+	// Edges contains the graph's edges.
+	Edges []evalEdge
+
+	byShort map[string]*evalNode
+}
+
+// evalNode is a single flow node in an evalGraph.
+type evalNode struct {
+	// ID is the node's graph identifier.
+	ID int64
+	// DOTID is the node's identifier in the dot file.
+	DOTID string
+	// Short is the abbreviated flow digest of the node.
+	Short string
+	// Digest is the node's full flow digest, if it was recorded.
+	Digest digest.Digest
+	// Op, Ident and Position describe the flow node.
+	Op, Ident, Position string
+	// State is the evaluation state of the node at the end of the
+	// run, if it was recorded.
+	State string
+	// Cached tells whether the node's value was retrieved from cache.
+	Cached bool
+	// Error is the node's evaluation error, if any.
+	Error string
+	// Runtime is the time taken to evaluate the node, if it was recorded.
+	Runtime time.Duration
+	// Components are the components of the node's digest,
+	// if they were recorded, in order of their names.
+	Components []flow.DigestComponent
+	// Collapsed is the number of nodes that were collapsed into
+	// this node (see evalGraph.Collapse).
+	Collapsed int
+	// Attrs stores all of the node's attributes.
+	Attrs map[string]string
+
+	// Deps and Dependents are the node's dependencies and the nodes
+	// that depend on it.
+	Deps, Dependents []*evalNode
+}
+
+// evalEdge is a dependency edge in an evalGraph.
+type evalEdge struct {
+	From, To *evalNode
+	// Dynamic tells whether the edge was discovered during evaluation.
+	Dynamic bool
+}
+
+// External tells whether the node is an exec, intern or extern.
+func (n *evalNode) External() bool {
+	switch n.Op {
+	case "exec", "intern", "extern":
+		return true
+	}
+	return false
+}
+
+// Failed tells whether the node's evaluation failed.
+func (n *evalNode) Failed() bool {
+	return n.Error != ""
+}
+
+// Matches tells whether the node represents the flow with digest d.
+func (n *evalNode) Matches(d digest.Digest) bool {
+	if !n.Digest.IsZero() {
+		return n.Digest == d
+	}
+	return d.Short() == n.Short
+}
+
+// Node returns the node representing the flow with digest d,
+// or nil if no such node exists.
+func (g *evalGraph) Node(d digest.Digest) *evalNode {
+	if n := g.byShort[d.Short()]; n != nil && n.Matches(d) {
+		return n
+	}
+	return nil
+}
+
+// Roots returns the nodes in g without dependents.
+func (g *evalGraph) Roots() []*evalNode {
+	var roots []*evalNode
+	for _, n := range g.Nodes {
+		if len(n.Dependents) == 0 {
+			roots = append(roots, n)
+		}
+	}
+	return roots
+}
+
+// Subgraph returns the subgraph of g induced by the nodes for
+// which keep returns true. Nodes are copied so that g is left
+// unmodified.
+func (g *evalGraph) Subgraph(keep func(*evalNode) bool) *evalGraph {
+	sub := &evalGraph{Name: g.Name, byShort: make(map[string]*evalNode)}
+	copies := make(map[*evalNode]*evalNode)
+	for _, n := range g.Nodes {
+		if !keep(n) {
+			continue
+		}
+		c := n.copy()
+		copies[n] = c
+		sub.Nodes = append(sub.Nodes, c)
+		sub.byShort[c.Short] = c
+	}
+	for _, e := range g.Edges {
+		from, to := copies[e.From], copies[e.To]
+		if from == nil || to == nil {
+			continue
+		}
+		sub.addEdge(from, to, e.Dynamic)
+	}
+	return sub
+}
+
+// Neighborhood returns the set of nodes within distance hops of the
+// nodes in set, following edges in either direction.
+func (g *evalGraph) Neighborhood(set map[*evalNode]bool, distance int) map[*evalNode]bool {
+	hood := make(map[*evalNode]bool)
+	var frontier []*evalNode
+	for n := range set {
+		hood[n] = true
+		frontier = append(frontier, n)
+	}
+	for i := 0; i < distance && len(frontier) > 0; i++ {
+		var next []*evalNode
+		for _, n := range frontier {
+			for _, m := range append(append([]*evalNode{}, n.Deps...), n.Dependents...) {
+				if !hood[m] {
+					hood[m] = true
+					next = append(next, m)
+				}
+			}
+		}
+		frontier = next
+	}
+	return hood
+}
+
+// Collapse returns a copy of g where the subtree of each node whose
+// op is in ops is replaced by the node itself. A node's subtree
+// comprises the nodes that are reachable from it only through the
+// node; edges out of the subtree are retained by the collapsed node.
+// Collapsed nodes inherit the failures of their subtree.
+func (g *evalGraph) Collapse(ops ...string) *evalGraph {
+	collapsible := make(map[string]bool)
+	for _, op := range ops {
+		collapsible[op] = true
+	}
+	// Visit nodes in topological order, dependents first, so that
+	// outer subtrees absorb inner ones.
+	var (
+		order   []*evalNode
+		visited = make(map[*evalNode]bool)
+		visit   func(n *evalNode)
+	)
+	visit = func(n *evalNode) {
+		if visited[n] {
+			return
+		}
+		visited[n] = true
+		for _, dep := range n.Deps {
+			visit(dep)
+		}
+		order = append(order, n)
+	}
+	for _, n := range g.Nodes {
+		visit(n)
+	}
+	owner := make(map[*evalNode]*evalNode)
+	for i := len(order) - 1; i >= 0; i-- {
+		root := order[i]
+		if !collapsible[root.Op] || owner[root] != nil {
+			continue
+		}
+		owner[root] = root
+		queue := append([]*evalNode{}, root.Deps...)
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if owner[n] != nil {
+				continue
+			}
+			inside := true
+			for _, d := range n.Dependents {
+				if owner[d] != root {
+					inside = false
+					break
+				}
+			}
+			if !inside {
+				continue
+			}
+			owner[n] = root
+			queue = append(queue, n.Deps...)
+		}
+	}
+
+	c := &evalGraph{Name: g.Name, byShort: make(map[string]*evalNode)}
+	copies := make(map[*evalNode]*evalNode)
+	for _, n := range g.Nodes {
+		if o := owner[n]; o != nil && o != n {
+			continue
+		}
+		cp := n.copy()
+		copies[n] = cp
+		c.Nodes = append(c.Nodes, cp)
+		c.byShort[cp.Short] = cp
+	}
+	for _, n := range g.Nodes {
+		if o := owner[n]; o != nil && o != n {
+			root := copies[o]
+			root.Collapsed++
+			if root.Error == "" && n.Error != "" {
+				root.Error = n.Error
+			}
+		}
+	}
+	resolve := func(n *evalNode) *evalNode {
+		if o := owner[n]; o != nil {
+			return copies[o]
+		}
+		return copies[n]
+	}
+	seen := make(map[[2]*evalNode]bool)
+	for _, e := range g.Edges {
+		from, to := resolve(e.From), resolve(e.To)
+		if from == to || seen[[2]*evalNode{from, to}] {
+			continue
+		}
+		seen[[2]*evalNode{from, to}] = true
+		c.addEdge(from, to, e.Dynamic)
+	}
+	return c
+}
+
+func (g *evalGraph) addEdge(from, to *evalNode, dynamic bool) {
+	from.Deps = append(from.Deps, to)
+	to.Dependents = append(to.Dependents, from)
+	g.Edges = append(g.Edges, evalEdge{From: from, To: to, Dynamic: dynamic})
+}
+
+// copy returns a copy of n without its edges.
+func (n *evalNode) copy() *evalNode {
+	c := *n
+	c.Deps, c.Dependents = nil, nil
+	return &c
+}
+
+// loadEvalGraph retrieves the evaluation graph with digest id from
+// the provided repository and parses it.
+func loadEvalGraph(ctx context.Context, repo reflow.Repository, id digest.Digest) (*evalGraph, error) {
+	rc, err := repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return parseEvalGraph(b)
+}
+
+// parseEvalGraph parses a dot-encoded evaluation graph. Graphs written
+// by older versions of Reflow do not carry node attributes; in this
+// case node properties are recovered from the node's dot ID.
+func parseEvalGraph(b []byte) (*evalGraph, error) {
+	dg := &dotGraph{DirectedGraph: simple.NewDirectedGraph()}
+	if err := dot.Unmarshal(b, dg); err != nil {
+		return nil, err
+	}
+	g := &evalGraph{Name: dg.id, byShort: make(map[string]*evalNode)}
+	byID := make(map[int64]*evalNode)
+	for it := dg.Nodes(); it.Next(); {
+		dn := it.Node().(*dotNode)
+		n := &evalNode{ID: dn.id, DOTID: dn.dotID, Attrs: dn.attrs}
+		n.init()
+		g.Nodes = append(g.Nodes, n)
+		byID[n.ID] = n
+		g.byShort[n.Short] = n
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	var edges []*dotEdge
+	for it := dg.Edges(); it.Next(); {
+		edges = append(edges, it.Edge().(*dotEdge))
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from.ID() != edges[j].from.ID() {
+			return edges[i].from.ID() < edges[j].from.ID()
+		}
+		return edges[i].to.ID() < edges[j].to.ID()
+	})
+	for _, e := range edges {
+		g.addEdge(byID[e.from.ID()], byID[e.to.ID()], e.attrs["dynamic"] == "true")
+	}
+	return g, nil
+}
+
+// init populates the node's fields from its attributes, falling back
+// to its dot ID, which is of the form "digest-op-ident[-url]".
+func (n *evalNode) init() {
+	parts := strings.SplitN(n.DOTID, "-", 3)
+	n.Short = parts[0]
+	if len(parts) > 1 {
+		n.Op = parts[1]
+	}
+	if len(parts) > 2 {
+		n.Ident = strings.TrimSuffix(parts[2], "-ExecDepIncorrectCacheKeyBug")
+		if n.Op == "intern" || n.Op == "extern" {
+			n.Ident = strings.SplitN(n.Ident, "-", 2)[0]
+		}
+	}
+	if v, ok := n.Attrs["digest"]; ok {
+		if d, err := reflow.Digester.Parse(v); err == nil {
+			n.Digest = d
+			n.Short = d.Short()
+		}
+	}
+	if v, ok := n.Attrs["op"]; ok {
+		n.Op = v
+	}
+	if v, ok := n.Attrs["ident"]; ok {
+		n.Ident = v
+	}
+	n.Position = n.Attrs["position"]
+	n.State = n.Attrs["state"]
+	n.Cached = n.Attrs["cached"] == "true"
+	n.Error = n.Attrs["error"]
+	if v, ok := n.Attrs["runtime"]; ok {
+		n.Runtime, _ = time.ParseDuration(v)
+	}
+	for k, v := range n.Attrs {
+		if !strings.HasPrefix(k, flow.DigestComponentAttrPrefix) {
+			continue
+		}
+		c := flow.DigestComponent{Name: strings.TrimPrefix(k, flow.DigestComponentAttrPrefix)}
+		parts := strings.SplitN(v, " ", 2)
+		if d, err := reflow.Digester.Parse(parts[0]); err == nil {
+			c.Digest = d
+		}
+		if len(parts) > 1 {
+			c.Value = parts[1]
+		}
+		n.Components = append(n.Components, c)
+	}
+	sortComponents(n.Components)
+}
+
+// sortComponents sorts digest components by name, ordering numbered
+// components (e.g., "arg2" and "arg10") numerically.
+func sortComponents(cs []flow.DigestComponent) {
+	split := func(name string) (string, int) {
+		i := len(name)
+		for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+			i--
+		}
+		n, _ := strconv.Atoi(name[i:])
+		return name[:i], n
+	}
+	sort.SliceStable(cs, func(i, j int) bool {
+		pi, ni := split(cs[i].Name)
+		pj, nj := split(cs[j].Name)
+		if pi != pj {
+			return pi < pj
+		}
+		return ni < nj
+	})
+}
+
+// Component returns the node's digest component with the provided
+// name, and whether it was present.
+func (n *evalNode) Component(name string) (flow.DigestComponent, bool) {
+	for _, c := range n.Components {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return flow.DigestComponent{}, false
+}
+
+// dotGraph is used to decode dot files into evalGraphs.
+type dotGraph struct {
+	*simple.DirectedGraph
+	id string
+}
+
+// SetDOTID implements dot.DOTIDSetter.
+func (g *dotGraph) SetDOTID(id string) { g.id = id }
+
+// NewNode implements graph.NodeAdder.
+func (g *dotGraph) NewNode() graph.Node {
+	return &dotNode{id: g.DirectedGraph.NewNode().ID(), attrs: make(map[string]string)}
+}
+
+// NewEdge implements graph.EdgeAdder.
+func (g *dotGraph) NewEdge(from, to graph.Node) graph.Edge {
+	return &dotEdge{from: from, to: to, attrs: make(map[string]string)}
+}
+
+type dotNode struct {
+	id    int64
+	dotID string
+	attrs map[string]string
+}
+
+func (n *dotNode) ID() int64 { return n.id }
+
+// SetDOTID implements dot.DOTIDSetter.
+func (n *dotNode) SetDOTID(id string) { n.dotID = id }
+
+// SetAttribute implements encoding.AttributeSetter.
+func (n *dotNode) SetAttribute(attr encoding.Attribute) error {
+	n.attrs[attr.Key] = attr.Value
+	return nil
+}
+
+type dotEdge struct {
+	from, to graph.Node
+	attrs    map[string]string
+}
+
+func (e *dotEdge) From() graph.Node { return e.from }
+func (e *dotEdge) To() graph.Node   { return e.to }
+
+func (e *dotEdge) ReversedEdge() graph.Edge {
+	return &dotEdge{from: e.to, to: e.from, attrs: e.attrs}
+}
+
+// SetAttribute implements encoding.AttributeSetter.
+func (e *dotEdge) SetAttribute(attr encoding.Attribute) error {
+	e.attrs[attr.Key] = attr.Value
+	return nil
+}
+
+func (e evalEdge) String() string {
+	return fmt.Sprintf("%s -> %s", e.From.DOTID, e.To.DOTID)
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/grailbio/reflow/flow"
+)
+
+func (c *Cmd) flowdiff(ctx context.Context, args ...string) {
+	var (
+		flags    = flag.NewFlagSet("flowdiff", flag.ExitOnError)
+		maxFlag  = flags.Int("n", 10, "maximum number of differing nodes to explain")
+		repoFlag = flags.String("repo", "", "read graphs from this local repository directory")
+		help     = `Flowdiff explains why the digest of a flow node differs between two
+runs.
+
+The nodes with the given identifier (or abbreviated flow digest) are
+located in the evaluation graphs of both runs, which record the
+components of each node's digest: its image, command, argument and
+dependency digests, and so on. Flowdiff then walks the digest trees
+of each pair of differing nodes and prints the path to the first
+differing leaf, for example:
+
+	exec align (align.rf:12:3) 5ce8b24e -> 0d1f3a9c
+		arg1: intern fastq
+			input file R1.fastq.gz changed: etag 3f1c.. -> 9e4a..
+
+Runs are named as in "reflow graph": by run id, graph digest or
+dot file.`
+	)
+	c.Parse(flags, args, help, "flowdiff [-n max] [-repo dir] run1 run2 ident|flowid")
+	if flags.NArg() != 3 {
+		flags.Usage()
+	}
+	ga, err := c.findEvalGraph(ctx, flags.Arg(0), *repoFlag)
+	if err != nil {
+		c.Fatalf("%s: %v", flags.Arg(0), err)
+	}
+	gb, err := c.findEvalGraph(ctx, flags.Arg(1), *repoFlag)
+	if err != nil {
+		c.Fatalf("%s: %v", flags.Arg(1), err)
+	}
+	name := flags.Arg(2)
+	as, bs := findNodes(ga, name), findNodes(gb, name)
+	if len(as) == 0 {
+		c.Fatalf("%s: no node named %s", flags.Arg(0), name)
+	}
+	if len(bs) == 0 {
+		c.Fatalf("%s: no node named %s", flags.Arg(1), name)
+	}
+	pairs := pairNodes(as, bs)
+	if len(pairs) == 0 {
+		fmt.Fprintf(c.Stdout, "%s: digests are unchanged\n", name)
+		return
+	}
+	for i, p := range pairs {
+		if i == *maxFlag {
+			fmt.Fprintf(c.Stdout, "(%d more differing nodes)\n", len(pairs)-i)
+			break
+		}
+		writeDigestDiff(c.Stdout, diffDigests(ga, gb, p[0], p[1]))
+	}
+}
+
+// findNodes returns the nodes in g with the provided identifier
+// or abbreviated flow digest.
+func findNodes(g *evalGraph, name string) []*evalNode {
+	var nodes []*evalNode
+	for _, n := range g.Nodes {
+		if n.Ident == name || n.Short == name {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// pairNodes pairs each node in bs whose digest does not appear in as
+// with its most similar node in as: the node with the same op which
+// shares the most digest components.
+func pairNodes(as, bs []*evalNode) [][2]*evalNode {
+	digests := make(map[string]bool)
+	for _, a := range as {
+		digests[a.Short] = true
+	}
+	var pairs [][2]*evalNode
+	for _, b := range bs {
+		if digests[b.Short] {
+			continue
+		}
+		var (
+			best  *evalNode
+			score = -1
+		)
+		for _, a := range as {
+			if a.Op != b.Op {
+				continue
+			}
+			var s int
+			for _, c := range b.Components {
+				if ac, ok := a.Component(c.Name); ok && ac.Digest == c.Digest {
+					s++
+				}
+			}
+			if s > score {
+				best, score = a, s
+			}
+		}
+		if best != nil {
+			pairs = append(pairs, [2]*evalNode{best, b})
+		}
+	}
+	return pairs
+}
+
+// digestDiff is the path through the digest trees of two nodes
+// to their first differing leaf.
+type digestDiff struct {
+	// A and B are the differing nodes.
+	A, B *evalNode
+	// Path is the list of dependency components traversed
+	// to reach the leaf.
+	Path []flow.DigestComponent
+	// Leaf is the first differing material component, as recorded in
+	// A and B. Leaf is nil if the nodes' components were not recorded.
+	Leaf *[2]flow.DigestComponent
+	// Notes contains differing non-material components.
+	Notes [][2]flow.DigestComponent
+}
+
+// diffDigests walks the digest trees of nodes a (in graph ga) and b
+// (in graph gb) to their first differing leaf.
+func diffDigests(ga, gb *evalGraph, a, b *evalNode) digestDiff {
+	d := digestDiff{A: a, B: b}
+	seen := make(map[[2]*evalNode]bool)
+	for !seen[[2]*evalNode{a, b}] {
+		seen[[2]*evalNode{a, b}] = true
+		var dep *[2]flow.DigestComponent
+		for _, cd := range diffComponents(a.Components, b.Components) {
+			cd := cd
+			switch {
+			case !cd[0].Material() || !cd[1].Material():
+				if len(d.Path) == 0 {
+					d.Notes = append(d.Notes, cd)
+				}
+			case !cd[0].IsDep() || !cd[1].IsDep():
+				if d.Leaf == nil {
+					d.Leaf = &cd
+				}
+			case dep == nil:
+				dep = &cd
+			}
+		}
+		if d.Leaf != nil {
+			return d
+		}
+		if dep == nil {
+			return d
+		}
+		na, nb := ga.Node(dep[0].Digest), gb.Node(dep[1].Digest)
+		if na == nil || nb == nil {
+			// The dependency was not recorded; it is the leaf.
+			d.Leaf = dep
+			return d
+		}
+		d.Path = append(d.Path, dep[1])
+		a, b = na, nb
+	}
+	return d
+}
+
+// diffComponents returns the pairs of components with the same name
+// that differ between as and bs. Components present in only one of
+// as or bs are paired with a zero component of the same name.
+func diffComponents(as, bs []flow.DigestComponent) [][2]flow.DigestComponent {
+	byName := make(map[string]flow.DigestComponent)
+	for _, c := range as {
+		byName[c.Name] = c
+	}
+	var diffs [][2]flow.DigestComponent
+	names := make(map[string]bool)
+	for _, b := range bs {
+		names[b.Name] = true
+		a, ok := byName[b.Name]
+		if !ok {
+			a = flow.DigestComponent{Name: b.Name}
+		}
+		if a.Digest != b.Digest {
+			diffs = append(diffs, [2]flow.DigestComponent{a, b})
+		}
+	}
+	for _, a := range as {
+		if !names[a.Name] {
+			diffs = append(diffs, [2]flow.DigestComponent{a, {Name: a.Name}})
+		}
+	}
+	sorted := make([]flow.DigestComponent, len(diffs))
+	index := make(map[string][2]flow.DigestComponent)
+	for i, d := range diffs {
+		sorted[i] = d[1]
+		index[d[1].Name] = d
+	}
+	sortComponents(sorted)
+	for i, c := range sorted {
+		diffs[i] = index[c.Name]
+	}
+	return diffs
+}
+
+func writeDigestDiff(w io.Writer, d digestDiff) {
+	fmt.Fprintf(w, "%s %s (%s) %s -> %s\n", d.B.Op, d.B.Ident, d.B.Position, d.A.Short, d.B.Short)
+	indent := "\t"
+	for _, c := range d.Path {
+		fmt.Fprintf(w, "%s%s: %s\n", indent, c.Name, c.Value)
+		indent += "\t"
+	}
+	switch {
+	case d.Leaf != nil:
+		fmt.Fprintf(w, "%s%s\n", indent, describeComponentDiff(d.Leaf[0], d.Leaf[1]))
+	case len(d.A.Components) == 0 || len(d.B.Components) == 0:
+		fmt.Fprintf(w, "%sdigest components were not recorded\n", indent)
+	default:
+		fmt.Fprintf(w, "%sno differing component found\n", indent)
+	}
+	for _, n := range d.Notes {
+		fmt.Fprintf(w, "\t(%s)\n", describeComponentDiff(n[0], n[1]))
+	}
+}
+
+// describeComponentDiff returns a human-readable description
+// of the change of a digest component from a to b.
+func describeComponentDiff(a, b flow.DigestComponent) string {
+	switch {
+	case a.Digest.IsZero():
+		return fmt.Sprintf("%s was added: %s", b.Name, b.Value)
+	case b.Digest.IsZero():
+		return fmt.Sprintf("%s was removed: %s", a.Name, a.Value)
+	}
+	switch {
+	case a.Name == "image":
+		ia, ib := strings.SplitN(a.Value, "@", 2), strings.SplitN(b.Value, "@", 2)
+		if len(ia) == 2 && len(ib) == 2 && ia[0] == ib[0] {
+			return fmt.Sprintf("image %s resolved to a new digest: %s -> %s", ia[0], ia[1], ib[1])
+		}
+		return fmt.Sprintf("image changed: %s -> %s", a.Value, b.Value)
+	case strings.HasPrefix(a.Name, "file"):
+		pa, pb := strings.SplitN(a.Value, " ", 2), strings.SplitN(b.Value, " ", 2)
+		if len(pa) == 2 && len(pb) == 2 && pa[0] == pb[0] {
+			return fmt.Sprintf("input file %s changed: %s -> %s", pa[0], pa[1], pb[1])
+		}
+	case a.IsDep():
+		return fmt.Sprintf("%s changed: %s (%s) -> %s (%s)", a.Name, a.Value, a.Digest.Short(), b.Value, b.Digest.Short())
+	}
+	if a.Value == b.Value || a.Value == "" {
+		return fmt.Sprintf("%s changed: %s -> %s", a.Name, a.Digest.Short(), b.Digest.Short())
+	}
+	return fmt.Sprintf("%s changed: %s -> %s", a.Name, a.Value, b.Value)
+}
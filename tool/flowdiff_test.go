@@ -0,0 +1,86 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/flow"
+	op "github.com/grailbio/reflow/test/flow"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// testRunGraph returns the evaluation graph of a run of
+// a two-step pipeline with the provided input etag and image.
+func testRunGraph(t *testing.T, etag, image string) *evalGraph {
+	t.Helper()
+	in := op.Val(reflow.Fileset{Map: map[string]reflow.File{
+		"R1.fastq": {Source: "s3://bucket/R1.fastq", ETag: etag},
+	}})
+	in.Ident = "fastq"
+	align := op.Exec("bwa@sha256:1111", "bwa %s", reflow.Resources{"mem": 1}, in)
+	align.Ident = "align"
+	call := op.Exec(image, "call %s", reflow.Resources{"mem": 1}, align)
+	call.Ident = "call"
+	g := simple.NewDirectedGraph()
+	for _, f := range []*flow.Flow{in, align, call} {
+		g.AddNode(flow.Node{Flow: f, Analysis: true})
+	}
+	g.SetEdge(flow.Edge{Edge: g.NewEdge(flow.Node{Flow: align, Analysis: true}, flow.Node{Flow: in, Analysis: true})})
+	g.SetEdge(flow.Edge{Edge: g.NewEdge(flow.Node{Flow: call, Analysis: true}, flow.Node{Flow: align, Analysis: true})})
+	b, err := dot.Marshal(g, "test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eg, err := parseEvalGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eg
+}
+
+func TestFlowDiff(t *testing.T) {
+	base := testRunGraph(t, "etag1", "gatk@sha256:aaaa")
+	for _, test := range []struct {
+		graph *evalGraph
+		path  []string
+		want  string
+	}{
+		{testRunGraph(t, "etag2", "gatk@sha256:aaaa"), []string{"arg0", "arg0"}, "input file R1.fastq changed"},
+		{testRunGraph(t, "etag1", "gatk@sha256:bbbb"), nil, "image gatk resolved to a new digest: sha256:aaaa -> sha256:bbbb"},
+	} {
+		as, bs := findNodes(base, "call"), findNodes(test.graph, "call")
+		pairs := pairNodes(as, bs)
+		if got, want := len(pairs), 1; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		d := diffDigests(base, test.graph, pairs[0][0], pairs[0][1])
+		var path []string
+		for _, c := range d.Path {
+			path = append(path, c.Name)
+		}
+		if got, want := strings.Join(path, "/"), strings.Join(test.path, "/"); got != want {
+			t.Errorf("got path %v, want %v", got, want)
+		}
+		if d.Leaf == nil {
+			t.Fatal("no differing leaf")
+		}
+		if got := describeComponentDiff(d.Leaf[0], d.Leaf[1]); !strings.HasPrefix(got, test.want) {
+			t.Errorf("got %q, want prefix %q", got, test.want)
+		}
+		var b bytes.Buffer
+		writeDigestDiff(&b, d)
+		if !strings.Contains(b.String(), test.want) {
+			t.Errorf("got %q, want %q", b.String(), test.want)
+		}
+	}
+	if pairs := pairNodes(findNodes(base, "call"), findNodes(base, "call")); len(pairs) != 0 {
+		t.Errorf("got %v, want no pairs", pairs)
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/digest"
+	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/errors"
+	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/repository/filerepo"
+	"github.com/grailbio/reflow/taskdb"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+func (c *Cmd) graph(ctx context.Context, args ...string) {
+	var (
+		flags        = flag.NewFlagSet("graph", flag.ExitOnError)
+		identFlag    = flags.String("ident", "", "only include nodes whose identifier matches this regular expression")
+		contextFlag  = flags.Int("context", 0, "include nodes within this many edges of the matched nodes")
+		collapseFlag = flags.Bool("collapse", false, "collapse the subtrees of map and continuation (k) nodes")
+		failedFlag   = flags.Bool("failed", false, "only include failed nodes (and their context)")
+		slowFlag     = flags.Duration("slow", 0, "highlight nodes whose runtime is at least this duration")
+		formatFlag   = flags.String("format", "summary", "output format: summary, dot, graphml or json")
+		outFlag      = flags.String("o", "", "write output to this file instead of stdout")
+		repoFlag     = flags.String("repo", "", "read the graph from this local repository directory")
+		help         = `Graph loads and explores the evaluation graph of a run.
+
+Each run records the graph of flow nodes it evaluated. Graph
+retrieves it, in order of preference, from: a dot file given as
+argument; the local run directory; the repository directory given
+by -repo; or the configured repository, by way of the run's entry in
+the taskdb. When -repo is given, the argument may also be the digest
+of the graph itself, so that graph works fully offline.
+
+The graph may be filtered: flag -ident selects nodes by identifier
+and -failed selects failed nodes; flag -context includes the nodes
+within the given number of edges of the selected ones. Flag
+-collapse replaces the subtree of each map and continuation node
+with a single node, annotated with the number of nodes collapsed.
+Failed nodes are highlighted in red; nodes whose runtime exceed
+-slow are highlighted in orange.
+
+The resulting graph is rendered as a summary of its nodes, or
+exported in dot, GraphML or JSON format.`
+	)
+	c.Parse(flags, args, help, "graph [flags] runid|graphid|file")
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	var identRE *regexp.Regexp
+	if *identFlag != "" {
+		var err error
+		if identRE, err = regexp.Compile(*identFlag); err != nil {
+			c.Fatalf("invalid -ident regular expression: %v", err)
+		}
+	}
+	g, err := c.findEvalGraph(ctx, flags.Arg(0), *repoFlag)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if *collapseFlag {
+		g = g.Collapse("map", "k", "kctx")
+	}
+	if identRE != nil || *failedFlag {
+		matched := make(map[*evalNode]bool)
+		for _, n := range g.Nodes {
+			if identRE != nil && !identRE.MatchString(n.Ident) {
+				continue
+			}
+			if *failedFlag && !n.Failed() {
+				continue
+			}
+			matched[n] = true
+		}
+		hood := g.Neighborhood(matched, *contextFlag)
+		g = g.Subgraph(func(n *evalNode) bool { return hood[n] })
+	}
+	highlight := func(n *evalNode) string {
+		switch {
+		case n.Failed():
+			return "failed"
+		case *slowFlag > 0 && n.Runtime >= *slowFlag:
+			return "slow"
+		}
+		return ""
+	}
+	w := c.Stdout
+	if *outFlag != "" {
+		f, err := os.Create(*outFlag)
+		if err != nil {
+			c.Fatal(err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				c.Fatal(err)
+			}
+		}()
+		w = f
+	}
+	switch *formatFlag {
+	case "summary":
+		err = writeGraphSummary(w, g, highlight)
+	case "dot":
+		err = writeGraphDOT(w, g, highlight)
+	case "graphml":
+		err = writeGraphML(w, g, highlight)
+	case "json":
+		err = writeGraphJSON(w, g, highlight)
+	default:
+		c.Fatalf("unknown format %q", *formatFlag)
+	}
+	if err != nil {
+		c.Fatal(err)
+	}
+}
+
+// findEvalGraph locates and loads the evaluation graph named by arg;
+// see the graph command's help text for the lookup order.
+func (c *Cmd) findEvalGraph(ctx context.Context, arg, repodir string) (*evalGraph, error) {
+	if _, err := os.Stat(arg); err == nil {
+		b, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return parseEvalGraph(b)
+	}
+	id, err := reflow.Digester.Parse(arg)
+	if err != nil {
+		return nil, errors.E("graph", arg, errors.Invalid, err)
+	}
+	if path, ok := c.localEvalGraph(id); ok {
+		log.Debugf("reading graph from %s", path)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseEvalGraph(b)
+	}
+	var repo reflow.Repository
+	if repodir != "" {
+		repo = &filerepo.Repository{Root: repodir}
+		if !id.IsAbbrev() {
+			switch g, err := loadEvalGraph(ctx, repo, id); {
+			case err == nil:
+				return g, nil
+			case !errors.Is(errors.NotExist, err):
+				return nil, err
+			}
+		}
+	} else {
+		c.must(c.Config.Instance(&repo))
+	}
+	var tdb taskdb.TaskDB
+	if err := c.Config.Instance(&tdb); err != nil || tdb == nil {
+		return nil, errors.E("graph", arg, errors.NotExist, errors.New("graph not found locally and no taskdb is configured"))
+	}
+	runs, err := tdb.Runs(ctx, taskdb.RunQuery{ID: taskdb.RunID(id)})
+	if err != nil {
+		return nil, err
+	}
+	switch len(runs) {
+	case 0:
+		return nil, errors.E("graph", arg, errors.NotExist)
+	case 1:
+	default:
+		return nil, errors.E("graph", arg, errors.Invalid, errors.New("ambiguous run id"))
+	}
+	if runs[0].EvalGraph.IsZero() {
+		return nil, errors.E("graph", arg, errors.NotExist, errors.New("run did not record an evaluation graph"))
+	}
+	return loadEvalGraph(ctx, repo, runs[0].EvalGraph)
+}
+
+// localEvalGraph returns the path of the graph written
+// by the run with the provided id to the local run directory.
+func (c *Cmd) localEvalGraph(id digest.Digest) (string, bool) {
+	if id.IsAbbrev() {
+		paths, err := filepath.Glob(filepath.Join(c.rundir(), "*.gv"))
+		if err != nil {
+			return "", false
+		}
+		for _, path := range paths {
+			full, err := reflow.Digester.Parse(strings.TrimSuffix(filepath.Base(path), ".gv"))
+			if err == nil && full.Expands(id) {
+				return path, true
+			}
+		}
+		return "", false
+	}
+	path := runbase(c.rundir(), taskdb.RunID(id)) + ".gv"
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// writeGraphSummary writes a human-readable summary of graph g:
+// node counts by op and state, its failed and highlighted nodes,
+// and its slowest nodes.
+func writeGraphSummary(w io.Writer, g *evalGraph, highlight func(*evalNode) string) error {
+	var tw tabwriter.Writer
+	tw.Init(w, 4, 4, 1, ' ', 0)
+	fmt.Fprintf(&tw, "%s: %d nodes, %d edges\n", g.Name, len(g.Nodes), len(g.Edges))
+	counts := make(map[[2]string]int)
+	for _, n := range g.Nodes {
+		counts[[2]string{n.Op, n.State}]++
+	}
+	keys := make([][2]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	fmt.Fprintln(&tw, "\top\tstate\tcount")
+	for _, k := range keys {
+		fmt.Fprintf(&tw, "\t%s\t%s\t%d\n", k[0], k[1], counts[k])
+	}
+	var highlighted []*evalNode
+	for _, n := range g.Nodes {
+		if highlight(n) != "" {
+			highlighted = append(highlighted, n)
+		}
+	}
+	if len(highlighted) > 0 {
+		fmt.Fprintln(&tw, "highlighted nodes:")
+		for _, n := range highlighted {
+			fmt.Fprintf(&tw, "\t%s\t%s\t%s\t%s\t%s\n", n.Short, highlight(n), n.Ident, n.Position, n.Error)
+		}
+	}
+	const maxSlowest = 10
+	slowest := append([]*evalNode{}, g.Nodes...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Runtime > slowest[j].Runtime })
+	if len(slowest) > maxSlowest {
+		slowest = slowest[:maxSlowest]
+	}
+	if len(slowest) > 0 && slowest[0].Runtime > 0 {
+		fmt.Fprintln(&tw, "slowest nodes:")
+		for _, n := range slowest {
+			if n.Runtime == 0 {
+				break
+			}
+			fmt.Fprintf(&tw, "\t%s\t%s\t%s\t%s\n", n.Short, n.Op, n.Ident, n.Runtime.Round(time.Second))
+		}
+	}
+	return tw.Flush()
+}
+
+// graphNode renders an evalNode for export.
+type graphNode struct {
+	*evalNode
+	highlight string
+}
+
+func (n graphNode) ID() int64 { return n.evalNode.ID }
+
+// DOTID implements dot.Node.
+func (n graphNode) DOTID() string { return n.evalNode.DOTID }
+
+// Attributes implements encoding.Attributer.
+func (n graphNode) Attributes() []encoding.Attribute {
+	attrs := make([]encoding.Attribute, 0, len(n.Attrs)+3)
+	for k, v := range n.Attrs {
+		switch k {
+		case "fillcolor", "style", "error":
+			continue
+		}
+		attrs = append(attrs, encoding.Attribute{Key: k, Value: v})
+	}
+	if n.Error != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "error", Value: n.Error})
+	}
+	if n.Collapsed > 0 {
+		attrs = append(attrs, encoding.Attribute{Key: "collapsed", Value: fmt.Sprint(n.Collapsed)})
+	}
+	switch n.highlight {
+	case "failed":
+		attrs = append(attrs, encoding.Attribute{Key: "fillcolor", Value: "red"})
+	case "slow":
+		attrs = append(attrs, encoding.Attribute{Key: "fillcolor", Value: "orange"})
+	default:
+		if v, ok := n.Attrs["fillcolor"]; ok {
+			attrs = append(attrs, encoding.Attribute{Key: "fillcolor", Value: v})
+		}
+	}
+	if n.highlight != "" || n.Attrs["style"] != "" {
+		attrs = append(attrs, encoding.Attribute{Key: "style", Value: "filled"})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+type graphEdge struct {
+	graph.Edge
+	dynamic bool
+}
+
+// Attributes implements encoding.Attributer.
+func (e graphEdge) Attributes() []encoding.Attribute {
+	attrs := []encoding.Attribute{{Key: "dynamic", Value: fmt.Sprint(e.dynamic)}}
+	if e.dynamic {
+		attrs = append(attrs, encoding.Attribute{Key: "color", Value: "blue"})
+	}
+	return attrs
+}
+
+// writeGraphDOT writes g in Graphviz dot format.
+func writeGraphDOT(w io.Writer, g *evalGraph, highlight func(*evalNode) string) error {
+	dg := simple.NewDirectedGraph()
+	nodes := make(map[*evalNode]graphNode)
+	for _, n := range g.Nodes {
+		nodes[n] = graphNode{n, highlight(n)}
+		dg.AddNode(nodes[n])
+	}
+	for _, e := range g.Edges {
+		dg.SetEdge(graphEdge{Edge: dg.NewEdge(nodes[e.From], nodes[e.To]), dynamic: e.Dynamic})
+	}
+	b, err := dot.Marshal(dg, g.Name, "", "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// jsonGraph is the JSON representation of an evalGraph.
+type jsonGraph struct {
+	Name  string     `json:"name"`
+	Nodes []jsonNode `json:"nodes"`
+	Edges []jsonEdge `json:"edges"`
+}
+
+type jsonNode struct {
+	ID        string `json:"id"`
+	Digest    string `json:"digest,omitempty"`
+	Op        string `json:"op"`
+	Ident     string `json:"ident,omitempty"`
+	Position  string `json:"position,omitempty"`
+	State     string `json:"state,omitempty"`
+	Cached    bool   `json:"cached,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Runtime   string `json:"runtime,omitempty"`
+	Collapsed int    `json:"collapsed,omitempty"`
+	Highlight string `json:"highlight,omitempty"`
+}
+
+type jsonEdge struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Dynamic bool   `json:"dynamic,omitempty"`
+}
+
+// writeGraphJSON writes g in JSON format.
+func writeGraphJSON(w io.Writer, g *evalGraph, highlight func(*evalNode) string) error {
+	jg := jsonGraph{Name: g.Name, Nodes: []jsonNode{}, Edges: []jsonEdge{}}
+	for _, n := range g.Nodes {
+		jn := jsonNode{
+			ID:        n.DOTID,
+			Op:        n.Op,
+			Ident:     n.Ident,
+			Position:  n.Position,
+			State:     n.State,
+			Cached:    n.Cached,
+			Error:     n.Error,
+			Collapsed: n.Collapsed,
+			Highlight: highlight(n),
+		}
+		if !n.Digest.IsZero() {
+			jn.Digest = n.Digest.String()
+		}
+		if n.Runtime > 0 {
+			jn.Runtime = n.Runtime.String()
+		}
+		jg.Nodes = append(jg.Nodes, jn)
+	}
+	for _, e := range g.Edges {
+		jg.Edges = append(jg.Edges, jsonEdge{From: e.From.DOTID, To: e.To.DOTID, Dynamic: e.Dynamic})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jg)
+}
+
+// GraphML document structure; see http://graphml.graphdrawing.org.
+type (
+	graphML struct {
+		XMLName xml.Name     `xml:"graphml"`
+		Xmlns   string       `xml:"xmlns,attr"`
+		Keys    []graphMLKey `xml:"key"`
+		Graph   graphMLGraph `xml:"graph"`
+	}
+	graphMLKey struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	graphMLGraph struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	}
+	graphMLNode struct {
+		ID   string        `xml:"id,attr"`
+		Data []graphMLData `xml:"data"`
+	}
+	graphMLEdge struct {
+		Source string        `xml:"source,attr"`
+		Target string        `xml:"target,attr"`
+		Data   []graphMLData `xml:"data"`
+	}
+	graphMLData struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+// graphMLNodeKeys are the node attributes exported to GraphML.
+var graphMLNodeKeys = []string{"digest", "op", "ident", "position", "state", "cached", "error", "runtime", "collapsed", "highlight"}
+
+// writeGraphML writes g in GraphML format.
+func writeGraphML(w io.Writer, g *evalGraph, highlight func(*evalNode) string) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{ID: g.Name, EdgeDefault: "directed"},
+	}
+	for _, k := range graphMLNodeKeys {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: k, For: "node", Name: k, Type: "string"})
+	}
+	doc.Keys = append(doc.Keys, graphMLKey{ID: "dynamic", For: "edge", Name: "dynamic", Type: "boolean"})
+	for _, n := range g.Nodes {
+		values := map[string]string{
+			"op":        n.Op,
+			"ident":     n.Ident,
+			"position":  n.Position,
+			"state":     n.State,
+			"cached":    fmt.Sprint(n.Cached),
+			"error":     n.Error,
+			"highlight": highlight(n),
+		}
+		if !n.Digest.IsZero() {
+			values["digest"] = n.Digest.String()
+		}
+		if n.Runtime > 0 {
+			values["runtime"] = n.Runtime.String()
+		}
+		if n.Collapsed > 0 {
+			values["collapsed"] = fmt.Sprint(n.Collapsed)
+		}
+		gn := graphMLNode{ID: n.DOTID}
+		for _, k := range graphMLNodeKeys {
+			if v := values[k]; v != "" {
+				gn.Data = append(gn.Data, graphMLData{Key: k, Value: v})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: e.From.DOTID,
+			Target: e.To.DOTID,
+			Data:   []graphMLData{{Key: "dynamic", Value: fmt.Sprint(e.Dynamic)}},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGraph is a map node whose subtree (two execs and a merge)
+// depends on an intern shared with another exec.
+const testGraph = `strict digraph "reflow flowgraph test" {
+	"00000001-exec-root" [op=exec ident=root state=done];
+	"00000002-map-mapped" [op=map ident=mapped state=done runtime="1m0s"];
+	"00000003-exec-each" [op=exec ident=each state=done error="exec failed"];
+	"00000004-exec-each" [op=exec ident=each state=done];
+	"00000005-merge-merged" [op=merge ident=merged state=done];
+	"00000006-intern-input" [op=intern ident=input state=done cached=true];
+	"00000007-exec-other" [op=exec ident=other state=done runtime="2h0m0s"];
+	"00000001-exec-root" -> "00000002-map-mapped";
+	"00000001-exec-root" -> "00000007-exec-other";
+	"00000002-map-mapped" -> "00000005-merge-merged" [dynamic=true];
+	"00000005-merge-merged" -> "00000003-exec-each";
+	"00000005-merge-merged" -> "00000004-exec-each";
+	"00000003-exec-each" -> "00000006-intern-input";
+	"00000004-exec-each" -> "00000006-intern-input";
+	"00000007-exec-other" -> "00000006-intern-input";
+}`
+
+func idents(g *evalGraph) string {
+	var ids []string
+	for _, n := range g.Nodes {
+		ids = append(ids, n.Short)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestEvalGraphCollapse(t *testing.T) {
+	g, err := parseEvalGraph([]byte(testGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(g.Edges), 8; got != want {
+		t.Fatalf("got %v edges, want %v", got, want)
+	}
+	c := g.Collapse("map", "k", "kctx")
+	if got, want := idents(c), "00000001,00000002,00000006,00000007"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	m := c.byShort["00000002"]
+	if got, want := m.Collapsed, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !m.Failed() {
+		t.Error("collapsed node should inherit failures")
+	}
+	if got, want := len(m.Deps), 1; got != want || m.Deps[0].Short != "00000006" {
+		t.Errorf("got deps %v, want [00000006]", m.Deps)
+	}
+	// The original graph is left untouched.
+	if got, want := len(g.Nodes), 7; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEvalGraphFilter(t *testing.T) {
+	g, err := parseEvalGraph([]byte(testGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		ident    string
+		distance int
+		want     string
+	}{
+		{"each", 0, "00000003,00000004"},
+		{"each", 1, "00000003,00000004,00000005,00000006"},
+		{"other", 2, "00000001,00000002,00000003,00000004,00000006,00000007"},
+	} {
+		matched := make(map[*evalNode]bool)
+		for _, n := range g.Nodes {
+			if n.Ident == test.ident {
+				matched[n] = true
+			}
+		}
+		hood := g.Neighborhood(matched, test.distance)
+		sub := g.Subgraph(func(n *evalNode) bool { return hood[n] })
+		if got := idents(sub); got != test.want {
+			t.Errorf("%s/%d: got %v, want %v", test.ident, test.distance, got, test.want)
+		}
+	}
+}
+
+func TestEvalGraphExport(t *testing.T) {
+	g, err := parseEvalGraph([]byte(testGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	highlight := func(n *evalNode) string {
+		switch {
+		case n.Failed():
+			return "failed"
+		case n.Runtime >= time.Hour:
+			return "slow"
+		}
+		return ""
+	}
+	var b bytes.Buffer
+	if err := writeGraphDOT(&b, g, highlight); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := parseEvalGraph(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idents(rt), idents(g); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rt.byShort["00000007"].Attrs["fillcolor"], "orange"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rt.byShort["00000003"].Attrs["fillcolor"], "red"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if err := writeGraphJSON(&b, g, highlight); err != nil {
+		t.Fatal(err)
+	}
+	var jg jsonGraph
+	if err := json.Unmarshal(b.Bytes(), &jg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(jg.Nodes), 7; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(jg.Edges), 8; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if err := writeGraphML(&b, g, highlight); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(b.String(), "<node "), 7; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
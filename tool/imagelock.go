@@ -0,0 +1,103 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+// ImageLock pins the images used by a module to registry digest
+// references. Image locks are stored in lockfiles next to Reflow
+// modules (see lockfilePath), and are embedded in Reflow bundles.
+type ImageLock map[string]string
+
+// lockfilePath returns the path of the lockfile for the module
+// at the given path.
+func lockfilePath(program string) string {
+	return program + ".lock"
+}
+
+// lockfile is the on-disk format of an image lock.
+type lockfile struct {
+	Images ImageLock `json:"images"`
+}
+
+// readImageLock reads the image lock stored in the lockfile at path.
+// ReadImageLock returns a nil lock if the lockfile does not exist.
+func readImageLock(path string) (ImageLock, error) {
+	p, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lf lockfile
+	if err := json.Unmarshal(p, &lf); err != nil {
+		return nil, errors.E("read lockfile", path, err)
+	}
+	if lf.Images == nil {
+		lf.Images = make(ImageLock)
+	}
+	return lf.Images, nil
+}
+
+// writeImageLock writes the image lock l to a lockfile at path.
+func writeImageLock(path string, l ImageLock) error {
+	p, err := json.MarshalIndent(lockfile{l}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(p, '\n'), 0644)
+}
+
+// imageDrift describes an image whose resolved digest reference
+// differs from the one recorded in an image lock.
+type imageDrift struct {
+	// Image is the image name as it appears in the module.
+	Image string
+	// Locked is the reference recorded in the lock; it is empty if
+	// the image was not locked.
+	Locked string
+	// Resolved is the reference the image currently resolves to.
+	Resolved string
+}
+
+func (d imageDrift) String() string {
+	if d.Locked == "" {
+		return fmt.Sprintf("image %s is not locked (resolves to %s)", d.Image, d.Resolved)
+	}
+	return fmt.Sprintf("image %s is locked to %s but resolves to %s", d.Image, d.Locked, d.Resolved)
+}
+
+// Drift returns the images in the resolved image map whose resolved
+// references differ from those recorded in the lock, ordered by
+// image name.
+func (l ImageLock) Drift(resolved map[string]string) []imageDrift {
+	var drift []imageDrift
+	for image, ref := range resolved {
+		if locked := l[image]; locked != ref {
+			drift = append(drift, imageDrift{image, locked, ref})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Image < drift[j].Image })
+	return drift
+}
+
+// driftError returns a Precondition error describing the provided drift.
+func driftError(drift []imageDrift) error {
+	msgs := make([]string, len(drift))
+	for i, d := range drift {
+		msgs[i] = d.String()
+	}
+	return errors.E(errors.Precondition,
+		errors.Errorf("image lock drift (rerun with -relock to accept):\n\t%s", strings.Join(msgs, "\n\t")))
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/reflow/errors"
+)
+
+// testRegistry is a stand-in for a Docker registry. It serves
+// manifests for tagged images, which may be retagged.
+type testRegistry struct {
+	mu sync.Mutex
+	// tags maps repository:tag to the tagged manifest.
+	tags map[string]string
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{tags: make(map[string]string)}
+}
+
+// Tag tags an image with a new, unique manifest and returns its digest.
+func (r *testRegistry) Tag(repo, tag string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifest := fmt.Sprintf(`{"schemaVersion": 2, "repo": %q, "tag": %q, "version": %d}`, repo, tag, len(r.tags))
+	r.tags[repo+":"+tag] = manifest
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/v2/" {
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	i := strings.LastIndex(path, "/manifests/")
+	if i < 0 {
+		http.NotFound(w, req)
+		return
+	}
+	repo, tag := path[:i], path[i+len("/manifests/"):]
+	r.mu.Lock()
+	manifest, ok := r.tags[repo+":"+tag]
+	r.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors": [{"code": "MANIFEST_UNKNOWN"}]}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	fmt.Fprint(w, manifest)
+}
+
+func TestImageLock(t *testing.T) {
+	reg := newTestRegistry()
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	var (
+		image = host + "/grailbio/tool:latest"
+		other = host + "/grailbio/other"
+		d1    = reg.Tag("grailbio/tool", "latest")
+		_     = reg.Tag("grailbio/other", "latest")
+		r     = ImageResolver{Authenticator: nilAuthenticator{}}
+		ctx   = context.Background()
+	)
+	resolved, err := r.ResolveImages(ctx, []string{image})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resolved[image], host+"/grailbio/tool@"+d1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "imagelock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.rf.lock")
+	if lock, err := readImageLock(path); err != nil || lock != nil {
+		t.Fatalf("got %v, %v, want nil, nil", lock, err)
+	}
+	if err := writeImageLock(path, ImageLock(resolved)); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := readImageLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A locked evaluation uses the locked images.
+	e := Eval{Images: []string{image}, ImageLock: lock, LockPath: path}
+	resolved, err = r.ResolveImages(ctx, e.Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.applyImageLock(resolved); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.ImageMap[image], lock[image]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Moving the tag, or using an image that is not locked, fails
+	// the evaluation.
+	d2 := reg.Tag("grailbio/tool", "latest")
+	e.Images = []string{image, other}
+	resolved, err = r.ResolveImages(ctx, e.Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drift := lock.Drift(resolved)
+	if got, want := len(drift), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := drift[0].Image, other; got != want || drift[0].Locked != "" {
+		t.Errorf("got %+v, want unlocked %v", drift[0], want)
+	}
+	if got, want := drift[1], (imageDrift{image, host + "/grailbio/tool@" + d1, host + "/grailbio/tool@" + d2}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if err := e.applyImageLock(resolved); !errors.Is(errors.Precondition, err) {
+		t.Errorf("got %v, want precondition error", err)
+	}
+
+	// Relocking accepts the new images and rewrites the lockfile.
+	e.Relock = true
+	if err := e.applyImageLock(resolved); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.ImageMap[image], host+"/grailbio/tool@"+d2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	lock, err = readImageLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lock.Drift(resolved); len(got) != 0 {
+		t.Errorf("got drift %v after relock", got)
+	}
+}
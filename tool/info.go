@@ -297,6 +297,7 @@ func (c *Cmd) printAlloc(ctx context.Context, w io.Writer, inspect pool.AllocIns
 	fmt.Fprintf(w, "\tcpu:\t%.1f\n", inspect.Resources["cpu"])
 	fmt.Fprintf(w, "\tdisk:\t%s\n", data.Size(inspect.Resources["disk"]))
 	fmt.Fprintf(w, "\towner:\t%s\n", inspect.Meta.Owner)
+	fmt.Fprintf(w, "\tstate:\t%s\n", inspect.State)
 	fmt.Fprintf(w, "\tkeepalive:\t%s (%s ago)\n", inspect.LastKeepalive, round(time.Since(inspect.LastKeepalive)))
 	if expires := time.Until(inspect.Expires); expires < time.Duration(0) {
 		fmt.Fprintf(w, "\texpires:\t%s (%s ago)\n", inspect.Expires, round(-expires))
@@ -0,0 +1,113 @@
+// Copyright 2020 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/grailbio/reflow/syntax"
+)
+
+func (c *Cmd) lock(ctx context.Context, args ...string) {
+	var (
+		flags     = flag.NewFlagSet("lock", flag.ExitOnError)
+		outFlag   = flags.String("o", "", "write the lock to this path")
+		checkFlag = flags.Bool("check", false, "report drift from the current lock without writing a new one")
+		help      = `Lock resolves every Docker image used by a Reflow module to its
+registry digest and records the result in an image lock.
+
+For modules (".rf"), the lock is written to a lockfile named by the
+module path with the suffix ".lock"; command bundle embeds the
+lockfile in the bundles it creates. For bundles (".rfx"), the lock is
+embedded in the bundle, which is rewritten in place unless -o is given.
+
+Command run evaluates locked modules with the locked images. If an
+image now resolves to a different digest (for example, because its
+tag was moved), or if the module uses an image that is not locked,
+the run fails, unless it is given the flag -relock, in which case
+the lock is updated.
+
+With -check, lock reports the images that have drifted from the
+module's current lock and exits with a non-zero status if any have.`
+	)
+	c.Parse(flags, args, help, "lock [-check] [-o output] path [args]")
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	e := Eval{InputArgs: flags.Args()}
+	c.must(e.Run())
+	if !e.V1 {
+		c.Fatalf("lock is supported only for v1 (.rf, .rfx) modules")
+	}
+	r, err := imageResolver(c.Config)
+	c.must(err)
+	resolved, err := r.ResolveImages(ctx, e.Images)
+	c.must(err)
+	lock := ImageLock(resolved)
+
+	if *checkFlag {
+		if e.ImageLock == nil {
+			c.Fatalf("%s is not locked", e.Program)
+		}
+		drift := e.ImageLock.Drift(resolved)
+		for _, d := range drift {
+			c.Println(d)
+		}
+		if len(drift) > 0 {
+			c.Exit(1)
+		}
+		return
+	}
+
+	var tw tabwriter.Writer
+	tw.Init(c.Stdout, 4, 4, 1, ' ', 0)
+	images := make([]string, 0, len(lock))
+	for image := range lock {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		status := "unchanged"
+		switch locked, ok := e.ImageLock[image]; {
+		case !ok:
+			status = "new"
+		case locked != lock[image]:
+			status = "updated"
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\n", image, lock[image], status)
+	}
+	c.must(tw.Flush())
+
+	if filepath.Ext(e.Program) != ".rfx" {
+		path := *outFlag
+		if path == "" {
+			path = e.LockPath
+		}
+		c.must(writeImageLock(path, lock))
+		return
+	}
+	// Rewrite the bundle with the new lock. We reopen the bundle
+	// itself: e.Bundle is a bundle of the session, which wraps the
+	// bundle module.
+	p, err := ioutil.ReadFile(e.Program)
+	c.must(err)
+	bundle, err := syntax.OpenBundle(bytes.NewReader(p), int64(len(p)))
+	c.must(err)
+	bundle.SetImageLock(lock)
+	var b bytes.Buffer
+	c.must(bundle.WriteTo(&b))
+	path := *outFlag
+	if path == "" {
+		path = e.Program
+	}
+	c.must(ioutil.WriteFile(path, b.Bytes(), 0644))
+}
@@ -92,6 +92,9 @@ var commands = map[string]Func{
 	"cat":          (*Cmd).cat,
 	"sync":         (*Cmd).sync,
 	"kill":         (*Cmd).kill,
+	"cordon":       (*Cmd).cordon,
+	"uncordon":     (*Cmd).uncordon,
+	"drain":        (*Cmd).drain,
 	"logs":         (*Cmd).logs,
 	"batchrun":     (*Cmd).batchrun,
 	"runbatch":     (*Cmd).runbatch,
@@ -110,6 +113,11 @@ var commands = map[string]Func{
 	"http":         (*Cmd).http,
 	"upgrade":      (*Cmd).upgrade,
 	"ec2verify":    (*Cmd).ec2verify,
+	"analyze":      (*Cmd).analyze,
+	"graph":        (*Cmd).graph,
+	"cache":        (*Cmd).cache,
+	"flowdiff":     (*Cmd).flowdiff,
+	"lock":         (*Cmd).lock,
 }
 
 var intro = `The reflow command helps users run Reflow programs, ExecInspect their
@@ -270,6 +278,7 @@ func (c *Cmd) Main() {
 	var err error
 	c.Config, err = c.Schema.Make(c.SchemaKeys)
 	c.must(err)
+	c.must(configureTiers(c.Config, c.Schema))
 
 	var (
 		bootstrapimage *infra2.BootstrapImage
@@ -26,7 +26,7 @@ import (
 )
 
 const (
-	runHeader                   = "runid\tuser\tstart\tend\tExecLog\tSysLog\tEvalGraph"
+	runHeader                   = "runid\tuser\tstart\tend\tExecLog\tSysLog\tEvalGraph\tspend"
 	taskHeader                  = "taskid\tident\tstart\tend\tduration\tstate\tmem\tcpu\tdisk\tprocs"
 	taskHeaderLongWithTaskDB    = "uri/resultid\tinspect"
 	taskHeaderLongWithoutTaskDB = "uri"
@@ -69,7 +69,7 @@ task:
 	ident         the exec identifier
 	time          the exec's start time
 	duration      the exec's run duration
-	state         the exec's state
+	state         the exec's state, and the state of its alloc if it is cordoned or draining
 	mem           the amount of memory used by the exec
 	cpu           the number of CPU cores used by the exec
 	disk          the total amount of disk space used by the exec
@@ -205,13 +205,18 @@ printed on the console.`
 				// This is a conservative estimate--we don't keep track of total max.
 				disk = info.Profile["disk"].Max + info.Profile["tmp"].Max
 			}
+			state := info.State
+			if info.Alloc.State != pool.AllocActive {
+				// Show the state of cordoned and draining allocs.
+				state += "(" + info.Alloc.State.String() + ")"
+			}
 			runtime := info.Runtime()
 			fmt.Fprintf(&tw, "%s\t%s\t%s\t%d:%02d\t%s\t%s\t%.1f\t%s\t%s",
 				info.ID.Short(), info.Config.Ident,
 				info.Created.Local().Format(layout),
 				int(runtime.Hours()),
 				int(runtime.Minutes()-60*runtime.Hours()),
-				info.State,
+				state,
 				data.Size(mem), cpu, data.Size(disk),
 				procs,
 			)
@@ -385,7 +390,7 @@ func (c *Cmd) writeRuns(ri []runInfo, w io.Writer, longListing bool) {
 		if len(run.taskInfo) == 0 {
 			continue
 		}
-		var st, et, exec, sys, graph string
+		var st, et, exec, sys, graph, spend string
 		layout := time.RFC822
 		if t := run.Run.Start; !t.IsZero() {
 			layout = format(t)
@@ -403,8 +408,11 @@ func (c *Cmd) writeRuns(ri []runInfo, w io.Writer, longListing bool) {
 		if d := run.Run.EvalGraph; !d.IsZero() {
 			graph = d.Short()
 		}
+		if s := run.Run.Spend; s != (taskdb.Spend{}) {
+			spend = fmt.Sprintf("%.1fh/$%.2f", s.InstanceHours, s.Dollars)
+		}
 		fmt.Fprint(w, runHeader, "\n")
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", run.Run.ID.IDShort(), run.Run.User, st, et, exec, sys, graph)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", run.Run.ID.IDShort(), run.Run.User, st, et, exec, sys, graph, spend)
 		fmt.Fprint(w, "\t", taskHeader)
 		if longListing {
 			fmt.Fprint(w, "\t", taskHeaderLongWithTaskDB)
@@ -30,6 +30,7 @@ import (
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/syntax"
 	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/tiered"
 	"github.com/grailbio/reflow/trace"
 	"github.com/grailbio/reflow/wg"
 )
@@ -75,8 +76,18 @@ retriable.`
 	file, args := flags.Arg(0), flags.Args()[1:]
 	e := Eval{
 		InputArgs: flags.Args(),
+		Relock:    config.Relock,
+	}
+	if config.MemoEval {
+		memo := &syntax.Memo{Log: c.Log}
+		c.must(c.Config.Instance(&memo.Assoc))
+		c.must(c.Config.Instance(&memo.Repository))
+		e.Memo = memo
 	}
 	c.must(e.Run())
+	if e.Memo != nil {
+		c.Log.Debug(e.Memo)
+	}
 	c.must(e.ResolveImages(c.Config))
 
 	if e.V1 && config.GC {
@@ -111,6 +122,22 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 		c.SchemaKeys[reflowinfra.Cluster] = fmt.Sprintf("localcluster,dir=%v", dir)
 		c.Config, err = c.Schema.Make(c.SchemaKeys)
 		c.must(err)
+		c.must(configureTiers(c.Config, c.Schema))
+	}
+	var tiers *tiered.Cache
+	if c.Config.Instance(&tiers) == nil {
+		if tiers.Async && !runFlags.Local {
+			// Remote executors cannot access objects that are not yet
+			// promoted to the write tier.
+			c.Log.Printf("tiered cache: asynchronous promotion requires -local; promoting synchronously")
+			tiers.Async = false
+		}
+		// Let pending promotions complete so that cache hits from
+		// slower tiers are available locally for subsequent runs.
+		c.onexit(func() {
+			tiers.Wait()
+			c.Log.Debugf("tiered cache: %s", tiers.Stats())
+		})
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -148,7 +175,7 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 	base := c.Runbase(runID)
 	c.must(os.MkdirAll(filepath.Dir(base), 0777))
 	var (
-		execfile, logfile, dotfile *os.File
+		execfile, logfile, dotfile, evalgraphfile *os.File
 	)
 	if execfile, err = os.Create(base + ".execlog"); err != nil {
 		c.Fatal(err)
@@ -163,6 +190,10 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 		c.Fatal(err)
 	}
 	defer dotfile.Close()
+	if evalgraphfile, err = os.Create(base + ".evalgraph.gv"); err != nil {
+		c.Fatal(err)
+	}
+	defer evalgraphfile.Close()
 
 	// execLogger is the target for exec status; we also output
 	// this to the main logger's outputter. The file-based log always
@@ -189,7 +220,7 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 			}()
 		}
 	}
-	runConfig.RunFlags.Cluster, err = clusterInstance(c.Config, c.Status)
+	runConfig.RunFlags.Cluster, err = clusterInstance(c.Config, c.Schema, c.Status)
 	c.must(err)
 	r, err := NewRunner(ctx, runConfig, c.Log)
 	if err != nil {
@@ -206,6 +237,7 @@ func (c *Cmd) runCommon(ctx context.Context, runFlags RunFlags, e Eval, file str
 	r.Log = execLogger
 	r.RunID = runID
 	r.DotWriter = dotfile
+	r.EvalGraphWriter = evalgraphfile
 
 	result, err = r.Go(ctx)
 	if err != nil {
@@ -263,9 +295,13 @@ func (c Cmd) WaitForBackgroundTasks(wg *wg.WaitGroup, timeout time.Duration) {
 // AssertionGenerator returns the configured AssertionGenerator mux.
 func assertionGenerator(config infra.Config) (reflow.AssertionGeneratorMux, error) {
 	mux := make(reflow.AssertionGeneratorMux)
-	var err error
-	mux[blob.AssertionsNamespace], err = blobMux(config)
-	return mux, err
+	bm, err := blobMux(config)
+	if err != nil {
+		return nil, err
+	}
+	mux[blob.AssertionsNamespace] = bm
+	mux[blob.ContentAssertionsNamespace] = blob.NewContentGenerator(bm)
+	return mux, nil
 }
 
 // asserter returns a reflow.Assert based on the given name.
@@ -275,11 +311,54 @@ func asserter(name string) (reflow.Assert, error) {
 		return reflow.AssertNever, nil
 	case "exact":
 		return reflow.AssertExact, nil
+	case "content":
+		return blob.AssertContent, nil
+	case "size":
+		return reflow.AssertProperties("size"), nil
 	default:
 		return nil, fmt.Errorf("unknown Assert policy %s", name)
 	}
 }
 
+// configureAssertPolicy configures the provided EvalConfig with the
+// AssertPolicy given by config, if any. Assertions that are not
+// governed by any of the policy's rules are asserted by the named
+// default policy.
+func configureAssertPolicy(c *flow.EvalConfig, config infra.Config, def string) error {
+	var pc *reflowinfra.AssertPolicyConfig
+	if config.Instance(&pc) != nil || len(pc.Rules) == 0 {
+		return nil
+	}
+	rule := func(prefix, namespace, name string) (reflow.AssertRule, error) {
+		r := reflow.AssertRule{Prefix: prefix, Namespace: namespace, Policy: name}
+		if name == "never" {
+			return r, nil
+		}
+		if name == "content" {
+			c.FileAssertions = blob.ContentAssertions
+		}
+		var err error
+		r.Assert, err = asserter(name)
+		return r, err
+	}
+	var (
+		policy = new(reflow.AssertPolicy)
+		err    error
+	)
+	if policy.Default, err = rule("", "", def); err != nil {
+		return err
+	}
+	for _, rc := range pc.Rules {
+		r, err := rule(rc.Prefix, rc.Namespace, rc.Policy)
+		if err != nil {
+			return err
+		}
+		policy.Rules = append(policy.Rules, r)
+	}
+	c.AssertPolicy = policy
+	return nil
+}
+
 func dockerClient() (*docker.Client, reflow.Resources, error) {
 	addr := os.Getenv("DOCKER_HOST")
 	if addr == "" {
@@ -7,6 +7,7 @@ import (
 	"regexp"
 
 	"github.com/grailbio/reflow"
+	"github.com/grailbio/reflow/blob"
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/flow"
 	"github.com/grailbio/reflow/runner"
@@ -30,8 +31,21 @@ type CommonRunFlags struct {
 	Assert string
 	// Use scalable scheduler instead of the work stealer mode.
 	Sched bool
+	// SchedService is the URL of a shared scheduling service to which
+	// tasks are submitted, instead of being scheduled by the run itself.
+	SchedService string
 	// PostUseChecksum indicates whether input filesets are checksummed after use.
 	PostUseChecksum bool
+	// Relock accepts images that have drifted from the module's image
+	// lock, updating the lock, instead of failing the run.
+	Relock bool
+	// FuseExecs fuses chains of single-consumer execs so that they
+	// run together on the same alloc (see flow.Config.FuseExecs).
+	FuseExecs bool
+	// VerifyDeterminism is the fraction of cache-missing execs that
+	// are re-executed to verify that they are deterministic (see
+	// flow.EvalConfig.VerifyDeterminism).
+	VerifyDeterminism float64
 }
 
 // Flags adds the common run flags to the provided flagset.
@@ -41,9 +55,13 @@ func (r *CommonRunFlags) Flags(flags *flag.FlagSet) {
 	flags.BoolVar(&r.RecomputeEmpty, "recomputeempty", false, "recompute empty cache values")
 	flags.StringVar(&r.EvalStrategy, "eval", "topdown", "evaluation strategy")
 	flags.StringVar(&r.Invalidate, "invalidate", "", "regular expression for node identifiers that should be invalidated")
-	flags.StringVar(&r.Assert, "assert", "never", "policy used to Assert cached flow result compatibility (eg: never, exact)")
+	flags.StringVar(&r.Assert, "assert", "never", "policy used to Assert cached flow result compatibility (eg: never, exact, content, size)")
 	flags.BoolVar(&r.Sched, "sched", true, "use scalable scheduler instead of work stealing")
+	flags.StringVar(&r.SchedService, "schedservice", "", "URL of a shared scheduling service (see reflow serve -sched) to which tasks are submitted")
 	flags.BoolVar(&r.PostUseChecksum, "postusechecksum", false, "checksum files after use")
+	flags.BoolVar(&r.Relock, "relock", false, "accept and relock images that have drifted from the module's image lock")
+	flags.BoolVar(&r.FuseExecs, "fuseexecs", false, "fuse chains of execs, where each exec's output is consumed only by the next, into single execs that run on the same alloc")
+	flags.Float64Var(&r.VerifyDeterminism, "verify-determinism", 0, "fraction of cache-missing execs to re-execute on a different alloc, reporting those whose outputs differ")
 }
 
 // Err checks if the flag values are consistent and valid.
@@ -59,6 +77,15 @@ func (r *CommonRunFlags) Err() error {
 			return err
 		}
 	}
+	if r.SchedService != "" && !r.Sched {
+		return errors.New("-schedservice cannot be used without -sched")
+	}
+	if r.VerifyDeterminism < 0 || r.VerifyDeterminism > 1 {
+		return fmt.Errorf("invalid determinism verification fraction %v: must be in [0, 1]", r.VerifyDeterminism)
+	}
+	if r.VerifyDeterminism > 0 && !r.Sched {
+		return errors.New("-verify-determinism cannot be used without -sched")
+	}
 	return nil
 }
 
@@ -70,11 +97,16 @@ func (r *CommonRunFlags) Configure(c *flow.EvalConfig) error {
 	if err != nil {
 		return err
 	}
+	if r.Assert == "content" {
+		c.FileAssertions = blob.ContentAssertions
+	}
 	c.NoCacheExtern = r.NoCacheExtern
 	c.GC = r.GC
 	c.RecomputeEmpty = r.RecomputeEmpty
 	c.BottomUp = r.EvalStrategy == "bottomup"
 	c.PostUseChecksum = r.PostUseChecksum
+	c.Config.FuseExecs = r.FuseExecs
+	c.VerifyDeterminism = r.VerifyDeterminism
 	if r.Invalidate != "" {
 		re := regexp.MustCompile(r.Invalidate)
 		c.Invalidate = func(f *flow.Flow) bool {
@@ -101,6 +133,9 @@ type RunFlags struct {
 	Resources reflow.Resources
 	Cache     bool
 	Pred      bool
+	// MemoEval memoizes the values of immediate module-level
+	// declarations in the cache (see syntax.Memo).
+	MemoEval bool
 	// Cluster is the externally specified cluster provider. If non-nil, this cluster provider overrides the one specified in the reflow config.
 	Cluster runner.Cluster
 
@@ -119,6 +154,7 @@ func (r *RunFlags) Flags(flags *flag.FlagSet) {
 	flags.BoolVar(&r.Trace, "trace", false, "trace flow evaluation")
 	flags.StringVar(&r.resourcesFlag, "resources", "", "override offered resources in local mode (JSON formatted reflow.Resources)")
 	flags.BoolVar(&r.Pred, "pred", false, "use predictor to optimize resource usage. sched must also be true for the predictor to be used")
+	flags.BoolVar(&r.MemoEval, "memoeval", false, "memoize the values of module declarations in the cache, so that they are not recomputed by subsequent runs")
 }
 
 // Err checks if the flag values are consistent and valid.
@@ -12,6 +12,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -33,16 +34,20 @@ import (
 	"github.com/grailbio/reflow/ec2cluster"
 	"github.com/grailbio/reflow/errors"
 	"github.com/grailbio/reflow/flow"
+	"github.com/grailbio/reflow/hostcluster"
 	infra2 "github.com/grailbio/reflow/infra"
 	"github.com/grailbio/reflow/log"
+	"github.com/grailbio/reflow/multicluster"
 	"github.com/grailbio/reflow/pool"
 	"github.com/grailbio/reflow/predictor"
 	"github.com/grailbio/reflow/repository"
 	"github.com/grailbio/reflow/repository/blobrepo"
 	repositoryhttp "github.com/grailbio/reflow/repository/http"
+	"github.com/grailbio/reflow/rest"
 	"github.com/grailbio/reflow/runner"
 	"github.com/grailbio/reflow/sched"
 	"github.com/grailbio/reflow/taskdb"
+	"github.com/grailbio/reflow/tiered"
 	"github.com/grailbio/reflow/wg"
 	"golang.org/x/net/http2"
 )
@@ -89,17 +94,14 @@ func httpClient(config infra.Config) (*http.Client, error) {
 	return &http.Client{Transport: transport}, nil
 }
 
-func clusterInstance(config infra.Config, status *status.Status) (runner.Cluster, error) {
+func clusterInstance(config infra.Config, schema infra.Schema, status *status.Status) (runner.Cluster, error) {
 	var cluster runner.Cluster
 	err := config.Instance(&cluster)
 	if err != nil {
 		return nil, err
 	}
-	if ec, ok := cluster.(*ec2cluster.Cluster); ok {
-		if status != nil {
-			ec.Status = status.Group("ec2cluster")
-		}
-		ec.Configuration = config
+	if err = configureCluster(cluster, config, schema, status, ""); err != nil {
+		return nil, err
 	}
 	var sess *session.Session
 	err = config.Instance(&sess)
@@ -111,6 +113,7 @@ func clusterInstance(config infra.Config, status *status.Status) (runner.Cluster
 	if err != nil {
 		return nil, err
 	}
+	configurePoolAuth(repositoryhttp.HTTPClient, config)
 	if n, ok := cluster.(needer); ok {
 		http.HandleFunc("/clusterneed", func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != "GET" {
@@ -128,20 +131,141 @@ func clusterInstance(config infra.Config, status *status.Status) (runner.Cluster
 	return cluster, nil
 }
 
+// configureCluster performs provider-specific configuration of a
+// cluster instantiated from config. The cluster's status is reported
+// in a group with the provided name, or else one named by the
+// cluster's provider. The member clusters of a multicluster are
+// instantiated from config with the members' providers bound to the
+// cluster key of schema.
+func configureCluster(cluster runner.Cluster, config infra.Config, schema infra.Schema, status *status.Status, name string) error {
+	switch c := cluster.(type) {
+	case *ec2cluster.Cluster:
+		if name == "" {
+			name = "ec2cluster"
+		}
+		if status != nil {
+			c.Status = status.Group(name)
+		}
+		c.Configuration = config
+		configurePoolAuth(c.HTTPClient, config)
+	case *hostcluster.Cluster:
+		if name == "" {
+			name = "hostcluster"
+		}
+		if status != nil {
+			c.Status = status.Group(name)
+		}
+		configurePoolAuth(c.HTTPClient, config)
+	case *multicluster.Cluster:
+		if schema == nil {
+			return errors.New("multicluster: member clusters require an infrastructure schema")
+		}
+		return c.Configure(func(m *multicluster.Member) (runner.Cluster, error) {
+			keys := config.Keys.Clone()
+			keys[infra2.Cluster] = m.Provider
+			mconfig, err := schema.Make(keys)
+			if err != nil {
+				return nil, err
+			}
+			var member runner.Cluster
+			if err := mconfig.Instance(&member); err != nil {
+				return nil, err
+			}
+			return member, configureCluster(member, mconfig, schema, status, m.Name)
+		})
+	}
+	return nil
+}
+
+// configurePoolAuth configures the provided (cluster, repository,
+// or scheduling service) client to present the user's token, if
+// any, in its calls to reflowlets and scheduling services.
+func configurePoolAuth(client *http.Client, config infra.Config) {
+	var auth *infra2.PoolAuthConfig
+	if client == nil || config.Instance(&auth) != nil {
+		return
+	}
+	if _, ok := client.Transport.(*rest.TokenTransport); ok {
+		return
+	}
+	client.Transport = auth.Transport(client.Transport)
+}
+
+// configureTiers instantiates the assocs and repositories of the
+// tiers of the tiered cache in config, if one is configured. Each
+// tier's assoc and repository are instantiated from config with the
+// tier's providers bound to the assoc and repository keys of schema.
+func configureTiers(config infra.Config, schema infra.Schema) error {
+	var cache *tiered.Cache
+	if config.Instance(&cache) != nil {
+		return nil
+	}
+	return cache.Configure(func(t *tiered.Tier) error {
+		keys := config.Keys.Clone()
+		keys[infra2.Assoc] = t.AssocProvider
+		keys[infra2.Repository] = t.RepositoryProvider
+		tconfig, err := schema.Make(keys)
+		if err != nil {
+			return err
+		}
+		if err := tconfig.Instance(&t.Assoc); err != nil {
+			return err
+		}
+		return tconfig.Instance(&t.Repository)
+	})
+}
+
 // NewScheduler returns a new scheduler with the specified configuration.
 // Cancelling the returned context.CancelFunc stops the scheduler.
 func NewScheduler(ctx context.Context, config infra.Config, wg *wg.WaitGroup, cluster runner.Cluster, logger *log.Logger, status *status.Status) (*sched.Scheduler, error) {
+	if logger == nil {
+		if err := config.Instance(&logger); err != nil {
+			return nil, err
+		}
+	}
+	scheduler, err := newScheduler(config, cluster, logger, status)
+	if err != nil {
+		return nil, err
+	}
+	startScheduler(ctx, scheduler, wg, logger)
+	return scheduler, nil
+}
+
+// NewRemoteScheduler returns a new scheduler that submits its tasks
+// to the scheduling service at the provided URL (see "reflow serve
+// -sched"). The execs of running tasks are retrieved from the provided
+// cluster. Cancelling the provided context stops the scheduler.
+func NewRemoteScheduler(ctx context.Context, config infra.Config, wg *wg.WaitGroup, service string, cluster runner.Cluster, logger *log.Logger) (*sched.Scheduler, error) {
+	if logger == nil {
+		if err := config.Instance(&logger); err != nil {
+			return nil, err
+		}
+	}
+	u, err := url.Parse(service)
+	if err != nil {
+		return nil, errors.E("scheduling service", service, err)
+	}
+	client, err := httpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	configurePoolAuth(client, config)
+	scheduler := sched.New()
+	scheduler.Log = logger.Tee(nil, "scheduler: ")
+	scheduler.Remote = sched.NewRemote(rest.NewClient(client, u, logger), cluster)
+	startScheduler(ctx, scheduler, wg, logger)
+	return scheduler, nil
+}
+
+// newScheduler returns a new scheduler with the specified
+// configuration. The scheduler is not started.
+func newScheduler(config infra.Config, cluster runner.Cluster, logger *log.Logger, status *status.Status) (*sched.Scheduler, error) {
 	var (
 		err   error
 		tdb   taskdb.TaskDB
 		repo  reflow.Repository
 		limit int
 	)
-	if logger == nil {
-		if err = config.Instance(&logger); err != nil {
-			return nil, err
-		}
-	}
 	if err = config.Instance(&tdb); err != nil {
 		if !strings.HasPrefix(err.Error(), "no providers for type taskdb.TaskDB") {
 			return nil, err
@@ -149,7 +273,7 @@ func NewScheduler(ctx context.Context, config infra.Config, wg *wg.WaitGroup, cl
 		logger.Debug(err)
 	}
 	if cluster == nil {
-		if cluster, err = clusterInstance(config, status); err != nil {
+		if cluster, err = clusterInstance(config, nil, status); err != nil {
 			return nil, err
 		}
 	}
@@ -174,12 +298,65 @@ func NewScheduler(ctx context.Context, config infra.Config, wg *wg.WaitGroup, cl
 	scheduler.Transferer = transferer
 	scheduler.Log = logger.Tee(nil, "scheduler: ")
 	scheduler.TaskDB = tdb
+	if pricer, ok := cluster.(sched.Pricer); ok {
+		scheduler.Pricer = pricer
+	}
 	scheduler.ExportStats()
+	if mc, ok := cluster.(*multicluster.Cluster); ok {
+		mc.QueueLen = scheduler.QueueLen
+	}
 	mux, err := blobMux(config)
 	if err != nil {
 		return nil, err
 	}
 	scheduler.Mux = mux
+	return scheduler, nil
+}
+
+// configureBudget configures the budgets of the provided (run)
+// scheduler from the budget provider, if any. The user's budget
+// accounts for the spend of the user's recent runs, as recorded in
+// the taskdb.
+func configureBudget(ctx context.Context, config infra.Config, scheduler *sched.Scheduler, logger *log.Logger) {
+	var budget *infra2.BudgetConfig
+	if err := config.Instance(&budget); err != nil || budget == nil {
+		logger.Debugf("no budget: %v", err)
+		return
+	}
+	if !budget.Run.IsZero() {
+		scheduler.Budget = &budget.Run
+	}
+	if budget.User.IsZero() {
+		return
+	}
+	scheduler.UserBudget = &budget.User
+	var user *infra2.User
+	if err := config.Instance(&user); err != nil {
+		logger.Errorf("user budget: %v", err)
+		return
+	}
+	if scheduler.TaskDB == nil {
+		logger.Errorf("user budget: no taskdb; the spend of user %s's other runs is not accounted for", *user)
+		return
+	}
+	runs, err := scheduler.TaskDB.Runs(ctx, taskdb.RunQuery{User: string(*user), Since: time.Now().Add(-budget.UserPeriod)})
+	if err != nil {
+		logger.Errorf("user budget: runs of user %s: %v", *user, err)
+	}
+	for _, run := range runs {
+		// Only the allocs of runs that are still active count against
+		// the user's budget.
+		if !run.End.IsZero() {
+			run.Spend.Allocs = 0
+		}
+		scheduler.UserSpend.Add(run.Spend)
+	}
+	logger.Debugf("user %s has spent %s in %d runs within the last %s", *user, scheduler.UserSpend, len(runs), budget.UserPeriod)
+}
+
+// startScheduler runs the provided scheduler until the provided
+// context is canceled.
+func startScheduler(ctx context.Context, scheduler *sched.Scheduler, wg *wg.WaitGroup, logger *log.Logger) {
 	wg.Add(1)
 	go func() {
 		err := scheduler.Do(ctx)
@@ -188,7 +365,6 @@ func NewScheduler(ctx context.Context, config infra.Config, wg *wg.WaitGroup, cl
 		}
 		wg.Done()
 	}()
-	return scheduler, nil
 }
 
 // NewRunner returns a new runner that can run the given run config. If scheduler is non nil,
@@ -210,7 +386,7 @@ func NewRunner(ctx context.Context, runConfig RunConfig, logger *log.Logger) (r
 	}()
 	cluster = runConfig.RunFlags.Cluster
 	if cluster == nil {
-		if cluster, err = clusterInstance(runConfig.Config, runConfig.Status); err != nil {
+		if cluster, err = clusterInstance(runConfig.Config, nil, runConfig.Status); err != nil {
 			return nil, err
 		}
 	}
@@ -243,7 +419,13 @@ func NewRunner(ctx context.Context, runConfig RunConfig, logger *log.Logger) (r
 		// disable govet check due to https://github.com/golang/go/issues/29587
 		// schedCancel is called, if appropriate, in a defer above.
 		schedCtx, schedCancel = context.WithCancel(ctx) //nolint: govet
-		scheduler, err = NewScheduler(schedCtx, runConfig.Config, &wg, cluster, logger, runConfig.Status)
+		if service := runConfig.RunFlags.SchedService; service != "" {
+			scheduler, err = NewRemoteScheduler(schedCtx, runConfig.Config, &wg, service, cluster, logger)
+		} else if scheduler, err = newScheduler(runConfig.Config, cluster, logger, runConfig.Status); err == nil {
+			// The run's budgets are configured before its scheduler is started.
+			configureBudget(ctx, runConfig.Config, scheduler, logger)
+			startScheduler(schedCtx, scheduler, &wg, logger)
+		}
 		if err != nil {
 			return //nolint: govet
 		}
@@ -308,6 +490,10 @@ type Runner struct {
 	Log *log.Logger
 	// DotWriter is the writer to write the flow evaluation graph to write to, in dot format.
 	DotWriter io.Writer
+	// EvalGraphWriter is the writer to write the flow evaluation graph,
+	// annotated for post-run analysis, to, in dot format. This is the
+	// graph that is recorded in the taskdb on run completion.
+	EvalGraphWriter io.Writer
 
 	runConfig   RunConfig
 	scheduler   *sched.Scheduler
@@ -368,6 +554,7 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	e := Eval{
 		Program: r.runConfig.Program,
 		Args:    r.runConfig.Args,
+		Relock:  r.runConfig.RunFlags.Relock,
 	}
 	if err = e.Run(); err != nil {
 		return runner.State{}, err
@@ -408,15 +595,19 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	}
 	r.Log.Debug(b.String())
 
+	var (
+		user     *infra2.User
+		username string
+	)
+	if errUser := r.runConfig.Config.Instance(&user); errUser != nil {
+		r.Log.Debug(errUser)
+	} else {
+		username = string(*user)
+	}
 	tctx, tcancel := context.WithCancel(ctx)
 	defer tcancel()
 	if r.tdb != nil {
-		var user *infra2.User
-		errTDB := r.runConfig.Config.Instance(&user)
-		if errTDB != nil {
-			r.Log.Debug(errTDB)
-		}
-		errTDB = r.tdb.CreateRun(tctx, r.RunID, string(*user))
+		errTDB := r.tdb.CreateRun(tctx, r.RunID, username)
 		if errTDB != nil {
 			r.Log.Debugf("error writing run to taskdb: %v", errTDB)
 		} else {
@@ -424,6 +615,9 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 			go func() { _ = r.uploadBundle(tctx, r.repo, r.tdb, r.RunID, e, r.runConfig.Program, r.runConfig.Args) }()
 		}
 	}
+	if r.scheduler != nil && r.scheduler.Remote == nil {
+		go r.reportSpend(tctx)
+	}
 	run := runner.Runner{
 		Flow: e.Main(),
 		EvalConfig: flow.EvalConfig{
@@ -441,6 +635,9 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 			TaskDB:             r.tdb,
 			RunID:              r.RunID,
 			DotWriter:          r.DotWriter,
+			EvalGraphWriter:    r.EvalGraphWriter,
+			Labels:             labels,
+			User:               username,
 		},
 		Type:    e.MainType(),
 		Labels:  labels,
@@ -451,6 +648,9 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	if err = r.runConfig.RunFlags.Configure(&run.EvalConfig); err != nil {
 		return runner.State{}, err
 	}
+	if err = configureAssertPolicy(&run.EvalConfig, r.runConfig.Config, r.runConfig.RunFlags.Assert); err != nil {
+		return runner.State{}, err
+	}
 	run.ID = r.RunID
 	run.Program = e.Program
 	run.Params = e.Params
@@ -492,6 +692,11 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 			if errTDB := r.setRunComplete(tctx, run.State.Completion); errTDB != nil {
 				r.Log.Debugf("error writing run result to taskdb: %v", errTDB)
 			}
+			if r.scheduler != nil && r.scheduler.Remote == nil {
+				if errTDB := r.tdb.SetRunSpend(tctx, r.RunID, r.scheduler.Spend()); errTDB != nil {
+					r.Log.Debugf("error writing run spend to taskdb: %v", errTDB)
+				}
+			}
 		}
 	}()
 
@@ -508,6 +713,29 @@ func (r *Runner) Go(ctx context.Context) (runner.State, error) {
 	return run.State, nil
 }
 
+// reportSpend periodically reports the resources spent by the run's
+// scheduler in the run's status, and records them in the taskdb,
+// until the provided context is done.
+func (r *Runner) reportSpend(ctx context.Context) {
+	status := r.runConfig.Status.Group("spend")
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		spend := r.scheduler.Spend()
+		status.Print(spend.String())
+		if r.tdb != nil {
+			if err := r.tdb.SetRunSpend(ctx, r.RunID, spend); err != nil {
+				r.Log.Debugf("error writing run spend to taskdb: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
 // GetRunID is a getter for the runID associated with the runner.
 func (r *Runner) GetRunID() taskdb.RunID {
 	return r.RunID
@@ -536,7 +764,7 @@ func (r *Runner) setRunComplete(ctx context.Context, endTime time.Time) error {
 			cancel()
 			_ = rc.Close()
 		}
-		if rc, err = os.Open(runbase + ".gv"); err == nil {
+		if rc, err = os.Open(runbase + ".evalgraph.gv"); err == nil {
 			pctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 			if dotFile, err = r.repo.Put(pctx, rc); err != nil {
 				r.Log.Debugf("put dotfile in repo %s: %v", r.repo.URL(), err)
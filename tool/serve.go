@@ -6,9 +6,21 @@ package tool
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"io/ioutil"
+	"net/http"
 
+	infratls "github.com/grailbio/infra/tls"
+	"github.com/grailbio/reflow/errors"
+	infra2 "github.com/grailbio/reflow/infra"
+	"github.com/grailbio/reflow/log"
 	"github.com/grailbio/reflow/reflowlet"
+	"github.com/grailbio/reflow/rest"
+	"github.com/grailbio/reflow/sched"
+	"github.com/grailbio/reflow/wg"
+	"golang.org/x/net/http2"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func (c *Cmd) serveCmd(ctx context.Context, args ...string) {
@@ -26,14 +38,37 @@ Flag -config defines a configuration filename from which the Reflowlet
 restores its configuration. When run in an automatic cluster configuration,
 the configuration is typically sealed, containing both configuration information
 as well as credentials to access various services.
+
+With flag -sched, serve instead runs a scheduling service: a single
+scheduler, using the configured cluster, to which the tasks of many
+runs are submitted (see flag -schedservice of reflow run). The
+service shares the cluster among the users and projects whose tasks
+it runs as configured by the YAML file given by flag -fairshare,
+which is of the form:
+
+	weights:
+	  alice: 2
+	userquotas:
+	  "*": {cpu: 64}
+	projectquotas:
+	  genomics: {cpu: 512, mem: 2199023255552}
+	preempt: true
+
+Tasks are run on behalf of the user running reflow, and of the project
+named by the label "project", if any.
 `
+		schedFlag     = flags.Bool("sched", false, "run a scheduling service instead of a reflowlet")
+		fairShareFlag = flags.String("fairshare", "", "YAML file configuring the scheduling service's fair share policy")
 	)
 	server := reflowlet.NewServer(c.Version, c.Config)
 	server.AddFlags(flags)
-	c.Parse(flags, args, help, "serve [-ec2cluster]")
+	c.Parse(flags, args, help, "serve [-ec2cluster] [-sched [-fairshare file]]")
 	if flags.NArg() > 0 {
 		flags.Usage()
 	}
+	if *schedFlag {
+		c.Fatal(c.serveSched(ctx, server.Addr, server.Insecure, *fairShareFlag))
+	}
 	go reflowlet.IgnoreSigpipe()
 	// Shutdown the server if the context is done.
 	go func() {
@@ -42,3 +77,70 @@ as well as credentials to access various services.
 	}()
 	c.Fatal(server.ListenAndServe())
 }
+
+// serveSched serves a scheduling service on the provided address.
+// The service's fair share policy is read from the file fairShare,
+// if provided.
+func (c *Cmd) serveSched(ctx context.Context, addr string, insecure bool, fairShare string) error {
+	scheduler, err := newScheduler(c.Config, nil, c.Log, c.Status)
+	if err != nil {
+		return err
+	}
+	if fairShare != "" {
+		b, err := ioutil.ReadFile(fairShare)
+		if err != nil {
+			return err
+		}
+		scheduler.FairShare = new(sched.FairShare)
+		if err := yaml.Unmarshal(b, scheduler.FairShare); err != nil {
+			return errors.E("fairshare", fairShare, err)
+		}
+	}
+	var wg wg.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		<-wg.C()
+	}()
+	startScheduler(ctx, scheduler, &wg, c.Log)
+
+	var httpLog *log.Logger
+	if c.Log.At(log.DebugLevel) {
+		httpLog = c.Log.Tee(nil, "http: ")
+	}
+	var auth sched.ServiceAuth
+	var poolauth *infra2.PoolAuthConfig
+	if err := c.Config.Instance(&poolauth); err == nil {
+		auth.Authenticator = poolauth.Authenticator()
+		auth.Projects = poolauth.Projects
+	}
+	if auth.Authenticator == nil {
+		c.Log.Printf("warning: scheduling service calls are not authenticated: no pool authentication tokens are configured")
+	}
+	server := &http.Server{
+		Addr:    addr,
+		Handler: rest.Handler(sched.NewAuthServiceNode(scheduler, auth), httpLog),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	c.Log.Printf("serving scheduling service on %s", addr)
+	if insecure {
+		return server.ListenAndServe()
+	}
+	var certs infratls.Certs
+	if err := c.Config.Instance(&certs); err != nil {
+		return err
+	}
+	_, serverConfig, err := certs.HTTPS()
+	if err != nil {
+		return err
+	}
+	serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	server.TLSConfig = serverConfig
+	if err := http2.ConfigureServer(server, nil); err != nil {
+		return err
+	}
+	return server.ListenAndServeTLS("", "")
+}